@@ -0,0 +1,181 @@
+package spree
+
+import (
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree/auth"
+	"golang.org/x/net/context"
+)
+
+// requireAdmin returns the caller's identity if they are an admin.
+func requireAdmin(ctx context.Context) (*auth.Identity, error) {
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, errUnauthenticated
+	}
+	if !id.Admin {
+		return nil, errPermissionDenied
+	}
+	return id, nil
+}
+
+func (s *Server) RevokeIdentity(ctx context.Context, req *RevokeIdentityRequest) (*RevokeIdentityResponse, error) {
+	resp, err := s.revokeIdentity(ctx, req)
+	method := "RevokeIdentity"
+	if req.Restore {
+		method = "RestoreIdentity"
+	}
+	s.audit.RecordTarget(ctx, method, revokeTarget(req), err)
+	return resp, err
+}
+
+// revokeTarget describes what req revokes or restores for the audit log.
+func revokeTarget(req *RevokeIdentityRequest) string {
+	var parts []string
+	if req.Email != "" {
+		parts = append(parts, "email:"+strings.ToLower(req.Email))
+	}
+	if req.TokenId != "" {
+		parts = append(parts, "token:"+req.TokenId)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *Server) revokeIdentity(ctx context.Context, req *RevokeIdentityRequest) (*RevokeIdentityResponse, error) {
+	ll := s.ll.With(zap.String("method", "RevokeIdentity"))
+	ll.Info("starting rpc")
+
+	admin, err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if (req.Email == "") == (req.TokenId == "") {
+		return nil, errInvalidArg
+	}
+
+	ll = ll.With(
+		zap.String("admin", admin.Email),
+		zap.String("email", req.Email),
+		zap.String("token.id", req.TokenId),
+		zap.Bool("restore", req.Restore),
+	)
+
+	if req.Email != "" {
+		err = s.sessions.SetIdentityRevoked(req.Email, !req.Restore)
+	} else {
+		err = s.sessions.SetTokenRevoked(req.TokenId, !req.Restore)
+	}
+	if err != nil {
+		ll.Error("unable to update revocation", zap.Error(err))
+		return nil, errInternal
+	}
+
+	ll.Info("updated revocation")
+	return &RevokeIdentityResponse{}, nil
+}
+
+func (s *Server) ListActiveClients(ctx context.Context, req *ListActiveClientsRequest) (*ListActiveClientsResponse, error) {
+	ll := s.ll.With(zap.String("method", "ListActiveClients"))
+	ll.Info("starting rpc")
+
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	clients, err := s.sessions.ListClients()
+	if err != nil {
+		ll.Error("error listing clients", zap.Error(err))
+		return nil, errInternal
+	}
+
+	tokenIDs, err := s.sessions.ListRevokedTokens()
+	if err != nil {
+		ll.Error("error listing revoked tokens", zap.Error(err))
+		return nil, errInternal
+	}
+
+	return &ListActiveClientsResponse{
+		Clients:         clients,
+		RevokedTokenIds: tokenIDs,
+	}, nil
+}
+
+func (s *Server) QueryAudit(ctx context.Context, req *QueryAuditRequest) (*QueryAuditResponse, error) {
+	ll := s.ll.With(zap.String("method", "QueryAudit"))
+	ll.Info("starting rpc")
+
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	since, err := parseOptionalTime(req.Since)
+	if err != nil {
+		return nil, errInvalidArg
+	}
+	until, err := parseOptionalTime(req.Until)
+	if err != nil {
+		return nil, errInvalidArg
+	}
+
+	recs, err := s.audit.Query(since, until, req.Identity, int(req.Limit))
+	if err != nil {
+		ll.Error("error querying audit log", zap.Error(err))
+		return nil, errInternal
+	}
+
+	return &QueryAuditResponse{
+		Records: recs,
+	}, nil
+}
+
+func parseOptionalTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// storageStatsTTL is how long GetStorageStats serves cached totals.
+const storageStatsTTL = time.Minute
+
+func (s *Server) GetStorageStats(ctx context.Context, req *GetStorageStatsRequest) (*GetStorageStatsResponse, error) {
+	ll := s.ll.With(zap.String("method", "GetStorageStats"))
+	ll.Info("starting rpc")
+
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if s.stats == nil || time.Since(s.statsAt) > storageStatsTTL {
+		shots, err := s.md.ListShots()
+		if err != nil {
+			ll.Error("error listing shots", zap.Error(err))
+			return nil, errInternal
+		}
+		s.stats, s.statsAt = storageStats(shots), time.Now()
+	}
+	return &GetStorageStatsResponse{
+		Stats: s.stats,
+	}, nil
+}
+
+func (s *Server) ReplicaHealth(ctx context.Context, req *ReplicaHealthRequest) (*ReplicaHealthResponse, error) {
+	ll := s.ll.With(zap.String("method", "ReplicaHealth"))
+	ll.Info("starting rpc")
+
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if s.replicas == nil {
+		return nil, errNoReplication
+	}
+
+	return &ReplicaHealthResponse{
+		Replicas: s.replicas.Health(),
+	}, nil
+}
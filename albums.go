@@ -0,0 +1,266 @@
+package spree
+
+import (
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree/auth"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	maxAlbumShots = 200
+)
+
+var (
+	errAlbumFull    = grpc.Errorf(codes.InvalidArgument, "an album can hold at most %d shots", maxAlbumShots)
+	errAlbumReorder = grpc.Errorf(codes.InvalidArgument, "shot_ids must list every shot in the album once")
+)
+
+// requireAlbumOwner returns the caller's identity if they own album or are
+// an admin.
+func requireAlbumOwner(ctx context.Context, album *Album) (*auth.Identity, error) {
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, errUnauthenticated
+	}
+	if !id.Admin && !strings.EqualFold(id.Email, album.Owner) {
+		return nil, errNotAlbumOwner
+	}
+	return id, nil
+}
+
+// checkAlbumShots checks that the shots exist and belong to the caller, and
+// returns their ids without repeats.
+func (s *Server) checkAlbumShots(ctx context.Context, ids []string, ll *zap.Logger) ([]string, error) {
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		shot, err := s.md.GetShotById(id)
+		if err != nil {
+			ll.Warn("unable to get shot", zap.String("shot.id", id), zap.Error(err))
+			return nil, metadataError(err)
+		}
+		if _, err := requireOwner(ctx, shot); err != nil {
+			return nil, err
+		}
+		seen[id] = true
+		out = append(out, id)
+	}
+	if len(out) > maxAlbumShots {
+		return nil, errAlbumFull
+	}
+	return out, nil
+}
+
+// albumResponse returns album with its shots. Shots deleted since they
+// were added are left out.
+func (s *Server) albumResponse(album *Album, ll *zap.Logger) (*AlbumResponse, error) {
+	shots, err := albumShots(s.md, album, ll)
+	if err != nil {
+		return nil, metadataError(err)
+	}
+	return &AlbumResponse{Album: album, Shots: shots}, nil
+}
+
+func albumShots(md Metadata, album *Album, ll *zap.Logger) ([]*Shot, error) {
+	shots := make([]*Shot, 0, len(album.ShotIds))
+	for _, id := range album.ShotIds {
+		shot, err := md.GetShotById(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			ll.Error("unable to get album shot", zap.String("shot.id", id), zap.Error(err))
+			return nil, err
+		}
+		shots = append(shots, shot)
+	}
+	return shots, nil
+}
+
+// searchAlbumShots returns the shots that match q, keeping their order.
+// Only the album's shots are returned, so someone with an album's link
+// can't use it to find anyone else's shots.
+func searchAlbumShots(md Metadata, shots []*Shot, q *Query) ([]*Shot, error) {
+	found, err := md.SearchShots(q)
+	if err != nil {
+		return nil, err
+	}
+	matched := make(map[string]bool, len(found))
+	for _, shot := range found {
+		matched[shot.Id] = true
+	}
+	out := make([]*Shot, 0, len(shots))
+	for _, shot := range shots {
+		if matched[shot.Id] {
+			out = append(out, shot)
+		}
+	}
+	return out, nil
+}
+
+func (s *Server) CreateAlbum(ctx context.Context, req *CreateAlbumRequest) (*AlbumResponse, error) {
+	resp, err := s.createAlbum(ctx, req)
+	var albumID string
+	if resp != nil {
+		albumID = resp.Album.Id
+	}
+	s.audit.Record(ctx, "CreateAlbum", albumID, 0, err)
+	return resp, err
+}
+
+func (s *Server) createAlbum(ctx context.Context, req *CreateAlbumRequest) (*AlbumResponse, error) {
+	ll := s.ll.With(zap.String("method", "CreateAlbum"))
+	ll.Info("starting rpc")
+
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, errUnauthenticated
+	}
+	if err := checkShotText(req.Title, ""); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	shotIDs, err := s.checkAlbumShots(ctx, req.ShotIds, ll)
+	if err != nil {
+		return nil, err
+	}
+
+	album := &Album{
+		Id:        newShotId(),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Owner:     strings.ToLower(id.Email),
+		Title:     req.Title,
+		ShotIds:   shotIDs,
+	}
+	if err := s.md.PutAlbum(album); err != nil {
+		ll.Error("unable to put album", zap.Error(err))
+		return nil, metadataError(err)
+	}
+	return s.albumResponse(album, ll)
+}
+
+func (s *Server) GetAlbum(ctx context.Context, req *GetAlbumRequest) (*AlbumResponse, error) {
+	ll := s.ll.With(zap.String("method", "GetAlbum"), zap.String("album.id", req.Id))
+	ll.Info("starting rpc")
+
+	album, err := s.md.GetAlbumById(req.Id)
+	if err != nil {
+		ll.Warn("unable to get album", zap.Error(err))
+		return nil, metadataError(err)
+	}
+	if _, err := requireAlbumOwner(ctx, album); err != nil {
+		return nil, err
+	}
+	return s.albumResponse(album, ll)
+}
+
+func (s *Server) AddAlbumShots(ctx context.Context, req *AlbumShotsRequest) (*AlbumResponse, error) {
+	return s.updateAlbum(ctx, "AddAlbumShots", req, true, func(album *Album, ids []string) error {
+		for _, id := range ids {
+			if !containsString(album.ShotIds, id) {
+				album.ShotIds = append(album.ShotIds, id)
+			}
+		}
+		if len(album.ShotIds) > maxAlbumShots {
+			return errAlbumFull
+		}
+		return nil
+	})
+}
+
+func (s *Server) RemoveAlbumShots(ctx context.Context, req *AlbumShotsRequest) (*AlbumResponse, error) {
+	return s.updateAlbum(ctx, "RemoveAlbumShots", req, false, func(album *Album, ids []string) error {
+		kept := album.ShotIds[:0]
+		for _, id := range album.ShotIds {
+			if !containsString(ids, id) {
+				kept = append(kept, id)
+			}
+		}
+		album.ShotIds = kept
+		return nil
+	})
+}
+
+func (s *Server) ReorderAlbum(ctx context.Context, req *AlbumShotsRequest) (*AlbumResponse, error) {
+	return s.updateAlbum(ctx, "ReorderAlbum", req, false, func(album *Album, ids []string) error {
+		if len(ids) != len(album.ShotIds) || len(ids) != len(req.ShotIds) {
+			return errAlbumReorder
+		}
+		for _, id := range ids {
+			if !containsString(album.ShotIds, id) {
+				return errAlbumReorder
+			}
+		}
+		album.ShotIds = ids
+		return nil
+	})
+}
+
+// updateAlbum applies fn to the album named in req, with the shot ids from
+// req without repeats. With checkShots the shots must exist and belong to
+// the caller; removals skip the check so deleted shots can still be taken
+// out of an album.
+func (s *Server) updateAlbum(ctx context.Context, method string, req *AlbumShotsRequest, checkShots bool,
+	fn func(album *Album, ids []string) error) (resp *AlbumResponse, err error) {
+	defer func() {
+		s.audit.Record(ctx, method, req.AlbumId, 0, err)
+	}()
+
+	ll := s.ll.With(zap.String("method", method), zap.String("album.id", req.AlbumId))
+	ll.Info("starting rpc")
+
+	album, err := s.md.GetAlbumById(req.AlbumId)
+	if err != nil {
+		ll.Warn("unable to get album", zap.Error(err))
+		return nil, metadataError(err)
+	}
+	if _, err := requireAlbumOwner(ctx, album); err != nil {
+		return nil, err
+	}
+
+	ids := uniqueStrings(req.ShotIds)
+	if checkShots {
+		if ids, err = s.checkAlbumShots(ctx, ids, ll); err != nil {
+			return nil, err
+		}
+	}
+
+	album, err = s.md.UpdateAlbum(req.AlbumId, func(album *Album) error {
+		return fn(album, ids)
+	})
+	if err == errAlbumFull || err == errAlbumReorder {
+		return nil, err
+	}
+	if err != nil {
+		ll.Error("unable to update album", zap.Error(err))
+		return nil, metadataError(err)
+	}
+	return s.albumResponse(album, ll)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueStrings(list []string) []string {
+	out := make([]string, 0, len(list))
+	for _, s := range list {
+		if !containsString(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
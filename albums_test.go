@@ -0,0 +1,148 @@
+package spree_test
+
+import (
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+	"github.com/ralfonso/spree/auth"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// albumShotIds returns the ids of the shots in resp, in order.
+func albumShotIds(resp *spree.AlbumResponse) string {
+	ids := make([]string, 0, len(resp.Shots))
+	for _, shot := range resp.Shots {
+		ids = append(ids, shot.Id)
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestAlbums(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+	audit, err := spree.NewAuditLog(md, "", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := spree.NewServer(md, spree.NewMemoryStorage(), md, audit, nil, zap.NewNop())
+
+	var shots []*spree.Shot
+	for _, upload := range []struct{ owner, filename string }{
+		{"alice@example.com", "one.png"},
+		{"alice@example.com", "two.png"},
+		{"alice@example.com", "three.png"},
+		{"bob@example.com", "bob.png"},
+	} {
+		stream := newCreateStream(upload.owner, upload.filename, []byte(upload.filename))
+		if err := s.Create(stream); err != nil {
+			t.Fatal(err)
+		}
+		shots = append(shots, stream.shot)
+	}
+	one, two, three, bobs := shots[0].Id, shots[1].Id, shots[2].Id, shots[3].Id
+
+	alice := auth.NewContext(context.Background(), &auth.Identity{Email: "Alice@example.com"})
+	bob := auth.NewContext(context.Background(), &auth.Identity{Email: "bob@example.com"})
+	admin := auth.NewContext(context.Background(), &auth.Identity{Email: "admin@example.com", Admin: true})
+
+	// create keeps the given order and drops repeats
+	resp, err := s.CreateAlbum(alice, &spree.CreateAlbumRequest{Title: "trip", ShotIds: []string{two, one, two}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	album := resp.Album
+	if album.Owner != "alice@example.com" || album.Title != "trip" || album.Path != "/a/"+album.Id {
+		t.Errorf("created album %+v", album)
+	}
+	if got, want := albumShotIds(resp), fmt.Sprint([]string{two, one}); got != want {
+		t.Errorf("created album lists %s, want %s", got, want)
+	}
+
+	// someone else's shot can't go in an album
+	_, err = s.CreateAlbum(alice, &spree.CreateAlbumRequest{ShotIds: []string{bobs}})
+	if grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("creating an album with bob's shot got error %v", err)
+	}
+	_, err = s.CreateAlbum(alice, &spree.CreateAlbumRequest{ShotIds: []string{"missing"}})
+	if grpc.Code(err) != codes.NotFound {
+		t.Errorf("creating an album with a missing shot got error %v", err)
+	}
+	_, err = s.AddAlbumShots(alice, &spree.AlbumShotsRequest{AlbumId: album.Id, ShotIds: []string{bobs}})
+	if grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("adding bob's shot got error %v", err)
+	}
+
+	// only the owner or an admin can see or change the album
+	if _, err := s.GetAlbum(bob, &spree.GetAlbumRequest{Id: album.Id}); grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("bob getting alice's album got error %v", err)
+	}
+	_, err = s.AddAlbumShots(bob, &spree.AlbumShotsRequest{AlbumId: album.Id, ShotIds: []string{bobs}})
+	if grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("bob adding to alice's album got error %v", err)
+	}
+	_, err = s.RemoveAlbumShots(bob, &spree.AlbumShotsRequest{AlbumId: album.Id, ShotIds: []string{one}})
+	if grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("bob removing from alice's album got error %v", err)
+	}
+	if _, err := s.GetAlbum(admin, &spree.GetAlbumRequest{Id: album.Id}); err != nil {
+		t.Errorf("admin getting alice's album got error %v", err)
+	}
+	if _, err := s.GetAlbum(alice, &spree.GetAlbumRequest{Id: "missing"}); grpc.Code(err) != codes.NotFound {
+		t.Errorf("getting a missing album got error %v", err)
+	}
+
+	// adds append shots that aren't already there
+	resp, err = s.AddAlbumShots(alice, &spree.AlbumShotsRequest{AlbumId: album.Id, ShotIds: []string{one, three}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := albumShotIds(resp), fmt.Sprint([]string{two, one, three}); got != want {
+		t.Errorf("after adding, album lists %s, want %s", got, want)
+	}
+
+	resp, err = s.RemoveAlbumShots(alice, &spree.AlbumShotsRequest{AlbumId: album.Id, ShotIds: []string{one}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := albumShotIds(resp), fmt.Sprint([]string{two, three}); got != want {
+		t.Errorf("after removing, album lists %s, want %s", got, want)
+	}
+
+	resp, err = s.GetAlbum(alice, &spree.GetAlbumRequest{Id: album.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := albumShotIds(resp), fmt.Sprint([]string{two, three}); got != want {
+		t.Errorf("album lists %s, want %s", got, want)
+	}
+
+	// a deleted shot drops out of the album and can still be removed
+	if _, err := s.DeleteShot(alice, &spree.DeleteShotRequest{Id: two}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = s.GetAlbum(alice, &spree.GetAlbumRequest{Id: album.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := albumShotIds(resp), fmt.Sprint([]string{three}); got != want {
+		t.Errorf("after deleting a shot, album lists %s, want %s", got, want)
+	}
+	if _, err := s.AddAlbumShots(alice, &spree.AlbumShotsRequest{AlbumId: album.Id, ShotIds: []string{two}}); grpc.Code(err) != codes.NotFound {
+		t.Errorf("adding a deleted shot got error %v", err)
+	}
+	if _, err := s.RemoveAlbumShots(alice, &spree.AlbumShotsRequest{AlbumId: album.Id, ShotIds: []string{two}}); err != nil {
+		t.Errorf("removing a deleted shot got error %v", err)
+	}
+	stored, err := md.GetAlbumById(album.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(stored.ShotIds), fmt.Sprint([]string{three}); got != want {
+		t.Errorf("stored album holds %s, want %s", got, want)
+	}
+}
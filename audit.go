@@ -0,0 +1,257 @@
+package spree
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/ralfonso/spree/auth"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	auditResultOK     = "ok"
+	defaultAuditLimit = 1000
+
+	// maxPendingAudit bounds the web requests held between flushes. More
+	// are dropped rather than letting a flood of hits use up memory.
+	maxPendingAudit = 10000
+	// auditPruneInterval is how often Loop removes expired records
+	auditPruneInterval = time.Hour
+)
+
+// AuditStore persists audit records. Records are only appended, and only
+// removed once they're older than the retention period.
+type AuditStore interface {
+	// AppendAudit stores recs in one write.
+	AppendAudit(recs ...*AuditRecord) error
+	// QueryAudit returns records between since and until, oldest first. A
+	// zero time leaves that end open and an empty identity matches everyone.
+	QueryAudit(since, until time.Time, identity string, limit int) ([]*AuditRecord, error)
+	// PruneAudit removes records older than before and returns how many.
+	PruneAudit(before time.Time) (int, error)
+}
+
+// AuditLog records who changed what. Every record goes to the AuditStore and,
+// if a file is configured, is also appended to it as a JSON line. Records
+// of web requests are held in memory and written in batches by Loop, so
+// serving files doesn't take a database write per hit.
+type AuditLog struct {
+	ll    *zap.Logger
+	store AuditStore
+	jm    jsonpb.Marshaler
+
+	mu   sync.Mutex
+	file *os.File
+
+	pendingMu sync.Mutex
+	pending   []*AuditRecord
+	dropped   int
+	// flushMu keeps flushes, and the records they put back on failure, in order
+	flushMu sync.Mutex
+}
+
+// NewAuditLog creates an AuditLog. filename may be empty.
+func NewAuditLog(store AuditStore, filename string, ll *zap.Logger) (*AuditLog, error) {
+	a := &AuditLog{
+		ll:    ll,
+		store: store,
+		jm:    jsonpb.Marshaler{OrigName: true},
+	}
+
+	if filename != "" {
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		a.file = f
+	}
+
+	return a, nil
+}
+
+// Record appends an audit record for an operation by the caller in ctx.
+// Failing to audit is logged but does not fail the operation.
+func (a *AuditLog) Record(ctx context.Context, method, shotID string, size uint64, opErr error) {
+	a.append(newAuditRecord(ctx, &AuditRecord{Method: method, ShotId: shotID, SizeBytes: size}, opErr))
+}
+
+// RecordTarget appends an audit record for an admin operation by the caller
+// in ctx on target, which isn't a shot.
+func (a *AuditLog) RecordTarget(ctx context.Context, method, target string, opErr error) {
+	a.append(newAuditRecord(ctx, &AuditRecord{Method: method, Target: target}, opErr))
+}
+
+// newAuditRecord fills in the time, caller and result of rec.
+func newAuditRecord(ctx context.Context, rec *AuditRecord, opErr error) *AuditRecord {
+	rec.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	rec.Result = auditResultOK
+	if id, ok := auth.FromContext(ctx); ok {
+		rec.Identity = id.Email
+		rec.PeerAddr = id.PeerAddr
+	} else if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		rec.PeerAddr = p.Addr.String()
+	}
+	if opErr != nil {
+		rec.Result = grpc.ErrorDesc(opErr)
+	}
+	return rec
+}
+
+// RecordHTTP records a web request from the client at addr for the file
+// stored at key. status is the HTTP status of the response. The record is
+// written by the next flush.
+func (a *AuditLog) RecordHTTP(addr, method, key string, size uint64, status int) {
+	rec := &AuditRecord{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Method:    method,
+		Key:       key,
+		SizeBytes: size,
+		PeerAddr:  addr,
+		Result:    auditResultOK,
+	}
+	if status >= http.StatusBadRequest {
+		rec.Result = http.StatusText(status)
+	}
+
+	a.pendingMu.Lock()
+	if len(a.pending) < maxPendingAudit {
+		a.pending = append(a.pending, rec)
+	} else {
+		a.dropped++
+	}
+	a.pendingMu.Unlock()
+}
+
+// Flush writes the web requests recorded since the last flush in one
+// AuditStore write and returns how many were written. If the write fails
+// they're kept for the next flush.
+func (a *AuditLog) Flush() (int, error) {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+
+	a.pendingMu.Lock()
+	batch, dropped := a.pending, a.dropped
+	a.pending, a.dropped = nil, 0
+	a.pendingMu.Unlock()
+
+	if dropped > 0 {
+		a.ll.Warn("dropped audit records of web requests", zap.Int("count", dropped))
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	if err := a.store.AppendAudit(batch...); err != nil {
+		a.pendingMu.Lock()
+		if room := maxPendingAudit - len(a.pending); room < len(batch) {
+			a.dropped += len(batch) - room
+			batch = batch[len(batch)-room:]
+		}
+		a.pending = append(batch, a.pending...)
+		a.pendingMu.Unlock()
+		return 0, err
+	}
+	for _, rec := range batch {
+		a.writeFile(rec)
+	}
+	return len(batch), nil
+}
+
+// Loop flushes every interval until ctx is done, then flushes once more so
+// no records are lost at shutdown. With a non-zero retention, records older
+// than it are removed every auditPruneInterval.
+func (a *AuditLog) Loop(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var pruned time.Time
+	for {
+		select {
+		case <-ticker.C:
+			a.flush()
+			if retention > 0 && time.Since(pruned) >= auditPruneInterval {
+				a.prune(retention)
+				pruned = time.Now()
+			}
+		case <-ctx.Done():
+			a.flush()
+			return
+		}
+	}
+}
+
+func (a *AuditLog) flush() {
+	n, err := a.Flush()
+	if err != nil {
+		a.ll.Error("unable to flush audit records, retrying next flush", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		a.ll.Debug("flushed audit records", zap.Int("records", n))
+	}
+}
+
+func (a *AuditLog) prune(retention time.Duration) {
+	n, err := a.store.PruneAudit(time.Now().Add(-retention))
+	if err != nil {
+		a.ll.Error("unable to prune audit records", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		a.ll.Info("pruned audit records", zap.Int("records", n), zap.Duration("retention", retention))
+	}
+}
+
+func (a *AuditLog) append(rec *AuditRecord) {
+	if err := a.store.AppendAudit(rec); err != nil {
+		a.ll.Error("unable to store audit record", zap.String("audit.method", rec.Method),
+			zap.String("audit.identity", rec.Identity), zap.Error(err))
+	}
+	a.writeFile(rec)
+}
+
+// writeFile appends rec to the audit file, if there is one.
+func (a *AuditLog) writeFile(rec *AuditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return
+	}
+
+	ll := a.ll.With(zap.String("audit.method", rec.Method), zap.String("audit.identity", rec.Identity))
+	line, err := a.jm.MarshalToString(rec)
+	if err != nil {
+		ll.Error("unable to marshal audit record", zap.Error(err))
+		return
+	}
+	if _, err := a.file.WriteString(line + "\n"); err != nil {
+		ll.Error("unable to write audit record to file", zap.Error(err))
+	}
+}
+
+// Query returns the stored audit records matching the filters.
+func (a *AuditLog) Query(since, until time.Time, identity string, limit int) ([]*AuditRecord, error) {
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	return a.store.QueryAudit(since, until, identity, limit)
+}
+
+// Close closes the audit file, if any.
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
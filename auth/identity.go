@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type identityKey struct{}
+
+// Identity is the authenticated caller of an RPC.
+type Identity struct {
+	Email    string
+	TokenID  string
+	PeerAddr string
+	Admin    bool
+}
+
+// NewContext returns a context carrying the caller's identity.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the caller's identity set by the JWT interceptors.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// SessionStore is consulted by the interceptors on every call. It holds the
+// deny list and records when each identity was last seen.
+type SessionStore interface {
+	// IsRevoked reports whether the identity or the token has been revoked.
+	IsRevoked(email, tokenID string) (bool, error)
+	// TouchClient records that email made a call from addr with the token.
+	TouchClient(email, tokenID, addr string, seen time.Time) error
+}
+
+// TokenID returns the jti claim, falling back to a digest of the raw token for
+// issuers (like Google) that don't set one.
+func TokenID(rawToken string, claims *TokenClaims) string {
+	if claims.ID != "" {
+		return claims.ID
+	}
+	sum := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(sum[:16])
+}
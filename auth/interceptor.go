@@ -1,46 +1,129 @@
 package auth
 
 import (
+	"time"
+
 	"go.uber.org/zap"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	errTokenRequired = grpc.Errorf(codes.Unauthenticated, "valid token required.")
+	errRevoked       = grpc.Errorf(codes.Unauthenticated, "token has been revoked.")
+	errSessionCheck  = grpc.Errorf(codes.Unavailable, "unable to check session.")
 )
 
+// InterceptorConfig controls who the JWT interceptors let through.
+type InterceptorConfig struct {
+	// AllowedEmails may make calls. Admins are always allowed.
+	AllowedEmails []string
+	// AdminEmails may also call the admin RPCs.
+	AdminEmails []string
+	// Sessions is consulted for revocations and records last-seen times. It may be nil.
+	Sessions SessionStore
+}
+
 // MakeJWTInterceptor creates an interceptor to validate JWT tokens for a unary RPC.
-func MakeJWTInterceptor(allowedEmails []string, authenticator *Authenticator, ll *zap.Logger) grpc.UnaryServerInterceptor {
+func MakeJWTInterceptor(conf InterceptorConfig, authenticator *Authenticator, ll *zap.Logger) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{},
 		info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 
-		md, ok := metadata.FromContext(ctx)
-		if !ok {
-			ll.Warn("missing metadata in RPC", zap.Any("req", req))
-			return nil, grpc.Errorf(codes.Unauthenticated, "valid token required")
+		ctx, err := authorize(ctx, conf, authenticator, ll.With(zap.String("rpc.method", info.FullMethod)))
+		if err != nil {
+			return nil, err
 		}
 
-		jwtTokenStr, ok := md["authorization"]
-		if !ok {
-			ll.Warn("missing authorization in RPC", zap.Any("req", req))
-			return nil, grpc.Errorf(codes.Unauthenticated, "valid token required.")
-		}
+		return handler(ctx, req)
+	}
+}
+
+// MakeJWTStreamInterceptor creates an interceptor to validate JWT tokens for a streaming RPC.
+func MakeJWTStreamInterceptor(conf InterceptorConfig, authenticator *Authenticator, ll *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream,
+		info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 
-		tok, err := authenticator.ValidateToken(jwtTokenStr[0])
+		ctx, err := authorize(ss.Context(), conf, authenticator, ll.With(zap.String("rpc.method", info.FullMethod)))
 		if err != nil {
-			ll.Warn("invalid token in RPC", zap.Any("req", req), zap.Error(err))
-			return nil, grpc.Errorf(codes.Unauthenticated, "valid token required.")
+			return err
 		}
 
-		authorized, err := authenticator.IsAuthorizedToken(tok, allowedEmails)
+		return handler(srv, &identityStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authorize validates the token in the call metadata and returns a context
+// carrying the caller's Identity.
+func authorize(ctx context.Context, conf InterceptorConfig, authenticator *Authenticator, ll *zap.Logger) (context.Context, error) {
+	md, ok := metadata.FromContext(ctx)
+	if !ok {
+		ll.Warn("missing metadata in RPC")
+		return nil, errTokenRequired
+	}
+
+	jwtTokenStr, ok := md["authorization"]
+	if !ok || len(jwtTokenStr) == 0 {
+		ll.Warn("missing authorization in RPC")
+		return nil, errTokenRequired
+	}
+
+	tok, err := authenticator.ValidateToken(jwtTokenStr[0])
+	if err != nil {
+		ll.Warn("invalid token in RPC", zap.Error(err))
+		return nil, errTokenRequired
+	}
+
+	claims, err := authenticator.Claims(tok)
+	if err != nil {
+		ll.Warn("unreadable claims in RPC", zap.Error(err))
+		return nil, errTokenRequired
+	}
+
+	id := &Identity{
+		Email:   claims.Email,
+		TokenID: TokenID(jwtTokenStr[0], claims),
+		Admin:   containsEmail(conf.AdminEmails, claims.Email),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		id.PeerAddr = p.Addr.String()
+	}
+	ll = ll.With(zap.String("email", id.Email), zap.String("peer.addr", id.PeerAddr))
+
+	if !id.Admin && !containsEmail(conf.AllowedEmails, id.Email) {
+		ll.Warn("unauthorized token in RPC", zap.Error(errUnauthorizedEmail))
+		return nil, errTokenRequired
+	}
+
+	if conf.Sessions != nil {
+		revoked, err := conf.Sessions.IsRevoked(id.Email, id.TokenID)
 		if err != nil {
-			ll.Warn("unauthorized token in RPC", zap.Any("req", req), zap.Error(err))
-			return nil, grpc.Errorf(codes.Unauthenticated, "valid token required.")
+			ll.Error("could not check revocations", zap.Error(err))
+			return nil, errSessionCheck
 		}
-
-		if !authorized {
-			return nil, grpc.Errorf(codes.Unauthenticated, "valid token required.")
+		if revoked {
+			ll.Warn("revoked token in RPC", zap.String("token.id", id.TokenID))
+			return nil, errRevoked
 		}
 
-		return handler(ctx, req)
+		err = conf.Sessions.TouchClient(id.Email, id.TokenID, id.PeerAddr, time.Now().UTC())
+		if err != nil {
+			ll.Error("could not record client", zap.Error(err))
+		}
 	}
+
+	return NewContext(ctx, id), nil
+}
+
+// identityStream replaces the context of a server stream with one carrying
+// the caller's Identity.
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
 }
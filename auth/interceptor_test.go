@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// staticKeys is a keyCache holding one key.
+type staticKeys struct {
+	kid string
+	key *rsa.PublicKey
+}
+
+func (k *staticKeys) Get(kid string) *rsa.PublicKey {
+	if kid != k.kid {
+		return nil
+	}
+	return k.key
+}
+
+// fakeSessions is a SessionStore with revocations in memory.
+type fakeSessions struct {
+	mu      sync.Mutex
+	emails  map[string]bool
+	tokens  map[string]bool
+	touched []string
+}
+
+func (s *fakeSessions) IsRevoked(email, tokenID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.emails[strings.ToLower(email)] || s.tokens[tokenID], nil
+}
+
+func (s *fakeSessions) TouchClient(email, tokenID, addr string, seen time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touched = append(s.touched, email+" "+tokenID)
+	return nil
+}
+
+// testAuthenticator returns an Authenticator trusting a generated key, and a
+// function that signs tokens with it.
+func testAuthenticator(t *testing.T) (*Authenticator, func(email, id string) string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       &jose.JSONWebKey{Key: key, KeyID: "test"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Authenticator{keys: &staticKeys{kid: "test", key: &key.PublicKey}, ll: zap.NewNop()}
+	return a, func(email, id string) string {
+		claims := &TokenClaims{
+			Claims: jwt.Claims{
+				Issuer: jwtIssuer,
+				ID:     id,
+				Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+			Email: email,
+		}
+		raw, err := jwt.Signed(sig).Claims(claims).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+}
+
+func TestInterceptorRevocation(t *testing.T) {
+	a, sign := testAuthenticator(t)
+	sessions := &fakeSessions{
+		emails: map[string]bool{"gone@example.com": true},
+		tokens: map[string]bool{"stolen": true},
+	}
+	intercept := MakeJWTInterceptor(InterceptorConfig{
+		AllowedEmails: []string{"user@example.com", "gone@example.com"},
+		Sessions:      sessions,
+	}, a, zap.NewNop())
+	info := &grpc.UnaryServerInfo{FullMethod: "/spree.Spree/List"}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "allowed", token: sign("user@example.com", "fresh")},
+		{name: "revoked token", token: sign("user@example.com", "stolen"), wantErr: errRevoked},
+		{name: "revoked identity", token: sign("GONE@example.com", "fresh"), wantErr: errRevoked},
+		{name: "unknown email", token: sign("stranger@example.com", "fresh"), wantErr: errTokenRequired},
+		{name: "garbage", token: "not a token", wantErr: errTokenRequired},
+	}
+	for _, tt := range tests {
+		sessions.touched = nil
+		var called *Identity
+		ctx := metadata.NewContext(context.Background(), metadata.Pairs("authorization", tt.token))
+		_, err := intercept(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			called, _ = FromContext(ctx)
+			return nil, nil
+		})
+
+		if err != tt.wantErr {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr != nil {
+			if called != nil || len(sessions.touched) > 0 {
+				t.Errorf("%s: rejected call reached the handler or was recorded", tt.name)
+			}
+			continue
+		}
+		if called == nil || called.Email != "user@example.com" || called.TokenID != "fresh" {
+			t.Errorf("%s: handler got identity %+v", tt.name, called)
+		}
+		if len(sessions.touched) != 1 || sessions.touched[0] != "user@example.com fresh" {
+			t.Errorf("%s: recorded clients %v", tt.name, sessions.touched)
+		}
+	}
+
+	// restoring lets the token back in
+	sessions.tokens["stolen"] = false
+	ctx := metadata.NewContext(context.Background(), metadata.Pairs("authorization", sign("user@example.com", "stolen")))
+	if _, err := intercept(ctx, nil, info, func(context.Context, interface{}) (interface{}, error) { return nil, nil }); err != nil {
+		t.Errorf("restored token got error %v", err)
+	}
+}
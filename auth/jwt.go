@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/rsa"
 	"errors"
 	"strings"
 	"time"
@@ -64,6 +65,12 @@ func NewClientJWTFromOauth2(token *oauth2.Token, ll *zap.Logger) (*ClientJWT, er
 
 var _ credentials.PerRPCCredentials = &ClientJWT{}
 
+// TokenClaims are the claims we use from a validated JWT.
+type TokenClaims struct {
+	jwt.Claims
+	Email string `json:"email"`
+}
+
 // Authenticator can validate or refresh JWT tokens.
 type Authenticator struct {
 	keys keyCache
@@ -136,42 +143,60 @@ func (a *Authenticator) ValidateToken(token string) (*jwt.JSONWebToken, error) {
 	return tok, nil
 }
 
+// Claims returns the claims of a token returned by ValidateToken.
+func (a *Authenticator) Claims(tok *jwt.JSONWebToken) (*TokenClaims, error) {
+	sharedKey, err := a.signingKey(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	cl := &TokenClaims{}
+	if err := tok.Claims(sharedKey, cl); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
 func (a *Authenticator) IsAuthorizedToken(tok *jwt.JSONWebToken, allowedEmails []string) (bool, error) {
-	headers := tok.Headers
+	cl, err := a.Claims(tok)
+	if err != nil {
+		return false, err
+	}
+
+	if !containsEmail(allowedEmails, cl.Email) {
+		return false, errUnauthorizedEmail
+	}
+
+	return true, nil
+}
+
+func (a *Authenticator) signingKey(tok *jwt.JSONWebToken) (*rsa.PublicKey, error) {
 	var keyID string
-	for _, header := range headers {
+	for _, header := range tok.Headers {
 		if header.KeyID != "" {
 			keyID = header.KeyID
 			break
 		}
 	}
 	if keyID == "" {
-		return false, errUnknownSigningKey
+		return nil, errUnknownSigningKey
 	}
 
 	sharedKey := a.keys.Get(keyID)
 	if sharedKey == nil {
-		return false, errUnknownSigningKey
+		return nil, errUnknownSigningKey
 	}
+	return sharedKey, nil
+}
 
-	emailClaims := struct {
-		Email string `json:"email"`
-	}{}
-	if err := tok.Claims(sharedKey, &emailClaims); err != nil {
-		return false, err
+func containsEmail(emails []string, email string) bool {
+	if email == "" {
+		return false
 	}
-
-	var authorized bool
-	for _, checkEmail := range allowedEmails {
-		if strings.ToLower(checkEmail) == strings.ToLower(emailClaims.Email) {
-			authorized = true
-			break
+	for _, checkEmail := range emails {
+		if strings.ToLower(checkEmail) == strings.ToLower(email) {
+			return true
 		}
 	}
-
-	if !authorized {
-		return false, errUnauthorizedEmail
-	}
-
-	return true, nil
+	return false
 }
@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// GoogleDeviceAuthURL is Google's OAuth device authorization endpoint.
+	GoogleDeviceAuthURL = "https://oauth2.googleapis.com/device/code"
+
+	deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// device flow intervals, in devicePollUnits
+	defaultDevicePollInterval = 5
+	slowDownInterval          = 5
+)
+
+// devicePollUnit is the unit of the intervals in device flow responses,
+// which is seconds unless a test shortens it.
+var devicePollUnit = time.Second
+
+var (
+	errStateMismatch  = errors.New("oauth state mismatch in redirect")
+	errMissingCode    = errors.New("redirect did not include an authorization code")
+	errDeviceExpired  = errors.New("device code expired before authorization completed")
+	errMissingToken   = errors.New("token response did not include an access token")
+	errMissingDevCode = errors.New("device authorization response did not include a device code")
+)
+
+// TokenError is an error response from an OAuth token or device authorization endpoint.
+type TokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *TokenError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth2: %s: %s", e.Code, e.Description)
+	}
+	return fmt.Sprintf("oauth2: %s", e.Code)
+}
+
+// DeviceCode is the response to a device authorization request.
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// URL returns the page the user should visit to enter the user code. Google
+// uses verification_url, RFC 8628 uses verification_uri.
+func (d *DeviceCode) URL() string {
+	if d.VerificationURI != "" {
+		return d.VerificationURI
+	}
+	return d.VerificationURL
+}
+
+// LoopbackLogin runs the authorization code flow with PKCE. The redirect is
+// received by a one-shot HTTP server on an ephemeral loopback port, and
+// openURL is called with the URL the user needs to visit.
+func LoopbackLogin(ctx context.Context, conf *oauth2.Config, openURL func(string) error, ll *zap.Logger) (*oauth2.Token, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer lis.Close()
+
+	state, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	redirectConf := *conf
+	redirectConf.RedirectURL = fmt.Sprintf("http://%s/", lis.Addr().String())
+	authURL := redirectConf.AuthCodeURL(state,
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			var res result
+			switch {
+			case q.Get("state") != state:
+				res.err = errStateMismatch
+			case q.Get("error") != "":
+				res.err = &TokenError{Code: q.Get("error"), Description: q.Get("error_description")}
+			case q.Get("code") == "":
+				res.err = errMissingCode
+			default:
+				res.code = q.Get("code")
+			}
+
+			w.Header().Set("Content-Type", "text/html")
+			if res.err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, "<p>Login failed. You may close this window.</p>")
+			} else {
+				io.WriteString(w, "<p>Login complete. You may close this window.</p>")
+			}
+
+			select {
+			case results <- res:
+			default:
+			}
+		}),
+	}
+	go srv.Serve(lis)
+
+	ll.Debug("waiting for oauth redirect", zap.String("redirect.url", redirectConf.RedirectURL))
+	if err := openURL(authURL); err != nil {
+		return nil, err
+	}
+
+	var res result
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	v := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {res.code},
+		"redirect_uri":  {redirectConf.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	return requestToken(ctx, conf, v)
+}
+
+// DeviceLogin runs the OAuth device authorization grant against
+// deviceAuthURL. prompt is called once with the user code and verification
+// URL, after which the token endpoint is polled until the user approves or
+// the device code expires.
+func DeviceLogin(ctx context.Context, conf *oauth2.Config, deviceAuthURL string, prompt func(*DeviceCode), ll *zap.Logger) (*oauth2.Token, error) {
+	v := url.Values{
+		"client_id": {conf.ClientID},
+		"scope":     {strings.Join(conf.Scopes, " ")},
+	}
+	dc := &DeviceCode{}
+	if err := postForm(ctx, deviceAuthURL, v, dc); err != nil {
+		return nil, err
+	}
+	if dc.DeviceCode == "" {
+		return nil, errMissingDevCode
+	}
+
+	prompt(dc)
+
+	wait := time.Duration(dc.Interval) * devicePollUnit
+	if wait <= 0 {
+		wait = defaultDevicePollInterval * devicePollUnit
+	}
+	var expired <-chan time.Time
+	if dc.ExpiresIn > 0 {
+		expired = time.After(time.Duration(dc.ExpiresIn) * devicePollUnit)
+	}
+
+	v = url.Values{
+		"grant_type":  {deviceGrantType},
+		"device_code": {dc.DeviceCode},
+	}
+	for {
+		select {
+		case <-time.After(wait):
+		case <-expired:
+			return nil, errDeviceExpired
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		token, err := requestToken(ctx, conf, v)
+		if err == nil {
+			return token, nil
+		}
+
+		tokErr, ok := err.(*TokenError)
+		if !ok {
+			return nil, err
+		}
+		switch tokErr.Code {
+		case "authorization_pending":
+			ll.Debug("device authorization pending")
+		case "slow_down":
+			wait += slowDownInterval * devicePollUnit
+		case "expired_token":
+			return nil, errDeviceExpired
+		default:
+			return nil, err
+		}
+	}
+}
+
+// requestToken posts v, along with the client credentials, to the token
+// endpoint. The vendored oauth2 package can't send a PKCE verifier or a
+// device code, so we make the request ourselves and keep id_token as an
+// extra field for NewClientJWTFromOauth2.
+func requestToken(ctx context.Context, conf *oauth2.Config, v url.Values) (*oauth2.Token, error) {
+	v.Set("client_id", conf.ClientID)
+	if conf.ClientSecret != "" {
+		v.Set("client_secret", conf.ClientSecret)
+	}
+
+	tr := struct {
+		AccessToken  string      `json:"access_token"`
+		TokenType    string      `json:"token_type"`
+		RefreshToken string      `json:"refresh_token"`
+		ExpiresIn    json.Number `json:"expires_in"`
+		IDToken      string      `json:"id_token"`
+	}{}
+	if err := postForm(ctx, conf.Endpoint.TokenURL, v, &tr); err != nil {
+		return nil, err
+	}
+	if tr.AccessToken == "" {
+		return nil, errMissingToken
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  tr.AccessToken,
+		TokenType:    tr.TokenType,
+		RefreshToken: tr.RefreshToken,
+	}
+	if secs, err := tr.ExpiresIn.Int64(); err == nil && secs > 0 {
+		token.Expiry = time.Now().Add(time.Duration(secs) * time.Second)
+	}
+
+	return token.WithExtra(map[string]interface{}{
+		"id_token": tr.IDToken,
+	}), nil
+}
+
+// postForm posts a form to endpoint and decodes a JSON response into out. OAuth
+// error responses are returned as a *TokenError.
+func postForm(ctx context.Context, endpoint string, v url.Values, out interface{}) error {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		tokErr := &TokenError{}
+		if err := json.Unmarshal(body, tokErr); err != nil || tokErr.Code == "" {
+			return fmt.Errorf("oauth2: unexpected status %s from %s", resp.Status, endpoint)
+		}
+		return tokErr
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
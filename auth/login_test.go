@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// fakeAuthServer is a local authorization server. Device authorization
+// requests get device, and token requests are answered by token, which gets
+// the posted form.
+type fakeAuthServer struct {
+	*httptest.Server
+
+	device *DeviceCode
+
+	mu        sync.Mutex
+	challenge string
+	tokenAt   []time.Time
+	token     func(form url.Values) (int, interface{})
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	s := &fakeAuthServer{
+		device: &DeviceCode{
+			DeviceCode:      "device",
+			UserCode:        "ABCD-EFGH",
+			VerificationURI: "https://example.com/device",
+			ExpiresIn:       100,
+			Interval:        1,
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("client_id") != "client" {
+			t.Errorf("device request has form %v", r.Form)
+		}
+		writeJSON(w, http.StatusOK, s.device)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		s.mu.Lock()
+		s.tokenAt = append(s.tokenAt, time.Now())
+		status, body := s.token(r.Form)
+		s.mu.Unlock()
+		writeJSON(w, status, body)
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *fakeAuthServer) config() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  s.URL + "/auth",
+			TokenURL: s.URL + "/token",
+		},
+		Scopes: []string{"email"},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func tokenResponse() (int, interface{}) {
+	return http.StatusOK, map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     "id",
+	}
+}
+
+// browser plays the user's browser: it checks the authorization URL and
+// follows the redirect back with code, replacing the state if state is set.
+func browser(t *testing.T, s *fakeAuthServer, code, state string) func(string) error {
+	return func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		if q.Get("code_challenge_method") != "S256" {
+			t.Errorf("code_challenge_method is %q, want S256", q.Get("code_challenge_method"))
+		}
+		s.mu.Lock()
+		s.challenge = q.Get("code_challenge")
+		s.mu.Unlock()
+
+		if state == "" {
+			state = q.Get("state")
+		}
+		redirect := q.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {state}}.Encode()
+		resp, err := http.Get(redirect)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+}
+
+func TestLoopbackLogin(t *testing.T) {
+	s := newFakeAuthServer(t)
+	defer s.Close()
+	s.token = func(form url.Values) (int, interface{}) {
+		sum := sha256.Sum256([]byte(form.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != s.challenge {
+			return http.StatusBadRequest, &TokenError{Code: "invalid_grant", Description: "code verifier does not match"}
+		}
+		if form.Get("code") != "code" || form.Get("grant_type") != "authorization_code" {
+			return http.StatusBadRequest, &TokenError{Code: "invalid_grant"}
+		}
+		return tokenResponse()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	token, err := LoopbackLogin(ctx, s.config(), browser(t, s, "code", ""), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access" || token.Extra("id_token") != "id" {
+		t.Errorf("got token %+v with id_token %v", token, token.Extra("id_token"))
+	}
+	if token.Expiry.Before(time.Now()) {
+		t.Errorf("token expires at %v, want an hour from now", token.Expiry)
+	}
+}
+
+func TestLoopbackLoginStateMismatch(t *testing.T) {
+	s := newFakeAuthServer(t)
+	defer s.Close()
+	s.token = func(form url.Values) (int, interface{}) {
+		t.Error("token requested after a state mismatch")
+		return tokenResponse()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := LoopbackLogin(ctx, s.config(), browser(t, s, "code", "forged"), zap.NewNop())
+	if err != errStateMismatch {
+		t.Errorf("got error %v, want %v", err, errStateMismatch)
+	}
+}
+
+func TestDeviceLogin(t *testing.T) {
+	defer func(unit time.Duration) { devicePollUnit = unit }(devicePollUnit)
+	devicePollUnit = 10 * time.Millisecond
+
+	tests := []struct {
+		name      string
+		responses []string
+		// wantErr is nil for a token
+		wantErr error
+		// slowed is the index of the request that should come late
+		slowed int
+	}{
+		{name: "pending", responses: []string{"authorization_pending", "authorization_pending", ""}},
+		{name: "slow down", responses: []string{"slow_down", ""}, slowed: 1},
+		{name: "expired", responses: []string{"authorization_pending", "expired_token"}, wantErr: errDeviceExpired},
+		{name: "denied", responses: []string{"access_denied"}, wantErr: &TokenError{Code: "access_denied"}},
+	}
+	for _, tt := range tests {
+		s := newFakeAuthServer(t)
+		calls := 0
+		s.token = func(form url.Values) (int, interface{}) {
+			if form.Get("grant_type") != deviceGrantType || form.Get("device_code") != "device" {
+				t.Errorf("%s: token request has form %v", tt.name, form)
+			}
+			code := tt.responses[calls]
+			calls++
+			if code == "" {
+				return tokenResponse()
+			}
+			return http.StatusBadRequest, &TokenError{Code: code}
+		}
+
+		var prompted *DeviceCode
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		token, err := DeviceLogin(ctx, s.config(), s.URL+"/device", func(dc *DeviceCode) { prompted = dc }, zap.NewNop())
+		cancel()
+		s.Close()
+
+		if prompted == nil || prompted.UserCode != "ABCD-EFGH" || prompted.URL() != "https://example.com/device" {
+			t.Errorf("%s: prompted with %+v", tt.name, prompted)
+		}
+		if calls != len(tt.responses) {
+			t.Errorf("%s: %d token requests, want %d", tt.name, calls, len(tt.responses))
+		}
+		switch want := tt.wantErr.(type) {
+		case nil:
+			if err != nil || token.AccessToken != "access" {
+				t.Errorf("%s: got token %v and error %v", tt.name, token, err)
+			}
+		case *TokenError:
+			if got, ok := err.(*TokenError); !ok || got.Code != want.Code {
+				t.Errorf("%s: got error %v, want %v", tt.name, err, want)
+			}
+		default:
+			if err != want {
+				t.Errorf("%s: got error %v, want %v", tt.name, err, want)
+			}
+		}
+		if tt.slowed > 0 {
+			gap := s.tokenAt[tt.slowed].Sub(s.tokenAt[tt.slowed-1])
+			if want := (1 + slowDownInterval) * devicePollUnit; gap < want {
+				t.Errorf("%s: polled again after %v, want at least %v", tt.name, gap, want)
+			}
+		}
+	}
+}
+
+func TestDeviceLoginExpires(t *testing.T) {
+	defer func(unit time.Duration) { devicePollUnit = unit }(devicePollUnit)
+	devicePollUnit = time.Millisecond
+
+	s := newFakeAuthServer(t)
+	defer s.Close()
+	s.token = func(form url.Values) (int, interface{}) {
+		return http.StatusBadRequest, &TokenError{Code: "authorization_pending"}
+	}
+	s.device.ExpiresIn = 50
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := DeviceLogin(ctx, s.config(), s.URL+"/device", func(*DeviceCode) {}, zap.NewNop())
+	if err != errDeviceExpired {
+		t.Errorf("got error %v, want %v", err, errDeviceExpired)
+	}
+}
@@ -0,0 +1,72 @@
+package spree
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/golang/protobuf/proto"
+)
+
+func (b *BoltKV) PutAlbum(album *Album) error {
+	data, err := proto.Marshal(album)
+	if err != nil {
+		return err
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(albumsBucket)).Put([]byte(album.Id), data)
+	})
+	if err != nil {
+		return err
+	}
+	album.Path = albumPath(album.Id)
+	return nil
+}
+
+func (b *BoltKV) GetAlbumById(id string) (*Album, error) {
+	album := &Album{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(albumsBucket)).Get([]byte(id))
+		if v == nil {
+			return ErrAlbumNotFound
+		}
+		return proto.Unmarshal(v, album)
+	})
+	if err != nil {
+		return nil, err
+	}
+	album.Path = albumPath(album.Id)
+	return album, nil
+}
+
+func (b *BoltKV) UpdateAlbum(id string, fn func(*Album) error) (*Album, error) {
+	album := &Album{}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(albumsBucket))
+		v := bkt.Get([]byte(id))
+		if v == nil {
+			return ErrAlbumNotFound
+		}
+		if err := proto.Unmarshal(v, album); err != nil {
+			return err
+		}
+		if err := fn(album); err != nil {
+			return err
+		}
+		album.Id = id
+
+		data, err := proto.Marshal(album)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(id), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	album.Path = albumPath(album.Id)
+	return album, nil
+}
+
+func albumPath(id string) string {
+	return fmt.Sprintf("%s/%s", albumPathPrefix, id)
+}
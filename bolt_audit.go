@@ -0,0 +1,130 @@
+package spree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/boltdb/bolt"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	auditBucket = "audit"
+)
+
+var _ AuditStore = &BoltKV{}
+
+// auditKey orders records by time. The bucket sequence breaks ties between
+// records written in the same nanosecond.
+func auditKey(t time.Time, seq uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(k[8:], seq)
+	return k
+}
+
+func (b *BoltKV) AppendAudit(recs ...*AuditRecord) error {
+	times := make([]time.Time, len(recs))
+	data := make([][]byte, len(recs))
+	for i, rec := range recs {
+		t, err := time.Parse(time.RFC3339Nano, rec.Time)
+		if err != nil {
+			return err
+		}
+		d, err := proto.Marshal(rec)
+		if err != nil {
+			b.ll.Error("could not marshal audit record", zap.Error(err))
+			return err
+		}
+		times[i], data[i] = t, d
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(auditBucket))
+		for i := range data {
+			seq, err := bkt.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := bkt.Put(auditKey(times[i], seq), data[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltKV) PruneAudit(before time.Time) (int, error) {
+	max := auditKey(before, 0)
+	var n int
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(auditBucket)).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, max) < 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (b *BoltKV) QueryAudit(since, until time.Time, identity string, limit int) ([]*AuditRecord, error) {
+	var min, max []byte
+	if !since.IsZero() {
+		min = auditKey(since, 0)
+	}
+	if !until.IsZero() {
+		max = auditKey(until, ^uint64(0))
+	}
+
+	// walk backwards from the end of the range so limit keeps the newest
+	recs := make([]*AuditRecord, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(auditBucket)).Cursor()
+
+		var k, v []byte
+		if max == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Seek(max)
+			if k == nil {
+				k, v = c.Last()
+			} else if bytes.Compare(k, max) > 0 {
+				k, v = c.Prev()
+			}
+		}
+
+		for ; k != nil && len(recs) < limit; k, v = c.Prev() {
+			if min != nil && bytes.Compare(k, min) < 0 {
+				break
+			}
+			rec := &AuditRecord{}
+			if err := proto.Unmarshal(v, rec); err != nil {
+				b.ll.Error("could not unmarshal audit record", zap.Error(err))
+				continue
+			}
+			if identity != "" && !strings.EqualFold(rec.Identity, identity) {
+				continue
+			}
+			recs = append(recs, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(recs)-1; i < j; i, j = i+1, j-1 {
+		recs[i], recs[j] = recs[j], recs[i]
+	}
+	return recs, nil
+}
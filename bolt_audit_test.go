@@ -0,0 +1,182 @@
+package spree_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/gorilla/mux"
+	"github.com/ralfonso/spree"
+	"golang.org/x/net/context"
+)
+
+func TestBoltKVQueryAudit(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	identities := []string{"a@example.com", "B@example.com", "a@example.com", "b@example.com", "a@example.com"}
+	for i, identity := range identities {
+		rec := &spree.AuditRecord{
+			Time:     start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339Nano),
+			Identity: identity,
+			Method:   "Create",
+			ShotId:   string('0' + rune(i)),
+		}
+		if err := md.AppendAudit(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	at := func(minute int) time.Time { return start.Add(time.Duration(minute) * time.Minute) }
+	tests := []struct {
+		name         string
+		since, until time.Time
+		identity     string
+		limit        int
+		want         string
+	}{
+		{name: "everything", limit: 10, want: "01234"},
+		{name: "since", since: at(2), limit: 10, want: "234"},
+		{name: "until", until: at(1), limit: 10, want: "01"},
+		{name: "range", since: at(1), until: at(3), limit: 10, want: "123"},
+		{name: "between records", since: at(1).Add(time.Second), until: at(3).Add(-time.Second), limit: 10, want: "2"},
+		{name: "identity", identity: "b@example.com", limit: 10, want: "13"},
+		{name: "identity in range", identity: "A@example.com", since: at(1), limit: 10, want: "24"},
+		{name: "limit keeps the newest", limit: 2, want: "34"},
+		{name: "limit with filters", identity: "a@example.com", until: at(3), limit: 1, want: "2"},
+		{name: "empty range", since: at(10), limit: 10, want: ""},
+	}
+	for _, tt := range tests {
+		recs, err := md.QueryAudit(tt.since, tt.until, tt.identity, tt.limit)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got string
+		for _, rec := range recs {
+			got += rec.ShotId
+		}
+		if got != tt.want {
+			t.Errorf("%s: got records %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// presignedStorage presigns links to an imaginary backend.
+type presignedStorage struct {
+	spree.Storage
+}
+
+func (presignedStorage) PresignGet(key string, expires time.Duration) (string, error) {
+	return "https://backend.example.com/" + key, nil
+}
+
+func TestAuditHTTP(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+	audit, err := spree.NewAuditLog(md, "", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := spree.NewMemoryStorage()
+	if _, err := storage.Put(context.Background(), "abc-shot.png", bytes.NewReader([]byte("png"))); err != nil {
+		t.Fatal(err)
+	}
+
+	proxies, err := spree.ParseTrustedProxies([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		storage spree.Storage
+		path    string
+		from    string
+		status  int
+		method  string
+		result  string
+	}{
+		{storage, "/r/abc-shot.png", "192.0.2.1", http.StatusOK, "ServeFile", "ok"},
+		{storage, "/r/missing.png", "192.0.2.1", http.StatusNotFound, "ServeFile", "Not Found"},
+		{presignedStorage{storage}, "/r/abc-shot.png", "192.0.2.1", http.StatusFound, "RedirectFile", "ok"},
+		{storage, "/r/abc-shot.png", "10.0.0.1", http.StatusOK, "ServeFile", "ok"},
+	} {
+		s := spree.NewHTTPServer("", md, spree.NewViewCounter(md, zap.NewNop()), tt.storage, audit, nil, time.Minute, proxies, zap.NewNop())
+		r := mux.NewRouter()
+		r.HandleFunc("/r/{filename}", s.DirectHandler)
+
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.RemoteAddr = tt.from + ":1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s got status %d, want %d", tt.path, w.Code, tt.status)
+		}
+
+		// web requests are only written when the log is flushed
+		before, err := md.QueryAudit(time.Time{}, time.Time{}, "", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := audit.Flush(); err != nil || n != 1 {
+			t.Fatalf("flushing %s wrote %d records: %v", tt.path, n, err)
+		}
+		recs, err := md.QueryAudit(time.Time{}, time.Time{}, "", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recs) != len(before)+1 {
+			t.Fatalf("%s wasn't audited", tt.path)
+		}
+		want := tt.from
+		if tt.from == "10.0.0.1" {
+			want = "198.51.100.7"
+		}
+		rec := recs[len(recs)-1]
+		if rec.Method != tt.method || rec.Result != tt.result || rec.Key != tt.path[len("/r/"):] || rec.PeerAddr != want {
+			t.Errorf("%s from %s audited as %+v", tt.path, tt.from, rec)
+		}
+	}
+}
+
+func TestBoltKVPruneAudit(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	var recs []*spree.AuditRecord
+	for i := 0; i < 5; i++ {
+		recs = append(recs, &spree.AuditRecord{
+			Time:   start.Add(time.Duration(i) * time.Hour).Format(time.RFC3339Nano),
+			Method: "Create",
+			ShotId: string('0' + rune(i)),
+		})
+	}
+	if err := md.AppendAudit(recs...); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := md.PruneAudit(start.Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("pruned %d records, want 2", n)
+	}
+	kept, err := md.QueryAudit(time.Time{}, time.Time{}, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	for _, rec := range kept {
+		got += rec.ShotId
+	}
+	if got != "234" {
+		t.Errorf("kept records %q, want %q", got, "234")
+	}
+}
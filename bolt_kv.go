@@ -1,8 +1,12 @@
 package spree
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -14,10 +18,35 @@ import (
 
 const (
 	hashidSalt string = "celery"
+
+	ownersBucket    = "owners"
+	tagsBucket      = "tags"
+	searchBucket    = "search"
+	albumsBucket    = "albums"
+	metaBucket      = "meta"
+	viewStatsBucket = "view_stats"
 )
 
 var (
 	h *hashids.HashID
+
+	// ErrDBLocked is returned by LockDB when another process, usually a
+	// running spreed, has the database open.
+	ErrDBLocked = errors.New("database is in use by another process")
+
+	// boltBuckets are created alongside the shot bucket
+	boltBuckets = []string{
+		ownersBucket,
+		tagsBucket,
+		searchBucket,
+		albumsBucket,
+		metaBucket,
+		viewStatsBucket,
+		revokedIdentitiesBucket,
+		revokedTokensBucket,
+		clientsBucket,
+		auditBucket,
+	}
 )
 
 func init() {
@@ -27,10 +56,30 @@ func init() {
 	h = hashids.NewWithData(hd)
 }
 
+// LockDB takes the lock a running server holds on dbFile, for commands that
+// must not run alongside one. It gives up with ErrDBLocked after timeout.
+// Closing the returned io.Closer releases the lock.
+func LockDB(dbFile string, timeout time.Duration) (io.Closer, error) {
+	db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: timeout})
+	if err == bolt.ErrTimeout {
+		return nil, ErrDBLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
 type BoltKV struct {
 	ll     *zap.Logger
 	db     *bolt.DB
 	bucket string
+
+	seenMu    sync.Mutex
+	seen      map[string]clientSeen
+	seenSwept time.Time
+
+	legacyOwner string
 }
 
 var _ Metadata = &BoltKV{}
@@ -42,28 +91,40 @@ func NewBoltKV(dbFile, dbBucketName string, ll *zap.Logger) (*BoltKV, error) {
 		return nil, err
 	}
 
-	var b *bolt.Bucket
-	db.Update(func(tx *bolt.Tx) error {
-		b, err = tx.CreateBucketIfNotExists([]byte(dbBucketName))
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range append([]string{dbBucketName}, boltBuckets...) {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return fmt.Errorf("create bucket %s: %s", name, err)
+			}
 		}
 		return nil
 	})
+	if err != nil {
+		ll.Error("could not create buckets", zap.Error(err))
+		db.Close()
+		return nil, err
+	}
 
 	return &BoltKV{
 		ll:     ll,
 		db:     db,
 		bucket: dbBucketName,
+		seen:   make(map[string]clientSeen),
 	}, nil
 }
 
-func (b *BoltKV) GetId(shot *Shot) string {
+// newShotId returns a short, unguessable id for a new shot.
+func newShotId() string {
 	now := time.Now().UTC().UnixNano()
 	id, _ := h.EncodeInt64([]int64{now, rand.Int63()})
 	return id
 }
 
+func (b *BoltKV) GetId(shot *Shot) string {
+	return newShotId()
+}
+
 func (b *BoltKV) Close() error {
 	b.ll.Info("Shutting down BoltDB")
 	return b.db.Close()
@@ -71,23 +132,141 @@ func (b *BoltKV) Close() error {
 
 func (b *BoltKV) PutShot(shot *Shot) error {
 	err := b.db.Update(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket([]byte(b.bucket))
-		data, err := proto.Marshal(shot)
-		if err != nil {
-			b.ll.Error("could not marshal proto file in PutFile", zap.Error(err))
+		return b.putShot(tx, shot)
+	})
+
+	return err
+}
+
+// putShot writes shot and keeps the owner, tag and search indexes in step
+// with it.
+func (b *BoltKV) putShot(tx *bolt.Tx, shot *Shot) error {
+	bkt := tx.Bucket([]byte(b.bucket))
+	data, err := proto.Marshal(shot)
+	if err != nil {
+		b.ll.Error("could not marshal proto file in PutFile", zap.Error(err))
+		return err
+	}
+
+	if v := bkt.Get([]byte(shot.Id)); v != nil {
+		old := &Shot{}
+		if err := proto.Unmarshal(v, old); err == nil {
+			if err := unindexShot(tx, old, shot); err != nil {
+				return err
+			}
+		}
+	}
+
+	err = bkt.Put([]byte(shot.Id), data)
+	if err != nil {
+		b.ll.Error("could not PutFile in BoltDB", zap.Error(err))
+		return err
+	}
+
+	if err := indexShot(tx, shot); err != nil {
+		b.ll.Error("could not index shot", zap.Error(err))
+		return err
+	}
+
+	shot.Path = fmt.Sprintf("/p/%s", shot.Id)
+	return nil
+}
+
+// indexShot adds shot to the owner, tag and search indexes.
+func indexShot(tx *bolt.Tx, shot *Shot) error {
+	if err := indexOwner(tx, shot.Owner, shot.Id); err != nil {
+		return err
+	}
+	for _, tag := range shot.Tags {
+		if err := indexKey(tx, tagsBucket, tag, shot.Id); err != nil {
+			return err
+		}
+	}
+	for _, term := range shotTerms(shot) {
+		if err := indexKey(tx, searchBucket, term, shot.Id); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		err = bkt.Put([]byte(shot.Id), data)
-		if err != nil {
-			b.ll.Error("could not PutFile in BoltDB", zap.Error(err))
+// unindexShot removes old from the indexes, apart from the entries its
+// replacement shot also has. shot is nil when old is being deleted.
+func unindexShot(tx *bolt.Tx, old, shot *Shot) error {
+	if shot == nil {
+		shot = &Shot{}
+	}
+	if old.Owner != shot.Owner {
+		if err := unindexOwner(tx, old.Owner, old.Id); err != nil {
 			return err
 		}
-		shot.Path = fmt.Sprintf("/p/%s", shot.Id)
+	}
+	for _, tag := range old.Tags {
+		if !hasTag(shot, tag) {
+			if err := unindexKey(tx, tagsBucket, tag, old.Id); err != nil {
+				return err
+			}
+		}
+	}
+
+	keep := make(map[string]bool)
+	for _, term := range shotTerms(shot) {
+		keep[term] = true
+	}
+	for _, term := range shotTerms(old) {
+		if !keep[term] {
+			if err := unindexKey(tx, searchBucket, term, old.Id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func indexOwner(tx *bolt.Tx, owner, id string) error {
+	if owner == "" {
 		return nil
-	})
+	}
+	obkt, err := tx.Bucket([]byte(ownersBucket)).CreateBucketIfNotExists(emailKey(owner))
+	if err != nil {
+		return err
+	}
+	return obkt.Put([]byte(id), []byte{})
+}
 
-	return err
+func unindexOwner(tx *bolt.Tx, owner, id string) error {
+	if owner == "" {
+		return nil
+	}
+	obkt := tx.Bucket([]byte(ownersBucket)).Bucket(emailKey(owner))
+	if obkt == nil {
+		return nil
+	}
+	return obkt.Delete([]byte(id))
+}
+
+// indexKey lists the shot id under key in the index bucket.
+func indexKey(tx *bolt.Tx, index, key, id string) error {
+	kbkt, err := tx.Bucket([]byte(index)).CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		return err
+	}
+	return kbkt.Put([]byte(id), []byte{})
+}
+
+func unindexKey(tx *bolt.Tx, index, key, id string) error {
+	kbkt := tx.Bucket([]byte(index)).Bucket([]byte(key))
+	if kbkt == nil {
+		return nil
+	}
+	if err := kbkt.Delete([]byte(id)); err != nil {
+		return err
+	}
+	// drop the key once nothing is listed under it
+	if k, _ := kbkt.Cursor().First(); k == nil {
+		return tx.Bucket([]byte(index)).DeleteBucket([]byte(key))
+	}
+	return nil
 }
 
 func (b *BoltKV) ListShots() ([]*Shot, error) {
@@ -97,7 +276,7 @@ func (b *BoltKV) ListShots() ([]*Shot, error) {
 		c := bkt.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			b.ll.Info("found shot", zap.String("shot.id", string(k)))
+			b.ll.Debug("found shot", zap.String("shot.id", string(k)))
 			shot := &Shot{}
 			err := proto.Unmarshal(v, shot)
 			if err != nil {
@@ -118,16 +297,66 @@ func (b *BoltKV) ListShots() ([]*Shot, error) {
 	return shots, nil
 }
 
+func (b *BoltKV) ListShotsByOwner(owner string) ([]*Shot, error) {
+	return b.listIndexed(ownersBucket, emailKey(owner))
+}
+
+func (b *BoltKV) ListShotsByTag(tag string) ([]*Shot, error) {
+	return b.listIndexed(tagsBucket, []byte(tag))
+}
+
+// listIndexed returns the shots listed under key in the index bucket.
+func (b *BoltKV) listIndexed(index string, key []byte) ([]*Shot, error) {
+	shots := make([]*Shot, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		ibkt := tx.Bucket([]byte(index)).Bucket(key)
+		if ibkt == nil {
+			return nil
+		}
+
+		bkt := tx.Bucket([]byte(b.bucket))
+		c := ibkt.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			v := bkt.Get(k)
+			if v == nil {
+				b.ll.Warn("index refers to missing shot", zap.String("index", index), zap.String("shot.id", string(k)))
+				continue
+			}
+			shot := &Shot{}
+			err := proto.Unmarshal(v, shot)
+			if err != nil {
+				b.ll.Error("could not marshal proto file in listIndexed", zap.Error(err))
+				continue
+			}
+			shot.Path = fmt.Sprintf("/p/%s", shot.Id)
+			shots = append(shots, shot)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return shots, nil
+}
+
+// SetLegacyOwner sets who Migrate assigns shots uploaded before ownership
+// was recorded to.
+func (b *BoltKV) SetLegacyOwner(owner string) {
+	b.legacyOwner = strings.ToLower(owner)
+}
+
 func (b *BoltKV) GetShotById(id string) (*Shot, error) {
 	shot := &Shot{}
 	err := b.db.View(func(tx *bolt.Tx) error {
 		bkt := tx.Bucket([]byte(b.bucket))
 		v := bkt.Get([]byte(id))
-		err := proto.Unmarshal(v, shot)
-		if err != nil {
-			return err
+		if v == nil {
+			return ErrNotFound
 		}
-		return nil
+		return proto.Unmarshal(v, shot)
 	})
 
 	if err != nil {
@@ -138,19 +367,24 @@ func (b *BoltKV) GetShotById(id string) (*Shot, error) {
 	return shot, nil
 }
 
+// IncrementViews adds a view to the shot in its own transaction. It isn't
+// part of Metadata, which counts views in batches with AddViews; it is kept
+// to compare against in BenchmarkPutShotWhileViewed.
 func (b *BoltKV) IncrementViews(id string) (*Shot, error) {
 	shot := &Shot{}
-	var err error
-	b.db.Update(func(tx *bolt.Tx) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
 		bkt := tx.Bucket([]byte(b.bucket))
 		v := bkt.Get([]byte(id))
-		err = proto.Unmarshal(v, shot)
+		if v == nil {
+			return ErrNotFound
+		}
 
-		if err != nil {
+		if err := proto.Unmarshal(v, shot); err != nil {
 			return err
 		}
 
 		shot.Views++
+		shot.LastViewedAt = time.Now().UTC().Format(time.RFC3339)
 
 		data, err := proto.Marshal(shot)
 		if err != nil {
@@ -167,5 +401,150 @@ func (b *BoltKV) IncrementViews(id string) (*Shot, error) {
 		return nil
 	})
 
-	return shot, err
+	if err != nil {
+		return nil, err
+	}
+	return shot, nil
+}
+
+// AddViews applies a batch of views in a single transaction, so Bolt syncs
+// once per batch rather than once per view.
+func (b *BoltKV) AddViews(views map[string]*ViewStats, at time.Time) error {
+	lastViewed := at.UTC().Format(time.RFC3339)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(b.bucket))
+		sbkt := tx.Bucket([]byte(viewStatsBucket))
+		for id, delta := range views {
+			v := bkt.Get([]byte(id))
+			if v == nil {
+				// deleted since it was viewed
+				continue
+			}
+
+			shot := &Shot{}
+			if err := proto.Unmarshal(v, shot); err != nil {
+				b.ll.Error("could not unmarshal shot in AddViews", zap.String("shot.id", id), zap.Error(err))
+				continue
+			}
+			shot.Views += delta.Views
+			shot.CrawlerViews += delta.CrawlerViews
+			if delta.Views > 0 {
+				shot.LastViewedAt = lastViewed
+			}
+			if delta.Reads > 0 {
+				shot.LastReadAt = lastViewed
+			}
+
+			data, err := proto.Marshal(shot)
+			if err != nil {
+				return err
+			}
+			if err := bkt.Put([]byte(id), data); err != nil {
+				return err
+			}
+
+			stats := &ViewStats{}
+			if v := sbkt.Get([]byte(id)); v != nil {
+				if err := proto.Unmarshal(v, stats); err != nil {
+					b.ll.Error("could not unmarshal view stats, starting over", zap.String("shot.id", id), zap.Error(err))
+					stats = &ViewStats{}
+				}
+			}
+			data, err = proto.Marshal(mergeViewStats(stats, delta))
+			if err != nil {
+				return err
+			}
+			if err := sbkt.Put([]byte(id), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltKV) GetViewStats(id string) (*ViewStats, error) {
+	stats := &ViewStats{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(b.bucket)).Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		v := tx.Bucket([]byte(viewStatsBucket)).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		return proto.Unmarshal(v, stats)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (b *BoltKV) UpdateShot(id string, fn func(*Shot)) (*Shot, error) {
+	shot := &Shot{}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(b.bucket)).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		if err := proto.Unmarshal(v, shot); err != nil {
+			return err
+		}
+		fn(shot)
+		shot.Id = id
+		return b.putShot(tx, shot)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shot, nil
+}
+
+// DeleteShot removes the shot, its index entries and its view stats.
+func (b *BoltKV) DeleteShot(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(b.bucket))
+		v := bkt.Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+
+		old := &Shot{}
+		if err := proto.Unmarshal(v, old); err != nil {
+			// RebuildIndexes clears up whatever is left in the indexes
+			b.ll.Warn("deleting unreadable shot", zap.String("shot.id", id), zap.Error(err))
+		} else if err := unindexShot(tx, old, nil); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(viewStatsBucket)).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return bkt.Delete([]byte(id))
+	})
+}
+
+// SetBackendType records where a shot's file is stored. It only touches the
+// backend so it can't race with view counting.
+func (b *BoltKV) SetBackendType(id, backendType string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(b.bucket))
+		v := bkt.Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+
+		shot := &Shot{}
+		if err := proto.Unmarshal(v, shot); err != nil {
+			return err
+		}
+		shot.Backend = &BackendDetails{
+			Type: backendType,
+		}
+
+		data, err := proto.Marshal(shot)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(id), data)
+	})
 }
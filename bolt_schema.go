@@ -0,0 +1,234 @@
+package spree
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+)
+
+const (
+	schemaVersionKey = "schema_version"
+)
+
+// Migration upgrades a BoltKV database by one schema version.
+type Migration struct {
+	Version     int
+	Description string
+	run         func(b *BoltKV, tx *bolt.Tx) error
+}
+
+// migrations are applied in order, each in its own transaction. Append new
+// ones; a migration that has shipped must never change.
+var migrations = []Migration{
+	{1, "index shots by owner", migrateOwnerIndex},
+	{2, "index shots for search", migrateSearchIndex},
+	{3, "assign shots without an owner to the legacy owner", migrateOwners},
+	{4, "record the storage key of each shot", migrateStorageKeys},
+}
+
+// SchemaVersion is the schema version this build of spree writes.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the schema version recorded in the database.
+// Databases created before versioning are version 0.
+func (b *BoltKV) SchemaVersion() (int, error) {
+	var version int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		version, err = schemaVersion(tx)
+		return err
+	})
+	return version, err
+}
+
+func schemaVersion(tx *bolt.Tx) (int, error) {
+	v := tx.Bucket([]byte(metaBucket)).Get([]byte(schemaVersionKey))
+	if v == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q: %s", v, err)
+	}
+	if version > SchemaVersion() {
+		return 0, fmt.Errorf("database schema version %d is newer than the %d this spree supports", version, SchemaVersion())
+	}
+	return version, nil
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	return tx.Bucket([]byte(metaBucket)).Put([]byte(schemaVersionKey), []byte(strconv.Itoa(version)))
+}
+
+// PendingMigrations returns the migrations Migrate would apply, in order.
+func (b *BoltKV) PendingMigrations() ([]Migration, error) {
+	var pending []Migration
+	err := b.db.View(func(tx *bolt.Tx) error {
+		version, err := schemaVersion(tx)
+		if err != nil || b.unversionedEmpty(tx, version) {
+			return err
+		}
+		pending = pendingMigrations(version)
+		return nil
+	})
+	return pending, err
+}
+
+// unversionedEmpty reports whether the database is new, with no shots to
+// migrate.
+func (b *BoltKV) unversionedEmpty(tx *bolt.Tx, version int) bool {
+	if version != 0 {
+		return false
+	}
+	k, _ := tx.Bucket([]byte(b.bucket)).Cursor().First()
+	return k == nil
+}
+
+func pendingMigrations(version int) []Migration {
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// Migrate brings the database up to SchemaVersion. The database is copied
+// to backupFile before the first migration runs; backupFile is empty when
+// nothing needed migrating. A database without any shots has nothing to
+// migrate and is stamped with the current version.
+func (b *BoltKV) Migrate() (applied []Migration, backupFile string, err error) {
+	var version int
+	var empty bool
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		version, err = schemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if b.unversionedEmpty(tx, version) {
+			empty = true
+			return setSchemaVersion(tx, SchemaVersion())
+		}
+		return nil
+	})
+	if err != nil || empty {
+		return nil, "", err
+	}
+
+	pending := pendingMigrations(version)
+	if len(pending) == 0 {
+		return nil, "", nil
+	}
+
+	backupFile = fmt.Sprintf("%s.v%d-%s.bak", b.db.Path(), version, time.Now().UTC().Format("20060102T150405Z"))
+	err = b.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(backupFile, 0600)
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("back up database: %s", err)
+	}
+	b.ll.Info("backed up database", zap.String("file", backupFile))
+
+	for _, m := range pending {
+		ll := b.ll.With(zap.Int("version", m.Version), zap.String("migration", m.Description))
+		err := b.db.Update(func(tx *bolt.Tx) error {
+			if err := m.run(b, tx); err != nil {
+				return err
+			}
+			return setSchemaVersion(tx, m.Version)
+		})
+		if err != nil {
+			ll.Error("migration failed", zap.Error(err))
+			return applied, backupFile, fmt.Errorf("migration %d (%s): %s", m.Version, m.Description, err)
+		}
+		ll.Info("applied migration")
+		applied = append(applied, m)
+	}
+	return applied, backupFile, nil
+}
+
+// migrateOwnerIndex indexes shots written before the owner index existed.
+func migrateOwnerIndex(b *BoltKV, tx *bolt.Tx) error {
+	return tx.Bucket([]byte(b.bucket)).ForEach(func(k, v []byte) error {
+		shot := &Shot{}
+		if err := proto.Unmarshal(v, shot); err != nil {
+			b.ll.Warn("skipping unreadable shot", zap.String("shot.id", string(k)), zap.Error(err))
+			return nil
+		}
+		return indexOwner(tx, shot.Owner, string(k))
+	})
+}
+
+// migrateSearchIndex indexes shots written before search existed.
+func migrateSearchIndex(b *BoltKV, tx *bolt.Tx) error {
+	_, err := b.rebuildIndexes(tx)
+	return err
+}
+
+// migrateOwners assigns shots uploaded before ownership was recorded to the
+// owner given to SetLegacyOwner. They are otherwise invisible to List.
+func migrateOwners(b *BoltKV, tx *bolt.Tx) error {
+	var unowned []*Shot
+	err := tx.Bucket([]byte(b.bucket)).ForEach(func(k, v []byte) error {
+		shot := &Shot{}
+		if err := proto.Unmarshal(v, shot); err != nil {
+			b.ll.Warn("skipping unreadable shot", zap.String("shot.id", string(k)), zap.Error(err))
+			return nil
+		}
+		if shot.Owner == "" {
+			unowned = append(unowned, shot)
+		}
+		return nil
+	})
+	if err != nil || len(unowned) == 0 {
+		return err
+	}
+	if b.legacyOwner == "" {
+		return fmt.Errorf("%d shots have no owner; start spreed with --migrate.owner=<email> to assign them", len(unowned))
+	}
+
+	for _, shot := range unowned {
+		shot.Owner = b.legacyOwner
+		if err := b.putShot(tx, shot); err != nil {
+			return err
+		}
+	}
+	b.ll.Info("assigned owners to existing shots", zap.String("owner", b.legacyOwner), zap.Int("count", len(unowned)))
+	return nil
+}
+
+// migrateStorageKeys records where shots uploaded before storage keys
+// included the id are stored: under their filename.
+func migrateStorageKeys(b *BoltKV, tx *bolt.Tx) error {
+	var legacy []*Shot
+	err := tx.Bucket([]byte(b.bucket)).ForEach(func(k, v []byte) error {
+		shot := &Shot{}
+		if err := proto.Unmarshal(v, shot); err != nil {
+			b.ll.Warn("skipping unreadable shot", zap.String("shot.id", string(k)), zap.Error(err))
+			return nil
+		}
+		if shot.StorageKey == "" {
+			legacy = append(legacy, shot)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, shot := range legacy {
+		shot.StorageKey = shot.Filename
+		if err := b.putShot(tx, shot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package spree_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+)
+
+func openBoltKV(t *testing.T, dbFile string) *spree.BoltKV {
+	md, err := spree.NewBoltKV(dbFile, "spree", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return md
+}
+
+func TestBoltKVMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spree-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a database written before schema versions were recorded
+	dbFile := filepath.Join(dir, "spree.boltdb")
+	md := openBoltKV(t, dbFile)
+	shot := &spree.Shot{Id: "abc", Filename: "shot.png", Owner: "someone@example.com"}
+	if err := md.PutShot(shot); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := md.PendingMigrations()
+	if err != nil {
+		t.Fatalf("PendingMigrations: %v", err)
+	}
+	if len(pending) != spree.SchemaVersion() {
+		t.Fatalf("%d pending migrations, want %d", len(pending), spree.SchemaVersion())
+	}
+	if version, err := md.SchemaVersion(); err != nil || version != 0 {
+		t.Fatalf("SchemaVersion = %d, %v, want 0", version, err)
+	}
+
+	applied, backupFile, err := md.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if len(applied) != len(pending) {
+		t.Errorf("Migrate applied %d migrations, want %d", len(applied), len(pending))
+	}
+	if version, err := md.SchemaVersion(); err != nil || version != spree.SchemaVersion() {
+		t.Errorf("SchemaVersion after Migrate = %d, %v, want %d", version, err, spree.SchemaVersion())
+	}
+	if shots, err := md.ListShotsByOwner("someone@example.com"); err != nil || len(shots) != 1 {
+		t.Errorf("ListShotsByOwner after Migrate = %v, %v", shots, err)
+	}
+
+	// Migrate is a no-op once the database is current
+	applied, again, err := md.Migrate()
+	if err != nil || len(applied) != 0 || again != "" {
+		t.Errorf("second Migrate = %v, %q, %v, want nothing applied", applied, again, err)
+	}
+	md.Close()
+
+	// the backup is the database as it was before migrating
+	backup := openBoltKV(t, backupFile)
+	defer backup.Close()
+	if version, err := backup.SchemaVersion(); err != nil || version != 0 {
+		t.Errorf("backup SchemaVersion = %d, %v, want 0", version, err)
+	}
+	if _, err := backup.GetShotById("abc"); err != nil {
+		t.Errorf("backup is missing the shot: %v", err)
+	}
+}
+
+func TestBoltKVMigrateNewDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spree-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	md := openBoltKV(t, filepath.Join(dir, "spree.boltdb"))
+	defer md.Close()
+
+	if pending, err := md.PendingMigrations(); err != nil || len(pending) != 0 {
+		t.Errorf("PendingMigrations on a new database = %v, %v, want none", pending, err)
+	}
+	applied, backupFile, err := md.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if len(applied) != 0 || backupFile != "" {
+		t.Errorf("Migrate of a new database = %v, %q, want nothing to do", applied, backupFile)
+	}
+	if version, err := md.SchemaVersion(); err != nil || version != spree.SchemaVersion() {
+		t.Errorf("SchemaVersion = %d, %v, want %d", version, err, spree.SchemaVersion())
+	}
+}
+
+func TestBoltKVRebuildIndexes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spree-reindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	md := openBoltKV(t, filepath.Join(dir, "spree.boltdb"))
+	defer md.Close()
+	for _, shot := range []*spree.Shot{
+		{Id: "a", Filename: "crash.png", Owner: "someone@example.com", Tags: []string{"bug"}},
+		{Id: "b", Filename: "logo.svg", Owner: "someone@example.com"},
+	} {
+		if err := md.PutShot(shot); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := md.RebuildIndexes()
+	if err != nil || n != 2 {
+		t.Fatalf("RebuildIndexes = %d, %v, want 2 shots", n, err)
+	}
+	if shots, err := md.ListShotsByOwner("someone@example.com"); err != nil || len(shots) != 2 {
+		t.Errorf("ListShotsByOwner after RebuildIndexes = %v, %v", shots, err)
+	}
+	if shots, err := md.ListShotsByTag("bug"); err != nil || len(shots) != 1 {
+		t.Errorf("ListShotsByTag after RebuildIndexes = %v, %v", shots, err)
+	}
+	q, _ := spree.ParseQuery("crash OR logo")
+	if shots, err := md.SearchShots(q); err != nil || len(shots) != 2 {
+		t.Errorf("SearchShots after RebuildIndexes = %v, %v", shots, err)
+	}
+}
+
+func TestBoltKVMigrateLegacyShots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spree-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	md := openBoltKV(t, filepath.Join(dir, "spree.boltdb"))
+	defer md.Close()
+	// shots from before owners and storage keys were recorded
+	for _, shot := range []*spree.Shot{
+		{Id: "a", Filename: "shot.png"},
+		{Id: "b", Filename: "shot.png", Owner: "someone@example.com"},
+	} {
+		if err := md.PutShot(shot); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := md.Migrate(); err == nil {
+		t.Fatal("Migrate assigned unowned shots without a legacy owner")
+	} else if !strings.Contains(err.Error(), "--migrate.owner") {
+		t.Errorf("Migrate error %q doesn't say how to assign owners", err)
+	}
+	md.SetLegacyOwner("Owner@example.com")
+	if _, _, err := md.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	a, err := md.GetShotById("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Owner != "owner@example.com" || a.StorageKey != "shot.png" {
+		t.Errorf("unowned shot migrated to %+v", a)
+	}
+	b, err := md.GetShotById("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Owner != "someone@example.com" || b.StorageKey != "shot.png" {
+		t.Errorf("owned shot migrated to %+v", b)
+	}
+	if shots, err := md.ListShotsByOwner("owner@example.com"); err != nil || len(shots) != 1 {
+		t.Errorf("ListShotsByOwner after Migrate = %v, %v", shots, err)
+	}
+}
+
+func TestLockDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spree-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbFile := filepath.Join(dir, "spree.db")
+
+	md := openBoltKV(t, dbFile)
+	if _, err := spree.LockDB(dbFile, 10*time.Millisecond); err != spree.ErrDBLocked {
+		t.Fatalf("locking a database in use got error %v", err)
+	}
+	md.Close()
+
+	lock, err := spree.LockDB(dbFile, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("locking a free database: %v", err)
+	}
+	if err := lock.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,105 @@
+package spree
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+)
+
+// SearchShots returns the shots matching q, ordered by id. Each term in the
+// search bucket holds the ids of the shots indexed under it, so a prefix
+// query walks the neighbouring terms with a cursor.
+func (b *BoltKV) SearchShots(q *Query) ([]*Shot, error) {
+	shots := make([]*Shot, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		sbkt := tx.Bucket([]byte(searchBucket))
+		ids, err := q.eval(func(term string, prefix bool) ([]string, error) {
+			if !prefix {
+				return bucketKeys(sbkt.Bucket([]byte(term))), nil
+			}
+			var ids []string
+			c := sbkt.Cursor()
+			for k, _ := c.Seek([]byte(term)); k != nil && bytes.HasPrefix(k, []byte(term)); k, _ = c.Next() {
+				ids = unionIds(ids, bucketKeys(sbkt.Bucket(k)))
+			}
+			return ids, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		bkt := tx.Bucket([]byte(b.bucket))
+		for _, id := range ids {
+			v := bkt.Get([]byte(id))
+			if v == nil {
+				b.ll.Warn("search index refers to missing shot", zap.String("shot.id", id))
+				continue
+			}
+			shot := &Shot{}
+			if err := proto.Unmarshal(v, shot); err != nil {
+				b.ll.Error("could not unmarshal shot in SearchShots", zap.String("shot.id", id), zap.Error(err))
+				continue
+			}
+			shot.Path = fmt.Sprintf("/p/%s", shot.Id)
+			shots = append(shots, shot)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shots, nil
+}
+
+// bucketKeys returns the keys of bkt, which may be nil, in order.
+func bucketKeys(bkt *bolt.Bucket) []string {
+	if bkt == nil {
+		return nil
+	}
+	var keys []string
+	c := bkt.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		keys = append(keys, string(k))
+	}
+	return keys
+}
+
+// RebuildIndexes recreates the owner, tag and search indexes from the shots
+// and returns how many shots were indexed. PutShot keeps the indexes up to
+// date, so this is only needed to repair them.
+func (b *BoltKV) RebuildIndexes() (int, error) {
+	var n int
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		n, err = b.rebuildIndexes(tx)
+		return err
+	})
+	return n, err
+}
+
+func (b *BoltKV) rebuildIndexes(tx *bolt.Tx) (int, error) {
+	for _, name := range []string{ownersBucket, tagsBucket, searchBucket} {
+		if err := tx.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+			return 0, fmt.Errorf("delete bucket %s: %s", name, err)
+		}
+		if _, err := tx.CreateBucket([]byte(name)); err != nil {
+			return 0, fmt.Errorf("create bucket %s: %s", name, err)
+		}
+	}
+
+	var n int
+	err := tx.Bucket([]byte(b.bucket)).ForEach(func(k, v []byte) error {
+		shot := &Shot{}
+		if err := proto.Unmarshal(v, shot); err != nil {
+			b.ll.Warn("skipping unreadable shot", zap.String("shot.id", string(k)), zap.Error(err))
+			return nil
+		}
+		shot.Id = string(k)
+		n++
+		return indexShot(tx, shot)
+	})
+	return n, err
+}
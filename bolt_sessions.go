@@ -0,0 +1,168 @@
+package spree
+
+import (
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/boltdb/bolt"
+	"github.com/golang/protobuf/proto"
+	"github.com/ralfonso/spree/auth"
+)
+
+const (
+	revokedIdentitiesBucket = "revoked_identities"
+	revokedTokensBucket     = "revoked_tokens"
+	clientsBucket           = "clients"
+
+	// a client seen again from the same address within this window is not rewritten
+	touchInterval = time.Minute
+)
+
+type clientSeen struct {
+	addr string
+	at   time.Time
+}
+
+var _ Sessions = &BoltKV{}
+var _ auth.SessionStore = &BoltKV{}
+
+func emailKey(email string) []byte {
+	return []byte(strings.ToLower(email))
+}
+
+func (b *BoltKV) SetIdentityRevoked(email string, revoked bool) error {
+	return b.setRevoked(revokedIdentitiesBucket, emailKey(email), revoked)
+}
+
+func (b *BoltKV) SetTokenRevoked(tokenID string, revoked bool) error {
+	return b.setRevoked(revokedTokensBucket, []byte(tokenID), revoked)
+}
+
+func (b *BoltKV) setRevoked(bucket string, key []byte, revoked bool) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if !revoked {
+			return bkt.Delete(key)
+		}
+		return bkt.Put(key, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
+
+func (b *BoltKV) IsRevoked(email, tokenID string) (bool, error) {
+	var revoked bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(revokedIdentitiesBucket)).Get(emailKey(email)) != nil {
+			revoked = true
+			return nil
+		}
+		if tokenID != "" && tx.Bucket([]byte(revokedTokensBucket)).Get([]byte(tokenID)) != nil {
+			revoked = true
+		}
+		return nil
+	})
+	return revoked, err
+}
+
+// clientKey keys a client record by identity and token, so each token an
+// identity uses is listed. Records from before tokens were tracked are keyed
+// by the email alone.
+func clientKey(email, tokenID string) []byte {
+	return []byte(strings.ToLower(email) + "\x00" + tokenID)
+}
+
+func (b *BoltKV) TouchClient(email, tokenID, addr string, seen time.Time) error {
+	key := clientKey(email, tokenID)
+
+	// every call would otherwise be a write transaction
+	b.seenMu.Lock()
+	last, ok := b.seen[string(key)]
+	if ok && last.addr == addr && seen.Sub(last.at) < touchInterval {
+		b.seenMu.Unlock()
+		return nil
+	}
+	b.seen[string(key)] = clientSeen{addr: addr, at: seen}
+	b.sweepSeen(seen)
+	b.seenMu.Unlock()
+
+	client := &Client{
+		Email:    strings.ToLower(email),
+		LastSeen: seen.Format(time.RFC3339),
+		Addr:     addr,
+		TokenId:  tokenID,
+	}
+	data, err := proto.Marshal(client)
+	if err != nil {
+		b.ll.Error("could not marshal client in TouchClient", zap.Error(err))
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(clientsBucket)).Put(key, data)
+	})
+}
+
+// sweepSeen drops throttle entries that no longer throttle anything, at most
+// once per touchInterval. Tokens expire, so the map would otherwise grow with
+// every token ever used. b.seenMu must be held.
+func (b *BoltKV) sweepSeen(now time.Time) {
+	if now.Sub(b.seenSwept) < touchInterval {
+		return
+	}
+	for key, last := range b.seen {
+		if now.Sub(last.at) >= touchInterval {
+			delete(b.seen, key)
+		}
+	}
+	b.seenSwept = now
+}
+
+func (b *BoltKV) ListClients() ([]*Client, error) {
+	clients := make([]*Client, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		revoked := tx.Bucket([]byte(revokedIdentitiesBucket))
+		revokedTokens := tx.Bucket([]byte(revokedTokensBucket))
+		connected := make(map[string]bool)
+		c := tx.Bucket([]byte(clientsBucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			client := &Client{}
+			err := proto.Unmarshal(v, client)
+			if err != nil {
+				b.ll.Error("could not unmarshal client in ListClients", zap.Error(err))
+				continue
+			}
+			client.Revoked = revoked.Get(emailKey(client.Email)) != nil ||
+				(client.TokenId != "" && revokedTokens.Get([]byte(client.TokenId)) != nil)
+			connected[strings.ToLower(client.Email)] = true
+			clients = append(clients, client)
+		}
+
+		// revoked identities that have never connected are still worth listing
+		c = revoked.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if !connected[string(k)] {
+				clients = append(clients, &Client{Email: string(k), Revoked: true})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (b *BoltKV) ListRevokedTokens() ([]string, error) {
+	ids := make([]string, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(revokedTokensBucket)).ForEach(func(k, v []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,169 @@
+package spree_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+	"github.com/ralfonso/spree/auth"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func withBoltKV(t *testing.T) (*spree.BoltKV, func()) {
+	dir, err := ioutil.TempDir("", "spree-sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := openBoltKV(t, filepath.Join(dir, "spree.db"))
+	return md, func() {
+		md.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func isRevoked(t *testing.T, md *spree.BoltKV, email, tokenID string) bool {
+	revoked, err := md.IsRevoked(email, tokenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return revoked
+}
+
+func TestBoltKVRevocation(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+
+	if isRevoked(t, md, "user@example.com", "a") {
+		t.Fatal("revoked before anything was revoked")
+	}
+
+	if err := md.SetTokenRevoked("a", true); err != nil {
+		t.Fatal(err)
+	}
+	if !isRevoked(t, md, "user@example.com", "a") || isRevoked(t, md, "user@example.com", "b") {
+		t.Error("revoking a token should revoke only that token")
+	}
+
+	if err := md.SetIdentityRevoked("User@Example.com", true); err != nil {
+		t.Fatal(err)
+	}
+	if !isRevoked(t, md, "user@example.com", "b") || !isRevoked(t, md, "USER@example.com", "") {
+		t.Error("revoking an identity should revoke all of its tokens")
+	}
+
+	if err := md.SetIdentityRevoked("user@example.com", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := md.SetTokenRevoked("a", false); err != nil {
+		t.Fatal(err)
+	}
+	if isRevoked(t, md, "user@example.com", "a") || isRevoked(t, md, "user@example.com", "b") {
+		t.Error("restored identity and token are still revoked")
+	}
+}
+
+func TestBoltKVListClients(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+
+	now := time.Now().UTC()
+	for _, tokenID := range []string{"laptop", "phone"} {
+		if err := md.TouchClient("User@example.com", tokenID, "10.0.0.1:5000", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a touch within the throttle window from a new address is still recorded
+	if err := md.TouchClient("user@example.com", "phone", "10.0.0.2:5000", now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := md.SetTokenRevoked("phone", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := md.SetIdentityRevoked("gone@example.com", true); err != nil {
+		t.Fatal(err)
+	}
+
+	clients, err := md.ListClients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]*spree.Client)
+	for _, client := range clients {
+		got[client.Email+" "+client.TokenId] = client
+	}
+	if len(got) != 3 {
+		t.Fatalf("listed %d clients, want 3: %v", len(got), clients)
+	}
+	if c := got["user@example.com laptop"]; c == nil || c.Revoked || c.Addr != "10.0.0.1:5000" {
+		t.Errorf("laptop listed as %v", c)
+	}
+	if c := got["user@example.com phone"]; c == nil || !c.Revoked || c.Addr != "10.0.0.2:5000" {
+		t.Errorf("phone listed as %v", c)
+	}
+	if c := got["gone@example.com "]; c == nil || !c.Revoked {
+		t.Errorf("revoked identity that never connected listed as %v", c)
+	}
+}
+
+func TestRevokeIdentityRestore(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+
+	audit, err := spree.NewAuditLog(md, "", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := spree.NewServer(md, spree.NewMemoryStorage(), md, audit, nil, zap.NewNop())
+
+	user := auth.NewContext(context.Background(), &auth.Identity{Email: "user@example.com"})
+	_, err = s.RevokeIdentity(user, &spree.RevokeIdentityRequest{TokenId: "a"})
+	if grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("non-admin revocation got error %v", err)
+	}
+
+	admin := auth.NewContext(context.Background(), &auth.Identity{Email: "admin@example.com", Admin: true})
+	if _, err := s.RevokeIdentity(admin, &spree.RevokeIdentityRequest{Email: "user@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if !isRevoked(t, md, "user@example.com", "a") {
+		t.Error("identity was not revoked")
+	}
+	if _, err := s.RevokeIdentity(admin, &spree.RevokeIdentityRequest{Email: "user@example.com", Restore: true}); err != nil {
+		t.Fatal(err)
+	}
+	if isRevoked(t, md, "user@example.com", "a") {
+		t.Error("identity was not restored")
+	}
+
+	_, err = s.RevokeIdentity(admin, &spree.RevokeIdentityRequest{Email: "user@example.com", TokenId: "a"})
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("revoking an identity and a token at once got error %v", err)
+	}
+	invalid := grpc.ErrorDesc(err)
+
+	// the audit log says who revoked what
+	recs, err := md.QueryAudit(time.Time{}, time.Time{}, "admin@example.com", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"RevokeIdentity email:user@example.com ok",
+		"RestoreIdentity email:user@example.com ok",
+		"RevokeIdentity email:user@example.com token:a " + invalid,
+	}
+	var got []string
+	for _, rec := range recs {
+		got = append(got, rec.Method+" "+rec.Target+" "+rec.Result)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("audited %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/codegangsta/cli"
+	"github.com/ralfonso/spree"
+	"golang.org/x/net/context"
+)
+
+var (
+	albumTitleFlag = cli.StringFlag{
+		Name:  "title",
+		Value: "",
+		Usage: "A title for the album",
+	}
+
+	albumCmd = cli.Command{
+		Name:  "album",
+		Usage: "group shots into albums, shown together at /a/<id>",
+		Subcommands: []cli.Command{
+			{
+				Name:      "create",
+				Usage:     "create an album of your shots",
+				ArgsUsage: "[shot id...]",
+				Action:    AlbumCreateCommand,
+				Flags:     []cli.Flag{albumTitleFlag, caCertFileFlag},
+			},
+			{
+				Name:      "show",
+				Usage:     "show an album and its shots",
+				ArgsUsage: "<album id>",
+				Action:    AlbumShowCommand,
+				Flags:     []cli.Flag{caCertFileFlag},
+			},
+			{
+				Name:      "add",
+				Usage:     "add shots to the end of an album",
+				ArgsUsage: "<album id> <shot id...>",
+				Action:    albumShotsCommand("add"),
+				Flags:     []cli.Flag{caCertFileFlag},
+			},
+			{
+				Name:      "remove",
+				Usage:     "remove shots from an album",
+				ArgsUsage: "<album id> <shot id...>",
+				Action:    albumShotsCommand("remove"),
+				Flags:     []cli.Flag{caCertFileFlag},
+			},
+			{
+				Name:      "reorder",
+				Usage:     "put an album's shots in a new order. every shot must be listed",
+				ArgsUsage: "<album id> <shot id...>",
+				Action:    albumShotsCommand("reorder"),
+				Flags:     []cli.Flag{caCertFileFlag},
+			},
+		},
+	}
+)
+
+func AlbumCreateCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	c := mustSpreeClient(ctx, ll)
+	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	resp, err := c.CreateAlbum(cctx, &spree.CreateAlbumRequest{
+		Title:   ctx.String(albumTitleFlag.Name),
+		ShotIds: ctx.Args(),
+	})
+	if err != nil {
+		fatalErr(ll, "error in create album response", err)
+	}
+	out.print(resp)
+}
+
+func AlbumShowCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	id := ctx.Args().First()
+	if id == "" {
+		fatal(ll, exitUsage, "specify the id of an album")
+	}
+
+	c := mustSpreeClient(ctx, ll)
+	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	resp, err := c.GetAlbum(cctx, &spree.GetAlbumRequest{Id: id})
+	if err != nil {
+		fatalErr(ll, "error in album response", err)
+	}
+	out.print(resp)
+}
+
+// albumShotsCommand returns the action for the album subcommands that take
+// an album id followed by shot ids.
+func albumShotsCommand(op string) func(*cli.Context) {
+	return func(ctx *cli.Context) {
+		ll := newLogger(ctx)
+		out := mustOutput(ctx, "json", ll)
+		req := &spree.AlbumShotsRequest{
+			AlbumId: ctx.Args().First(),
+			ShotIds: ctx.Args().Tail(),
+		}
+		if req.AlbumId == "" || len(req.ShotIds) == 0 {
+			fatal(ll, exitUsage, "specify the id of an album and at least one shot id")
+		}
+
+		c := mustSpreeClient(ctx, ll)
+		cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+		defer cancel()
+
+		var resp *spree.AlbumResponse
+		var err error
+		switch op {
+		case "add":
+			resp, err = c.AddAlbumShots(cctx, req)
+		case "remove":
+			resp, err = c.RemoveAlbumShots(cctx, req)
+		case "reorder":
+			resp, err = c.ReorderAlbum(cctx, req)
+		}
+		if err != nil {
+			fatalErr(ll, "error in album response", err, zap.String("op", op))
+		}
+		out.print(resp)
+	}
+}
@@ -1,14 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -16,8 +13,6 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/codegangsta/cli"
-	"github.com/golang/protobuf/jsonpb"
-	"github.com/golang/protobuf/proto"
 	"github.com/ralfonso/spree"
 	"github.com/ralfonso/spree/auth"
 	"github.com/skratchdot/open-golang/open"
@@ -43,14 +38,35 @@ var (
 	oauthConfigFileFlag = cli.StringFlag{
 		Name:  "oauth.config.file",
 		Value: "",
-		Usage: "Addr for the remote RPC server",
+		Usage: "OAuth client config file. Defaults to oauth.json in the config dir",
+	}
+	profileFlag = cli.StringFlag{
+		Name:   "profile",
+		Value:  "",
+		Usage:  "The config profile to use. Defaults to the current profile",
+		EnvVar: "SPREE_PROFILE",
+	}
+	certFileFlag = cli.StringFlag{
+		Name:  "cert.file",
+		Value: "",
+		Usage: "Client cert file for TLS",
+	}
+	keyFileFlag = cli.StringFlag{
+		Name:  "key.file",
+		Value: "",
+		Usage: "Client key file for TLS",
+	}
+	baseURLFlag = cli.StringFlag{
+		Name:  "base.url",
+		Value: "",
+		Usage: "Public URL of the web server, used to print share links",
 	}
 
 	// subcommand flags
 	srcFlag = cli.StringFlag{
 		Name:  "src",
 		Value: "-",
-		Usage: "The src file to upload. \"-\" for stdin",
+		Usage: "The src file to upload when no files are given. \"-\" for stdin",
 	}
 	filenameFlag = cli.StringFlag{
 		Name:  "file",
@@ -62,6 +78,94 @@ var (
 		Value: "",
 		Usage: "CA cert file",
 	}
+	deviceFlag = cli.BoolFlag{
+		Name:  "device",
+		Usage: "Log in with a code on another device instead of a local browser redirect (for SSH sessions)",
+	}
+	deviceAuthURLFlag = cli.StringFlag{
+		Name:  "device.auth.url",
+		Value: auth.GoogleDeviceAuthURL,
+		Usage: "The OAuth device authorization endpoint used with --device",
+	}
+	tokenIDFlag = cli.StringFlag{
+		Name:  "token.id",
+		Value: "",
+		Usage: "Revoke a single token instead of an email",
+	}
+	allFlag = cli.BoolFlag{
+		Name:  "all",
+		Usage: "List every user's shots (admin only)",
+	}
+	searchAllFlag = cli.BoolFlag{
+		Name:  "all",
+		Usage: "Search every user's shots (admin only)",
+	}
+	restoreFlag = cli.BoolFlag{
+		Name:  "restore",
+		Usage: "Lift an earlier revocation",
+	}
+	sinceFlag = cli.StringFlag{
+		Name:  "since",
+		Value: "24h",
+		Usage: "Only show records after this RFC3339 time or this long ago",
+	}
+	untilFlag = cli.StringFlag{
+		Name:  "until",
+		Value: "",
+		Usage: "Only show records before this RFC3339 time or this long ago",
+	}
+	identityFlag = cli.StringFlag{
+		Name:  "identity",
+		Value: "",
+		Usage: "Only show records for this email",
+	}
+	limitFlag = cli.IntFlag{
+		Name:  "limit",
+		Value: 100,
+		Usage: "The maximum number of records to show. The most recent are kept",
+	}
+	e2eFlag = cli.BoolFlag{
+		Name:  "e2e",
+		Usage: "Encrypt locally so the server can't read the shot. The key is only in the printed link. Only the file is encrypted, so --title, --description and --tag can't be used with it and the shot is named after the file's extension alone",
+	}
+	titleFlag = cli.StringFlag{
+		Name:  "title",
+		Value: "",
+		Usage: "A title for the shot",
+	}
+	descriptionFlag = cli.StringFlag{
+		Name:  "description",
+		Value: "",
+		Usage: "A description of the shot",
+	}
+	tagFlag = cli.StringSliceFlag{
+		Name:  "tag",
+		Usage: "Tag the shot. May be repeated",
+	}
+	listTagFlag = cli.StringFlag{
+		Name:  "tag",
+		Value: "",
+		Usage: "Only list shots with this tag",
+	}
+	parallelFlag = cli.IntFlag{
+		Name:  "parallel",
+		Value: 4,
+		Usage: "How many files to upload at once",
+	}
+	albumFlag = cli.StringFlag{
+		Name:  "album",
+		Value: "",
+		Usage: "Put the uploads in a new album with this title",
+	}
+	clearTagsFlag = cli.BoolFlag{
+		Name:  "clear.tags",
+		Usage: "Remove every tag from the shot",
+	}
+	authTimeoutFlag = cli.DurationFlag{
+		Name:  "timeout",
+		Value: 5 * time.Minute,
+		Usage: "How long to wait for the login to complete",
+	}
 
 	oauthScopes = []string{
 		"https://www.googleapis.com/auth/userinfo.email",
@@ -70,6 +174,14 @@ var (
 
 var GlobalFlags = []cli.Flag{
 	rpcAddrFlag,
+	oauthConfigFileFlag,
+	profileFlag,
+	caCertFileFlag,
+	certFileFlag,
+	keyFileFlag,
+	baseURLFlag,
+	outputFlag,
+	verboseFlag,
 }
 
 var (
@@ -78,14 +190,26 @@ var (
 		Name:   "auth",
 		Usage:  "authenticates with Google and stores the token",
 		Action: AuthCommand,
+		Flags: []cli.Flag{
+			deviceFlag,
+			deviceAuthURLFlag,
+			authTimeoutFlag,
+		},
 	}
 	uploadCmd = cli.Command{
-		Name:   "upload",
-		Usage:  "upload to the server",
-		Action: UploadCommand,
+		Name:      "upload",
+		Usage:     "upload files, globs and directories to the server",
+		ArgsUsage: "[path...]",
+		Action:    UploadCommand,
 		Flags: []cli.Flag{
 			srcFlag,
 			filenameFlag,
+			e2eFlag,
+			titleFlag,
+			descriptionFlag,
+			tagFlag,
+			albumFlag,
+			parallelFlag,
 			caCertFileFlag,
 		},
 	}
@@ -93,6 +217,96 @@ var (
 		Name:   "list",
 		Usage:  "list the files server",
 		Action: ListCommand,
+		Flags: []cli.Flag{
+			allFlag,
+			listTagFlag,
+			caCertFileFlag,
+		},
+	}
+	searchCmd = cli.Command{
+		Name:      "search",
+		Usage:     "search shot filenames, titles, descriptions and tags. Words must all match unless separated by OR; end a word with * to match its start",
+		ArgsUsage: "<query>",
+		Action:    SearchCommand,
+		Flags: []cli.Flag{
+			searchAllFlag,
+			caCertFileFlag,
+		},
+	}
+	deleteCmd = cli.Command{
+		Name:      "delete",
+		Usage:     "delete a shot and its file (owner or admin only)",
+		ArgsUsage: "<id>",
+		Action:    DeleteCommand,
+		Flags: []cli.Flag{
+			caCertFileFlag,
+		},
+	}
+	updateCmd = cli.Command{
+		Name:      "update",
+		Usage:     "change a shot's title, description or tags (owner or admin only)",
+		ArgsUsage: "<id>",
+		Action:    UpdateCommand,
+		Flags: []cli.Flag{
+			titleFlag,
+			descriptionFlag,
+			tagFlag,
+			clearTagsFlag,
+			caCertFileFlag,
+		},
+	}
+	statsCmd = cli.Command{
+		Name:      "stats",
+		Usage:     "show a shot's views by day, referrer and kind of viewer (owner or admin only)",
+		ArgsUsage: "<id>",
+		Action:    StatsCommand,
+		Flags: []cli.Flag{
+			caCertFileFlag,
+		},
+	}
+	revokeCmd = cli.Command{
+		Name:      "revoke",
+		Usage:     "revoke every token for an email, or a single token (admin only)",
+		ArgsUsage: "[email]",
+		Action:    RevokeCommand,
+		Flags: []cli.Flag{
+			tokenIDFlag,
+			restoreFlag,
+			caCertFileFlag,
+		},
+	}
+	auditCmd = cli.Command{
+		Name:   "audit",
+		Usage:  "query the audit log of uploads and admin changes (admin only)",
+		Action: AuditCommand,
+		Flags: []cli.Flag{
+			sinceFlag,
+			untilFlag,
+			identityFlag,
+			limitFlag,
+			caCertFileFlag,
+		},
+	}
+	clientsCmd = cli.Command{
+		Name:   "clients",
+		Usage:  "list the clients seen by the server and revoked tokens (admin only)",
+		Action: ClientsCommand,
+		Flags: []cli.Flag{
+			caCertFileFlag,
+		},
+	}
+	storageCmd = cli.Command{
+		Name:   "storage",
+		Usage:  "show how much space compression has saved (admin only)",
+		Action: StorageCommand,
+		Flags: []cli.Flag{
+			caCertFileFlag,
+		},
+	}
+	replicasCmd = cli.Command{
+		Name:   "replicas",
+		Usage:  "show the health of each storage replica (admin only)",
+		Action: ReplicasCommand,
 		Flags: []cli.Flag{
 			caCertFileFlag,
 		},
@@ -103,169 +317,354 @@ var Commands = []cli.Command{
 	authCmd,
 	uploadCmd,
 	listCmd,
+	searchCmd,
+	updateCmd,
+	deleteCmd,
+	albumCmd,
+	watchCmd,
+	statsCmd,
+	revokeCmd,
+	clientsCmd,
+	auditCmd,
+	replicasCmd,
+	storageCmd,
+	profileCmd,
 }
 
 func AuthCommand(ctx *cli.Context) {
-	ll, _ := zap.NewDevelopment()
-	oauthConf := mustOauthConfFromFile(ctx, ll)
-	fmt.Println("Opening web browser to log in with Google.")
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "", ll)
+	conf, name, profile := mustProfile(ctx, ll)
+	oauthConf := mustOauthConfFromFile(ctx, profile, ll)
 
-	authURL := oauthConf.AuthCodeURL("test")
-	err := open.Run(authURL)
-	if err != nil {
-		ll.Fatal("could not open url in browser", zap.Error(err))
-	}
+	cctx, cancel := context.WithTimeout(context.Background(), ctx.Duration(authTimeoutFlag.Name))
+	defer cancel()
 
-	fmt.Print("Paste Google Developer OAuth Code: ")
-	reader := bufio.NewReader(os.Stdin)
-	code, _ := reader.ReadString('\n')
-	code = strings.TrimRight(code, "\n")
-	token, err := oauthConf.Exchange(context.Background(), code)
+	var token *oauth2.Token
+	var err error
+	if ctx.Bool(deviceFlag.Name) {
+		token, err = auth.DeviceLogin(cctx, oauthConf, ctx.String(deviceAuthURLFlag.Name), promptDeviceCode, ll)
+	} else {
+		token, err = auth.LoopbackLogin(cctx, oauthConf, openBrowser, ll)
+	}
 	if err != nil {
-		ll.Fatal("could not exchange code for token", zap.Error(err))
+		fatal(ll, exitAuth, "could not log in", zap.Error(err))
 	}
 
 	jwt, err := auth.NewClientJWTFromOauth2(token, ll)
 	if err != nil {
-		ll.Fatal("could not convert oauth2 token to JWT", zap.Error(err))
+		fatal(ll, exitAuth, "could not convert oauth2 token to JWT", zap.Error(err))
 	}
 
-	clientConf := &auth.ClientConfig{
-		OauthToken: token,
-		JWT:        jwt,
+	// remember the connection settings used to log in with this profile
+	if addr := ctx.GlobalString(rpcAddrFlag.Name); addr != "" {
+		profile.RPCAddr = addr
 	}
+	if file := flagString(ctx, caCertFileFlag.Name); file != "" {
+		profile.CACertFile = file
+	}
+	if file := ctx.GlobalString(certFileFlag.Name); file != "" {
+		profile.CertFile = file
+	}
+	if file := ctx.GlobalString(keyFileFlag.Name); file != "" {
+		profile.KeyFile = file
+	}
+	if file := ctx.GlobalString(oauthConfigFileFlag.Name); file != "" {
+		profile.OauthConfigFile = file
+	}
+	if url := ctx.GlobalString(baseURLFlag.Name); url != "" {
+		profile.BaseURL = url
+	}
+	profile.OauthToken = token
+	profile.JWT = jwt
 
-	err = storeConfig(clientConf, ll)
-	if err != nil {
-		ll.Fatal("could not store token in config")
+	conf.Profiles[name] = profile
+	if conf.CurrentProfile == "" {
+		conf.CurrentProfile = name
 	}
+
+	err = storeConfig(conf, ll)
+	if err != nil {
+		fatalErr(ll, "could not store token in config", err)
+	}
+	if out.format == "" {
+		fmt.Printf("Logged in with profile %q.\n", name)
+		return
+	}
+	out.print(&profileInfo{
+		Name:     name,
+		RPCAddr:  profile.RPCAddr,
+		BaseURL:  profile.BaseURL,
+		Current:  name == conf.CurrentProfile,
+		LoggedIn: true,
+	})
 }
 
-func UploadCommand(ctx *cli.Context) {
-	filename := ctx.String(filenameFlag.Name)
-	src := ctx.String(srcFlag.Name)
+// openBrowser tries to open url in a browser, falling back to printing it so
+// the user can open it by hand. Prompts go to stderr to keep stdout for
+// --output.
+func openBrowser(url string) error {
+	fmt.Fprintln(os.Stderr, "Opening web browser to log in with Google.")
+	if err := open.Run(url); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not open a browser. Visit this URL to log in:")
+	} else {
+		fmt.Fprintln(os.Stderr, "If the browser did not open, visit this URL to log in:")
+	}
+	fmt.Fprintf(os.Stderr, "\n    %s\n\n", url)
+	return nil
+}
 
-	var rdr io.Reader
-	var err error
+func promptDeviceCode(dc *auth.DeviceCode) {
+	fmt.Fprintf(os.Stderr, "Visit %s on any device and enter the code: %s\n", dc.URL(), dc.UserCode)
+}
 
-	ll, _ := zap.NewDevelopment()
+func ListCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	c := mustSpreeClient(ctx, ll)
+	req := &spree.ListRequest{
+		All: ctx.Bool(allFlag.Name),
+		Tag: ctx.String(listTagFlag.Name),
+	}
+	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	ll.Info("making list request")
+	resp, err := c.List(cctx, req)
+	if err != nil {
+		fatalErr(ll, "error in list response", err)
+	}
+	out.print(resp)
+}
 
-	if src == "-" {
-		if filename == "" {
-			ll.Fatal("You must specify \"file\" when using stdin")
-		}
-		rdr = os.Stdin
-	} else {
-		if filename == "" {
-			filename = path.Base(src)
-		}
-		rdr, err = os.Open(src)
-		if err != nil {
-			ll.Fatal("could not open src file", zap.Error(err))
-		}
+func SearchCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	req := &spree.SearchRequest{
+		Query: strings.Join(ctx.Args(), " "),
+		All:   ctx.Bool(searchAllFlag.Name),
+	}
+	if req.Query == "" {
+		fatal(ll, exitUsage, "specify what to search for")
 	}
 
 	c := mustSpreeClient(ctx, ll)
 	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
-	srv, err := c.Create(cctx)
+	resp, err := c.Search(cctx, req)
 	if err != nil {
-		ll.Fatal("could not call Create", zap.Error(err))
+		fatalErr(ll, "error in search response", err)
 	}
+	out.print(resp)
+}
 
-	start := time.Now()
-	uploadedBytes, err := doUpload(srv, filename, rdr, ll)
+func DeleteCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	id := ctx.Args().First()
+	if id == "" {
+		fatal(ll, exitUsage, "specify the id of a shot")
+	}
 
-	finish := time.Since(start)
-	ll.Info("completed upload",
-		zap.Float64("MiB/s", float64(uploadedBytes/1024)/finish.Seconds()))
+	c := mustSpreeClient(ctx, ll)
+	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	resp, err := c.DeleteShot(cctx, &spree.DeleteShotRequest{Id: id})
+	if err != nil {
+		fatalErr(ll, "error in delete response", err)
+	}
+	out.print(resp)
 }
 
-func doUpload(srv spree.Spree_CreateClient, filename string, rdr io.Reader, ll *zap.Logger) (int64, error) {
-	buf := make([]byte, chunkSizeBytes)
-	msg := &spree.CreateRequest{
-		Filename: path.Base(filename),
+func UpdateCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	req := &spree.UpdateShotRequest{
+		Id:   ctx.Args().First(),
+		Shot: &spree.Shot{},
+	}
+	if req.Id == "" {
+		fatal(ll, exitUsage, "specify the id of a shot")
+	}
+	if ctx.IsSet(titleFlag.Name) {
+		req.Shot.Title = ctx.String(titleFlag.Name)
+		req.UpdateMask = append(req.UpdateMask, "title")
+	}
+	if ctx.IsSet(descriptionFlag.Name) {
+		req.Shot.Description = ctx.String(descriptionFlag.Name)
+		req.UpdateMask = append(req.UpdateMask, "description")
+	}
+	if ctx.IsSet(tagFlag.Name) || ctx.Bool(clearTagsFlag.Name) {
+		req.Shot.Tags = ctx.StringSlice(tagFlag.Name)
+		req.UpdateMask = append(req.UpdateMask, "tags")
+	}
+	if len(req.UpdateMask) == 0 {
+		fatal(ll, exitUsage, "specify --title, --description, --tag or --clear.tags")
 	}
 
-	var offset int64
-	for {
-		n, err := rdr.Read(buf)
-		if err == io.EOF {
-			srv.CloseSend()
-
-			resp, err := srv.Recv()
-			if err == io.EOF {
-				return offset, err
-			}
-			if err != nil {
-				ll.Fatal("error reading response from server", zap.Error(err))
-			}
+	c := mustSpreeClient(ctx, ll)
+	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	resp, err := c.UpdateShot(cctx, req)
+	if err != nil {
+		fatalErr(ll, "error in update response", err)
+	}
+	out.print(resp)
+}
 
-			if resp.Shot != nil {
-				printProto(resp, ll)
-			}
+func StatsCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	id := ctx.Args().First()
+	if id == "" {
+		fatal(ll, exitUsage, "specify the id of a shot")
+	}
 
-			return offset, nil
-		}
-		if err != nil {
-			ll.Fatal("error reading file", zap.Error(err))
-		}
+	c := mustSpreeClient(ctx, ll)
+	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	resp, err := c.GetStats(cctx, &spree.GetStatsRequest{Id: id})
+	if err != nil {
+		fatalErr(ll, "error in stats response", err)
+	}
+	out.print(resp)
+}
 
-		msg.Offset = offset
-		msg.Length = int64(n)
-		msg.Data = buf[:n]
-		ll.With(
-			zap.Int64("msg.offset", msg.Offset),
-			zap.Int64("msg.length", msg.Length),
-		).Info("sending message part")
+func RevokeCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	req := &spree.RevokeIdentityRequest{
+		Email:   ctx.Args().First(),
+		TokenId: ctx.String(tokenIDFlag.Name),
+		Restore: ctx.Bool(restoreFlag.Name),
+	}
+	if (req.Email == "") == (req.TokenId == "") {
+		fatal(ll, exitUsage, "specify either an email or --token.id")
+	}
 
-		err = srv.Send(msg)
-		if err != nil {
-			ll.Fatal("error sending message", zap.Error(err))
-		}
+	c := mustSpreeClient(ctx, ll)
+	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	resp, err := c.RevokeIdentity(cctx, req)
+	if err != nil {
+		fatalErr(ll, "error in revoke response", err)
+	}
+	out.print(resp)
+}
 
-		resp, err := srv.Recv()
-		if err == io.EOF {
-			return offset, err
-		}
-		if err != nil {
-			ll.Fatal("error reading response from server", zap.Error(err))
-		}
+func ClientsCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	c := mustSpreeClient(ctx, ll)
+	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	resp, err := c.ListActiveClients(cctx, &spree.ListActiveClientsRequest{})
+	if err != nil {
+		fatalErr(ll, "error in clients response", err)
+	}
+	out.print(resp)
+}
 
-		if resp.BytesWritten != msg.Length {
-			ll.Fatal("mismatch in bytes written to msg length",
-				zap.Int64("written.bytes", resp.BytesWritten),
-				zap.Int64("msg.bytes", msg.Length))
-		}
+func ReplicasCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	c := mustSpreeClient(ctx, ll)
+	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	resp, err := c.ReplicaHealth(cctx, &spree.ReplicaHealthRequest{})
+	if err != nil {
+		fatalErr(ll, "error in replica health response", err)
+	}
+	out.print(resp)
+}
 
-		offset += msg.Length
+func StorageCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	c := mustSpreeClient(ctx, ll)
+	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	resp, err := c.GetStorageStats(cctx, &spree.GetStorageStatsRequest{})
+	if err != nil {
+		fatalErr(ll, "error in storage stats response", err)
 	}
+	out.print(resp)
 }
 
-func ListCommand(ctx *cli.Context) {
-	ll, _ := zap.NewDevelopment()
+func AuditCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "json", ll)
+	now := time.Now()
+	since, err := parseTimeFlag(ctx.String(sinceFlag.Name), now)
+	if err != nil {
+		fatal(ll, exitUsage, "invalid --since", zap.Error(err))
+	}
+	until, err := parseTimeFlag(ctx.String(untilFlag.Name), now)
+	if err != nil {
+		fatal(ll, exitUsage, "invalid --until", zap.Error(err))
+	}
+
+	req := &spree.QueryAuditRequest{
+		Since:    since,
+		Until:    until,
+		Identity: ctx.String(identityFlag.Name),
+		Limit:    int32(ctx.Int(limitFlag.Name)),
+	}
+
 	c := mustSpreeClient(ctx, ll)
-	req := &spree.ListRequest{}
 	cctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
-	ll.Info("making list request")
-	resp, err := c.List(cctx, req)
+	resp, err := c.QueryAudit(cctx, req)
 	if err != nil {
-		ll.Fatal("error in list response", zap.Error(err))
+		fatalErr(ll, "error in audit response", err)
 	}
-	printProto(resp, ll)
+	out.print(resp)
+}
+
+// parseTimeFlag accepts an RFC3339 time or a duration before now, and
+// returns an RFC3339 time.
+func parseTimeFlag(val string, now time.Time) (string, error) {
+	if val == "" {
+		return "", nil
+	}
+	if d, err := time.ParseDuration(val); err == nil {
+		return now.Add(-d).UTC().Format(time.RFC3339), nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format(time.RFC3339), nil
 }
 
 func mustSpreeClient(ctx *cli.Context, ll *zap.Logger) spree.SpreeClient {
+	conf, name, profile := mustProfile(ctx, ll)
+	if profile.JWT == nil {
+		fatal(ll, exitAuth, "missing token. please use the auth command first",
+			zap.String("profile", name))
+	}
+
+	// flags override the profile
 	rpcAddr := ctx.GlobalString(rpcAddrFlag.Name)
-	caCertFile := ctx.String(caCertFileFlag.Name)
-	oauthConfig := mustOauthConfFromFile(ctx, ll)
+	if rpcAddr == "" {
+		rpcAddr = profile.RPCAddr
+	}
+	caCertFile := flagString(ctx, caCertFileFlag.Name)
+	if caCertFile == "" {
+		caCertFile = profile.CACertFile
+	}
+	certFile := ctx.GlobalString(certFileFlag.Name)
+	keyFile := ctx.GlobalString(keyFileFlag.Name)
+	if certFile == "" && keyFile == "" {
+		certFile, keyFile = profile.CertFile, profile.KeyFile
+	}
+
+	oauthConfig := mustOauthConfFromFile(ctx, profile, ll)
 	tlsConfig := &tls.Config{}
 
 	if caCertFile != "" {
 		caCert, err := ioutil.ReadFile(caCertFile)
 		if err != nil {
-			ll.Fatal("could not read ca cert file", zap.Error(err),
+			fatalErr(ll, "could not read ca cert file", err,
 				zap.String("ca.cert.file", caCertFile))
 		}
 		certPool := x509.NewCertPool()
@@ -273,17 +672,13 @@ func mustSpreeClient(ctx *cli.Context, ll *zap.Logger) spree.SpreeClient {
 		tlsConfig.RootCAs = certPool
 	}
 
-	clientConf, err := getConfig(ll)
-	if err != nil {
-		ll.Fatal("unable to retrieve client configuration")
-	}
-	if clientConf == nil || clientConf.JWT == nil {
-		ll.Fatal("missing token. please use the auth command first")
-	}
-
-	// allow the config file to override only if the flag was not set
-	if rpcAddr == "" {
-		rpcAddr = clientConf.RPCAddr
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			fatalErr(ll, "could not load client keypair", err,
+				zap.String("cert.file", certFile), zap.String("key.file", keyFile))
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
 	// local dev hax
@@ -291,7 +686,7 @@ func mustSpreeClient(ctx *cli.Context, ll *zap.Logger) spree.SpreeClient {
 	if rpcParts[0] == "localhost" {
 		tlsConfig.InsecureSkipVerify = true
 	}
-	ll = ll.With(zap.String("rpc.addr", rpcAddr))
+	ll = ll.With(zap.String("rpc.addr", rpcAddr), zap.String("profile", name))
 
 	creds := credentials.NewTLS(tlsConfig)
 	opts := []grpc.DialOption{
@@ -300,17 +695,21 @@ func mustSpreeClient(ctx *cli.Context, ll *zap.Logger) spree.SpreeClient {
 
 	cctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	a := auth.NewAuthenticator(ll)
-	oauthToken, jwt, err := a.RefreshJWT(cctx, clientConf, oauthConfig)
+	oauthToken, jwt, err := a.RefreshJWT(cctx, &profile.ClientConfig, oauthConfig)
 	if err != nil {
-		ll.Fatal("could not refresh jwt", zap.Error(err))
+		code := exitCode(err)
+		if code == exitFailure {
+			code = exitAuth
+		}
+		fatal(ll, code, "could not refresh jwt", zap.Error(err))
 	}
 	cancel()
-	if oauthToken.AccessToken != clientConf.OauthToken.AccessToken || jwt.Token != clientConf.JWT.Token {
-		clientConf.OauthToken = oauthToken
-		clientConf.JWT = jwt
-		err = storeConfig(clientConf, ll)
+	if oauthToken.AccessToken != profile.OauthToken.AccessToken || jwt.Token != profile.JWT.Token {
+		profile.OauthToken = oauthToken
+		profile.JWT = jwt
+		err = storeConfig(conf, ll)
 		if err != nil {
-			ll.Fatal("unable to write new access token to config", zap.Error(err))
+			fatalErr(ll, "unable to write new access token to config", err)
 		}
 	}
 
@@ -319,40 +718,58 @@ func mustSpreeClient(ctx *cli.Context, ll *zap.Logger) spree.SpreeClient {
 	ll.Debug("dialing rpc endpoint")
 	conn, err := grpc.Dial(rpcAddr, opts...)
 	if err != nil {
-		ll.Fatal("could not connect", zap.Error(err))
+		fatal(ll, exitNetwork, "could not connect", zap.Error(err))
 	}
 	return spree.NewSpreeClient(conn)
 }
 
-func mustOauthConfFromFile(ctx *cli.Context, ll *zap.Logger) *oauth2.Config {
+// mustProfile loads the config and returns the selected profile, creating an
+// empty one if it does not exist yet.
+func mustProfile(ctx *cli.Context, ll *zap.Logger) (*Config, string, *Profile) {
+	conf, err := getConfig(ll)
+	if err != nil {
+		fatalErr(ll, "unable to retrieve client configuration", err)
+	}
+
+	name := conf.ProfileName(ctx.GlobalString(profileFlag.Name))
+	profile, ok := conf.Profiles[name]
+	if !ok {
+		profile = &Profile{}
+	}
+	return conf, name, profile
+}
+
+func mustOauthConfFromFile(ctx *cli.Context, profile *Profile, ll *zap.Logger) *oauth2.Config {
 	oauthConfFilename := ctx.GlobalString(oauthConfigFileFlag.Name)
+	if oauthConfFilename == "" {
+		oauthConfFilename = profile.OauthConfigFile
+	}
 	if oauthConfFilename == "" {
 		// try the default location
 		oauthConfFilename = filepath.Join(configHome(), "oauth.json")
 		if _, err := os.Stat(oauthConfFilename); os.IsNotExist(err) {
-			ll.Fatal("oauth config file not specified or found at default location",
+			fatal(ll, exitUsage, "oauth config file not specified or found at default location",
 				zap.String("default", oauthConfFilename))
 		}
 	}
 	jsonConf, err := ioutil.ReadFile(oauthConfFilename)
 	if err != nil {
-		ll.Fatal("could not read oauth config file",
-			zap.Error(err), zap.String("oauth.config.file", oauthConfFilename))
+		fatalErr(ll, "could not read oauth config file", err,
+			zap.String("oauth.config.file", oauthConfFilename))
 	}
 	oauthConf, err := google.ConfigFromJSON(jsonConf, oauthScopes...)
 	if err != nil {
-		ll.Fatal("could not parse JSON oauth config",
-			zap.Error(err))
+		fatalErr(ll, "could not parse JSON oauth config", err)
 	}
 
 	return oauthConf
 }
 
-func printProto(p proto.Message, ll *zap.Logger) {
-	jm := jsonpb.Marshaler{Indent: "    "}
-	out, err := jm.MarshalToString(p)
-	if err != nil {
-		ll.Fatal("error marhsaling proto to json", zap.Error(err))
+// flagString returns a flag that may be given either before or after the
+// subcommand name.
+func flagString(ctx *cli.Context, name string) string {
+	if ctx.IsSet(name) {
+		return ctx.String(name)
 	}
-	fmt.Println(out)
+	return ctx.GlobalString(name)
 }
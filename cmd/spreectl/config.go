@@ -2,62 +2,153 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"go.uber.org/zap"
 
 	"github.com/ralfonso/spree/auth"
 )
 
-// getConfig gets the client config from disk.
-func getConfig(ll *zap.Logger) (*auth.ClientConfig, error) {
-	configDir := configHome()
-	configFile := configFileName(configDir)
-	f, err := os.Open(configFile)
+const (
+	defaultProfileName = "default"
+	configFileMode     = 0600
+)
+
+// Config is the on-disk client configuration. It holds any number of named
+// profiles, one of which is current.
+type Config struct {
+	CurrentProfile string              `json:"current_profile"`
+	Profiles       map[string]*Profile `json:"profiles"`
+}
+
+// Profile is a server and the account used to talk to it.
+type Profile struct {
+	auth.ClientConfig
+
+	CACertFile      string `json:"ca_cert_file,omitempty"`
+	CertFile        string `json:"cert_file,omitempty"`
+	KeyFile         string `json:"key_file,omitempty"`
+	OauthConfigFile string `json:"oauth_config_file,omitempty"`
+	BaseURL         string `json:"base_url,omitempty"`
+}
+
+// ProfileName returns the name of the profile to use: the explicit selection
+// if there is one, then the current profile, then the default.
+func (c *Config) ProfileName(selected string) string {
+	if selected != "" {
+		return selected
+	}
+	if c.CurrentProfile != "" {
+		return c.CurrentProfile
+	}
+	return defaultProfileName
+}
+
+// ProfileNames returns the profile names in sorted order.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getConfig gets the client config from disk. A missing file is an empty
+// config. Files written before profiles existed hold a single
+// auth.ClientConfig, which becomes the default profile.
+func getConfig(ll *zap.Logger) (*Config, error) {
+	configFile := configFileName(configHome())
+	b, err := ioutil.ReadFile(configFile)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, nil
+			return &Config{Profiles: make(map[string]*Profile)}, nil
 		}
 		return nil, err
 	}
-	defer f.Close()
-	b, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil, err
-	}
 
-	conf := &auth.ClientConfig{}
+	conf := &Config{}
 	err = json.Unmarshal(b, conf)
 	if err != nil {
 		return nil, err
 	}
 
+	if conf.Profiles == nil {
+		conf.Profiles = make(map[string]*Profile)
+		legacy := &Profile{}
+		err = json.Unmarshal(b, &legacy.ClientConfig)
+		if err != nil {
+			return nil, err
+		}
+		if legacy.RPCAddr != "" || legacy.JWT != nil {
+			ll.Debug("converting single-account config to the default profile")
+			conf.Profiles[defaultProfileName] = legacy
+			conf.CurrentProfile = defaultProfileName
+		}
+	}
+
 	return conf, nil
 }
 
-// storeConfig stores the client config to disk.
-func storeConfig(conf *auth.ClientConfig, ll *zap.Logger) error {
+// storeConfig stores the client config to disk. The file holds tokens, so it
+// is only readable by the owner, and it is replaced atomically so a failed
+// write can't lose every profile.
+func storeConfig(conf *Config, ll *zap.Logger) error {
 	jsonConf, err := json.MarshalIndent(conf, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	configDir := configHome()
-	if err := os.MkdirAll(configDir, 0755); err != nil {
+	configFile := configFileName(configHome())
+	ll.Debug("writing config", zap.String("file", configFile))
+	return writePrivateFile(configFile, jsonConf)
+}
+
+// writePrivateFile replaces filename with b atomically, creating its
+// directory if needed. Only the owner can read the file.
+func writePrivateFile(filename string, b []byte) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
 
-	configFile := configFileName(configDir)
-	f, err := os.Create(configFile)
+	f, err := ioutil.TempFile(dir, ".config-")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	tmpName := f.Name()
+	defer os.Remove(tmpName)
 
-	_, err = f.Write(jsonConf)
-	return err
+	if err := f.Chmod(configFileMode); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, filename)
+}
+
+// removeProfile deletes a profile, clearing the current selection if it
+// pointed at the removed profile.
+func removeProfile(conf *Config, name string) error {
+	if _, ok := conf.Profiles[name]; !ok {
+		return fmt.Errorf("no such profile: %s", name)
+	}
+	delete(conf.Profiles, name)
+	if conf.CurrentProfile == name {
+		conf.CurrentProfile = ""
+	}
+	return nil
 }
 
 func configHome() string {
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/codegangsta/cli"
+	"github.com/ralfonso/spree/auth"
+)
+
+// withConfigHome points the config directory at a temporary one for the
+// length of a test.
+func withConfigHome(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "spreectl-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, had := os.LookupEnv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	return dir, func() {
+		if had {
+			os.Setenv("XDG_CONFIG_HOME", old)
+		} else {
+			os.Unsetenv("XDG_CONFIG_HOME")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetConfigLegacy(t *testing.T) {
+	dir, cleanup := withConfigHome(t)
+	defer cleanup()
+
+	legacy := `{"rpc_addr": "spree.example.com:4285", "jwt": {"token": "t"}}`
+	if err := ioutil.WriteFile(configFileName(dir), []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := getConfig(zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.CurrentProfile != defaultProfileName {
+		t.Errorf("current profile is %q, want %q", conf.CurrentProfile, defaultProfileName)
+	}
+	profile := conf.Profiles[defaultProfileName]
+	if profile == nil || profile.RPCAddr != "spree.example.com:4285" || profile.JWT == nil || profile.JWT.Token != "t" {
+		t.Fatalf("legacy config became profile %+v", profile)
+	}
+
+	// storing writes the profile format, which reads back the same
+	if err := storeConfig(conf, zap.NewNop()); err != nil {
+		t.Fatal(err)
+	}
+	again, err := getConfig(zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := again.Profiles[defaultProfileName]; got == nil || got.RPCAddr != profile.RPCAddr || again.CurrentProfile != defaultProfileName {
+		t.Errorf("stored config read back as %+v", again)
+	}
+}
+
+func TestGetConfigMissing(t *testing.T) {
+	_, cleanup := withConfigHome(t)
+	defer cleanup()
+
+	conf, err := getConfig(zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Profiles) != 0 || conf.CurrentProfile != "" {
+		t.Errorf("missing config read as %+v", conf)
+	}
+}
+
+func TestWritePrivateFile(t *testing.T) {
+	dir, cleanup := withConfigHome(t)
+	defer cleanup()
+
+	filename := filepath.Join(dir, "nested", "state.json")
+	for _, content := range []string{"first", "second"} {
+		if err := writePrivateFile(filename, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Errorf("file holds %q, want %q", b, content)
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != configFileMode {
+			t.Errorf("file mode is %v, want %v", mode, os.FileMode(configFileMode))
+		}
+	}
+
+	// the temp files are gone
+	infos, err := ioutil.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("directory holds %d files, want 1", len(infos))
+	}
+}
+
+func TestProfileSelection(t *testing.T) {
+	_, cleanup := withConfigHome(t)
+	defer cleanup()
+	old, had := os.LookupEnv(profileFlag.EnvVar)
+	defer func() {
+		if had {
+			os.Setenv(profileFlag.EnvVar, old)
+		} else {
+			os.Unsetenv(profileFlag.EnvVar)
+		}
+	}()
+
+	conf := &Config{
+		CurrentProfile: "work",
+		Profiles: map[string]*Profile{
+			"work": {ClientConfig: auth.ClientConfig{RPCAddr: "work:4285"}},
+			"home": {ClientConfig: auth.ClientConfig{RPCAddr: "home:4285"}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		flag    string
+		env     string
+		current string
+		want    string
+	}{
+		{name: "flag wins", flag: "home", env: "env", current: "work", want: "home"},
+		{name: "env beats current", env: "home", current: "work", want: "home"},
+		{name: "current", current: "work", want: "work"},
+		{name: "default", want: defaultProfileName},
+	}
+	for _, tt := range tests {
+		conf.CurrentProfile = tt.current
+		if err := storeConfig(conf, zap.NewNop()); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv(profileFlag.EnvVar, tt.env)
+
+		var got string
+		app := cli.NewApp()
+		app.HideVersion = true
+		app.Flags = GlobalFlags
+		app.Commands = []cli.Command{{
+			Name: "show",
+			Action: func(ctx *cli.Context) {
+				_, got, _ = mustProfile(ctx, zap.NewNop())
+			},
+		}}
+		args := []string{"spreectl"}
+		if tt.flag != "" {
+			args = append(args, "--profile", tt.flag)
+		}
+		app.Run(append(args, "show"))
+
+		if got != tt.want {
+			t.Errorf("%s: selected profile %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/codegangsta/cli"
+	"github.com/ralfonso/spree"
+)
+
+// encryptE2E seals everything in rdr with a new AES-256-GCM key in the
+// format the /p/ page decrypts: a version byte, the nonce, then the sealed
+// file. The browser can only decrypt whole buffers, so the file is read
+// into memory.
+func encryptE2E(rdr io.Reader) (io.Reader, []byte, error) {
+	plain, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := make([]byte, spree.E2EKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make([]byte, 1+spree.E2ENonceSize, 1+spree.E2ENonceSize+len(plain)+aead.Overhead())
+	out[0] = spree.E2EVersion
+	nonce := out[1:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	out = aead.Seal(out, nonce, plain, nil)
+	return bytes.NewReader(out), key, nil
+}
+
+// e2eShareURL builds the link for an e2e shot. The key goes in the fragment
+// so it never reaches the server.
+func e2eShareURL(baseURL string, shot *spree.Shot, key []byte) string {
+	return fmt.Sprintf("%s/p/%s#%s", strings.TrimRight(baseURL, "/"), shot.Id,
+		base64.RawURLEncoding.EncodeToString(key))
+}
+
+// checkE2EFlags refuses a title, description or tags with --e2e. Only the
+// file is encrypted, so they would reach the server in the clear.
+func checkE2EFlags(ctx *cli.Context, ll *zap.Logger) {
+	if !ctx.Bool(e2eFlag.Name) {
+		return
+	}
+	for _, name := range []string{titleFlag.Name, descriptionFlag.Name, tagFlag.Name} {
+		if ctx.IsSet(name) {
+			fatal(ll, exitUsage, fmt.Sprintf("--%s can't be used with --e2e: only the file is encrypted", name))
+		}
+	}
+}
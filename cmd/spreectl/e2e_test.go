@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/ralfonso/spree"
+)
+
+// pageKey decodes the key in a link's fragment the way the /p/ page does:
+// base64url with the padding put back.
+func pageKey(t *testing.T, link string) []byte {
+	i := strings.Index(link, "#")
+	if i < 0 {
+		t.Fatalf("%s has no fragment", link)
+	}
+	s := strings.NewReplacer("-", "+", "_", "/").Replace(link[i+1:])
+	for len(s)%4 != 0 {
+		s += "="
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("decoding the key in %s: %v", link, err)
+	}
+	return key
+}
+
+// pageDecrypt opens data the way the /p/ page does: a version byte, an
+// E2ENonceSize byte nonce, then AES-GCM ciphertext with the tag at the end.
+func pageDecrypt(key, data []byte) ([]byte, error) {
+	if len(data) < 1+spree.E2ENonceSize || data[0] != spree.E2EVersion {
+		return nil, errors.New("unknown format")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	iv := data[1 : 1+spree.E2ENonceSize]
+	return aead.Open(nil, iv, data[1+spree.E2ENonceSize:], nil)
+}
+
+func TestEncryptE2E(t *testing.T) {
+	plain := []byte("\x89PNG not really a png")
+	sealed := func() ([]byte, []byte) {
+		rdr, key, err := encryptE2E(bytes.NewReader(plain))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data, key
+	}
+	data, key := sealed()
+
+	if len(key) != spree.E2EKeySize {
+		t.Errorf("key is %d bytes, want %d", len(key), spree.E2EKeySize)
+	}
+	if bytes.Contains(data, plain) {
+		t.Error("the file is in the clear")
+	}
+
+	link := e2eShareURL("https://spree.example.com/", &spree.Shot{Id: "abc", Path: "/p/abc"}, key)
+	if !strings.HasPrefix(link, "https://spree.example.com/p/abc#") {
+		t.Errorf("link is %s", link)
+	}
+	if strings.ContainsAny(link[strings.Index(link, "#"):], "+/=") {
+		t.Errorf("link %s isn't base64url without padding", link)
+	}
+	if got := pageKey(t, link); !bytes.Equal(got, key) {
+		t.Fatalf("the page would read key %x from the link, want %x", got, key)
+	}
+
+	got, err := pageDecrypt(pageKey(t, link), data)
+	if err != nil {
+		t.Fatalf("the page couldn't decrypt the file: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("decrypted %q, want %q", got, plain)
+	}
+
+	// every upload gets its own key and nonce
+	other, otherKey := sealed()
+	if bytes.Equal(otherKey, key) || bytes.Equal(other[:1+spree.E2ENonceSize], data[:1+spree.E2ENonceSize]) {
+		t.Error("two uploads share a key or nonce")
+	}
+
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := pageDecrypt(key, tampered); err == nil {
+		t.Error("a tampered file decrypted")
+	}
+	if _, err := pageDecrypt(otherKey, data); err == nil {
+		t.Error("the file decrypted with another key")
+	}
+}
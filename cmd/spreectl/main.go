@@ -10,7 +10,10 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "spree-client"
 	app.Usage = "upload stuff"
+	// -v is --verbose
+	app.HideVersion = true
 	app.Flags = GlobalFlags
-	app.Commands = Commands
+	app.Commands = withCommonFlags(Commands)
+	cli.AppHelpTemplate += exitCodesHelp
 	app.Run(os.Args)
 }
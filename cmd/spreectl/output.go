@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"unicode"
+
+	"github.com/codegangsta/cli"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v2"
+)
+
+// Exit codes, so scripts can tell why a command failed.
+const (
+	exitFailure  = 1 // anything not listed below
+	exitUsage    = 2 // bad arguments, flags or requests
+	exitAuth     = 3 // not logged in, or not allowed
+	exitNetwork  = 4 // the server couldn't be reached in time
+	exitNotFound = 5 // no such shot, album or profile
+	exitQuota    = 6 // the server refused because of a limit
+)
+
+const exitCodesHelp = `EXIT CODES:
+   1  failure not listed below
+   2  bad arguments, flags or requests
+   3  not logged in, or not allowed
+   4  the server couldn't be reached in time
+   5  no such shot, album or profile
+   6  the server refused because of a limit, such as an oversize message
+`
+
+var (
+	outputFlag = cli.StringFlag{
+		Name:  "output, o",
+		Usage: "How to print results: json, yaml, table, url or template=<go template>",
+	}
+	verboseFlag = cli.BoolFlag{
+		Name:  "verbose, v",
+		Usage: "Log debugging information to stderr",
+	}
+)
+
+// withCommonFlags adds --output and --verbose to every command that runs
+// something, so they can be given after the command name.
+func withCommonFlags(cmds []cli.Command) []cli.Command {
+	for i := range cmds {
+		if len(cmds[i].Subcommands) > 0 {
+			cmds[i].Subcommands = withCommonFlags(cmds[i].Subcommands)
+			continue
+		}
+		cmds[i].Flags = append(cmds[i].Flags, outputFlag, verboseFlag)
+	}
+	return cmds
+}
+
+// newLogger logs warnings and errors to stderr, or everything with
+// --verbose.
+func newLogger(ctx *cli.Context) *zap.Logger {
+	conf := zap.NewDevelopmentConfig()
+	if !ctx.Bool("verbose") && !ctx.GlobalBool("verbose") {
+		conf.Level.SetLevel(zap.WarnLevel)
+		conf.DisableCaller = true
+		conf.DisableStacktrace = true
+	}
+	ll, err := conf.Build()
+	if err != nil {
+		return zap.NewNop()
+	}
+	return ll
+}
+
+// fatal logs msg and exits with code.
+func fatal(ll *zap.Logger, code int, msg string, fields ...zapcore.Field) {
+	ll.Error(msg, fields...)
+	ll.Sync()
+	os.Exit(code)
+}
+
+// fatalErr logs msg and err, and exits with the code for err.
+func fatalErr(ll *zap.Logger, msg string, err error, fields ...zapcore.Field) {
+	fatal(ll, exitCode(err), msg, append(fields, zap.Error(err))...)
+}
+
+// exitCode classifies err, which is usually from an RPC.
+func exitCode(err error) int {
+	switch grpc.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		return exitUsage
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return exitAuth
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return exitNetwork
+	case codes.NotFound:
+		return exitNotFound
+	case codes.ResourceExhausted:
+		return exitQuota
+	}
+	if _, ok := err.(net.Error); ok {
+		return exitNetwork
+	}
+	return exitFailure
+}
+
+// output prints results in the format given by --output.
+type output struct {
+	format string
+	tmpl   *template.Template
+
+	ctx     *cli.Context
+	ll      *zap.Logger
+	baseURL *string
+}
+
+// mustOutput reads --output, using def when it isn't given. Commands with
+// their own way of printing pass an empty def and check format.
+func mustOutput(ctx *cli.Context, def string, ll *zap.Logger) *output {
+	o := &output{format: flagString(ctx, "output"), ctx: ctx, ll: ll}
+	if o.format == "" {
+		o.format = def
+	}
+
+	if strings.HasPrefix(o.format, "template=") {
+		tmpl, err := template.New("output").Funcs(template.FuncMap{
+			"url":  o.url,
+			"join": joinValues,
+		}).Parse(strings.TrimPrefix(o.format, "template="))
+		if err != nil {
+			fatalErr(ll, "invalid --output template", err)
+		}
+		o.format, o.tmpl = "template", tmpl
+	}
+
+	switch o.format {
+	case "", "json", "yaml", "table", "url", "template":
+	default:
+		fatal(ll, exitUsage, "--output must be json, yaml, table, url or template=<go template>",
+			zap.String("output", o.format))
+	}
+	return o
+}
+
+// print prints v, which is a proto message or something encoding/json can
+// marshal.
+func (o *output) print(v interface{}) {
+	if o.format == "json" {
+		o.printJSON(v)
+		return
+	}
+
+	b, err := marshalJSON(v, "")
+	if err != nil {
+		fatalErr(o.ll, "error marshaling result", err)
+	}
+	if o.format == "template" {
+		var data interface{}
+		if err := json.Unmarshal(b, &data); err != nil {
+			fatalErr(o.ll, "error unmarshaling result", err)
+		}
+		if err := o.tmpl.Execute(os.Stdout, data); err != nil {
+			fatal(o.ll, exitUsage, "error running --output template", zap.Error(err))
+		}
+		return
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	tree, err := decodeOrdered(dec)
+	if err != nil {
+		fatalErr(o.ll, "error unmarshaling result", err)
+	}
+	switch o.format {
+	case "yaml":
+		out, err := yaml.Marshal(tree)
+		if err != nil {
+			fatalErr(o.ll, "error marshaling result to yaml", err)
+		}
+		os.Stdout.Write(out)
+	case "table":
+		printTable(os.Stdout, tree)
+	case "url":
+		for _, link := range o.links(tree, nil) {
+			fmt.Println(link)
+		}
+	}
+}
+
+func (o *output) printJSON(v interface{}) {
+	out, err := marshalJSON(v, "    ")
+	if err != nil {
+		fatalErr(o.ll, "error marshaling result to json", err)
+	}
+	fmt.Println(string(out))
+}
+
+func marshalJSON(v interface{}, indent string) ([]byte, error) {
+	if p, ok := v.(proto.Message); ok {
+		jm := jsonpb.Marshaler{Indent: indent}
+		s, err := jm.MarshalToString(p)
+		return []byte(s), err
+	}
+	if indent == "" {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", indent)
+}
+
+// url turns the path of a shot or album into a link.
+func (o *output) url(path string) string {
+	if o.baseURL == nil {
+		baseURL := strings.TrimRight(shareBaseURL(o.ctx, o.ll), "/")
+		if baseURL == "" {
+			fatal(o.ll, exitUsage, "set --base.url, or log in with it, to print links")
+		}
+		o.baseURL = &baseURL
+	}
+	return *o.baseURL + path
+}
+
+// links appends the links in v to out: url fields as they are, and path
+// fields made into links.
+func (o *output) links(v interface{}, out []string) []string {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		var link string
+		for _, item := range v {
+			switch s, _ := item.Value.(string); {
+			case item.Key == "url" && s != "":
+				link = s
+			case item.Key == "path" && link == "" && strings.HasPrefix(s, "/"):
+				link = o.url(s)
+			}
+		}
+		if link != "" {
+			out = append(out, link)
+		}
+		for _, item := range v {
+			out = o.links(item.Value, out)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			out = o.links(elem, out)
+		}
+	}
+	return out
+}
+
+// decodeOrdered decodes JSON with objects as yaml.MapSlice, so tables and
+// yaml keep fields in the order they're defined.
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			obj := yaml.MapSlice{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				val, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj = append(obj, yaml.MapItem{Key: key, Value: val})
+			}
+			_, err := dec.Token()
+			return obj, err
+		}
+
+		list := []interface{}{}
+		for dec.More() {
+			elem, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elem)
+		}
+		_, err := dec.Token()
+		return list, err
+	case json.Number:
+		if i, err := tok.Int64(); err == nil {
+			return i, nil
+		}
+		return tok.Float64()
+	}
+	return tok, nil
+}
+
+// printTable prints the first list of objects in v as a table with a column
+// per field. Without one, it prints the fields of the first object in v, or
+// of v itself. Nested objects are left out.
+func printTable(w io.Writer, v interface{}) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	rows, fields := tableData(v)
+	for _, item := range fields {
+		if cell, ok := tableCell(item.Value); ok {
+			fmt.Fprintf(tw, "%s\t%s\n", columnName(item.Key), cell)
+		}
+	}
+
+	var columns []interface{}
+	seen := make(map[interface{}]bool)
+	for _, row := range rows {
+		obj, _ := row.(yaml.MapSlice)
+		for _, item := range obj {
+			if _, ok := tableCell(item.Value); ok && !seen[item.Key] {
+				seen[item.Key] = true
+				columns = append(columns, item.Key)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return
+	}
+
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = columnName(col)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		obj, _ := row.(yaml.MapSlice)
+		cells := make([]string, len(columns))
+		for _, item := range obj {
+			for i, col := range columns {
+				if col == item.Key {
+					cells[i], _ = tableCell(item.Value)
+				}
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+}
+
+func tableData(v interface{}) ([]interface{}, yaml.MapSlice) {
+	if list, ok := v.([]interface{}); ok {
+		return list, nil
+	}
+	obj, _ := v.(yaml.MapSlice)
+	for _, item := range obj {
+		if list, ok := item.Value.([]interface{}); ok && len(list) > 0 {
+			if _, ok := list[0].(yaml.MapSlice); ok {
+				return list, nil
+			}
+		}
+	}
+	for _, item := range obj {
+		if inner, ok := item.Value.(yaml.MapSlice); ok {
+			return nil, inner
+		}
+	}
+	return nil, obj
+}
+
+// tableCell formats a value for a table, or returns false if it's an
+// object or a list of objects.
+func tableCell(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		return "", false
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, elem := range v {
+			s, ok := tableCell(elem)
+			if !ok {
+				return "", false
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), true
+	case nil:
+		return "", true
+	}
+	return fmt.Sprint(v), true
+}
+
+// columnName turns a field name like createdAt into CREATED AT.
+func columnName(key interface{}) string {
+	var out []rune
+	for i, r := range fmt.Sprint(key) {
+		if r == '_' {
+			r = ' '
+		} else if unicode.IsUpper(r) && i > 0 {
+			out = append(out, ' ')
+		}
+		out = append(out, unicode.ToUpper(r))
+	}
+	return string(out)
+}
+
+// joinValues joins a list from a result for templates.
+func joinValues(v interface{}, sep string) string {
+	list, _ := v.([]interface{})
+	parts := make([]string, len(list))
+	for i, elem := range list {
+		parts[i] = fmt.Sprint(elem)
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v2"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{grpc.Errorf(codes.InvalidArgument, "bad title"), exitUsage},
+		{grpc.Errorf(codes.FailedPrecondition, "not allowed yet"), exitUsage},
+		{grpc.Errorf(codes.Unauthenticated, "log in"), exitAuth},
+		{grpc.Errorf(codes.PermissionDenied, "someone else's"), exitAuth},
+		{grpc.Errorf(codes.Unavailable, "down"), exitNetwork},
+		{grpc.Errorf(codes.DeadlineExceeded, "slow"), exitNetwork},
+		{grpc.Errorf(codes.NotFound, "no such shot"), exitNotFound},
+		{grpc.Errorf(codes.ResourceExhausted, "too big"), exitQuota},
+		{grpc.Errorf(codes.Internal, "broken"), exitFailure},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("refused")}, exitNetwork},
+		{errors.New("something else"), exitFailure},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func decodeString(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	return decodeOrdered(dec)
+}
+
+func TestDecodeOrdered(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{`"shot"`, "shot"},
+		{`true`, true},
+		{`null`, nil},
+		{`42`, int64(42)},
+		{`1.5`, 1.5},
+		{`[]`, []interface{}{}},
+		{`{}`, yaml.MapSlice{}},
+		{
+			`{"zebra": 1, "apple": [2, "b"], "mango": {"y": null, "x": false}}`,
+			yaml.MapSlice{
+				{Key: "zebra", Value: int64(1)},
+				{Key: "apple", Value: []interface{}{int64(2), "b"}},
+				{Key: "mango", Value: yaml.MapSlice{{Key: "y", Value: nil}, {Key: "x", Value: false}}},
+			},
+		},
+		{
+			`[{"b": 1, "a": 2}, {"a": 3}]`,
+			[]interface{}{
+				yaml.MapSlice{{Key: "b", Value: int64(1)}, {Key: "a", Value: int64(2)}},
+				yaml.MapSlice{{Key: "a", Value: int64(3)}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := decodeString(tt.in)
+		if err != nil {
+			t.Errorf("decoding %s: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decoding %s got %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{``, `{"a": }`, `[1, 2`, `{"a": 1`} {
+		if _, err := decodeString(in); err == nil {
+			t.Errorf("decoding %q succeeded", in)
+		}
+	}
+}
+
+func TestPrintTable(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "list of objects",
+			in:   `[{"id": "a", "sizeBytes": 10}, {"id": "bb", "tags": ["x", "y"], "owner": {"email": "e"}}]`,
+			want: "ID  SIZE BYTES  TAGS\n" +
+				"a   10          \n" +
+				"bb              x,y\n",
+		},
+		{
+			name: "list inside an object",
+			in:   `{"next": "token", "shots": [{"id": "a"}, {"id": "b"}]}`,
+			want: "ID\na\nb\n",
+		},
+		{
+			name: "nested object",
+			in:   `{"shot": {"id": "a", "created_at": "today", "stats": {"views": 1}, "tags": []}}`,
+			want: "ID          a\n" +
+				"CREATED AT  today\n" +
+				"TAGS        \n",
+		},
+		{
+			name: "flat object",
+			in:   `{"name": "work", "current": true, "base_url": null}`,
+			want: "NAME      work\n" +
+				"CURRENT   true\n" +
+				"BASE URL  \n",
+		},
+		{
+			name: "empty list",
+			in:   `[]`,
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		v, err := decodeString(tt.in)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var buf bytes.Buffer
+		printTable(&buf, v)
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: printed\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"go.uber.org/zap"
+
+	"github.com/codegangsta/cli"
+)
+
+var (
+	profileCmd = cli.Command{
+		Name:  "profile",
+		Usage: "manage server and account profiles",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "list the profiles. the current profile is marked with *",
+				Action: ProfileListCommand,
+			},
+			{
+				Name:      "use",
+				Usage:     "make a profile the current profile",
+				ArgsUsage: "<name>",
+				Action:    ProfileUseCommand,
+			},
+			{
+				Name:      "remove",
+				Usage:     "remove a profile and its tokens",
+				ArgsUsage: "<name>",
+				Action:    ProfileRemoveCommand,
+			},
+		},
+	}
+)
+
+// profileInfo is a profile as printed by profile list with --output.
+type profileInfo struct {
+	Name     string `json:"name"`
+	RPCAddr  string `json:"rpc_addr,omitempty"`
+	BaseURL  string `json:"base_url,omitempty"`
+	Current  bool   `json:"current"`
+	LoggedIn bool   `json:"logged_in"`
+}
+
+func ProfileListCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "", ll)
+	conf, err := getConfig(ll)
+	if err != nil {
+		fatalErr(ll, "unable to retrieve client configuration", err)
+	}
+
+	current := conf.ProfileName(ctx.GlobalString(profileFlag.Name))
+	if out.format != "" {
+		infos := []*profileInfo{}
+		for _, name := range conf.ProfileNames() {
+			infos = append(infos, newProfileInfo(conf, name, current))
+		}
+		out.print(infos)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\tNAME\tRPC ADDR\tLOGGED IN")
+	for _, name := range conf.ProfileNames() {
+		profile := conf.Profiles[name]
+		marker := ""
+		if name == current {
+			marker = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", marker, name, profile.RPCAddr, profile.JWT != nil)
+	}
+	w.Flush()
+}
+
+func ProfileUseCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "", ll)
+	name := mustProfileArg(ctx, ll)
+	conf, err := getConfig(ll)
+	if err != nil {
+		fatalErr(ll, "unable to retrieve client configuration", err)
+	}
+
+	if _, ok := conf.Profiles[name]; !ok {
+		fatal(ll, exitNotFound, "no such profile. use the auth command with --profile to create it",
+			zap.String("profile", name))
+	}
+	conf.CurrentProfile = name
+
+	err = storeConfig(conf, ll)
+	if err != nil {
+		fatalErr(ll, "could not store config", err)
+	}
+	if out.format != "" {
+		out.print(newProfileInfo(conf, name, name))
+	}
+}
+
+func ProfileRemoveCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "", ll)
+	name := mustProfileArg(ctx, ll)
+	conf, err := getConfig(ll)
+	if err != nil {
+		fatalErr(ll, "unable to retrieve client configuration", err)
+	}
+
+	var removed *profileInfo
+	if _, ok := conf.Profiles[name]; ok {
+		removed = newProfileInfo(conf, name, "")
+	}
+	err = removeProfile(conf, name)
+	if err != nil {
+		fatal(ll, exitNotFound, "could not remove profile", zap.Error(err))
+	}
+
+	err = storeConfig(conf, ll)
+	if err != nil {
+		fatalErr(ll, "could not store config", err)
+	}
+	if out.format != "" {
+		out.print(removed)
+	}
+}
+
+func newProfileInfo(conf *Config, name, current string) *profileInfo {
+	profile := conf.Profiles[name]
+	return &profileInfo{
+		Name:     name,
+		RPCAddr:  profile.RPCAddr,
+		BaseURL:  profile.BaseURL,
+		Current:  name == current,
+		LoggedIn: profile.JWT != nil,
+	}
+}
+
+func mustProfileArg(ctx *cli.Context, ll *zap.Logger) string {
+	name := ctx.Args().First()
+	if name == "" {
+		fatal(ll, exitUsage, "a profile name is required")
+	}
+	return name
+}
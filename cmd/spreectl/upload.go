@@ -0,0 +1,452 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/codegangsta/cli"
+	"github.com/ralfonso/spree"
+	"golang.org/x/net/context"
+)
+
+// uploadResult is the outcome of uploading one file, as printed in the
+// summary.
+type uploadResult struct {
+	Src   string `json:"src"`
+	ID    string `json:"id,omitempty"`
+	URL   string `json:"url,omitempty"`
+	Bytes int64  `json:"bytes"`
+	Error string `json:"error,omitempty"`
+
+	err error
+}
+
+// UploadCommand uploads the paths given as arguments, or --src when there
+// are none. Uploading --src prints the server's response as before, for
+// scripts that read it; several paths are summarized once they're all done.
+func UploadCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+
+	args := []string(ctx.Args())
+	single := len(args) == 0
+	def := "table"
+	if single {
+		def = "json"
+	}
+	out := mustOutput(ctx, def, ll)
+	filename := ctx.String(filenameFlag.Name)
+	if !single && filename != "" {
+		fatal(ll, exitUsage, "\"file\" can only be used with --src")
+	}
+	if ctx.IsSet(albumFlag.Name) && ctx.Bool(e2eFlag.Name) {
+		fatal(ll, exitUsage, "encrypted shots can't be shown in an album")
+	}
+	checkE2EFlags(ctx, ll)
+
+	var srcs []string
+	var results []*uploadResult
+	if single {
+		srcs = []string{ctx.String(srcFlag.Name)}
+	} else {
+		var failed []*uploadResult
+		srcs, failed = expandUploadPaths(args)
+		results = append(results, failed...)
+	}
+
+	c := mustSpreeClient(ctx, ll)
+	baseURL := shareBaseURL(ctx, ll)
+
+	progress := newUploadProgress(srcs, !single)
+	shots := make([]*spree.Shot, len(srcs))
+	uploaded := make([]*uploadResult, len(srcs))
+	parallel := ctx.Int(parallelFlag.Name)
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				res := &uploadResult{Src: srcs[i]}
+				shot, key, n, err := uploadFile(ctx, c, srcs[i], filename, progress)
+				res.Bytes = n
+				if err != nil {
+					ll.Debug("upload failed", zap.String("src", srcs[i]), zap.Error(err))
+					res.Error = err.Error()
+					res.err = err
+				} else {
+					shots[i] = shot
+					res.ID = shot.Id
+					res.URL = shareURL(baseURL, shot, key)
+				}
+				progress.finish(err == nil)
+				uploaded[i] = res
+			}
+		}()
+	}
+	for i := range srcs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	progress.stop()
+	results = append(results, uploaded...)
+
+	if single && results[0].err != nil {
+		fatalErr(ll, "upload failed", results[0].err)
+	}
+
+	var album *spree.AlbumResponse
+	if ctx.IsSet(albumFlag.Name) {
+		album = createUploadAlbum(ll, c, ctx.String(albumFlag.Name), shots)
+		res := &uploadResult{Src: "album"}
+		if album != nil {
+			res.ID, res.URL = album.Album.Id, strings.TrimRight(baseURL, "/")+album.Album.Path
+		} else {
+			res.Error = "no shots were uploaded to put in it"
+		}
+		results = append(results, res)
+	}
+
+	if single {
+		res := results[0]
+		switch {
+		case album != nil:
+			// the album lists the shot as well
+			out.print(album)
+		case out.format == "url":
+			fmt.Println(res.URL)
+		default:
+			out.print(&spree.CreateResponse{Shot: shots[0]})
+			// the key is only in the link, which goes to stderr so stdout
+			// holds just the response
+			if ctx.Bool(e2eFlag.Name) {
+				fmt.Fprintln(os.Stderr, res.URL)
+			}
+		}
+		return
+	}
+	out.print(results)
+
+	for _, res := range results {
+		if res.Error != "" {
+			os.Exit(exitFailure)
+		}
+	}
+}
+
+// expandUploadPaths returns the files named by args, in order. Globs are
+// expanded, and directories are walked for every file under them apart
+// from hidden ones. Arguments that match nothing are returned as failures.
+func expandUploadPaths(args []string) ([]string, []*uploadResult) {
+	var files []string
+	var failed []*uploadResult
+	seen := make(map[string]bool)
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, arg := range args {
+		if arg == "-" {
+			failed = append(failed, &uploadResult{Src: arg, Error: "stdin can only be uploaded with --src"})
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			failed = append(failed, &uploadResult{Src: arg, Error: err.Error()})
+			continue
+		}
+		if len(matches) == 0 {
+			failed = append(failed, &uploadResult{Src: arg, Error: "no such file"})
+			continue
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				failed = append(failed, &uploadResult{Src: match, Error: err.Error()})
+				continue
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+
+			var walked []string
+			err = filepath.Walk(match, func(p string, info os.FileInfo, err error) error {
+				if err != nil {
+					failed = append(failed, &uploadResult{Src: p, Error: err.Error()})
+					return nil
+				}
+				hidden := p != match && strings.HasPrefix(info.Name(), ".")
+				switch {
+				case info.IsDir() && hidden:
+					return filepath.SkipDir
+				case info.Mode().IsRegular() && !hidden:
+					walked = append(walked, p)
+				}
+				return nil
+			})
+			if err != nil {
+				failed = append(failed, &uploadResult{Src: match, Error: err.Error()})
+			}
+			sort.Strings(walked)
+			for _, p := range walked {
+				add(p)
+			}
+		}
+	}
+	return files, failed
+}
+
+// shareBaseURL is the public URL of the web server, from --base.url or the
+// profile.
+func shareBaseURL(ctx *cli.Context, ll *zap.Logger) string {
+	if url := ctx.GlobalString(baseURLFlag.Name); url != "" {
+		return url
+	}
+	_, _, profile := mustProfile(ctx, ll)
+	return profile.BaseURL
+}
+
+// shareURL is the link to a shot's page. key is the e2e key, if any.
+func shareURL(baseURL string, shot *spree.Shot, key []byte) string {
+	if key != nil {
+		return e2eShareURL(baseURL, shot, key)
+	}
+	return strings.TrimRight(baseURL, "/") + shot.Path
+}
+
+// uploadFile uploads src, or stdin when src is "-", as a new shot named
+// filename, which defaults to the name of src. It returns the shot, its e2e
+// key if it was encrypted, and the number of bytes read from src.
+func uploadFile(ctx *cli.Context, c spree.SpreeClient, src, filename string, progress *uploadProgress) (*spree.Shot, []byte, int64, error) {
+	var rdr io.Reader
+	if src == "-" {
+		if filename == "" {
+			return nil, nil, 0, fmt.Errorf("\"file\" is required when uploading stdin")
+		}
+		rdr = os.Stdin
+	} else {
+		if filename == "" {
+			filename = path.Base(src)
+		}
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		defer f.Close()
+		rdr = f
+	}
+	counter := &progressReader{r: rdr, progress: progress}
+	rdr = counter
+
+	e2e := ctx.Bool(e2eFlag.Name)
+	var key []byte
+	if e2e {
+		var err error
+		rdr, key, err = encryptE2E(rdr)
+		if err != nil {
+			return nil, nil, counter.n, fmt.Errorf("could not encrypt file: %s", err)
+		}
+	}
+
+	// no deadline: a large file on a slow link can take as long as it takes
+	cctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv, err := c.Create(cctx)
+	if err != nil {
+		return nil, nil, counter.n, err
+	}
+
+	if e2e {
+		// the server would see the name, so only its extension is sent
+		filename = spree.E2EFilename(filename)
+	}
+	msg := &spree.CreateRequest{
+		Filename:    path.Base(filename),
+		E2E:         e2e,
+		Title:       ctx.String(titleFlag.Name),
+		Description: ctx.String(descriptionFlag.Name),
+		Tags:        ctx.StringSlice(tagFlag.Name),
+	}
+	shot, err := doUpload(srv, msg, rdr)
+	if err != nil {
+		return nil, nil, counter.n, err
+	}
+	return shot, key, counter.n, nil
+}
+
+// doUpload streams rdr to the server. msg describes the shot and is sent
+// with the first chunk.
+func doUpload(srv spree.Spree_CreateClient, msg *spree.CreateRequest, rdr io.Reader) (*spree.Shot, error) {
+	buf := make([]byte, chunkSizeBytes)
+
+	var offset int64
+	for {
+		n, err := rdr.Read(buf)
+		if err == io.EOF {
+			srv.CloseSend()
+
+			resp, err := srv.Recv()
+			if err == io.EOF {
+				return nil, fmt.Errorf("server closed the stream without a shot")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if resp.Shot == nil {
+				return nil, fmt.Errorf("server returned no shot")
+			}
+			return resp.Shot, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %s", err)
+		}
+		if n == 0 {
+			continue
+		}
+
+		msg.Offset = offset
+		msg.Length = int64(n)
+		msg.Data = buf[:n]
+		if err := srv.Send(msg); err != nil {
+			return nil, fmt.Errorf("error sending message: %s", err)
+		}
+
+		resp, err := srv.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if resp.BytesWritten != msg.Length {
+			return nil, fmt.Errorf("server wrote %d bytes of a %d byte chunk", resp.BytesWritten, msg.Length)
+		}
+
+		offset += msg.Length
+	}
+}
+
+// uploadProgress reports the files and bytes uploaded so far on stderr
+// while uploads are running, if stderr is a terminal.
+type uploadProgress struct {
+	files  int
+	total  int64
+	sent   int64
+	done   int32
+	failed int32
+
+	stopc chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newUploadProgress(srcs []string, show bool) *uploadProgress {
+	p := &uploadProgress{files: len(srcs), stopc: make(chan struct{})}
+	for _, src := range srcs {
+		if info, err := os.Stat(src); err == nil {
+			p.total += info.Size()
+		}
+	}
+	if info, err := os.Stderr.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		show = false
+	}
+	if show {
+		p.wg.Add(1)
+		go p.loop()
+	}
+	return p
+}
+
+func (p *uploadProgress) add(n int64) {
+	atomic.AddInt64(&p.sent, n)
+}
+
+func (p *uploadProgress) finish(ok bool) {
+	atomic.AddInt32(&p.done, 1)
+	if !ok {
+		atomic.AddInt32(&p.failed, 1)
+	}
+}
+
+func (p *uploadProgress) loop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.print("\r")
+		case <-p.stopc:
+			p.print("\r")
+			fmt.Fprintln(os.Stderr)
+			return
+		}
+	}
+}
+
+func (p *uploadProgress) print(prefix string) {
+	fmt.Fprintf(os.Stderr, "%s%d/%d files, %.1f/%.1f MiB, %d failed ", prefix,
+		atomic.LoadInt32(&p.done), p.files,
+		float64(atomic.LoadInt64(&p.sent))/(1<<20), float64(p.total)/(1<<20),
+		atomic.LoadInt32(&p.failed))
+}
+
+func (p *uploadProgress) stop() {
+	close(p.stopc)
+	p.wg.Wait()
+}
+
+// progressReader counts the bytes read through it, adding them to progress
+// if it's set.
+type progressReader struct {
+	r        io.Reader
+	n        int64
+	progress *uploadProgress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.progress != nil {
+		r.progress.add(int64(n))
+	}
+	return n, err
+}
+
+// createUploadAlbum creates an album titled title holding the shots that
+// were uploaded. It returns nil without creating one if none were.
+func createUploadAlbum(ll *zap.Logger, c spree.SpreeClient, title string, shots []*spree.Shot) *spree.AlbumResponse {
+	var ids []string
+	for _, shot := range shots {
+		if shot != nil {
+			ids = append(ids, shot.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	resp, err := c.CreateAlbum(ctx, &spree.CreateAlbumRequest{Title: title, ShotIds: ids})
+	if err != nil {
+		fatalErr(ll, "error in create album response", err)
+	}
+	return resp
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+)
+
+func TestExpandUploadPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spreectl-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{
+		"a.png",
+		"b.png",
+		"notes.txt",
+		"shots/c.png",
+		"shots/nested/d.png",
+		"shots/.hidden.png",
+		"shots/.cache/e.png",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name   string
+		args   []string
+		files  []string
+		failed []string
+	}{
+		{
+			name:  "files",
+			args:  []string{in("b.png"), in("a.png")},
+			files: []string{in("b.png"), in("a.png")},
+		},
+		{
+			name:  "glob",
+			args:  []string{in("*.png")},
+			files: []string{in("a.png"), in("b.png")},
+		},
+		{
+			name:  "directory skips hidden files",
+			args:  []string{in("shots")},
+			files: []string{in("shots/c.png"), in("shots/nested/d.png")},
+		},
+		{
+			name:  "duplicates",
+			args:  []string{in("a.png"), in("*.png"), in("shots/nested"), in("shots")},
+			files: []string{in("a.png"), in("b.png"), in("shots/nested/d.png"), in("shots/c.png")},
+		},
+		{
+			name:   "no match",
+			args:   []string{in("*.gif"), in("missing.png"), in("a.png")},
+			files:  []string{in("a.png")},
+			failed: []string{in("*.gif"), in("missing.png")},
+		},
+		{
+			name:   "stdin",
+			args:   []string{"-"},
+			failed: []string{"-"},
+		},
+		{
+			name:   "bad glob",
+			args:   []string{in("[")},
+			failed: []string{in("[")},
+		},
+	}
+	for _, tt := range tests {
+		files, failed := expandUploadPaths(tt.args)
+		if fmt.Sprint(files) != fmt.Sprint(tt.files) {
+			t.Errorf("%s: got files %v, want %v", tt.name, files, tt.files)
+		}
+		var srcs []string
+		for _, res := range failed {
+			if res.Error == "" {
+				t.Errorf("%s: %s failed without an error", tt.name, res.Src)
+			}
+			srcs = append(srcs, res.Src)
+		}
+		if fmt.Sprint(srcs) != fmt.Sprint(tt.failed) {
+			t.Errorf("%s: got failures %v, want %v", tt.name, srcs, tt.failed)
+		}
+	}
+}
+
+func TestCreateUploadAlbumNothingUploaded(t *testing.T) {
+	// with no client, asking the server for an album would panic
+	if album := createUploadAlbum(zap.NewNop(), nil, "trip", []*spree.Shot{nil, nil}); album != nil {
+		t.Errorf("created %v with nothing uploaded", album)
+	}
+}
@@ -2,8 +2,25 @@
 
 package main
 
-import "os"
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
 
 func homeDir() string {
 	return os.Getenv("HOME")
 }
+
+// shellCommand runs script with sh, which sees args as $1, $2 and so on.
+func shellCommand(script string, args ...string) *exec.Cmd {
+	return exec.Command("sh", append([]string{"-c", script, "sh"}, args...)...)
+}
+
+// fileInode returns the inode number of the file described by info.
+func fileInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
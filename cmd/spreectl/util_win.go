@@ -2,8 +2,23 @@
 
 package main
 
-import "os"
+import (
+	"os"
+	"os/exec"
+)
 
 func homeDir() string {
 	return os.Getenv("USERPROFILE")
 }
+
+// shellCommand runs script with cmd. cmd has no way to pass args through,
+// so they're dropped and scripts should use the environment instead.
+func shellCommand(script string, args ...string) *exec.Cmd {
+	return exec.Command("cmd", "/C", script)
+}
+
+// fileInode returns 0, as windows has no inode numbers in os.FileInfo;
+// files are told apart by size and modification time alone.
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
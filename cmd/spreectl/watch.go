@@ -0,0 +1,389 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/codegangsta/cli"
+	"github.com/ralfonso/spree"
+)
+
+// maxWatchAttempts is how many times a file is uploaded before it's left
+// alone until it changes again.
+const maxWatchAttempts = 3
+
+var (
+	watchPatternFlag = cli.StringSliceFlag{
+		Name:  "pattern",
+		Usage: "Only upload files whose names match this glob, e.g. \"*.png\". May be repeated; the default is every file",
+	}
+	watchSettleFlag = cli.DurationFlag{
+		Name:  "settle",
+		Value: 2 * time.Second,
+		Usage: "How long a file must stop changing before it's uploaded",
+	}
+	watchIntervalFlag = cli.DurationFlag{
+		Name:  "interval",
+		Value: 2 * time.Second,
+		Usage: "How often to look for new files when the directory can't be watched for changes",
+	}
+	watchPollFlag = cli.BoolFlag{
+		Name:  "poll",
+		Usage: "Poll the directory instead of watching it for changes",
+	}
+	watchExecFlag = cli.StringFlag{
+		Name:  "exec",
+		Usage: "A shell command to run after each upload. $1 is the link and $2 the file; SPREE_URL, SPREE_ID and SPREE_FILE are set too",
+	}
+	watchExistingFlag = cli.BoolFlag{
+		Name:  "existing",
+		Usage: "Upload files that were in the directory before it was first watched",
+	}
+	watchStateFlag = cli.StringFlag{
+		Name:  "state",
+		Usage: "Where to record uploaded files. The default is a file per directory in the config directory",
+	}
+
+	watchCmd = cli.Command{
+		Name:      "watch",
+		Usage:     "upload new files in a directory once they stop changing",
+		ArgsUsage: "<dir>",
+		Action:    WatchCommand,
+		Flags: []cli.Flag{
+			watchPatternFlag,
+			watchSettleFlag,
+			watchIntervalFlag,
+			watchPollFlag,
+			watchExecFlag,
+			watchExistingFlag,
+			watchStateFlag,
+			e2eFlag,
+			titleFlag,
+			descriptionFlag,
+			tagFlag,
+			caCertFileFlag,
+		},
+	}
+)
+
+// watchState records the files uploaded from a directory, so restarting
+// watch doesn't upload them again.
+type watchState struct {
+	Dir string `json:"dir"`
+	// Existing holds the files that were in the directory when it was
+	// first watched. They're skipped unless --existing is given.
+	Existing map[string]fileVersion  `json:"existing"`
+	Files    map[string]*watchedFile `json:"files"`
+
+	// Since is when the directory was first watched, from state files
+	// written before Existing. It's only read to fill in Existing.
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// fileVersion tells one version of a file from another. A file moved or
+// copied over another has a new inode even if its size and modification
+// time are kept.
+type fileVersion struct {
+	Inode   uint64    `json:"inode,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func versionOf(info os.FileInfo) fileVersion {
+	return fileVersion{Inode: fileInode(info), Size: info.Size(), ModTime: info.ModTime()}
+}
+
+// same reports whether v and o are the same version. Inodes are only
+// compared when both are known.
+func (v fileVersion) same(o fileVersion) bool {
+	if v.Inode != 0 && o.Inode != 0 && v.Inode != o.Inode {
+		return false
+	}
+	return v.Size == o.Size && v.ModTime.Equal(o.ModTime)
+}
+
+type watchedFile struct {
+	File string `json:"file"`
+	fileVersion
+	Id         string    `json:"id"`
+	URL        string    `json:"url"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// pendingFile is a file waiting to stop changing before it's uploaded.
+type pendingFile struct {
+	version  fileVersion
+	changed  time.Time
+	attempts int
+}
+
+type watcher struct {
+	dir       string
+	patterns  []string
+	settle    time.Duration
+	existing  bool
+	hook      string
+	baseURL   string
+	stateFile string
+	state     *watchState
+	pending   map[string]*pendingFile
+	out       *output
+	ll        *zap.Logger
+
+	// send uploads a file, returning the shot and its e2e key
+	send func(src string) (*spree.Shot, []byte, error)
+}
+
+func WatchCommand(ctx *cli.Context) {
+	ll := newLogger(ctx)
+	out := mustOutput(ctx, "url", ll)
+	checkE2EFlags(ctx, ll)
+
+	dir := ctx.Args().First()
+	if dir == "" {
+		fatal(ll, exitUsage, "a directory to watch is required")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		fatal(ll, exitUsage, "invalid directory", zap.Error(err))
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fatal(ll, exitUsage, "not a directory", zap.String("dir", dir))
+	}
+
+	patterns := ctx.StringSlice(watchPatternFlag.Name)
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			fatal(ll, exitUsage, "invalid pattern", zap.String("pattern", pattern), zap.Error(err))
+		}
+	}
+
+	stateFile := ctx.String(watchStateFlag.Name)
+	if stateFile == "" {
+		sum := sha1.Sum([]byte(dir))
+		stateFile = filepath.Join(configHome(), "watch", hex.EncodeToString(sum[:8])+".json")
+	}
+	state, err := loadWatchState(stateFile, dir)
+	if err != nil {
+		fatalErr(ll, "unable to read watch state", err, zap.String("file", stateFile))
+	}
+
+	c := mustSpreeClient(ctx, ll)
+	w := &watcher{
+		dir:       dir,
+		patterns:  patterns,
+		settle:    ctx.Duration(watchSettleFlag.Name),
+		existing:  ctx.Bool(watchExistingFlag.Name),
+		hook:      ctx.String(watchExecFlag.Name),
+		baseURL:   shareBaseURL(ctx, ll),
+		stateFile: stateFile,
+		state:     state,
+		pending:   make(map[string]*pendingFile),
+		out:       out,
+		ll:        ll,
+		send: func(src string) (*spree.Shot, []byte, error) {
+			shot, key, _, err := uploadFile(ctx, c, src, "", nil)
+			return shot, key, err
+		},
+	}
+	if err := w.saveState(); err != nil {
+		fatalErr(ll, "unable to write watch state", err, zap.String("file", stateFile))
+	}
+
+	var changes <-chan struct{}
+	if !ctx.Bool(watchPollFlag.Name) {
+		changes, err = watchDir(dir)
+		if err != nil {
+			ll.Warn("unable to watch directory for changes, polling instead", zap.Error(err))
+		}
+	}
+	ll.Info("watching", zap.String("dir", dir), zap.Bool("polling", changes == nil))
+
+	var poll <-chan time.Time
+	for {
+		if changes == nil && poll == nil {
+			poll = time.NewTicker(ctx.Duration(watchIntervalFlag.Name)).C
+		}
+		w.scan()
+
+		var settled <-chan time.Time
+		if len(w.pending) > 0 {
+			settled = time.After(w.settle)
+		}
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				ll.Warn("stopped receiving changes, polling instead")
+				changes = nil
+			}
+		case <-settled:
+		case <-poll:
+		}
+	}
+}
+
+func loadWatchState(filename, dir string) (*watchState, error) {
+	state := &watchState{}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, state); err != nil {
+			return nil, err
+		}
+		if state.Dir != dir {
+			return nil, fmt.Errorf("state is for %s", state.Dir)
+		}
+	}
+
+	state.Dir = dir
+	if state.Files == nil {
+		state.Files = make(map[string]*watchedFile)
+	}
+	if state.Existing == nil {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		state.Existing = make(map[string]fileVersion)
+		for _, info := range infos {
+			// files added since an older state file was written are new
+			if info.Mode().IsRegular() && (state.Since == nil || info.ModTime().Before(*state.Since)) {
+				state.Existing[info.Name()] = versionOf(info)
+			}
+		}
+	}
+	state.Since = nil
+	return state, nil
+}
+
+func (w *watcher) saveState() error {
+	b, err := json.MarshalIndent(w.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(w.stateFile, b)
+}
+
+func (w *watcher) matches(name string) bool {
+	if len(w.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range w.patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// scan looks for new and changed files, and uploads the ones that haven't
+// changed for the settle time.
+func (w *watcher) scan() {
+	infos, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		w.ll.Error("unable to read directory", zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool, len(infos))
+	inDir := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		inDir[name] = true
+		if !info.Mode().IsRegular() || strings.HasPrefix(name, ".") || !w.matches(name) {
+			continue
+		}
+		version := versionOf(info)
+		if done, ok := w.state.Files[name]; ok && done.same(version) {
+			continue
+		}
+		if old, ok := w.state.Existing[name]; ok && !w.existing && old.same(version) {
+			continue
+		}
+		seen[name] = true
+
+		f, ok := w.pending[name]
+		if !ok || !f.version.same(version) {
+			w.pending[name] = &pendingFile{version: version, changed: now}
+			continue
+		}
+		if f.attempts >= maxWatchAttempts || now.Sub(f.changed) < w.settle {
+			continue
+		}
+		w.upload(name, f)
+	}
+
+	for name := range w.pending {
+		if !seen[name] {
+			delete(w.pending, name)
+		}
+	}
+
+	// forget existing files once they're gone, so a new file by the same
+	// name is uploaded
+	var gone bool
+	for name := range w.state.Existing {
+		if !inDir[name] {
+			delete(w.state.Existing, name)
+			gone = true
+		}
+	}
+	if gone {
+		if err := w.saveState(); err != nil {
+			w.ll.Error("unable to write watch state", zap.String("file", w.stateFile), zap.Error(err))
+		}
+	}
+}
+
+func (w *watcher) upload(name string, f *pendingFile) {
+	src := filepath.Join(w.dir, name)
+	shot, key, err := w.send(src)
+	if err != nil {
+		f.attempts++
+		f.changed = time.Now()
+		w.ll.Error("upload failed", zap.String("file", src), zap.Int("attempt", f.attempts), zap.Error(err))
+		return
+	}
+	delete(w.pending, name)
+
+	url := shareURL(w.baseURL, shot, key)
+	done := &watchedFile{
+		File:        src,
+		fileVersion: f.version,
+		Id:          shot.Id,
+		URL:         url,
+		UploadedAt:  time.Now(),
+	}
+	w.state.Files[name] = done
+	if err := w.saveState(); err != nil {
+		w.ll.Error("unable to write watch state", zap.String("file", w.stateFile), zap.Error(err))
+	}
+	if w.out.format == "url" {
+		fmt.Println(url)
+	} else {
+		w.out.print(done)
+	}
+
+	if w.hook == "" {
+		return
+	}
+	cmd := shellCommand(w.hook, url, src)
+	cmd.Env = append(os.Environ(), "SPREE_URL="+url, "SPREE_ID="+shot.Id, "SPREE_FILE="+src)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		w.ll.Error("post-upload command failed", zap.String("file", src), zap.Error(err))
+	}
+}
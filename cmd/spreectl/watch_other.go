@@ -0,0 +1,10 @@
+// +build !linux
+
+package main
+
+import "errors"
+
+// watchDir is only implemented with inotify, so other systems poll.
+func watchDir(dir string) (<-chan struct{}, error) {
+	return nil, errors.New("watching for changes is only supported on linux")
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+)
+
+// testWatcher is a watcher over a temporary directory that records uploads
+// instead of sending them.
+type testWatcher struct {
+	*watcher
+	sent    []string
+	failing bool
+}
+
+func newTestWatcher(t *testing.T, dir, stateFile string, existing bool) *testWatcher {
+	state, err := loadWatchState(stateFile, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := &testWatcher{}
+	tw.watcher = &watcher{
+		dir:       dir,
+		settle:    time.Hour,
+		existing:  existing,
+		baseURL:   "https://spree.example.com",
+		stateFile: stateFile,
+		state:     state,
+		pending:   make(map[string]*pendingFile),
+		out:       &output{format: "url"},
+		ll:        zap.NewNop(),
+		send: func(src string) (*spree.Shot, []byte, error) {
+			if tw.failing {
+				return nil, nil, errors.New("server unavailable")
+			}
+			tw.sent = append(tw.sent, filepath.Base(src))
+			id := fmt.Sprintf("shot%d", len(tw.sent))
+			return &spree.Shot{Id: id, Path: "/p/" + id}, nil, nil
+		},
+	}
+	if err := tw.saveState(); err != nil {
+		t.Fatal(err)
+	}
+	return tw
+}
+
+// settled scans twice with no settle time, so files seen unchanged on the
+// first scan are uploaded on the second, and returns what was uploaded.
+func (tw *testWatcher) settled() string {
+	settle := tw.settle
+	tw.settle = 0
+	tw.sent = nil
+	tw.scan()
+	tw.scan()
+	tw.settle = settle
+	sort.Strings(tw.sent)
+	return fmt.Sprint(tw.sent)
+}
+
+func writeWatched(t *testing.T, dir, name, data string, modTime time.Time) {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func withWatchDir(t *testing.T) (string, string, func()) {
+	tmp, err := ioutil.TempDir("", "spreectl-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(tmp, "shots")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir, filepath.Join(tmp, "state.json"), func() { os.RemoveAll(tmp) }
+}
+
+func TestWatchDebounce(t *testing.T) {
+	dir, stateFile, cleanup := withWatchDir(t)
+	defer cleanup()
+	w := newTestWatcher(t, dir, stateFile, false)
+
+	writeWatched(t, dir, "a.png", "a", time.Now())
+	w.scan()
+	w.scan()
+	if len(w.sent) > 0 {
+		t.Fatalf("uploaded %v before the settle time", w.sent)
+	}
+
+	// a change restarts the wait
+	writeWatched(t, dir, "a.png", "ab", time.Now())
+	w.scan()
+	if f := w.pending["a.png"]; f == nil || f.version.Size != 2 || time.Since(f.changed) > time.Minute {
+		t.Fatalf("pending file is %+v after a change", f)
+	}
+	w.pending["a.png"].changed = time.Now().Add(-2 * time.Hour)
+	w.scan()
+	if fmt.Sprint(w.sent) != "[a.png]" {
+		t.Fatalf("uploaded %v once settled, want [a.png]", w.sent)
+	}
+	if len(w.pending) > 0 {
+		t.Errorf("%v still pending after upload", w.pending)
+	}
+
+	// hidden files and files not matching a pattern are left alone
+	w.patterns = []string{"*.png"}
+	writeWatched(t, dir, ".b.png", "b", time.Now())
+	writeWatched(t, dir, "c.txt", "c", time.Now())
+	if got := w.settled(); got != "[]" {
+		t.Errorf("uploaded %s, want nothing", got)
+	}
+
+	// failed uploads are retried up to maxWatchAttempts times
+	w.failing = true
+	writeWatched(t, dir, "d.png", "d", time.Now())
+	w.settle = 0
+	for i := 0; i < maxWatchAttempts+2; i++ {
+		w.scan()
+	}
+	if f := w.pending["d.png"]; f == nil || f.attempts != maxWatchAttempts {
+		t.Errorf("failing file is %+v, want %d attempts", f, maxWatchAttempts)
+	}
+	w.failing = false
+	writeWatched(t, dir, "d.png", "dd", time.Now())
+	if got := w.settled(); got != "[d.png]" {
+		t.Errorf("changed file uploaded %s, want [d.png]", got)
+	}
+}
+
+func TestWatchState(t *testing.T) {
+	dir, stateFile, cleanup := withWatchDir(t)
+	defer cleanup()
+	old := time.Now().Add(-24 * time.Hour)
+	writeWatched(t, dir, "existing.png", "e", old)
+
+	w := newTestWatcher(t, dir, stateFile, false)
+	if got := w.settled(); got != "[]" {
+		t.Fatalf("uploaded %s, want existing files skipped", got)
+	}
+
+	// files moved or copied in keep their old times but are still new
+	writeWatched(t, filepath.Dir(dir), "moved.png", "m", old)
+	if err := os.Rename(filepath.Join(filepath.Dir(dir), "moved.png"), filepath.Join(dir, "moved.png")); err != nil {
+		t.Fatal(err)
+	}
+	writeWatched(t, dir, "copied.png", "c", old)
+	if got := w.settled(); got != "[copied.png moved.png]" {
+		t.Fatalf("uploaded %s, want the moved and copied files", got)
+	}
+
+	// a restart remembers what was uploaded and what was already there
+	w = newTestWatcher(t, dir, stateFile, false)
+	if got := w.settled(); got != "[]" {
+		t.Errorf("after a restart, uploaded %s again", got)
+	}
+
+	// a file replaced by another of the same size and time is new
+	replacement := filepath.Join(filepath.Dir(dir), "replacement")
+	writeWatched(t, filepath.Dir(dir), "replacement", "x", old)
+	if err := os.Rename(replacement, filepath.Join(dir, "copied.png")); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.settled(); got != "[copied.png]" {
+		t.Errorf("replaced file uploaded %s, want [copied.png]", got)
+	}
+
+	// an existing file that's removed and put back is new
+	if err := os.Remove(filepath.Join(dir, "existing.png")); err != nil {
+		t.Fatal(err)
+	}
+	w.scan()
+	writeWatched(t, dir, "existing.png", "e", old)
+	if got := w.settled(); got != "[existing.png]" {
+		t.Errorf("re-added file uploaded %s, want [existing.png]", got)
+	}
+
+	// --existing uploads the files that were already there
+	other, otherState, cleanupOther := withWatchDir(t)
+	defer cleanupOther()
+	writeWatched(t, other, "existing.png", "e", old)
+	w = newTestWatcher(t, other, otherState, true)
+	if got := w.settled(); got != "[existing.png]" {
+		t.Errorf("with --existing, uploaded %s", got)
+	}
+}
+
+func TestLoadWatchStateLegacy(t *testing.T) {
+	dir, stateFile, cleanup := withWatchDir(t)
+	defer cleanup()
+	since := time.Now().Add(-time.Hour)
+	writeWatched(t, dir, "before.png", "b", since.Add(-time.Hour))
+	writeWatched(t, dir, "uploaded.png", "u", since.Add(time.Minute))
+	writeWatched(t, dir, "after.png", "a", since.Add(time.Minute))
+
+	legacy := fmt.Sprintf(`{"dir": %q, "since": %q, "files": {"uploaded.png": {"size": 1, "mod_time": %q, "id": "shot0"}}}`,
+		dir, since.Format(time.RFC3339Nano), since.Add(time.Minute).Format(time.RFC3339Nano))
+	if err := ioutil.WriteFile(stateFile, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newTestWatcher(t, dir, stateFile, false)
+	if w.state.Since != nil {
+		t.Errorf("since was kept as %v", w.state.Since)
+	}
+	if got := w.settled(); got != "[after.png]" {
+		t.Errorf("uploaded %s, want [after.png]", got)
+	}
+
+	if _, err := loadWatchState(stateFile, filepath.Dir(dir)); err == nil {
+		t.Error("loaded the state for another directory")
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
-import "github.com/codegangsta/cli"
+import (
+	"time"
+
+	"github.com/codegangsta/cli"
+)
 
 var (
 	caCertFileFlag = cli.StringFlag{
@@ -39,6 +43,107 @@ var (
 		Usage:  "The directory in which to store uploaded files",
 		EnvVar: "SPREE_DATA_DIR",
 	}
+	storageBackendFlag = cli.StringFlag{
+		Name:   "storage.backend",
+		Value:  "file",
+		Usage:  "where uploaded files are stored: \"file\" (data.dir), \"s3\" or \"memory\" (lost on exit)",
+		EnvVar: "SPREE_STORAGE_BACKEND",
+	}
+	tierColdBackendFlag = cli.StringFlag{
+		Name:   "tier.cold.backend",
+		Value:  "",
+		Usage:  "enables tiering: where files that haven't been viewed recently are moved, \"file\" (tier.cold.dir) or \"s3\"",
+		EnvVar: "SPREE_TIER_COLD_BACKEND",
+	}
+	tierColdDirFlag = cli.StringFlag{
+		Name:   "tier.cold.dir",
+		Value:  "",
+		Usage:  "the directory for the cold tier when tier.cold.backend is \"file\"",
+		EnvVar: "SPREE_TIER_COLD_DIR",
+	}
+	tierColdDaysFlag = cli.IntFlag{
+		Name:   "tier.cold.days",
+		Value:  30,
+		Usage:  "move files to the cold tier after this many days without a view",
+		EnvVar: "SPREE_TIER_COLD_DAYS",
+	}
+	tierIntervalFlag = cli.DurationFlag{
+		Name:   "tier.interval",
+		Value:  time.Hour,
+		Usage:  "how often to look for files to move to the cold tier",
+		EnvVar: "SPREE_TIER_INTERVAL",
+	}
+	replicaDirsFlag = cli.StringFlag{
+		Name:   "replica.dirs",
+		Value:  "",
+		Usage:  "comma-separated directories to replicate every file to, in addition to the storage backend",
+		EnvVar: "SPREE_REPLICA_DIRS",
+	}
+	replicaWriteQuorumFlag = cli.IntFlag{
+		Name:   "replica.write.quorum",
+		Value:  1,
+		Usage:  "how many replicas must store an upload for it to succeed. the rest are repaired later",
+		EnvVar: "SPREE_REPLICA_WRITE_QUORUM",
+	}
+	replicaScanIntervalFlag = cli.DurationFlag{
+		Name:   "replica.scan.interval",
+		Value:  6 * time.Hour,
+		Usage:  "how often to compare replicas and copy missing files",
+		EnvVar: "SPREE_REPLICA_SCAN_INTERVAL",
+	}
+	viewsFlushIntervalFlag = cli.DurationFlag{
+		Name:   "views.flush.interval",
+		Value:  5 * time.Second,
+		Usage:  "how often view counts are written to the database",
+		EnvVar: "SPREE_VIEWS_FLUSH_INTERVAL",
+	}
+	s3EndpointFlag = cli.StringFlag{
+		Name:   "s3.endpoint",
+		Value:  "https://s3.amazonaws.com",
+		Usage:  "base URL of the S3-compatible endpoint",
+		EnvVar: "SPREE_S3_ENDPOINT",
+	}
+	s3RegionFlag = cli.StringFlag{
+		Name:   "s3.region",
+		Value:  "us-east-1",
+		Usage:  "region used to sign S3 requests",
+		EnvVar: "SPREE_S3_REGION,AWS_REGION",
+	}
+	s3BucketFlag = cli.StringFlag{
+		Name:   "s3.bucket",
+		Value:  "",
+		Usage:  "the S3 bucket to store files in",
+		EnvVar: "SPREE_S3_BUCKET",
+	}
+	s3AccessKeyFlag = cli.StringFlag{
+		Name:   "s3.access.key",
+		Value:  "",
+		Usage:  "S3 access key id",
+		EnvVar: "SPREE_S3_ACCESS_KEY,AWS_ACCESS_KEY_ID",
+	}
+	s3SecretKeyFlag = cli.StringFlag{
+		Name:   "s3.secret.key",
+		Value:  "",
+		Usage:  "S3 secret access key",
+		EnvVar: "SPREE_S3_SECRET_KEY,AWS_SECRET_ACCESS_KEY",
+	}
+	s3PathStyleFlag = cli.BoolFlag{
+		Name:   "s3.path.style",
+		Usage:  "use path-style bucket addressing (MinIO, Ceph RGW)",
+		EnvVar: "SPREE_S3_PATH_STYLE",
+	}
+	httpTrustedProxiesFlag = cli.StringFlag{
+		Name:   "http.trusted.proxies",
+		Value:  "",
+		Usage:  "comma-separated addresses or CIDR ranges of proxies in front of the http server. X-Forwarded-For is ignored from anyone else",
+		EnvVar: "SPREE_HTTP_TRUSTED_PROXIES",
+	}
+	s3RedirectFlag = cli.DurationFlag{
+		Name:   "s3.redirect.expiry",
+		Value:  0,
+		Usage:  "redirect /r/ to presigned S3 URLs valid for this long instead of proxying. 0 proxies",
+		EnvVar: "SPREE_S3_REDIRECT_EXPIRY",
+	}
 	dbFileFlag = cli.StringFlag{
 		Name:   "db.file",
 		Value:  "/tmp/spree.boltdb",
@@ -57,6 +162,54 @@ var (
 		Usage:  "comma-separated string containing the emails allowed to access the server",
 		EnvVar: "SPREE_ALLOWED_EMAILS",
 	}
+	migrateOwnerFlag = cli.StringFlag{
+		Name:   "migrate.owner",
+		Value:  "",
+		Usage:  "assign shots uploaded before ownership was recorded to this email when migrating the database. required if there are any",
+		EnvVar: "SPREE_MIGRATE_OWNER",
+	}
+	auditFileFlag = cli.StringFlag{
+		Name:   "audit.file",
+		Value:  "",
+		Usage:  "optional file to append JSON-lines audit records to, in addition to the database",
+		EnvVar: "SPREE_AUDIT_FILE",
+	}
+	auditFlushIntervalFlag = cli.DurationFlag{
+		Name:   "audit.flush.interval",
+		Value:  5 * time.Second,
+		Usage:  "how often audit records of web requests are written to the database",
+		EnvVar: "SPREE_AUDIT_FLUSH_INTERVAL",
+	}
+	auditRetentionFlag = cli.DurationFlag{
+		Name:   "audit.retention",
+		Value:  90 * 24 * time.Hour,
+		Usage:  "how long audit records are kept in the database. 0 keeps them forever",
+		EnvVar: "SPREE_AUDIT_RETENTION",
+	}
+	encryptionKeyFileFlag = cli.StringFlag{
+		Name:   "encryption.key.file",
+		Value:  "",
+		Usage:  "file holding the 32-byte master key (raw, hex or base64). enables encryption at rest",
+		EnvVar: "SPREE_ENCRYPTION_KEY_FILE",
+	}
+	encryptionKeyFlag = cli.StringFlag{
+		Name:   "encryption.key",
+		Value:  "",
+		Usage:  "hex or base64 master key, for when the key can't be kept in a file",
+		EnvVar: "SPREE_ENCRYPTION_KEY",
+	}
+	encryptionPreviousKeyFilesFlag = cli.StringFlag{
+		Name:   "encryption.previous.key.files",
+		Value:  "",
+		Usage:  "comma-separated files holding retired master keys that can still unwrap data keys",
+		EnvVar: "SPREE_ENCRYPTION_PREVIOUS_KEY_FILES",
+	}
+	adminEmailsFlag = cli.StringFlag{
+		Name:   "admin.emails",
+		Value:  "",
+		Usage:  "comma-separated string containing the emails allowed to call admin RPCs",
+		EnvVar: "SPREE_ADMIN_EMAILS",
+	}
 )
 
 var GlobalFlags = []cli.Flag{
@@ -65,8 +218,72 @@ var GlobalFlags = []cli.Flag{
 	keyFileFlag,
 	rpcAddrFlag,
 	httpAddrFlag,
+	httpTrustedProxiesFlag,
 	dataDirFlag,
+	storageBackendFlag,
+	tierColdBackendFlag,
+	tierColdDirFlag,
+	tierColdDaysFlag,
+	tierIntervalFlag,
+	replicaDirsFlag,
+	replicaWriteQuorumFlag,
+	replicaScanIntervalFlag,
+	viewsFlushIntervalFlag,
+	s3EndpointFlag,
+	s3RegionFlag,
+	s3BucketFlag,
+	s3AccessKeyFlag,
+	s3SecretKeyFlag,
+	s3PathStyleFlag,
+	s3RedirectFlag,
 	dbFileFlag,
 	dbBucketFlag,
 	allowedEmailsFlag,
+	adminEmailsFlag,
+	migrateOwnerFlag,
+	auditFileFlag,
+	auditFlushIntervalFlag,
+	auditRetentionFlag,
+	encryptionKeyFileFlag,
+	encryptionKeyFlag,
+	encryptionPreviousKeyFilesFlag,
+}
+
+var (
+	// rotate-key flags
+	newKeyFileFlag = cli.StringFlag{
+		Name:  "new.key.file",
+		Value: "",
+		Usage: "file holding the master key to re-wrap every data key with",
+	}
+
+	// migrate flags
+	dryRunFlag = cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "report the pending migrations without applying them",
+	}
+)
+
+var Commands = []cli.Command{
+	{
+		Name:   "rotate-key",
+		Usage:  "re-wrap the data keys with a new master key without re-encrypting files. stop serve first",
+		Action: rotateKey,
+		Flags: []cli.Flag{
+			newKeyFileFlag,
+		},
+	},
+	{
+		Name:   "migrate",
+		Usage:  "upgrade the database schema. serve does this at startup",
+		Action: migrate,
+		Flags: []cli.Flag{
+			dryRunFlag,
+		},
+	},
+	{
+		Name:   "reindex",
+		Usage:  "rebuild the owner, tag and search indexes from the shots",
+		Action: reindex,
+	},
 }
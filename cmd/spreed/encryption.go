@@ -0,0 +1,99 @@
+package main
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/codegangsta/cli"
+	"github.com/ralfonso/spree"
+	"golang.org/x/net/context"
+)
+
+// masterKey loads the master key from the key file or the key flag. It
+// returns nil when encryption isn't configured.
+func masterKey(ctx *cli.Context, ll *zap.Logger) *spree.MasterKey {
+	if keyFile := ctx.GlobalString(encryptionKeyFileFlag.Name); keyFile != "" {
+		key, err := spree.LoadMasterKey(keyFile)
+		if err != nil {
+			ll.Fatal("unable to load master key", zap.String("file", keyFile), zap.Error(err))
+		}
+		return key
+	}
+
+	if raw := ctx.GlobalString(encryptionKeyFlag.Name); raw != "" {
+		key, err := spree.ParseMasterKey([]byte(raw))
+		if err != nil {
+			ll.Fatal("unable to parse master key", zap.Error(err))
+		}
+		return key
+	}
+
+	return nil
+}
+
+func previousKeys(ctx *cli.Context, ll *zap.Logger) []*spree.MasterKey {
+	var keys []*spree.MasterKey
+	for _, keyFile := range stringCSV(ctx, encryptionPreviousKeyFilesFlag) {
+		key, err := spree.LoadMasterKey(keyFile)
+		if err != nil {
+			ll.Fatal("unable to load previous master key", zap.String("file", keyFile), zap.Error(err))
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// rotateKey re-wraps every data key with the key in new.key.file. The
+// current and previous keys are used to unwrap. Once it finishes, point
+// encryption.key.file at the new key.
+//
+// It runs offline: an upload rewriting a key's sidecar while it's re-wrapped
+// would lose one of the two, so it refuses to start while serve has the
+// database open, and holds it so serve can't start until it's done.
+func rotateKey(ctx *cli.Context) {
+	ll, _ := zap.NewDevelopment()
+
+	dbFile := ctx.GlobalString(dbFileFlag.Name)
+	lock, err := spree.LockDB(dbFile, time.Second)
+	if err == spree.ErrDBLocked {
+		ll.Fatal("the database is in use. stop spreed before rotating the key", zap.String("db.file", dbFile))
+	}
+	if err != nil {
+		ll.Fatal("unable to open database", zap.String("db.file", dbFile), zap.Error(err))
+	}
+	defer lock.Close()
+
+	newKeyFile := ctx.String(newKeyFileFlag.Name)
+	if newKeyFile == "" {
+		ll.Fatal("must set flag", zap.String("flag.name", newKeyFileFlag.Name))
+	}
+	newKey, err := spree.LoadMasterKey(newKeyFile)
+	if err != nil {
+		ll.Fatal("unable to load new master key", zap.String("file", newKeyFile), zap.Error(err))
+	}
+
+	current := masterKey(ctx, ll)
+	if current == nil {
+		ll.Fatal("encryption is not configured, nothing to rotate")
+	}
+	oldKeys := append([]*spree.MasterKey{current}, previousKeys(ctx, ll)...)
+
+	var n int
+	hot, cold, _ := mustBackends(ctx, ll)
+	for _, backend := range []spree.Storage{hot, cold} {
+		if backend == nil {
+			continue
+		}
+		store := spree.NewEncryptedStorage(backend, newKey, oldKeys, ll)
+		rewrapped, err := store.RewrapKeys(context.Background())
+		n += rewrapped
+		if err != nil {
+			ll.Fatal("unable to rotate master key", zap.Int("rewrapped", n), zap.Error(err))
+		}
+	}
+	ll.Info("rotated master key",
+		zap.String("old.key.id", current.ID()),
+		zap.String("new.key.id", newKey.ID()),
+		zap.Int("rewrapped", n))
+}
@@ -6,7 +6,9 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
@@ -17,6 +19,7 @@ import (
 	assetfs "github.com/elazarl/go-bindata-assetfs"
 	"github.com/ralfonso/spree"
 	"github.com/ralfonso/spree/auth"
+	"golang.org/x/net/context"
 )
 
 var Version = "0.2.0"
@@ -28,28 +31,43 @@ func main() {
 	app.Name = "spree"
 	app.Usage = "upload stuff"
 	app.Action = serve
+	app.Commands = Commands
 	app.Run(os.Args)
 }
 
 func serve(ctx *cli.Context) {
 	ll, _ := zap.NewDevelopment()
-	boltKV, err := spree.NewBoltKV(ctx.String(dbFileFlag.Name), ctx.String(dbBucketFlag.Name), ll)
-	if err != nil {
-		ll.Fatal("unable to create BoltKV", zap.Error(err))
+	boltKV := mustBoltKV(ctx, ll)
+	mustMigrate(boltKV, ll)
+
+	stack := mustStorage(ctx, ll)
+	if stack.tiers != nil {
+		coldAfter := time.Duration(ctx.GlobalInt(tierColdDaysFlag.Name)) * 24 * time.Hour
+		migrator := spree.NewTierMigrator(boltKV, stack.tiers, coldAfter, ll)
+		go migrator.Loop(context.Background(), ctx.GlobalDuration(tierIntervalFlag.Name))
+	}
+	if stack.replicas != nil {
+		go stack.replicas.ScanLoop(context.Background(), ctx.GlobalDuration(replicaScanIntervalFlag.Name))
 	}
 
-	dataDir := ctx.String(dataDirFlag.Name)
-	store, err := spree.NewFileStorage(dataDir)
+	auditLog, err := spree.NewAuditLog(boltKV, ctx.GlobalString(auditFileFlag.Name), ll)
 	if err != nil {
-		ll.Fatal("unable to create FileStore", zap.Error(err))
+		ll.Fatal("unable to open audit log", zap.Error(err))
 	}
-
-	server := spree.NewServer(boltKV, store, ll)
+	auditCtx, stopAudit := context.WithCancel(context.Background())
+	auditDone := make(chan struct{})
+	go func() {
+		auditLog.Loop(auditCtx, ctx.GlobalDuration(auditFlushIntervalFlag.Name), ctx.GlobalDuration(auditRetentionFlag.Name))
+		close(auditDone)
+	}()
+
+	server := spree.NewServer(boltKV, stack.storage, boltKV, auditLog, stack.replicas, ll)
 	rpcAddr := ctx.GlobalString(rpcAddrFlag.Name)
 	caCertFile := ctx.GlobalString(caCertFileFlag.Name)
 	certFile := ctx.GlobalString(certFileFlag.Name)
 	keyFile := ctx.GlobalString(keyFileFlag.Name)
 	allowedEmails := mustStringCSV(ctx, allowedEmailsFlag, ll)
+	adminEmails := stringCSV(ctx, adminEmailsFlag)
 
 	if caCertFile == "" || certFile == "" || keyFile == "" {
 		ll.Fatal("must have CA cert, server cert, and server key")
@@ -73,9 +91,14 @@ func serve(ctx *cli.Context) {
 	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
 
 	a := auth.NewAuthenticator(ll)
-	jwtInterceptor := auth.MakeJWTInterceptor(allowedEmails, a, ll)
+	interceptorConf := auth.InterceptorConfig{
+		AllowedEmails: allowedEmails,
+		AdminEmails:   adminEmails,
+		Sessions:      boltKV,
+	}
 	serverOpts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(jwtInterceptor),
+		grpc.UnaryInterceptor(auth.MakeJWTInterceptor(interceptorConf, a, ll)),
+		grpc.StreamInterceptor(auth.MakeJWTStreamInterceptor(interceptorConf, a, ll)),
 	}
 
 	lis, err := tls.Listen("tcp", rpcAddr, tlsConfig)
@@ -95,9 +118,35 @@ func serve(ctx *cli.Context) {
 		Prefix:    "static/server/static",
 	}
 	httpAddr := ctx.String(httpAddrFlag.Name)
-	httpServer := spree.NewHTTPServer(httpAddr, boltKV, store, assetFS, ll)
+	views := spree.NewViewCounter(boltKV, ll)
+	viewsCtx, stopViews := context.WithCancel(context.Background())
+	viewsDone := make(chan struct{})
+	go func() {
+		views.Loop(viewsCtx, ctx.GlobalDuration(viewsFlushIntervalFlag.Name))
+		close(viewsDone)
+	}()
+
+	trustedProxies, err := spree.ParseTrustedProxies(stringCSV(ctx, httpTrustedProxiesFlag))
+	if err != nil {
+		ll.Fatal("invalid trusted proxies", zap.Error(err))
+	}
+	httpServer := spree.NewHTTPServer(httpAddr, boltKV, views, stack.storage, auditLog, assetFS,
+		ctx.GlobalDuration(s3RedirectFlag.Name), trustedProxies, ll)
 	go httpServer.Run()
-	select {}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	ll.Info("shutting down", zap.String("signal", (<-sig).String()))
+
+	// write out the views counted and web requests audited since the last flush
+	stopViews()
+	<-viewsDone
+	stopAudit()
+	<-auditDone
+	if err := auditLog.Close(); err != nil {
+		ll.Error("unable to close audit log", zap.Error(err))
+	}
+	boltKV.Close()
 }
 
 func mustStringCSV(ctx *cli.Context, strFlag cli.StringFlag, ll *zap.Logger) []string {
@@ -107,3 +156,11 @@ func mustStringCSV(ctx *cli.Context, strFlag cli.StringFlag, ll *zap.Logger) []s
 	}
 	return strings.Split(raw, ",")
 }
+
+func stringCSV(ctx *cli.Context, strFlag cli.StringFlag) []string {
+	raw := ctx.GlobalString(strFlag.Name)
+	if raw == "" {
+		return nil
+	}
+	return strings.Split(raw, ",")
+}
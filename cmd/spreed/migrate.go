@@ -0,0 +1,76 @@
+package main
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/codegangsta/cli"
+	"github.com/ralfonso/spree"
+)
+
+func mustBoltKV(ctx *cli.Context, ll *zap.Logger) *spree.BoltKV {
+	boltKV, err := spree.NewBoltKV(ctx.GlobalString(dbFileFlag.Name), ctx.GlobalString(dbBucketFlag.Name), ll)
+	if err != nil {
+		ll.Fatal("unable to create BoltKV", zap.Error(err))
+	}
+	boltKV.SetLegacyOwner(ctx.GlobalString(migrateOwnerFlag.Name))
+	return boltKV
+}
+
+// mustMigrate brings the database up to the current schema version.
+func mustMigrate(boltKV *spree.BoltKV, ll *zap.Logger) {
+	applied, backupFile, err := boltKV.Migrate()
+	if err != nil {
+		ll.Fatal("unable to migrate database",
+			zap.String("backup.file", backupFile),
+			zap.Int("applied", len(applied)),
+			zap.Error(err))
+	}
+	if len(applied) > 0 {
+		ll.Info("migrated database",
+			zap.Int("schema.version", spree.SchemaVersion()),
+			zap.String("backup.file", backupFile),
+			zap.Int("applied", len(applied)))
+	}
+}
+
+// migrate applies pending migrations, or with --dry-run lists them.
+func migrate(ctx *cli.Context) {
+	ll, _ := zap.NewDevelopment()
+	boltKV := mustBoltKV(ctx, ll)
+	defer boltKV.Close()
+
+	version, err := boltKV.SchemaVersion()
+	if err != nil {
+		ll.Fatal("unable to read schema version", zap.Error(err))
+	}
+	pending, err := boltKV.PendingMigrations()
+	if err != nil {
+		ll.Fatal("unable to list migrations", zap.Error(err))
+	}
+
+	ll.Info("database schema",
+		zap.Int("schema.version", version),
+		zap.Int("latest.version", spree.SchemaVersion()),
+		zap.Int("pending", len(pending)))
+	if !ctx.Bool(dryRunFlag.Name) {
+		mustMigrate(boltKV, ll)
+		return
+	}
+	for _, m := range pending {
+		ll.Info("pending migration", zap.Int("version", m.Version), zap.String("description", m.Description))
+	}
+}
+
+// reindex rebuilds the database's indexes.
+func reindex(ctx *cli.Context) {
+	ll, _ := zap.NewDevelopment()
+	boltKV := mustBoltKV(ctx, ll)
+	defer boltKV.Close()
+	mustMigrate(boltKV, ll)
+
+	n, err := boltKV.RebuildIndexes()
+	if err != nil {
+		ll.Fatal("unable to rebuild indexes", zap.Error(err))
+	}
+	ll.Info("rebuilt indexes", zap.Int("shots", n))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/codegangsta/cli"
+	"github.com/ralfonso/spree"
+)
+
+const (
+	// uploads older than this at startup were interrupted by a crash
+	staleUploadAge = time.Hour
+)
+
+// storageStack is the configured Storage, plus the layers that serve needs
+// to reach directly. tiers and replicas are nil when not configured.
+type storageStack struct {
+	storage  spree.Storage
+	tiers    *spree.TieredStorage
+	replicas *spree.ReplicatedStorage
+}
+
+// mustStorage creates the Storage selected by the storage flags. Each
+// backend is encrypted when a master key is configured.
+func mustStorage(ctx *cli.Context, ll *zap.Logger) storageStack {
+	hot, cold, replicas := mustBackends(ctx, ll)
+
+	if master := masterKey(ctx, ll); master != nil {
+		ll.Info("encrypting storage", zap.String("key.id", master.ID()))
+		previous := previousKeys(ctx, ll)
+		hot = spree.NewEncryptedStorage(hot, master, previous, ll)
+		if cold != nil {
+			cold = spree.NewEncryptedStorage(cold, master, previous, ll)
+		}
+	}
+
+	if cold == nil {
+		return storageStack{storage: hot, replicas: replicas}
+	}
+	tiers := spree.NewTieredStorage(hot, cold, ll)
+	return storageStack{storage: tiers, tiers: tiers, replicas: replicas}
+}
+
+// mustBackends creates the primary backend, replicated to replica.dirs if
+// set, and the cold tier backend if tiering is enabled.
+func mustBackends(ctx *cli.Context, ll *zap.Logger) (spree.Storage, spree.Storage, *spree.ReplicatedStorage) {
+	backend := ctx.GlobalString(storageBackendFlag.Name)
+	dataDir := ctx.GlobalString(dataDirFlag.Name)
+	hot := mustBackend(ctx, backend, dataDir, ll)
+
+	var replicas *spree.ReplicatedStorage
+	if dirs := stringCSV(ctx, replicaDirsFlag); len(dirs) > 0 {
+		reps := []spree.Replica{{Name: backendName(ctx, backend, dataDir), Storage: hot}}
+		for _, dir := range dirs {
+			reps = append(reps, spree.Replica{
+				Name:    backendName(ctx, "file", dir),
+				Storage: mustBackend(ctx, "file", dir, ll),
+			})
+		}
+
+		var err error
+		replicas, err = spree.NewReplicatedStorage(reps, ctx.GlobalInt(replicaWriteQuorumFlag.Name), ll)
+		if err != nil {
+			ll.Fatal("unable to create ReplicatedStorage", zap.Error(err))
+		}
+		hot = replicas
+	}
+
+	coldBackend := ctx.GlobalString(tierColdBackendFlag.Name)
+	if coldBackend == "" {
+		return hot, nil, replicas
+	}
+	if coldBackend == "s3" && backend == "s3" {
+		ll.Fatal("the hot and cold tiers can't both use the s3 flags")
+	}
+	cold := mustBackend(ctx, coldBackend, ctx.GlobalString(tierColdDirFlag.Name), ll)
+	return hot, cold, replicas
+}
+
+// backendName describes a backend for replica health reports.
+func backendName(ctx *cli.Context, backend, dataDir string) string {
+	if backend == "s3" {
+		return "s3:" + ctx.GlobalString(s3BucketFlag.Name)
+	}
+	return backend + ":" + dataDir
+}
+
+func mustBackend(ctx *cli.Context, backend, dataDir string, ll *zap.Logger) spree.Storage {
+	ll = ll.With(zap.String("storage.backend", backend))
+
+	switch backend {
+	case "file":
+		store, err := spree.NewFileStorage(dataDir)
+		if err != nil {
+			ll.Fatal("unable to create FileStore", zap.Error(err))
+		}
+		removed, err := store.SweepTempFiles(staleUploadAge)
+		if err != nil {
+			ll.Fatal("unable to remove stale uploads", zap.Error(err))
+		}
+		if removed > 0 {
+			ll.Info("removed stale uploads", zap.Int("count", removed))
+		}
+		return store
+	case "s3":
+		conf := spree.S3Config{
+			Endpoint:  ctx.GlobalString(s3EndpointFlag.Name),
+			Region:    ctx.GlobalString(s3RegionFlag.Name),
+			Bucket:    ctx.GlobalString(s3BucketFlag.Name),
+			AccessKey: ctx.GlobalString(s3AccessKeyFlag.Name),
+			SecretKey: ctx.GlobalString(s3SecretKeyFlag.Name),
+			PathStyle: ctx.GlobalBool(s3PathStyleFlag.Name),
+		}
+		store, err := spree.NewS3Storage(conf, ll)
+		if err != nil {
+			ll.Fatal("unable to create S3Storage", zap.Error(err))
+		}
+		return store
+	case "memory":
+		ll.Warn("files are kept in memory and will be lost when spreed exits")
+		return spree.NewMemoryStorage()
+	}
+
+	ll.Fatal("unknown storage backend")
+	return nil
+}
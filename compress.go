@@ -0,0 +1,171 @@
+package spree
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	gzipEncoding = "gzip"
+	// compressedSuffix is added to the storage key of compressed files so
+	// DirectHandler can find them without a metadata lookup
+	compressedSuffix = ".~gz"
+	// sniffLen is how much of an upload http.DetectContentType looks at.
+	// Anything smaller isn't worth compressing.
+	sniffLen = 512
+)
+
+// compressible reports whether a sniffed content type is text-like.
+// Images and archives are already compressed.
+func compressible(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	if i := strings.Index(ct, ";"); i >= 0 {
+		ct = ct[:i]
+	}
+	switch {
+	case strings.HasPrefix(ct, "text/"):
+		return true
+	case strings.Contains(ct, "json"), strings.Contains(ct, "xml"), strings.Contains(ct, "javascript"):
+		return true
+	}
+	return false
+}
+
+// shotKey is the storage key holding a shot's file.
+func shotKey(shot *Shot) string {
+	if shot.ContentEncoding == gzipEncoding {
+		return servedKey(shot) + compressedSuffix
+	}
+	return servedKey(shot)
+}
+
+// servedKey is the key a shot's file is served as under /r/. Shots
+// uploaded before storage keys were recorded are stored by filename.
+func servedKey(shot *Shot) string {
+	if shot.StorageKey == "" {
+		return shot.Filename
+	}
+	return shot.StorageKey
+}
+
+// legacyStorageKey reports whether a shot is stored by filename alone, as
+// shots were before keys included the id. Shots with the same name share
+// the file.
+func legacyStorageKey(shot *Shot) bool {
+	return servedKey(shot) == shot.Filename
+}
+
+// storageStats totals the sizes of shots before and after compression.
+func storageStats(shots []*Shot) *StorageStats {
+	stats := &StorageStats{}
+	for _, shot := range shots {
+		stats.Shots++
+		stats.SizeBytes += shot.SizeBytes
+		// shots from before compression didn't record a stored size
+		stored := shot.StoredBytes
+		if stored == 0 {
+			stored = shot.SizeBytes
+		}
+		stats.StoredBytes += stored
+		if shot.ContentEncoding != "" {
+			stats.CompressedShots++
+		}
+	}
+	stats.SavedBytes = int64(stats.SizeBytes) - int64(stats.StoredBytes)
+	return stats
+}
+
+// keyShotId is the id of the shot whose file is served as key. Legacy keys
+// are bare filenames, so the prefix may name no shot; views of missing
+// shots are dropped when they're flushed.
+func keyShotId(key string) string {
+	if i := strings.Index(key, "-"); i > 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// statBlob finds the file served at /r/filename, which may have been
+// stored compressed. The encoding is empty for files stored as uploaded.
+func statBlob(ctx context.Context, storage Storage, filename string) (*ObjectInfo, string, error) {
+	info, err := storage.Stat(ctx, filename)
+	if err == nil || !os.IsNotExist(err) {
+		return info, "", err
+	}
+
+	info, cerr := storage.Stat(ctx, filename+compressedSuffix)
+	if cerr != nil {
+		return nil, "", err
+	}
+	return info, gzipEncoding, nil
+}
+
+// gzipReader compresses r as it is read. Close stops the compressor and
+// waits for it to finish with r, so r is safe to look at once Close
+// returns. A compressor blocked reading r stops when that read returns.
+func gzipReader(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, r)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return &gzipPipe{PipeReader: pr, done: done}
+}
+
+type gzipPipe struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+func (g *gzipPipe) Close() error {
+	g.PipeReader.Close()
+	<-g.done
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding != gzipEncoding && coding != "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		return q > 0
+	}
+	return false
+}
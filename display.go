@@ -0,0 +1,190 @@
+package spree
+
+import (
+	"html/template"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// statsDays is how many days of views the display page charts
+	statsDays = 14
+)
+
+// pageTemplates holds the pages served at /p/ and the parts they share.
+// Referrers are left off the public pages; owners can see them with
+// GetStats.
+var pageTemplates = template.Must(template.New("stats").Parse(`
+<section id="stats" style="font-family:sans-serif; font-size:small; color:#555">
+<p>views: {{.Views}} &middot; unique viewers: about {{.UniqueViewers}}{{if .CrawlerViews}} &middot; link previews and bots: {{.CrawlerViews}}{{end}}</p>
+<table>
+{{range .Days}}<tr><td>{{.Day}}</td><td><div style="background:#8ab; height:0.8em; width:{{.Width}}px"></div></td><td>{{.Views}}</td></tr>
+{{end}}</table>
+{{if .Agents}}<p>{{range $i, $a := .Agents}}{{if $i}} &middot; {{end}}{{$a.Key}}: {{$a.Views}}{{end}}</p>{{end}}
+</section>
+`))
+
+var displayPage = template.Must(pageTemplates.New("display").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{with .Shot.Title}}{{.}}{{else}}{{.Shot.Filename}}{{end}}</title>
+{{with .Shot.Title}}<meta property="og:title" content="{{.}}">{{end}}
+{{with .Shot.Description}}<meta property="og:description" content="{{.}}">{{end}}
+{{if .Image}}<meta property="og:image" content="{{.URL}}">{{end}}
+</head>
+<body>
+{{with .Shot.Title}}<h1 style="font-family:sans-serif">{{.}}</h1>{{end}}
+{{if .Image}}<a href="{{.URL}}"><img src="{{.URL}}" alt="{{.Shot.Filename}}" style="max-width:100%"></a>
+{{else}}<p><a href="{{.URL}}">{{.Shot.Filename}}</a></p>
+{{end}}
+{{with .Shot.Description}}<p style="font-family:sans-serif; white-space:pre-wrap">{{.}}</p>{{end}}
+{{with .Shot.Tags}}<p style="font-family:sans-serif; font-size:small">{{range $i, $t := .}}{{if $i}} &middot; {{end}}#{{$t}}{{end}}</p>{{end}}
+{{template "stats" .Stats}}
+</body>
+</html>
+`))
+
+var albumPage = template.Must(template.New("album").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{with .Album.Title}}{{.}}{{else}}Album{{end}}</title>
+{{with .Album.Title}}<meta property="og:title" content="{{.}}">{{end}}
+{{with .Cover}}<meta property="og:image" content="{{.}}">{{end}}
+</head>
+<body style="font-family:sans-serif">
+{{with .Album.Title}}<h1>{{.}}</h1>{{end}}
+{{if .Search.Searchable}}<form action="{{.Album.Path}}">
+<input type="search" name="q" value="{{.Search.Query}}" placeholder="search this album"> <input type="submit" value="Search">
+</form>
+{{with .Search.Error}}<p>{{.}}</p>{{end}}
+{{end}}{{range .Items}}<figure>
+{{if .Image}}<a href="{{.Shot.Path}}"><img src="{{.URL}}" alt="{{.Shot.Filename}}" style="max-width:100%"></a>
+{{else}}<p><a href="{{.Shot.Path}}">{{.Shot.Filename}}</a>{{if .Shot.E2E}} (encrypted, open it from its share link){{end}}</p>
+{{end}}<figcaption>{{with .Shot.Title}}{{.}}{{else}}{{.Shot.Filename}}{{end}}</figcaption>
+{{with .Shot.Description}}<p style="white-space:pre-wrap">{{.}}</p>{{end}}
+</figure>
+{{else}}{{if .Search.Query}}<p>No shots in this album match. <a href="{{.Album.Path}}">Show them all</a></p>
+{{else}}<p>This album is empty.</p>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// albumSearch is the search box on an album page. Searchable hides it on
+// empty albums.
+type albumSearch struct {
+	Query      string
+	Error      string
+	Searchable bool
+}
+
+type albumItem struct {
+	Shot  *Shot
+	URL   string
+	Image bool
+}
+
+// isImage reports whether the shot's file can be shown in an img tag.
+func isImage(shot *Shot) bool {
+	return !shot.E2E && strings.HasPrefix(mime.TypeByExtension(filepath.Ext(shot.Filename)), "image/")
+}
+
+// serveAlbumPage renders an album's shots in order, or the ones matching
+// search.
+func (s *HTTPServer) serveAlbumPage(w http.ResponseWriter, album *Album, shots []*Shot, search albumSearch, ll *zap.Logger) {
+	data := struct {
+		Album  *Album
+		Items  []albumItem
+		Cover  string
+		Search albumSearch
+	}{Album: album, Search: search}
+	for _, shot := range shots {
+		item := albumItem{Shot: shot, URL: directUrl(shot), Image: isImage(shot)}
+		if item.Image && data.Cover == "" {
+			data.Cover = item.URL
+		}
+		data.Items = append(data.Items, item)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if search.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	if err := albumPage.Execute(w, data); err != nil {
+		ll.Error("unable to render album page", zap.Error(err))
+	}
+}
+
+// pageStats is the view summary shown on display pages.
+type pageStats struct {
+	Views         uint64
+	CrawlerViews  uint64
+	UniqueViewers uint64
+	Days          []dayViews
+	Agents        []*ViewCount
+}
+
+type dayViews struct {
+	Day   string
+	Views uint64
+	// Width is the length of the day's bar in pixels
+	Width int
+}
+
+// newPageStats summarizes stats for the statsDays days up to now.
+func newPageStats(shot *Shot, stats *ViewStats, now time.Time) *pageStats {
+	daily := make(map[string]uint64, len(stats.Daily))
+	for _, c := range stats.Daily {
+		daily[c.Key] = c.Views
+	}
+
+	days := make([]dayViews, statsDays)
+	var most uint64
+	for i := range days {
+		day := now.UTC().AddDate(0, 0, i-statsDays+1).Format(dayFormat)
+		days[i] = dayViews{Day: day, Views: daily[day]}
+		if days[i].Views > most {
+			most = days[i].Views
+		}
+	}
+	for i := range days {
+		if most > 0 {
+			days[i].Width = int(days[i].Views * 200 / most)
+		}
+	}
+
+	return &pageStats{
+		Views:         shot.Views,
+		CrawlerViews:  shot.CrawlerViews,
+		UniqueViewers: hllCount(stats.Viewers),
+		Days:          days,
+		Agents:        topCounts(stats.Agents, -1),
+	}
+}
+
+// serveDisplayPage renders the page for a shot that isn't end-to-end
+// encrypted.
+func (s *HTTPServer) serveDisplayPage(w http.ResponseWriter, shot *Shot, stats *pageStats, ll *zap.Logger) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := displayPage.Execute(w, struct {
+		Shot  *Shot
+		URL   string
+		Image bool
+		Stats *pageStats
+	}{
+		Shot:  shot,
+		URL:   directUrl(shot),
+		Image: isImage(shot),
+		Stats: stats,
+	})
+	if err != nil {
+		ll.Error("unable to render display page", zap.Error(err))
+	}
+}
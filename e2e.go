@@ -0,0 +1,118 @@
+package spree
+
+import (
+	"html/template"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// e2eSuffix is added to the filename of end-to-end encrypted shots
+	e2eSuffix = ".e2e"
+	// E2EVersion is the first byte of an end-to-end encrypted blob. It is
+	// followed by a 12 byte AES-GCM nonce and the sealed file.
+	E2EVersion   = 1
+	E2ENonceSize = 12
+	// E2EKeySize is the size of the AES-256 key carried in the link fragment
+	E2EKeySize = 32
+
+	// maxE2EExtension bounds the extension kept by E2EFilename
+	maxE2EExtension = 8
+)
+
+// E2EFilename is the name an e2e shot is stored and shown under in place of
+// name. Only the file is encrypted, so just a short extension is kept,
+// which browsers and tools use to tell what the file is.
+func E2EFilename(name string) string {
+	ext := strings.ToLower(path.Ext(path.Base(name)))
+	if len(ext) < 2 || len(ext) > maxE2EExtension+1 {
+		return "shot"
+	}
+	for _, c := range ext[1:] {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return "shot"
+		}
+	}
+	return "shot" + ext
+}
+
+// e2ePage fetches the ciphertext and decrypts it with the key from the URL
+// fragment, which browsers never send to the server.
+var e2ePage = template.Must(pageTemplates.New("e2e").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="no-referrer">
+<title>{{.Filename}}</title>
+</head>
+<body>
+<p id="status">Decrypting&hellip;</p>
+<img id="shot" style="display:none; max-width:100%">
+{{template "stats" .Stats}}
+<script>
+(function() {
+  var status = document.getElementById("status");
+  function fail(msg) { status.textContent = msg; }
+  function b64url(s) {
+    s = s.replace(/-/g, "+").replace(/_/g, "/");
+    while (s.length % 4) { s += "="; }
+    var raw = atob(s), out = new Uint8Array(raw.length);
+    for (var i = 0; i < raw.length; i++) { out[i] = raw.charCodeAt(i); }
+    return out;
+  }
+
+  var frag = window.location.hash.slice(1);
+  if (!frag) { return fail("This link is missing its decryption key."); }
+  if (!window.crypto || !window.crypto.subtle) { return fail("This browser can't decrypt the shot."); }
+
+  var key;
+  try { key = b64url(frag); } catch (e) { return fail("The decryption key in this link is malformed."); }
+  Promise.all([
+    fetch({{.URL}}, {credentials: "omit", cache: "no-store"}).then(function(r) {
+      if (!r.ok) { throw new Error("fetch failed: " + r.status); }
+      return r.arrayBuffer();
+    }),
+    crypto.subtle.importKey("raw", key, {name: "AES-GCM"}, false, ["decrypt"])
+  ]).then(function(res) {
+    var data = new Uint8Array(res[0]);
+    if (data.length < 1 + {{.NonceSize}} || data[0] !== {{.Version}}) { throw new Error("unknown format"); }
+    var iv = data.subarray(1, 1 + {{.NonceSize}});
+    return crypto.subtle.decrypt({name: "AES-GCM", iv: iv}, res[1], data.subarray(1 + {{.NonceSize}}));
+  }).then(function(plain) {
+    var img = document.getElementById("shot");
+    img.src = URL.createObjectURL(new Blob([plain]));
+    img.style.display = "";
+    status.style.display = "none";
+  }).catch(function() {
+    fail("Unable to decrypt this shot. The link may be incomplete or the key wrong.");
+  });
+})();
+</script>
+</body>
+</html>
+`))
+
+// serveE2EPage renders the page that decrypts shot in the browser.
+func (s *HTTPServer) serveE2EPage(w http.ResponseWriter, shot *Shot, stats *pageStats, ll *zap.Logger) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err := e2ePage.Execute(w, struct {
+		Filename  string
+		URL       string
+		Version   int
+		NonceSize int
+		Stats     *pageStats
+	}{
+		Filename:  shot.Filename,
+		URL:       directUrl(shot),
+		Version:   E2EVersion,
+		NonceSize: E2ENonceSize,
+		Stats:     stats,
+	})
+	if err != nil {
+		ll.Error("unable to render e2e page", zap.Error(err))
+	}
+}
@@ -0,0 +1,622 @@
+package spree
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+)
+
+const (
+	// encSegmentSize is the plaintext size of each sealed segment. Ranges
+	// only need to decrypt the segments they touch.
+	encSegmentSize = 64 << 10
+	encTagSize     = 16
+	encNonceSize   = 12
+	encSealedSize  = encSegmentSize + encTagSize
+
+	// dekSuffix is appended to a blob's key to name its wrapped data key
+	dekSuffix  = ".dek"
+	dekVersion = 1
+
+	masterKeySize = 32
+)
+
+var (
+	errBadMasterKey   = errors.New("master key must be 32 bytes, raw or hex/base64 encoded")
+	errUnknownKeyID   = errors.New("data key is wrapped by an unknown master key")
+	errCorruptBlob    = errors.New("encrypted blob is corrupt")
+	errReservedSuffix = errors.New("storage key uses the reserved " + dekSuffix + " suffix")
+)
+
+// MasterKey wraps and unwraps the per-blob data keys.
+type MasterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewMasterKey creates a MasterKey from 32 bytes of key material.
+func NewMasterKey(raw []byte) (*MasterKey, error) {
+	if len(raw) != masterKeySize {
+		return nil, errBadMasterKey
+	}
+	aead, err := newGCM(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &MasterKey{
+		id:   hex.EncodeToString(sum[:8]),
+		aead: aead,
+	}, nil
+}
+
+// ParseMasterKey accepts a key as raw bytes or hex/base64 text, which is
+// how keys are kept in files and environment variables.
+func ParseMasterKey(data []byte) (*MasterKey, error) {
+	if len(data) == masterKeySize {
+		return NewMasterKey(data)
+	}
+
+	text := strings.TrimSpace(string(data))
+	if raw, err := hex.DecodeString(text); err == nil && len(raw) == masterKeySize {
+		return NewMasterKey(raw)
+	}
+	if raw, err := base64.StdEncoding.DecodeString(text); err == nil && len(raw) == masterKeySize {
+		return NewMasterKey(raw)
+	}
+	return nil, errBadMasterKey
+}
+
+// LoadMasterKey reads a master key from filename.
+func LoadMasterKey(filename string) (*MasterKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMasterKey(data)
+}
+
+// ID identifies the key without revealing it.
+func (m *MasterKey) ID() string {
+	return m.id
+}
+
+// wrappedKey is the sidecar stored next to every blob.
+type wrappedKey struct {
+	Version int    `json:"version"`
+	KeyID   string `json:"key_id"`
+	Nonce   []byte `json:"nonce"`
+	Key     []byte `json:"key"`
+}
+
+// EncryptedStorage encrypts blobs before handing them to another Storage.
+// Every blob gets its own data key, which is stored next to it wrapped by
+// the master key. Blobs are sealed with AES-GCM in fixed-size segments, so
+// ranged reads only decrypt what they return.
+//
+// EncryptedStorage deliberately doesn't implement Presigner: a presigned
+// URL would hand out ciphertext.
+type EncryptedStorage struct {
+	ll       *zap.Logger
+	inner    Storage
+	master   *MasterKey
+	previous []*MasterKey
+}
+
+var _ Storage = &EncryptedStorage{}
+var _ Locator = &EncryptedStorage{}
+
+// NewEncryptedStorage wraps inner. New data keys are wrapped with master;
+// keys wrapped by any of previous can still be read, so the server keeps
+// working while RewrapKeys is part way through.
+func NewEncryptedStorage(inner Storage, master *MasterKey, previous []*MasterKey, ll *zap.Logger) *EncryptedStorage {
+	return &EncryptedStorage{
+		ll:       ll,
+		inner:    inner,
+		master:   master,
+		previous: previous,
+	}
+}
+
+func (e *EncryptedStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if strings.HasSuffix(key, dekSuffix) {
+		return 0, errReservedSuffix
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return 0, err
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return 0, err
+	}
+
+	// until the blob is replaced, the sidecar holds the new data key as
+	// well as the old ones. Whichever blob is in place stays readable if
+	// the put fails or the server dies part way, since Get tries each key.
+	prev, err := e.readWrapped(ctx, key)
+	if err != nil && !os.IsNotExist(err) {
+		if _, ok := err.(*json.SyntaxError); !ok {
+			return 0, err
+		}
+		e.ll.Warn("replacing unreadable data keys", zap.String("key", key), zap.Error(err))
+		prev = nil
+	}
+	wk, err := e.wrap(key, dek, e.master)
+	if err != nil {
+		return 0, err
+	}
+	if err := e.writeWrapped(ctx, key, append([]*wrappedKey{wk}, prev...)); err != nil {
+		return 0, err
+	}
+
+	er := &encryptReader{
+		src:  bufio.NewReaderSize(r, encSegmentSize),
+		aead: aead,
+		aad:  []byte(key),
+		buf:  make([]byte, encSegmentSize),
+	}
+	if _, err := e.inner.Put(ctx, key, er); err != nil {
+		var rerr error
+		if len(prev) > 0 {
+			rerr = e.writeWrapped(ctx, key, prev)
+		} else {
+			rerr = e.inner.Delete(ctx, key+dekSuffix)
+		}
+		if rerr != nil {
+			e.ll.Error("unable to restore data keys after failed put", zap.String("key", key), zap.Error(rerr))
+		}
+		return er.n, err
+	}
+
+	if err := e.writeWrapped(ctx, key, []*wrappedKey{wk}); err != nil {
+		// the old keys can't open the new blob, so leaving them is harmless
+		e.ll.Warn("unable to drop old data keys", zap.String("key", key), zap.Error(err))
+	}
+	return er.n, nil
+}
+
+func (e *EncryptedStorage) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if strings.HasSuffix(key, dekSuffix) {
+		return nil, os.ErrNotExist
+	}
+
+	info, err := e.inner.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	size, err := plaintextSize(info.Size)
+	if err != nil {
+		return nil, err
+	}
+	if offset > size {
+		offset = size
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	aeads, err := e.dataKeys(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := segmentCount(size)
+	first := offset / encSegmentSize
+	last := (offset + length - 1) / encSegmentSize
+
+	rc, err := e.inner.Get(ctx, key, first*encSealedSize, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	dr := &decryptReader{
+		src:      rc,
+		keys:     aeads,
+		aad:      []byte(key),
+		seg:      first,
+		last:     last,
+		final:    segments - 1,
+		skip:     offset - first*encSegmentSize,
+		left:     length,
+		sealed:   make([]byte, encSealedSize),
+		plainBuf: make([]byte, 0, encSegmentSize),
+	}
+	return dr, nil
+}
+
+func (e *EncryptedStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	if strings.HasSuffix(key, dekSuffix) {
+		return nil, os.ErrNotExist
+	}
+
+	info, err := e.inner.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	size, err := plaintextSize(info.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:     key,
+		Size:    size,
+		ModTime: info.ModTime,
+	}, nil
+}
+
+func (e *EncryptedStorage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	infos, err := e.inner.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := make([]*ObjectInfo, 0, len(infos)/2)
+	for _, info := range infos {
+		if strings.HasSuffix(info.Key, dekSuffix) {
+			continue
+		}
+		size, err := plaintextSize(info.Size)
+		if err != nil {
+			e.ll.Warn("skipping corrupt blob", zap.String("key", info.Key), zap.Int64("size", info.Size))
+			continue
+		}
+		blobs = append(blobs, &ObjectInfo{
+			Key:     info.Key,
+			Size:    size,
+			ModTime: info.ModTime,
+		})
+	}
+	return blobs, nil
+}
+
+func (e *EncryptedStorage) Delete(ctx context.Context, key string) error {
+	if strings.HasSuffix(key, dekSuffix) {
+		return errReservedSuffix
+	}
+
+	err := e.inner.Delete(ctx, key)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if derr := e.inner.Delete(ctx, key+dekSuffix); derr != nil && !os.IsNotExist(derr) {
+		return derr
+	}
+	return err
+}
+
+func (e *EncryptedStorage) Locate(ctx context.Context, key string) (string, error) {
+	return "encrypted:" + backendType(ctx, e.inner, key), nil
+}
+
+// RewrapKeys re-wraps every data key that isn't wrapped by the current
+// master key. Blobs are left alone. It is safe to run again after a failure;
+// keys that were already re-wrapped are skipped. It returns the number of
+// blobs whose keys were re-wrapped. Nothing may Put to the storage while it
+// runs, or a fresh key could be overwritten with the one read before it.
+func (e *EncryptedStorage) RewrapKeys(ctx context.Context) (int, error) {
+	infos, err := e.inner.List(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+
+	var rewrapped int
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Key, dekSuffix) {
+			continue
+		}
+		key := strings.TrimSuffix(info.Key, dekSuffix)
+		ll := e.ll.With(zap.String("key", key))
+
+		wks, err := e.readWrapped(ctx, key)
+		if err != nil {
+			return rewrapped, fmt.Errorf("%s: %v", key, err)
+		}
+		var changed bool
+		for i, wk := range wks {
+			if wk.KeyID == e.master.ID() {
+				continue
+			}
+			dek, err := e.unwrap(key, wk)
+			if err != nil {
+				return rewrapped, fmt.Errorf("%s: %v", key, err)
+			}
+			if wks[i], err = e.wrap(key, dek, e.master); err != nil {
+				return rewrapped, err
+			}
+			ll.Debug("rewrapped data key", zap.String("old.key.id", wk.KeyID))
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err := e.writeWrapped(ctx, key, wks); err != nil {
+			return rewrapped, err
+		}
+		rewrapped++
+	}
+	return rewrapped, nil
+}
+
+func (e *EncryptedStorage) wrap(key string, dek []byte, master *MasterKey) (*wrappedKey, error) {
+	nonce := make([]byte, encNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &wrappedKey{
+		Version: dekVersion,
+		KeyID:   master.ID(),
+		Nonce:   nonce,
+		Key:     master.aead.Seal(nil, nonce, dek, []byte(key)),
+	}, nil
+}
+
+func (e *EncryptedStorage) unwrap(key string, wk *wrappedKey) ([]byte, error) {
+	keys := append([]*MasterKey{e.master}, e.previous...)
+	for _, m := range keys {
+		if m.ID() != wk.KeyID {
+			continue
+		}
+		return m.aead.Open(nil, wk.Nonce, wk.Key, []byte(key))
+	}
+	return nil, errUnknownKeyID
+}
+
+// readWrapped reads the data keys in key's sidecar, newest first. Sidecars
+// written before Put kept more than one key hold a single object.
+func (e *EncryptedStorage) readWrapped(ctx context.Context, key string) ([]*wrappedKey, error) {
+	rc, err := e.inner.Get(ctx, key+dekSuffix, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	var wks []*wrappedKey
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '{' {
+		wks = []*wrappedKey{{}}
+		err = json.Unmarshal(data, wks[0])
+	} else {
+		err = json.Unmarshal(data, &wks)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, wk := range wks {
+		if wk.Version != dekVersion {
+			return nil, fmt.Errorf("unsupported data key version %d", wk.Version)
+		}
+	}
+	return wks, nil
+}
+
+func (e *EncryptedStorage) writeWrapped(ctx context.Context, key string, wks []*wrappedKey) error {
+	data, err := json.Marshal(wks)
+	if err != nil {
+		return err
+	}
+	_, err = e.inner.Put(ctx, key+dekSuffix, bytes.NewReader(data))
+	return err
+}
+
+// dataKeys unwraps the data keys that may have sealed key's blob.
+func (e *EncryptedStorage) dataKeys(ctx context.Context, key string) ([]cipher.AEAD, error) {
+	wks, err := e.readWrapped(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wks) == 0 {
+		return nil, errCorruptBlob
+	}
+
+	aeads := make([]cipher.AEAD, 0, len(wks))
+	for _, wk := range wks {
+		dek, uerr := e.unwrap(key, wk)
+		if uerr != nil {
+			err = uerr
+			continue
+		}
+		aead, err := newGCM(dek)
+		if err != nil {
+			return nil, err
+		}
+		aeads = append(aeads, aead)
+	}
+	if len(aeads) == 0 {
+		return nil, err
+	}
+	return aeads, nil
+}
+
+// encryptReader seals its source one segment at a time. The source is read
+// one byte ahead so the last segment can be marked, which stops a truncated
+// blob from decrypting cleanly.
+type encryptReader struct {
+	src  *bufio.Reader
+	aead cipher.AEAD
+	aad  []byte
+	buf  []byte
+	out  []byte
+	seg  int64
+	n    int64
+	done bool
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.seal(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *encryptReader) seal() error {
+	n, err := io.ReadFull(r.src, r.buf)
+	switch err {
+	case nil:
+		if _, err := r.src.Peek(1); err == io.EOF {
+			r.done = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		r.done = true
+	default:
+		return err
+	}
+
+	r.out = r.aead.Seal(r.out[:0], segmentNonce(r.seg, r.done), r.buf[:n], r.aad)
+	r.seg++
+	r.n += int64(n)
+	return nil
+}
+
+// decryptReader opens the sealed segments from seg to last and returns the
+// requested range of their plaintext. The first segment is tried with each
+// of keys; the one that opens it is used for the rest.
+type decryptReader struct {
+	src      io.ReadCloser
+	keys     []cipher.AEAD
+	aad      []byte
+	seg      int64
+	last     int64
+	final    int64
+	skip     int64
+	left     int64
+	sealed   []byte
+	plainBuf []byte
+	plain    []byte
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if r.left <= 0 {
+		return 0, io.EOF
+	}
+	for len(r.plain) == 0 {
+		if r.seg > r.last {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	r.left -= int64(n)
+	return n, nil
+}
+
+func (r *decryptReader) open() error {
+	n, err := io.ReadFull(r.src, r.sealed)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return errCorruptBlob
+		}
+		return err
+	}
+
+	nonce := segmentNonce(r.seg, r.seg == r.final)
+	var plain []byte
+	err = errCorruptBlob
+	for _, aead := range r.keys {
+		if plain, err = aead.Open(r.plainBuf[:0], nonce, r.sealed[:n], r.aad); err == nil {
+			r.keys = []cipher.AEAD{aead}
+			break
+		}
+	}
+	if err != nil {
+		return errCorruptBlob
+	}
+	r.seg++
+
+	if r.skip > 0 {
+		if r.skip > int64(len(plain)) {
+			return errCorruptBlob
+		}
+		plain = plain[r.skip:]
+		r.skip = 0
+	}
+	r.plain = plain
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.src.Close()
+}
+
+// segmentNonce derives a nonce from the segment index. Data keys are never
+// reused across blobs, so the nonces never repeat under one key.
+func segmentNonce(seg int64, final bool) []byte {
+	nonce := make([]byte, encNonceSize)
+	binary.BigEndian.PutUint64(nonce, uint64(seg))
+	if final {
+		nonce[encNonceSize-1] = 1
+	}
+	return nonce
+}
+
+// plaintextSize works out a blob's size from its sealed size. Every blob has
+// at least one segment, even when empty.
+func plaintextSize(sealed int64) (int64, error) {
+	full := sealed / encSealedSize
+	rem := sealed % encSealedSize
+	if rem == 0 {
+		if full == 0 {
+			return 0, errCorruptBlob
+		}
+		return full * encSegmentSize, nil
+	}
+	if rem < encTagSize {
+		return 0, errCorruptBlob
+	}
+	return full*encSegmentSize + rem - encTagSize, nil
+}
+
+func segmentCount(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + encSegmentSize - 1) / encSegmentSize
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,212 @@
+package spree_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+	"golang.org/x/net/context"
+)
+
+// segment is the plaintext size of EncryptedStorage's sealed segments.
+const segment = 64 << 10
+
+func masterKey(t *testing.T, b byte) *spree.MasterKey {
+	m, err := spree.NewMasterKey(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func readAll(s spree.Storage, key string) ([]byte, error) {
+	rc, err := s.Get(context.Background(), key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func TestEncryptedStorageRotate(t *testing.T) {
+	ctx := context.Background()
+	inner := spree.NewMemoryStorage()
+	oldKey, newKey := masterKey(t, 1), masterKey(t, 2)
+
+	blobs := map[string][]byte{
+		"empty": nil,
+		"small": []byte("hello"),
+		"exact": bytes.Repeat([]byte{'a'}, segment),
+		"multi": bytes.Repeat([]byte{'b'}, 2*segment+17),
+	}
+	old := spree.NewEncryptedStorage(inner, oldKey, nil, zap.NewNop())
+	for key, data := range blobs {
+		if _, err := old.Put(ctx, key, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := spree.NewEncryptedStorage(inner, newKey, []*spree.MasterKey{oldKey}, zap.NewNop()).RewrapKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(blobs) {
+		t.Errorf("rewrapped %d keys, want %d", n, len(blobs))
+	}
+
+	// once rewrapped, the old key is no longer needed
+	rotated := spree.NewEncryptedStorage(inner, newKey, nil, zap.NewNop())
+	for key, data := range blobs {
+		got, err := readAll(rotated, key)
+		if err != nil {
+			t.Errorf("%s: %v", key, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: read %d bytes back, want %d", key, len(got), len(data))
+		}
+	}
+	if _, err := readAll(old, "small"); err == nil {
+		t.Error("old master key still reads rotated blobs")
+	}
+
+	n, err = rotated.RewrapKeys(ctx)
+	if err != nil || n != 0 {
+		t.Errorf("second rewrap got %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestEncryptedStorageRejects(t *testing.T) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789"), (2*segment+500)/10)
+
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, inner spree.Storage, sealed []byte)
+		master byte
+	}{
+		{
+			name: "flipped byte",
+			tamper: func(t *testing.T, inner spree.Storage, sealed []byte) {
+				sealed[segment+100] ^= 1
+				putRaw(t, inner, "shot", sealed)
+			},
+		},
+		{
+			name: "dropped last segment",
+			tamper: func(t *testing.T, inner spree.Storage, sealed []byte) {
+				putRaw(t, inner, "shot", sealed[:2*(segment+16)])
+			},
+		},
+		{
+			name: "truncated segment",
+			tamper: func(t *testing.T, inner spree.Storage, sealed []byte) {
+				putRaw(t, inner, "shot", sealed[:len(sealed)-10])
+			},
+		},
+		{
+			name: "moved to another key",
+			tamper: func(t *testing.T, inner spree.Storage, sealed []byte) {
+				raw, err := readAll(inner, "other")
+				if err != nil {
+					t.Fatal(err)
+				}
+				putRaw(t, inner, "shot", raw)
+			},
+		},
+		{
+			name:   "wrong master key",
+			master: 9,
+		},
+	}
+	for _, tt := range tests {
+		inner := spree.NewMemoryStorage()
+		s := spree.NewEncryptedStorage(inner, masterKey(t, 1), nil, zap.NewNop())
+		for _, key := range []string{"shot", "other"} {
+			if _, err := s.Put(ctx, key, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tt.tamper != nil {
+			sealed, err := readAll(inner, "shot")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(t, inner, sealed)
+		}
+		if tt.master != 0 {
+			s = spree.NewEncryptedStorage(inner, masterKey(t, tt.master), nil, zap.NewNop())
+		}
+
+		if got, err := readAll(s, "shot"); err == nil {
+			t.Errorf("%s: read %d bytes without error", tt.name, len(got))
+		}
+	}
+}
+
+func putRaw(t *testing.T, s spree.Storage, key string, data []byte) {
+	if _, err := s.Put(context.Background(), key, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// crashingStorage fails the first blob put part way and every write after
+// it, as if the server died while replacing a blob.
+type crashingStorage struct {
+	spree.Storage
+	crashed bool
+}
+
+func (s *crashingStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if s.crashed {
+		return 0, errors.New("crashed")
+	}
+	if strings.HasSuffix(key, ".dek") {
+		return s.Storage.Put(ctx, key, r)
+	}
+	s.crashed = true
+	n, _ := io.CopyN(ioutil.Discard, r, segment)
+	return n, errors.New("crashed")
+}
+
+func (s *crashingStorage) Delete(ctx context.Context, key string) error {
+	if s.crashed {
+		return errors.New("crashed")
+	}
+	return s.Storage.Delete(ctx, key)
+}
+
+func TestEncryptedStorageInterruptedPut(t *testing.T) {
+	ctx := context.Background()
+	inner := spree.NewMemoryStorage()
+	master := masterKey(t, 1)
+	for i, want := range []string{"", "original"} {
+		key := fmt.Sprintf("shot%d", i)
+		if want != "" {
+			putRaw(t, spree.NewEncryptedStorage(inner, master, nil, zap.NewNop()), key, []byte(want))
+		}
+
+		crashing := spree.NewEncryptedStorage(&crashingStorage{Storage: inner}, master, nil, zap.NewNop())
+		if _, err := crashing.Put(ctx, key, bytes.NewReader(bytes.Repeat([]byte{'x'}, 2*segment))); err == nil {
+			t.Fatalf("%s: interrupted put succeeded", key)
+		}
+
+		got, err := readAll(spree.NewEncryptedStorage(inner, master, nil, zap.NewNop()), key)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s: read %q from a blob that was never written", key, got)
+			}
+			continue
+		}
+		if err != nil || string(got) != want {
+			t.Errorf("%s: read %q, %v after interrupted overwrite; want %q", key, got, err, want)
+		}
+	}
+}
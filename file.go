@@ -1,19 +1,39 @@
 package spree
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// tempFilePrefix marks uploads that haven't been renamed into place yet
+	tempFilePrefix = ".upload-"
 )
 
 var (
 	prefixChar = "0123456789abcdef"
+
+	errInvalidKey     = errors.New("invalid storage key")
+	errInvalidWhence  = errors.New("invalid whence")
+	errNegativeOffset = errors.New("negative offset")
 )
 
 type FileStorage struct {
 	path string
 }
 
+var _ Storage = &FileStorage{}
+var _ Locator = &FileStorage{}
+
 func NewFileStorage(path string) (*FileStorage, error) {
 	err := createPrefixPaths(path)
 	if err != nil {
@@ -31,7 +51,7 @@ func createPrefixPaths(path string) error {
 	}
 
 	if os.IsNotExist(err) {
-		return fmt.Errorf("path does not exist for FileStorage:", path)
+		return fmt.Errorf("path does not exist for FileStorage: %s", path)
 	}
 
 	return createDirs(path, 1)
@@ -55,17 +75,180 @@ func createDirs(path string, depth int) error {
 	return nil
 }
 
-func (fs *FileStorage) Open(filename string) (File, error) {
-	filePath := filepath.Join(fs.path, filename)
-	return os.Open(filePath)
+// filePath maps a key to a file directly under the storage path. Keys come
+// from URLs, so anything that could escape the directory or collide with a
+// temp file is rejected.
+func (fs *FileStorage) filePath(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) ||
+		strings.HasPrefix(key, tempFilePrefix) {
+		return "", errInvalidKey
+	}
+	return filepath.Join(fs.path, key), nil
+}
+
+// Put writes into a temp file next to the destination and renames it into
+// place once everything has been synced, so readers never see a partial file.
+func (fs *FileStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	filePath, err := fs.filePath(key)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filePath), tempFilePrefix)
+	if err != nil {
+		return 0, err
+	}
+	tmpName := f.Name()
+
+	// TempFile creates files only the owner can read
+	err = f.Chmod(0644)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(f, r)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filePath)
+	}
+	if err != nil {
+		if rerr := os.Remove(tmpName); rerr != nil && !os.IsNotExist(rerr) {
+			return n, fmt.Errorf("%v (and unable to remove temp file: %v)", err, rerr)
+		}
+		return n, err
+	}
+
+	return n, syncDir(filepath.Dir(filePath))
+}
+
+func (fs *FileStorage) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	filePath, err := fs.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return &limitedReadCloser{
+		Reader: io.LimitReader(f, length),
+		Closer: f,
+	}, nil
+}
+
+func (fs *FileStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	filePath, err := fs.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, os.ErrNotExist
+	}
+
+	return &ObjectInfo{
+		Key:     key,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}, nil
 }
 
-func (fs *FileStorage) Create(filename string) (File, error) {
-	filePath := filepath.Join(fs.path, filename)
-	return os.Create(filePath)
+func (fs *FileStorage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	fis, err := ioutil.ReadDir(fs.path)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*ObjectInfo, 0, len(fis))
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || !strings.HasPrefix(fi.Name(), prefix) ||
+			strings.HasPrefix(fi.Name(), tempFilePrefix) {
+			continue
+		}
+		infos = append(infos, &ObjectInfo{
+			Key:     fi.Name(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	sort.Sort(byKey(infos))
+	return infos, nil
 }
 
-func (fs *FileStorage) Remove(filename string) error {
-	filePath := filepath.Join(fs.path, filename)
+func (fs *FileStorage) Delete(ctx context.Context, key string) error {
+	filePath, err := fs.filePath(key)
+	if err != nil {
+		return err
+	}
 	return os.Remove(filePath)
 }
+
+func (fs *FileStorage) Locate(ctx context.Context, key string) (string, error) {
+	return "file", nil
+}
+
+// SweepTempFiles removes temp files left behind by uploads that were
+// interrupted by a crash. Only files older than olderThan are removed, so
+// uploads in progress in another process sharing the directory survive.
+func (fs *FileStorage) SweepTempFiles(olderThan time.Duration) (int, error) {
+	fis, err := ioutil.ReadDir(fs.path)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	var removed int
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || !strings.HasPrefix(fi.Name(), tempFilePrefix) || fi.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(fs.path, fi.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// syncDir flushes a directory so a rename inside it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type byKey []*ObjectInfo
+
+func (b byKey) Len() int           { return len(b) }
+func (b byKey) Less(i, j int) bool { return b[i].Key < b[j].Key }
+func (b byKey) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
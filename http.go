@@ -1,12 +1,16 @@
 package spree
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
 	"mime"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -21,24 +25,41 @@ type HTTPServer struct {
 	addr    string
 	storage Storage
 	md      Metadata
+	views   *ViewCounter
+	audit   *AuditLog
 	jm      jsonpb.Marshaler
 	assetFS *assetfs.AssetFS
+
+	// redirectExpiry is how long presigned URLs last. Zero proxies file
+	// contents through the server instead.
+	redirectExpiry time.Duration
+	// trustedProxies may set X-Forwarded-For
+	trustedProxies []*net.IPNet
 }
 
 const (
 	displayPath = "/p"
 	directPath  = "/r"
+	// albumPathPrefix is where album pages are served
+	albumPathPrefix = "/a"
 )
 
-func NewHTTPServer(addr string, md Metadata, storage Storage,
-	assetFS *assetfs.AssetFS, ll *zap.Logger) *HTTPServer {
+// NewHTTPServer creates an HTTPServer. When redirectExpiry is non-zero and
+// storage is a Presigner, /r/ redirects to a presigned backend URL.
+// X-Forwarded-For is only believed from trustedProxies.
+func NewHTTPServer(addr string, md Metadata, views *ViewCounter, storage Storage, audit *AuditLog,
+	assetFS *assetfs.AssetFS, redirectExpiry time.Duration, trustedProxies []*net.IPNet, ll *zap.Logger) *HTTPServer {
 	return &HTTPServer{
-		ll:      ll,
-		addr:    addr,
-		storage: storage,
-		md:      md,
-		jm:      jsonpb.Marshaler{Indent: "  "},
-		assetFS: assetFS,
+		ll:             ll,
+		addr:           addr,
+		storage:        storage,
+		md:             md,
+		views:          views,
+		audit:          audit,
+		jm:             jsonpb.Marshaler{Indent: "  "},
+		assetFS:        assetFS,
+		redirectExpiry: redirectExpiry,
+		trustedProxies: trustedProxies,
 	}
 }
 
@@ -49,6 +70,7 @@ func (s *HTTPServer) Run() {
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(s.assetFS)))
 	r.HandleFunc("/p/{id}", s.DisplayPageHandler)
 	r.HandleFunc("/r/{filename}", s.DirectHandler)
+	r.HandleFunc("/a/{id}", s.AlbumPageHandler)
 
 	s.ll.Info("Starting HTTP server",
 		zap.String("addr", s.addr))
@@ -68,20 +90,73 @@ func (s *HTTPServer) DisplayPageHandler(w http.ResponseWriter, r *http.Request)
 	shot, err := s.md.GetShotById(id)
 	ll := s.ll.With(zap.String("id", id))
 	if err != nil {
-		ll.Error("error getting shot", zap.Error(err))
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		httpError(w, err, ll)
 		return
 	}
 
-	if shot == nil {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	now := time.Now()
+	s.views.Add(id, newView(r, clientAddr(r, s.trustedProxies), now))
+
+	// embeds and clients fetching the file are sent to it as before. The
+	// page is needed to decrypt e2e shots, so they always get it.
+	w.Header().Set("Vary", "Accept")
+	if !shot.E2E && !wantsPage(r) {
+		http.Redirect(w, r, directUrl(shot), http.StatusFound)
 		return
 	}
 
-	// increment the views asynchronously
-	go s.md.IncrementViews(id)
+	stats, err := s.md.GetViewStats(id)
+	if err != nil {
+		ll.Warn("unable to get view stats", zap.Error(err))
+		stats = &ViewStats{}
+	}
+	page := newPageStats(shot, stats, now)
+
+	if shot.E2E {
+		s.serveE2EPage(w, shot, page, ll)
+		return
+	}
+	s.serveDisplayPage(w, shot, page, ll)
+}
+
+// wantsPage reports whether r asks for HTML, as browsers and link
+// previews opening a link do. Image tags and most clients don't.
+func wantsPage(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		switch strings.TrimSpace(strings.Split(part, ";")[0]) {
+		case "text/html", "application/xhtml+xml":
+			return true
+		}
+	}
+	return false
+}
+
+func (s *HTTPServer) AlbumPageHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	ll := s.ll.With(zap.String("album.id", id))
+	album, err := s.md.GetAlbumById(id)
+	if err != nil {
+		httpError(w, err, ll)
+		return
+	}
+	shots, err := albumShots(s.md, album, ll)
+	if err != nil {
+		httpError(w, err, ll)
+		return
+	}
 
-	http.Redirect(w, r, directUrl(shot), http.StatusFound)
+	search := albumSearch{Query: r.URL.Query().Get("q"), Searchable: len(shots) > 0}
+	if search.Query != "" {
+		q, err := ParseQuery(search.Query)
+		if err != nil {
+			search.Error = err.Error()
+			shots = nil
+		} else if shots, err = searchAlbumShots(s.md, shots, q); err != nil {
+			httpError(w, err, ll.With(zap.String("query", search.Query)))
+			return
+		}
+	}
+	s.serveAlbumPage(w, album, shots, search, ll)
 }
 
 func (s *HTTPServer) DirectHandler(w http.ResponseWriter, r *http.Request) {
@@ -92,22 +167,116 @@ func (s *HTTPServer) DirectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	ll := s.ll.With(zap.String("filename", filename))
-	ll.Info("fetching file")
 
-	file, err := s.storage.Open(filename)
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	w = sw
+	method := "ServeFile"
+	var size uint64
+	defer func() {
+		s.audit.RecordHTTP(clientAddr(r, s.trustedProxies), method, filename, size, sw.status)
+		// embedded files are read without their page being viewed, and
+		// tiering needs to know they're still in use
+		if sw.status < http.StatusBadRequest {
+			if id := keyShotId(filename); id != "" {
+				s.views.Read(id)
+			}
+		}
+	}()
+
+	// e2e shots are fetched by the decrypting page, which needs a same-origin
+	// response rather than a redirect to the backend
+	e2e := strings.HasSuffix(filename, e2eSuffix)
+
+	ctx := r.Context()
+	info, encoding, err := statBlob(ctx, s.storage, filename)
+	if err != nil {
+		httpError(w, err, ll)
+		return
+	}
+
+	// compressed files need Content-Encoding, which a presigned URL can't add
+	if presigner, ok := s.storage.(Presigner); ok && s.redirectExpiry > 0 && !e2e && encoding == "" {
+		method = "RedirectFile"
+		url, err := presigner.PresignGet(filename, s.redirectExpiry)
+		if err != nil {
+			ll.Error("error presigning file url", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		ll.Info("redirecting to presigned url")
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	ll.Info("sending file", zap.String("encoding", encoding))
+	size = uint64(info.Size)
+	if e2e {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	} else {
+		ext := filepath.Ext(filename)
+		mimeType := mime.TypeByExtension(ext)
+		w.Header().Set("Content-Type", mimeType)
+	}
+
+	if encoding == "" {
+		file := newBlobReader(ctx, s.storage, info)
+		defer file.Close()
+		http.ServeContent(w, r, filename, info.ModTime, file)
+		return
+	}
+
+	w.Header().Set("Vary", "Accept-Encoding")
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", encoding)
+		file := newBlobReader(ctx, s.storage, info)
+		defer file.Close()
+		http.ServeContent(w, r, filename, info.ModTime, file)
+		return
+	}
+
+	// the client can't decompress, so the size and ranges aren't known
+	rc, err := s.storage.Get(ctx, info.Key, 0, -1)
 	if err != nil {
 		ll.Error("error reading file from storage", zap.Error(err))
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	defer rc.Close()
+	zr, err := gzip.NewReader(rc)
+	if err != nil {
+		ll.Error("error decompressing file", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	if _, err := io.Copy(w, zr); err != nil {
+		ll.Error("error sending decompressed file", zap.Error(err))
+	}
+}
+
+// statusWriter remembers the status of a response for the audit log.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
 
-	ll.Info("sending file")
-	ext := filepath.Ext(filename)
-	mimeType := mime.TypeByExtension(ext)
-	w.Header().Set("Content-Type", mimeType)
-	io.Copy(w, file)
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
 }
 
 func directUrl(shot *Shot) string {
-	return fmt.Sprintf("%s/%s", directPath, shot.Filename)
+	return fmt.Sprintf("%s/%s", directPath, servedKey(shot))
+}
+
+// httpError responds with 404 for shots, albums and files that don't exist,
+// which includes filenames no file could have, and 500 for anything else.
+func httpError(w http.ResponseWriter, err error, ll *zap.Logger) {
+	if err == ErrNotFound || err == ErrAlbumNotFound || err == errInvalidKey || os.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	ll.Error("request failed", zap.Error(err))
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
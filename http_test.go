@@ -0,0 +1,297 @@
+package spree_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/gorilla/mux"
+	"github.com/ralfonso/spree"
+	"golang.org/x/net/context"
+)
+
+func TestDisplayPageAccept(t *testing.T) {
+	md := spree.NewMemoryMetadata()
+	for _, shot := range []*spree.Shot{
+		{Id: "plain", Filename: "shot.png", StorageKey: "plain-shot.png"},
+		{Id: "sealed", Filename: "shot.png.e2e", StorageKey: "sealed-shot.png.e2e", E2E: true},
+	} {
+		if err := md.PutShot(shot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	views := spree.NewViewCounter(md, zap.NewNop())
+	s := spree.NewHTTPServer("", md, views, spree.NewMemoryStorage(), nil, nil, 0, nil, zap.NewNop())
+	r := mux.NewRouter()
+	r.HandleFunc("/p/{id}", s.DisplayPageHandler)
+
+	const browser = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	tests := []struct {
+		id       string
+		accept   string
+		status   int
+		location string
+	}{
+		{"plain", browser, http.StatusOK, ""},
+		{"plain", "image/webp,image/*,*/*;q=0.8", http.StatusFound, "/r/plain-shot.png"},
+		{"plain", "*/*", http.StatusFound, "/r/plain-shot.png"},
+		{"plain", "", http.StatusFound, "/r/plain-shot.png"},
+		// the key is only useful to the page
+		{"sealed", "*/*", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/p/"+tt.id, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("Accept-Language", "en")
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s accepting %q got %d to %q, want %d to %q",
+				tt.id, tt.accept, w.Code, w.Header().Get("Location"), tt.status, tt.location)
+		}
+		if tt.status == http.StatusOK && !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("%s accepting %q served %s", tt.id, tt.accept, w.Header().Get("Content-Type"))
+		}
+	}
+
+	// redirects count as views, as they did before there was a page
+	if _, err := views.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	shot, err := md.GetShotById("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shot.Views != 4 {
+		t.Errorf("plain shot has %d views, want 4", shot.Views)
+	}
+}
+
+func TestE2EServing(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+	audit, err := spree.NewAuditLog(md, "", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := &spree.Shot{Id: "sealed", Filename: "shot.png.e2e", StorageKey: "sealed-shot.png.e2e", E2E: true}
+	if err := md.PutShot(sealed); err != nil {
+		t.Fatal(err)
+	}
+	blob := []byte{spree.E2EVersion, 0x3c, 'h', 't', 'm', 'l', 0x3e}
+	storage := spree.NewMemoryStorage()
+	if _, err := storage.Put(context.Background(), sealed.StorageKey, bytes.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the page has to fetch the blob itself, so it isn't sent to the backend
+	s := spree.NewHTTPServer("", md, spree.NewViewCounter(md, zap.NewNop()), presignedStorage{storage}, audit, nil,
+		time.Minute, nil, zap.NewNop())
+	r := mux.NewRouter()
+	r.HandleFunc("/p/{id}", s.DisplayPageHandler)
+	r.HandleFunc("/r/{filename}", s.DirectHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/r/"+sealed.StorageKey, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("fetching the blob got status %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/octet-stream" {
+		t.Errorf("blob served as %s", got)
+	}
+	if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("blob served with X-Content-Type-Options %q", got)
+	}
+	if !bytes.Equal(w.Body.Bytes(), blob) {
+		t.Errorf("blob served as %q, want %q", w.Body.Bytes(), blob)
+	}
+
+	// the page reads the layout encryptE2E writes
+	req := httptest.NewRequest("GET", "/p/"+sealed.Id, nil)
+	req.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	// templates pad numbers in scripts with spaces
+	page := strings.Join(strings.Fields(w.Body.String()), " ")
+	for _, want := range []string{
+		fmt.Sprintf("data[0] !== %d )", spree.E2EVersion),
+		fmt.Sprintf("data.subarray(1, 1 + %d )", spree.E2ENonceSize),
+		`"/r/sealed-shot.png.e2e"`,
+		`name: "AES-GCM"`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("e2e page doesn't contain %s:\n%s", want, page)
+		}
+	}
+}
+
+func TestCompressedServing(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+	audit, err := spree.NewAuditLog(md, "", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the blob is only stored compressed, so it's found by the fallback
+	plain := []byte(strings.Repeat("plain text compresses well\n", 50))
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(plain)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	storage := spree.NewMemoryStorage()
+	ctx := context.Background()
+	if _, err := storage.Put(ctx, "abc-notes.txt.~gz", bytes.NewReader(compressed.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Put(ctx, "abc-shot.png", bytes.NewReader([]byte("png"))); err != nil {
+		t.Fatal(err)
+	}
+	info, err := storage.Stat(ctx, "abc-notes.txt.~gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := info.ModTime.UTC().Format(http.TimeFormat)
+
+	// Content-Encoding can't be added to a presigned URL, so compressed
+	// blobs are served directly even when other blobs are redirected
+	s := spree.NewHTTPServer("", md, spree.NewViewCounter(md, zap.NewNop()), presignedStorage{storage}, audit, nil,
+		time.Minute, nil, zap.NewNop())
+	r := mux.NewRouter()
+	r.HandleFunc("/r/{filename}", s.DirectHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/r/abc-shot.png", nil))
+	if w.Code != http.StatusFound {
+		t.Errorf("uncompressed blob got status %d, want a redirect", w.Code)
+	}
+
+	for _, tt := range []struct {
+		accept string
+		gzip   bool
+	}{
+		{"gzip", true},
+		{"deflate, GZIP;q=0.5", true},
+		{"*", true},
+		{"", false},
+		{"deflate", false},
+		{"gzip;q=0", false},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+	} {
+		req := httptest.NewRequest("GET", "/r/abc-notes.txt", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept-Encoding", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("Accept-Encoding %q got status %d", tt.accept, w.Code)
+			continue
+		}
+		h := w.Header()
+		if got := h.Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q got Vary %q", tt.accept, got)
+		}
+		if got := h.Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+			t.Errorf("Accept-Encoding %q got Content-Type %q", tt.accept, got)
+		}
+		if got := h.Get("Last-Modified"); got != modified {
+			t.Errorf("Accept-Encoding %q got Last-Modified %q, want %q", tt.accept, got, modified)
+		}
+		want, encoding := plain, ""
+		if tt.gzip {
+			want, encoding = compressed.Bytes(), "gzip"
+		}
+		if got := h.Get("Content-Encoding"); got != encoding {
+			t.Errorf("Accept-Encoding %q got Content-Encoding %q, want %q", tt.accept, got, encoding)
+		}
+		if !bytes.Equal(w.Body.Bytes(), want) {
+			t.Errorf("Accept-Encoding %q got body %q, want %q", tt.accept, w.Body.Bytes(), want)
+		}
+	}
+}
+
+func TestAlbumPageSearch(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+	audit, err := spree.NewAuditLog(md, "", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, shot := range []*spree.Shot{
+		{Id: "sunset", Owner: "alice@example.com", Filename: "a.png", Title: "beach sunset"},
+		{Id: "summit", Owner: "alice@example.com", Filename: "b.png", Title: "mountain summit"},
+		{Id: "party", Owner: "alice@example.com", Filename: "c.png", Title: "beach party"},
+		{Id: "bobs", Owner: "bob@example.com", Filename: "d.png", Title: "beach"},
+	} {
+		if err := md.PutShot(shot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := md.PutAlbum(&spree.Album{Id: "trip", Owner: "alice@example.com", ShotIds: []string{"summit", "sunset"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := md.PutAlbum(&spree.Album{Id: "empty", Owner: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := spree.NewHTTPServer("", md, spree.NewViewCounter(md, zap.NewNop()), spree.NewMemoryStorage(), audit, nil,
+		0, nil, zap.NewNop())
+	r := mux.NewRouter()
+	r.HandleFunc("/a/{id}", s.AlbumPageHandler)
+
+	// only the album's own shots are searched
+	for _, tt := range []struct {
+		path   string
+		status int
+		want   []string
+	}{
+		{"/a/trip", http.StatusOK, []string{"summit", "sunset"}},
+		{"/a/trip?q=beach", http.StatusOK, []string{"sunset"}},
+		{"/a/trip?q=sunset+OR+mount*", http.StatusOK, []string{"summit", "sunset"}},
+		{"/a/trip?q=party", http.StatusOK, nil},
+		{"/a/trip?q=OR", http.StatusBadRequest, nil},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s got status %d, want %d", tt.path, w.Code, tt.status)
+		}
+		page := w.Body.String()
+		if !strings.Contains(page, `name="q"`) {
+			t.Errorf("%s has no search box", tt.path)
+		}
+		var listed []string
+		for _, id := range []string{"summit", "sunset", "party", "bobs"} {
+			if strings.Contains(page, `href="/p/`+id+`"`) {
+				listed = append(listed, id)
+			}
+		}
+		if fmt.Sprint(listed) != fmt.Sprint(tt.want) {
+			t.Errorf("%s lists %v, want %v", tt.path, listed, tt.want)
+		}
+		if i, j := strings.Index(page, "/p/summit"), strings.Index(page, "/p/sunset"); len(tt.want) == 2 && i > j {
+			t.Errorf("%s doesn't keep the album's order", tt.path)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/a/empty", nil))
+	if strings.Contains(w.Body.String(), `name="q"`) {
+		t.Error("empty album has a search box")
+	}
+}
@@ -0,0 +1,395 @@
+package spree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+)
+
+var errClosed = errors.New("metadata store is closed")
+
+// MemoryMetadata keeps shots in memory. It is meant for tests and for
+// deployments that don't need shots to survive a restart.
+type MemoryMetadata struct {
+	mu     sync.Mutex
+	shots  map[string]*Shot
+	stats  map[string]*ViewStats
+	albums map[string]*Album
+	closed bool
+}
+
+var _ Metadata = &MemoryMetadata{}
+
+func NewMemoryMetadata() *MemoryMetadata {
+	return &MemoryMetadata{
+		shots:  make(map[string]*Shot),
+		stats:  make(map[string]*ViewStats),
+		albums: make(map[string]*Album),
+	}
+}
+
+func (m *MemoryMetadata) GetId(shot *Shot) string {
+	return newShotId()
+}
+
+func (m *MemoryMetadata) PutShot(shot *Shot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errClosed
+	}
+
+	shot.Path = fmt.Sprintf("/p/%s", shot.Id)
+	m.shots[shot.Id] = proto.Clone(shot).(*Shot)
+	return nil
+}
+
+func (m *MemoryMetadata) ListShots() ([]*Shot, error) {
+	return m.list(func(*Shot) bool { return true })
+}
+
+func (m *MemoryMetadata) ListShotsByOwner(owner string) ([]*Shot, error) {
+	owner = strings.ToLower(owner)
+	return m.list(func(shot *Shot) bool {
+		return shot.Owner != "" && strings.ToLower(shot.Owner) == owner
+	})
+}
+
+func (m *MemoryMetadata) ListShotsByTag(tag string) ([]*Shot, error) {
+	return m.list(func(shot *Shot) bool { return hasTag(shot, tag) })
+}
+
+// SearchShots matches q against every shot in turn rather than keeping an
+// index.
+func (m *MemoryMetadata) SearchShots(q *Query) ([]*Shot, error) {
+	m.mu.Lock()
+	ids := make([]string, 0, len(m.shots))
+	terms := make(map[string][]string, len(m.shots))
+	for id, shot := range m.shots {
+		ids = append(ids, id)
+		terms[id] = shotTerms(shot)
+	}
+	m.mu.Unlock()
+	sort.Strings(ids)
+
+	found, err := q.eval(func(term string, prefix bool) ([]string, error) {
+		var out []string
+		for _, id := range ids {
+			for _, t := range terms[id] {
+				if t == term || prefix && strings.HasPrefix(t, term) {
+					out = append(out, id)
+					break
+				}
+			}
+		}
+		return out, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make(map[string]bool, len(found))
+	for _, id := range found {
+		matched[id] = true
+	}
+	return m.list(func(shot *Shot) bool { return matched[shot.Id] })
+}
+
+// list returns copies of the shots matched by fn, ordered by id like the
+// keys of a BoltKV bucket.
+func (m *MemoryMetadata) list(fn func(*Shot) bool) ([]*Shot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errClosed
+	}
+
+	ids := make([]string, 0, len(m.shots))
+	for id, shot := range m.shots {
+		if fn(shot) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	shots := make([]*Shot, 0, len(ids))
+	for _, id := range ids {
+		shots = append(shots, proto.Clone(m.shots[id]).(*Shot))
+	}
+	return shots, nil
+}
+
+func (m *MemoryMetadata) GetShotById(id string) (*Shot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errClosed
+	}
+
+	shot, ok := m.shots[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return proto.Clone(shot).(*Shot), nil
+}
+
+func (m *MemoryMetadata) UpdateShot(id string, fn func(*Shot)) (*Shot, error) {
+	var out *Shot
+	err := m.update(id, func(shot *Shot) {
+		fn(shot)
+		shot.Id = id
+		out = proto.Clone(shot).(*Shot)
+	})
+	return out, err
+}
+
+func (m *MemoryMetadata) AddViews(views map[string]*ViewStats, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errClosed
+	}
+
+	lastViewed := at.UTC().Format(time.RFC3339)
+	for id, delta := range views {
+		if shot, ok := m.shots[id]; ok {
+			shot.Views += delta.Views
+			shot.CrawlerViews += delta.CrawlerViews
+			if delta.Views > 0 {
+				shot.LastViewedAt = lastViewed
+			}
+			if delta.Reads > 0 {
+				shot.LastReadAt = lastViewed
+			}
+			m.stats[id] = mergeViewStats(m.stats[id], delta)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryMetadata) GetViewStats(id string) (*ViewStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errClosed
+	}
+
+	if _, ok := m.shots[id]; !ok {
+		return nil, ErrNotFound
+	}
+	stats, ok := m.stats[id]
+	if !ok {
+		return &ViewStats{}, nil
+	}
+	return proto.Clone(stats).(*ViewStats), nil
+}
+
+func (m *MemoryMetadata) DeleteShot(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errClosed
+	}
+
+	if _, ok := m.shots[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.shots, id)
+	delete(m.stats, id)
+	return nil
+}
+
+func (m *MemoryMetadata) SetBackendType(id, backendType string) error {
+	return m.update(id, func(shot *Shot) {
+		shot.Backend = &BackendDetails{
+			Type: backendType,
+		}
+	})
+}
+
+func (m *MemoryMetadata) update(id string, fn func(*Shot)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errClosed
+	}
+
+	shot, ok := m.shots[id]
+	if !ok {
+		return ErrNotFound
+	}
+	fn(shot)
+	return nil
+}
+
+func (m *MemoryMetadata) PutAlbum(album *Album) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errClosed
+	}
+
+	album.Path = albumPath(album.Id)
+	m.albums[album.Id] = proto.Clone(album).(*Album)
+	return nil
+}
+
+func (m *MemoryMetadata) GetAlbumById(id string) (*Album, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errClosed
+	}
+
+	album, ok := m.albums[id]
+	if !ok {
+		return nil, ErrAlbumNotFound
+	}
+	return proto.Clone(album).(*Album), nil
+}
+
+func (m *MemoryMetadata) UpdateAlbum(id string, fn func(*Album) error) (*Album, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errClosed
+	}
+
+	stored, ok := m.albums[id]
+	if !ok {
+		return nil, ErrAlbumNotFound
+	}
+	// fn works on a copy so a failed update leaves the album alone
+	album := proto.Clone(stored).(*Album)
+	if err := fn(album); err != nil {
+		return nil, err
+	}
+	album.Id = id
+	m.albums[id] = album
+	return proto.Clone(album).(*Album), nil
+}
+
+func (m *MemoryMetadata) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+// MemoryStorage keeps blobs in memory. Like MemoryMetadata it is meant for
+// tests and ephemeral deployments.
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+var _ Storage = &MemoryStorage{}
+var _ Locator = &MemoryStorage{}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		blobs: make(map[string]memoryBlob),
+	}
+}
+
+// Put reads all of r before storing it, so a failed upload leaves any
+// existing blob in place.
+func (ms *MemoryStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return int64(len(data)), err
+	}
+
+	ms.mu.Lock()
+	ms.blobs[key] = memoryBlob{
+		data:    data,
+		modTime: time.Now(),
+	}
+	ms.mu.Unlock()
+	return int64(len(data)), nil
+}
+
+func (ms *MemoryStorage) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	ms.mu.RLock()
+	blob, ok := ms.blobs[key]
+	ms.mu.RUnlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if offset < 0 {
+		return nil, errNegativeOffset
+	}
+
+	// blobs are replaced rather than modified, so data can be shared
+	data := blob.data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (ms *MemoryStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	ms.mu.RLock()
+	blob, ok := ms.blobs[key]
+	ms.mu.RUnlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &ObjectInfo{
+		Key:     key,
+		Size:    int64(len(blob.data)),
+		ModTime: blob.modTime,
+	}, nil
+}
+
+func (ms *MemoryStorage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	infos := make([]*ObjectInfo, 0, len(ms.blobs))
+	for key, blob := range ms.blobs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		infos = append(infos, &ObjectInfo{
+			Key:     key,
+			Size:    int64(len(blob.data)),
+			ModTime: blob.modTime,
+		})
+	}
+	sort.Sort(byKey(infos))
+	return infos, nil
+}
+
+func (ms *MemoryStorage) Delete(ctx context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.blobs[key]; !ok {
+		return os.ErrNotExist
+	}
+	delete(ms.blobs, key)
+	return nil
+}
+
+func (ms *MemoryStorage) Locate(ctx context.Context, key string) (string, error) {
+	return "memory", nil
+}
@@ -1,10 +1,58 @@
 package spree
 
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by Metadata methods given the id of a shot
+	// that doesn't exist.
+	ErrNotFound = errors.New("shot not found")
+	// ErrAlbumNotFound is returned for the id of an album that doesn't
+	// exist.
+	ErrAlbumNotFound = errors.New("album not found")
+)
+
+// Metadata stores shots. The spreetest package has a conformance suite that
+// every implementation must pass.
 type Metadata interface {
 	GetId(*Shot) string
+	// PutShot creates or replaces the shot with shot.Id.
 	PutShot(*Shot) error
+	// ListShots returns every shot, ordered by id.
 	ListShots() ([]*Shot, error)
+	// ListShotsByOwner returns the shots owned by owner, ordered by id.
+	// Owners are matched case-insensitively.
+	ListShotsByOwner(owner string) ([]*Shot, error)
+	// ListShotsByTag returns the shots tagged with tag, ordered by id.
+	ListShotsByTag(tag string) ([]*Shot, error)
 	GetShotById(id string) (*Shot, error)
-	IncrementViews(id string) (*Shot, error)
+	// UpdateShot applies fn to the shot and stores the result in one step,
+	// so the update can't race with view counting, and returns the updated
+	// shot.
+	UpdateShot(id string, fn func(*Shot)) (*Shot, error)
+	// AddViews adds a batch of views, keyed by shot id, to the shots' view
+	// and crawler view counts and ViewStats, and records at as their last
+	// view if anyone but a crawler viewed them, and as their last read if
+	// their file was fetched. Views of missing shots are dropped.
+	AddViews(views map[string]*ViewStats, at time.Time) error
+	// GetViewStats returns the view history of a shot. Shots that have
+	// never been viewed have empty stats.
+	GetViewStats(id string) (*ViewStats, error)
+	// SearchShots returns the shots matching q, ordered by id.
+	SearchShots(q *Query) ([]*Shot, error)
+	// DeleteShot removes the shot and its view stats.
+	DeleteShot(id string) error
+	SetBackendType(id, backendType string) error
+
+	// PutAlbum creates or replaces the album with album.Id.
+	PutAlbum(*Album) error
+	GetAlbumById(id string) (*Album, error)
+	// UpdateAlbum applies fn to the album and stores the result in one
+	// step. If fn returns an error nothing is stored and the error is
+	// returned.
+	UpdateAlbum(id string, fn func(*Album) error) (*Album, error)
+
 	Close() error
 }
@@ -0,0 +1,34 @@
+package spree_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+	"github.com/ralfonso/spree/spreetest"
+)
+
+func TestBoltKV(t *testing.T) {
+	spreetest.TestMetadata(t, func(t *testing.T) (spree.Metadata, func()) {
+		dir, err := ioutil.TempDir("", "spree-bolt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		md, err := spree.NewBoltKV(filepath.Join(dir, "spree.boltdb"), "spree", zap.NewNop())
+		if err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+		return md, func() { os.RemoveAll(dir) }
+	})
+}
+
+func TestMemoryMetadata(t *testing.T) {
+	spreetest.TestMetadata(t, func(t *testing.T) (spree.Metadata, func()) {
+		return spree.NewMemoryMetadata(), nil
+	})
+}
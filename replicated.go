@@ -0,0 +1,726 @@
+package spree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+)
+
+const (
+	// repairs run in the background, bounded like promotions
+	repairTimeout = 10 * time.Minute
+
+	// tombstoneSuffix names the empty blob that records a deletion on each
+	// replica. A copy of the blob no newer than its tombstone is deleted.
+	tombstoneSuffix = ".~deleted"
+)
+
+var (
+	errNoReplicas      = errors.New("replicated storage needs at least one replica")
+	errReservedKey     = errors.New("storage key uses the reserved " + tombstoneSuffix + " suffix")
+	errNoListedReplica = errors.New("no replica could be listed")
+)
+
+// Replica is one of the backends a ReplicatedStorage writes to.
+type Replica struct {
+	Name    string
+	Storage Storage
+}
+
+// replicaState is what we know about a replica's health. A replica is
+// unhealthy from its first error until its next successful operation.
+type replicaState struct {
+	Replica
+
+	healthy    bool
+	lastErr    error
+	lastErrAt  time.Time
+	lastScanAt time.Time
+	blobs      int64
+	missing    int64
+	repaired   int64
+}
+
+// ReplicatedStorage writes every blob to all of its replicas and reads from
+// the first healthy one that has it. A read that finds a blob missing from
+// earlier replicas copies it back to them, and Scan repairs everything else.
+// Deletes leave a tombstone on each replica so that neither brings back a
+// blob from a replica that missed the delete.
+type ReplicatedStorage struct {
+	ll          *zap.Logger
+	writeQuorum int
+
+	mu        sync.Mutex
+	replicas  []*replicaState
+	repairing map[string]bool
+}
+
+var _ Storage = &ReplicatedStorage{}
+var _ Locator = &ReplicatedStorage{}
+
+// NewReplicatedStorage creates a ReplicatedStorage. Put succeeds once
+// writeQuorum replicas have the blob; the rest are repaired later.
+func NewReplicatedStorage(replicas []Replica, writeQuorum int, ll *zap.Logger) (*ReplicatedStorage, error) {
+	if len(replicas) == 0 {
+		return nil, errNoReplicas
+	}
+	if writeQuorum < 1 || writeQuorum > len(replicas) {
+		return nil, fmt.Errorf("write quorum must be between 1 and %d", len(replicas))
+	}
+
+	states := make([]*replicaState, len(replicas))
+	for i, r := range replicas {
+		states[i] = &replicaState{
+			Replica: r,
+			healthy: true,
+		}
+	}
+	return &ReplicatedStorage{
+		ll:          ll,
+		writeQuorum: writeQuorum,
+		replicas:    states,
+		repairing:   make(map[string]bool),
+	}, nil
+}
+
+// Put streams r to every replica at once and returns once they have all
+// finished, so it runs at the pace of the slowest replica. A replica that
+// fails drops out and the rest carry on. If fewer than writeQuorum
+// replicas succeed, the copies that were written are removed again.
+func (rs *ReplicatedStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if strings.HasSuffix(key, tombstoneSuffix) {
+		return 0, errReservedKey
+	}
+
+	type result struct {
+		i   int
+		err error
+	}
+
+	writers := make([]*io.PipeWriter, len(rs.replicas))
+	results := make(chan result, len(rs.replicas))
+	for i, rep := range rs.replicas {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		go func(i int, s Storage) {
+			_, err := s.Put(ctx, key, pr)
+			// unblock the writer if Put gave up early
+			if err != nil {
+				pr.CloseWithError(err)
+			} else {
+				pr.Close()
+			}
+			results <- result{i, err}
+		}(i, rep.Storage)
+	}
+
+	n, copyErr := rs.fanOut(writers, r)
+
+	errs := make([]error, len(rs.replicas))
+	var ok int
+	for range rs.replicas {
+		res := <-results
+		err := res.err
+		if err == nil && writers[res.i] == nil {
+			err = io.ErrShortWrite
+		}
+		errs[res.i] = err
+		rs.observe(res.i, err)
+		if err == nil {
+			ok++
+		}
+	}
+
+	if copyErr != nil {
+		return n, copyErr
+	}
+	if ok < rs.writeQuorum {
+		// the upload failed, so don't leave copies for Scan to spread
+		for i, err := range errs {
+			if err != nil {
+				continue
+			}
+			if derr := rs.replicas[i].Storage.Delete(ctx, key); derr != nil {
+				rs.ll.Error("unable to roll back partial put",
+					zap.String("key", key), zap.String("replica", rs.replicas[i].Name), zap.Error(derr))
+			}
+		}
+		return n, fmt.Errorf("stored %s on %d of %d replicas, need %d: %v",
+			key, ok, len(rs.replicas), rs.writeQuorum, firstError(errs))
+	}
+	if ok < len(rs.replicas) {
+		rs.ll.Warn("blob is under-replicated until the next repair",
+			zap.String("key", key), zap.Int("replicas", ok), zap.Error(firstError(errs)))
+	}
+	return n, nil
+}
+
+// fanOut copies r to every writer. Each chunk is written to every writer
+// before the next is read, so a slow writer slows them all. Writers that
+// fail are set to nil and skipped from then on.
+func (rs *ReplicatedStorage) fanOut(writers []*io.PipeWriter, r io.Reader) (int64, error) {
+	buf := make([]byte, 32<<10)
+	var n int64
+	for {
+		nr, rerr := r.Read(buf)
+		if nr > 0 {
+			for i, w := range writers {
+				if w == nil {
+					continue
+				}
+				if _, err := w.Write(buf[:nr]); err != nil {
+					writers[i] = nil
+				}
+			}
+			n += int64(nr)
+		}
+		if rerr == io.EOF {
+			for _, w := range writers {
+				if w != nil {
+					w.Close()
+				}
+			}
+			return n, nil
+		}
+		if rerr != nil {
+			for _, w := range writers {
+				if w != nil {
+					w.CloseWithError(rerr)
+				}
+			}
+			return n, rerr
+		}
+	}
+}
+
+func (rs *ReplicatedStorage) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if strings.HasSuffix(key, tombstoneSuffix) {
+		return nil, os.ErrNotExist
+	}
+	var rc io.ReadCloser
+	err := rs.read(key, func(s Storage) error {
+		var err error
+		rc, err = s.Get(ctx, key, offset, length)
+		return err
+	})
+	return rc, err
+}
+
+func (rs *ReplicatedStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	if strings.HasSuffix(key, tombstoneSuffix) {
+		return nil, os.ErrNotExist
+	}
+	var info *ObjectInfo
+	err := rs.read(key, func(s Storage) error {
+		var err error
+		info, err = s.Stat(ctx, key)
+		return err
+	})
+	return info, err
+}
+
+// read tries fn on each replica, healthy ones first, until one succeeds.
+// Replicas that were missing the blob are repaired from the one that had it.
+func (rs *ReplicatedStorage) read(key string, fn func(Storage) error) error {
+	var missing []int
+	var lastErr error = os.ErrNotExist
+	for _, i := range rs.readOrder() {
+		err := fn(rs.replicas[i].Storage)
+		rs.observe(i, err)
+		if err == nil {
+			if len(missing) > 0 {
+				rs.repair(key, i, missing)
+			}
+			return nil
+		}
+		if os.IsNotExist(err) {
+			missing = append(missing, i)
+			continue
+		}
+		lastErr = err
+	}
+
+	if len(missing) == len(rs.replicas) {
+		return os.ErrNotExist
+	}
+	return lastErr
+}
+
+// List returns the union of every replica's blobs. Replicas that can't be
+// listed are skipped as long as one can.
+func (rs *ReplicatedStorage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	seen := make(map[string]bool)
+	var infos []*ObjectInfo
+	var listed int
+	var lastErr error
+	for i, rep := range rs.replicas {
+		repInfos, err := rep.Storage.List(ctx, prefix)
+		rs.observe(i, err)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		listed++
+		for _, info := range repInfos {
+			if strings.HasSuffix(info.Key, tombstoneSuffix) {
+				continue
+			}
+			if !seen[info.Key] {
+				seen[info.Key] = true
+				infos = append(infos, info)
+			}
+		}
+	}
+	if listed == 0 {
+		return nil, lastErr
+	}
+
+	sort.Sort(byKey(infos))
+	return infos, nil
+}
+
+// Delete removes the blob from every replica. Tombstones are written first,
+// to at least writeQuorum replicas, so a replica that misses the delete is
+// cleaned up by Scan rather than copied back from. Scan retries the delete
+// until every replica confirms it, then drops the tombstones.
+func (rs *ReplicatedStorage) Delete(ctx context.Context, key string) error {
+	if strings.HasSuffix(key, tombstoneSuffix) {
+		return os.ErrNotExist
+	}
+
+	errs := make([]error, len(rs.replicas))
+	var marked int
+	for i, rep := range rs.replicas {
+		_, err := rep.Storage.Put(ctx, key+tombstoneSuffix, bytes.NewReader(nil))
+		rs.observe(i, err)
+		errs[i] = err
+		if err == nil {
+			marked++
+		}
+	}
+	if marked < rs.writeQuorum {
+		for i, err := range errs {
+			if err == nil {
+				rs.dropTombstone(ctx, key, i)
+			}
+		}
+		return fmt.Errorf("recorded the deletion of %s on %d of %d replicas, need %d: %v",
+			key, marked, len(rs.replicas), rs.writeQuorum, firstError(errs))
+	}
+
+	var notExist, failed int
+	for i, rep := range rs.replicas {
+		err := rep.Storage.Delete(ctx, key)
+		rs.observe(i, err)
+		switch {
+		case err == nil:
+		case os.IsNotExist(err):
+			notExist++
+		default:
+			failed++
+			rs.ll.Warn("unable to delete blob, retrying at the next scan",
+				zap.String("key", key), zap.String("replica", rep.Name), zap.Error(err))
+		}
+	}
+	if failed == 0 {
+		for i, err := range errs {
+			if err == nil {
+				rs.dropTombstone(ctx, key, i)
+			}
+		}
+	}
+	if notExist == len(rs.replicas) {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+// dropTombstone removes key's tombstone from replica i. One left behind is
+// only removed by a later Scan.
+func (rs *ReplicatedStorage) dropTombstone(ctx context.Context, key string, i int) {
+	err := rs.replicas[i].Storage.Delete(ctx, key+tombstoneSuffix)
+	rs.observe(i, err)
+	if err != nil && !os.IsNotExist(err) {
+		rs.ll.Warn("unable to remove tombstone",
+			zap.String("key", key), zap.String("replica", rs.replicas[i].Name), zap.Error(err))
+	}
+}
+
+func (rs *ReplicatedStorage) Locate(ctx context.Context, key string) (string, error) {
+	if strings.HasSuffix(key, tombstoneSuffix) {
+		return "", os.ErrNotExist
+	}
+	for _, i := range rs.readOrder() {
+		s := rs.replicas[i].Storage
+		if _, err := s.Stat(ctx, key); err == nil {
+			return fmt.Sprintf("replicated:%s", backendType(ctx, s, key)), nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// ScanStats counts what an anti-entropy pass did.
+type ScanStats struct {
+	Blobs    int
+	Repaired int
+	Deleted  int
+	Failed   int
+}
+
+// Scan compares every replica's listing. Blobs that were deleted are
+// deleted from the replicas that still have them, and the tombstones are
+// dropped once every replica has confirmed the delete. Other blobs are
+// copied from the newest copy to the replicas missing them or holding a
+// copy of a different size.
+func (rs *ReplicatedStorage) Scan(ctx context.Context) (ScanStats, error) {
+	var stats ScanStats
+
+	blobs := make([]map[string]*ObjectInfo, len(rs.replicas))
+	tombs := make([]map[string]time.Time, len(rs.replicas))
+	var listed int
+	for i, rep := range rs.replicas {
+		infos, err := rep.Storage.List(ctx, "")
+		rs.observe(i, err)
+		if err != nil {
+			rs.ll.Error("unable to list replica", zap.String("replica", rep.Name), zap.Error(err))
+			continue
+		}
+		listed++
+		blobs[i] = make(map[string]*ObjectInfo, len(infos))
+		tombs[i] = make(map[string]time.Time)
+		for _, info := range infos {
+			if strings.HasSuffix(info.Key, tombstoneSuffix) {
+				tombs[i][strings.TrimSuffix(info.Key, tombstoneSuffix)] = info.ModTime
+				continue
+			}
+			blobs[i][info.Key] = info
+		}
+	}
+	if listed == 0 {
+		return stats, errNoListedReplica
+	}
+
+	keys := make(map[string]bool)
+	for i := range blobs {
+		for key := range blobs[i] {
+			keys[key] = true
+		}
+		for key := range tombs[i] {
+			keys[key] = true
+		}
+	}
+
+	present := make([]int64, len(rs.replicas))
+	missing := make([]int64, len(rs.replicas))
+	repaired := make([]int64, len(rs.replicas))
+	for key := range keys {
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+
+		src := newestCopy(blobs, key)
+		deletedAt, deleted := newestTombstone(tombs, key)
+		if deleted && (src < 0 || !blobs[src][key].ModTime.After(deletedAt)) {
+			rs.finishDelete(ctx, key, blobs, tombs, listed == len(rs.replicas), &stats)
+			continue
+		}
+		stats.Blobs++
+
+		size := blobs[src][key].Size
+		for i, b := range blobs {
+			if b == nil {
+				continue
+			}
+			if have, ok := b[key]; ok && have.Size == size {
+				present[i]++
+				continue
+			}
+			missing[i]++
+
+			rep := rs.replicas[i]
+			err := rs.copyBlob(ctx, key, rs.replicas[src].Storage, rep.Storage)
+			rs.observe(i, err)
+			if err != nil {
+				rs.ll.Error("unable to repair replica",
+					zap.String("key", key), zap.String("replica", rep.Name), zap.Error(err))
+				stats.Failed++
+				continue
+			}
+			repaired[i]++
+			stats.Repaired++
+		}
+
+		// the blob was put again after it was deleted
+		if deleted {
+			for i, t := range tombs {
+				if _, ok := t[key]; ok {
+					rs.dropTombstone(ctx, key, i)
+				}
+			}
+		}
+	}
+
+	now := time.Now()
+	rs.mu.Lock()
+	for i, b := range blobs {
+		if b == nil {
+			continue
+		}
+		st := rs.replicas[i]
+		st.lastScanAt = now
+		st.blobs = present[i] + repaired[i]
+		st.missing = missing[i] - repaired[i]
+		st.repaired += repaired[i]
+	}
+	rs.mu.Unlock()
+
+	return stats, nil
+}
+
+// finishDelete deletes key from the replicas that still have it. The
+// tombstones are dropped if every replica is known to be rid of the blob.
+func (rs *ReplicatedStorage) finishDelete(ctx context.Context, key string, blobs []map[string]*ObjectInfo,
+	tombs []map[string]time.Time, allListed bool, stats *ScanStats) {
+	confirmed := allListed
+	for i, b := range blobs {
+		if _, ok := b[key]; !ok {
+			continue
+		}
+		rep := rs.replicas[i]
+		err := rep.Storage.Delete(ctx, key)
+		rs.observe(i, err)
+		if err != nil && !os.IsNotExist(err) {
+			rs.ll.Error("unable to delete blob from replica",
+				zap.String("key", key), zap.String("replica", rep.Name), zap.Error(err))
+			stats.Failed++
+			confirmed = false
+			continue
+		}
+		stats.Deleted++
+	}
+
+	if !confirmed {
+		return
+	}
+	for i, t := range tombs {
+		if _, ok := t[key]; ok {
+			rs.dropTombstone(ctx, key, i)
+		}
+	}
+}
+
+// ScanLoop runs Scan every interval until ctx is done.
+func (rs *ReplicatedStorage) ScanLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		stats, err := rs.Scan(ctx)
+		if err != nil {
+			rs.ll.Error("replica scan failed", zap.Error(err))
+			continue
+		}
+		rs.ll.Info("replica scan complete",
+			zap.Int("blobs", stats.Blobs),
+			zap.Int("repaired", stats.Repaired),
+			zap.Int("deleted", stats.Deleted),
+			zap.Int("failed", stats.Failed))
+	}
+}
+
+// Health reports the state of each replica.
+func (rs *ReplicatedStorage) Health() []*ReplicaStatus {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	statuses := make([]*ReplicaStatus, len(rs.replicas))
+	for i, st := range rs.replicas {
+		status := &ReplicaStatus{
+			Name:     st.Name,
+			Healthy:  st.healthy,
+			Blobs:    st.blobs,
+			Missing:  st.missing,
+			Repaired: st.repaired,
+		}
+		if st.lastErr != nil {
+			status.LastError = st.lastErr.Error()
+			status.LastErrorAt = st.lastErrAt.UTC().Format(time.RFC3339)
+		}
+		if !st.lastScanAt.IsZero() {
+			status.LastScanAt = st.lastScanAt.UTC().Format(time.RFC3339)
+		}
+		statuses[i] = status
+	}
+	return statuses
+}
+
+// readOrder lists healthy replicas first, keeping the configured order
+// within each group.
+func (rs *ReplicatedStorage) readOrder() []int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	order := make([]int, 0, len(rs.replicas))
+	for i, st := range rs.replicas {
+		if st.healthy {
+			order = append(order, i)
+		}
+	}
+	for i, st := range rs.replicas {
+		if !st.healthy {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// observe records the outcome of an operation on replica i. Missing blobs
+// aren't the replica's fault.
+func (rs *ReplicatedStorage) observe(i int, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	st := rs.replicas[i]
+	if err == nil || os.IsNotExist(err) {
+		if !st.healthy {
+			rs.ll.Info("replica recovered", zap.String("replica", st.Name))
+		}
+		st.healthy = true
+		return
+	}
+
+	if st.healthy {
+		rs.ll.Warn("replica failed", zap.String("replica", st.Name), zap.Error(err))
+	}
+	st.healthy = false
+	st.lastErr = err
+	st.lastErrAt = time.Now()
+}
+
+// repair copies key from replica src to the replicas in dst in the
+// background, once per key at a time.
+func (rs *ReplicatedStorage) repair(key string, src int, dst []int) {
+	rs.mu.Lock()
+	if rs.repairing[key] {
+		rs.mu.Unlock()
+		return
+	}
+	rs.repairing[key] = true
+	rs.mu.Unlock()
+
+	go func() {
+		defer func() {
+			rs.mu.Lock()
+			delete(rs.repairing, key)
+			rs.mu.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), repairTimeout)
+		defer cancel()
+		deleted, err := rs.deleted(ctx, key, src)
+		if err != nil {
+			rs.ll.Error("unable to check for a tombstone", zap.String("key", key), zap.Error(err))
+			return
+		}
+		if deleted {
+			// the copy that was read missed a delete; Scan removes it
+			rs.ll.Debug("not repairing deleted blob", zap.String("key", key))
+			return
+		}
+		for _, i := range dst {
+			rep := rs.replicas[i]
+			ll := rs.ll.With(zap.String("key", key), zap.String("replica", rep.Name))
+			err := rs.copyBlob(ctx, key, rs.replicas[src].Storage, rep.Storage)
+			rs.observe(i, err)
+			if err != nil {
+				ll.Error("unable to repair missing replica", zap.Error(err))
+				continue
+			}
+			rs.mu.Lock()
+			rep.repaired++
+			rs.mu.Unlock()
+			ll.Info("repaired missing replica")
+		}
+	}()
+}
+
+func (rs *ReplicatedStorage) copyBlob(ctx context.Context, key string, from, to Storage) error {
+	rc, err := from.Get(ctx, key, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = to.Put(ctx, key, rc)
+	return err
+}
+
+// deleted reports whether replica src's copy of key is no newer than a
+// tombstone on any replica.
+func (rs *ReplicatedStorage) deleted(ctx context.Context, key string, src int) (bool, error) {
+	info, err := rs.replicas[src].Storage.Stat(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	for i, rep := range rs.replicas {
+		tomb, err := rep.Storage.Stat(ctx, key+tombstoneSuffix)
+		rs.observe(i, err)
+		if err == nil && !info.ModTime.After(tomb.ModTime) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// newestCopy picks the replica to repair key from: the one with the most
+// recently written copy, preferring earlier replicas on a tie. It returns -1
+// if no replica has the blob.
+func newestCopy(blobs []map[string]*ObjectInfo, key string) int {
+	best := -1
+	for i, b := range blobs {
+		info, ok := b[key]
+		if !ok {
+			continue
+		}
+		if best < 0 || info.ModTime.After(blobs[best][key].ModTime) {
+			best = i
+		}
+	}
+	return best
+}
+
+// newestTombstone returns when key was last deleted, if it has been.
+func newestTombstone(tombs []map[string]time.Time, key string) (time.Time, bool) {
+	var newest time.Time
+	var found bool
+	for _, t := range tombs {
+		if at, ok := t[key]; ok && (!found || at.After(newest)) {
+			newest, found = at, true
+		}
+	}
+	return newest, found
+}
+
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package spree_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+	"golang.org/x/net/context"
+)
+
+var errReplicaDown = errors.New("replica is down")
+
+// downableStorage fails every operation while it's down.
+type downableStorage struct {
+	spree.Storage
+
+	mu   sync.Mutex
+	down bool
+}
+
+func (s *downableStorage) setDown(down bool) {
+	s.mu.Lock()
+	s.down = down
+	s.mu.Unlock()
+}
+
+func (s *downableStorage) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errReplicaDown
+	}
+	return nil
+}
+
+func (s *downableStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := s.err(); err != nil {
+		return 0, err
+	}
+	return s.Storage.Put(ctx, key, r)
+}
+
+func (s *downableStorage) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.Get(ctx, key, offset, length)
+}
+
+func (s *downableStorage) Stat(ctx context.Context, key string) (*spree.ObjectInfo, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.Stat(ctx, key)
+}
+
+func (s *downableStorage) List(ctx context.Context, prefix string) ([]*spree.ObjectInfo, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.Storage.List(ctx, prefix)
+}
+
+func (s *downableStorage) Delete(ctx context.Context, key string) error {
+	if err := s.err(); err != nil {
+		return err
+	}
+	return s.Storage.Delete(ctx, key)
+}
+
+// newReplicas returns a ReplicatedStorage over three memory replicas, a, b
+// and c, with a write quorum of two.
+func newReplicas(t *testing.T) (*spree.ReplicatedStorage, []*downableStorage) {
+	var backends []*downableStorage
+	var replicas []spree.Replica
+	for _, name := range []string{"a", "b", "c"} {
+		s := &downableStorage{Storage: spree.NewMemoryStorage()}
+		backends = append(backends, s)
+		replicas = append(replicas, spree.Replica{Name: name, Storage: s})
+	}
+	rs, err := spree.NewReplicatedStorage(replicas, 2, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs, backends
+}
+
+func mustScan(t *testing.T, rs *spree.ReplicatedStorage) spree.ScanStats {
+	stats, err := rs.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+// listed returns every key in s, tombstones included.
+func listed(t *testing.T, s spree.Storage) []string {
+	infos, err := s.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys(infos)
+}
+
+func keys(infos []*spree.ObjectInfo) []string {
+	out := make([]string, len(infos))
+	for i, info := range infos {
+		out[i] = info.Key
+	}
+	return out
+}
+
+func TestReplicatedStorageQuorum(t *testing.T) {
+	ctx := context.Background()
+	rs, backends := newReplicas(t)
+
+	// below quorum the put fails and the one copy is removed again
+	backends[1].setDown(true)
+	backends[2].setDown(true)
+	if _, err := rs.Put(ctx, "shot", bytes.NewReader([]byte("pixels"))); err == nil {
+		t.Fatal("put on one of three replicas succeeded")
+	}
+	if got := listed(t, backends[0]); len(got) != 0 {
+		t.Errorf("failed put left %v behind", got)
+	}
+
+	backends[1].setDown(false)
+	putRaw(t, rs, "shot", []byte("pixels"))
+	if stored(t, backends[2].Storage, "shot") {
+		t.Fatal("down replica has the blob")
+	}
+
+	health := rs.Health()
+	if len(health) != 3 || !health[0].Healthy || !health[1].Healthy || health[2].Healthy {
+		t.Fatalf("health with c down is %v", health)
+	}
+	if health[2].LastError != errReplicaDown.Error() || health[2].LastErrorAt == "" {
+		t.Errorf("c's last error is %q at %q", health[2].LastError, health[2].LastErrorAt)
+	}
+
+	backends[2].setDown(false)
+	if stats := mustScan(t, rs); stats.Blobs != 1 || stats.Repaired != 1 || stats.Failed != 0 {
+		t.Errorf("scan after c came back got %+v", stats)
+	}
+	if got := readFile(t, backends[2], "shot"); got != "pixels" {
+		t.Errorf("c holds %q after the scan", got)
+	}
+	for _, st := range rs.Health() {
+		if !st.Healthy || st.Blobs != 1 || st.Missing != 0 || st.LastScanAt == "" {
+			t.Errorf("health after the scan is %v", st)
+		}
+		var want int64
+		if st.Name == "c" {
+			want = 1
+		}
+		if st.Repaired != want {
+			t.Errorf("%s has %d repairs, want %d", st.Name, st.Repaired, want)
+		}
+	}
+}
+
+func TestReplicatedStorageReadRepair(t *testing.T) {
+	ctx := context.Background()
+	rs, backends := newReplicas(t)
+
+	backends[0].setDown(true)
+	putRaw(t, rs, "shot", []byte("pixels"))
+
+	// reads skip a while it's down
+	if got := readFile(t, rs, "shot"); got != "pixels" {
+		t.Errorf("read %q with a down", got)
+	}
+
+	// once a is back it is read first, misses the blob and is repaired
+	backends[0].setDown(false)
+	if _, err := rs.List(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, rs, "shot"); got != "pixels" {
+		t.Errorf("read %q after a came back", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !stored(t, backends[0], "shot") {
+		if time.Now().After(deadline) {
+			t.Fatal("a wasn't repaired by the read")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := readFile(t, backends[0], "shot"); got != "pixels" {
+		t.Errorf("a was repaired with %q", got)
+	}
+}
+
+func TestReplicatedStorageDeleteWhileDown(t *testing.T) {
+	ctx := context.Background()
+	rs, backends := newReplicas(t)
+	putRaw(t, rs, "shot", []byte("pixels"))
+
+	backends[2].setDown(true)
+	if err := rs.Delete(ctx, "shot"); err != nil {
+		t.Fatal(err)
+	}
+	backends[2].setDown(false)
+
+	// c still has the blob, but neither a read nor a scan copies it back
+	if _, err := rs.List(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, rs, "shot"); got != "pixels" {
+		t.Errorf("read %q from c", got)
+	}
+	// give a read repair the chance to go wrong
+	time.Sleep(20 * time.Millisecond)
+	if stats := mustScan(t, rs); stats.Blobs != 0 || stats.Deleted != 1 || stats.Repaired != 0 {
+		t.Errorf("scan after c came back got %+v", stats)
+	}
+	for i, b := range backends {
+		if got := listed(t, b); len(got) != 0 {
+			t.Errorf("replica %d holds %v after the scan", i, got)
+		}
+	}
+	if infos, err := rs.List(ctx, ""); err != nil || len(infos) != 0 {
+		t.Errorf("List after the delete = %v, %v", keys(infos), err)
+	}
+
+	// a blob put again after it was deleted is kept
+	backends[2].setDown(true)
+	putRaw(t, rs, "shot", []byte("again"))
+	if err := rs.Delete(ctx, "shot"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	putRaw(t, rs, "shot", []byte("third"))
+	backends[2].setDown(false)
+	if stats := mustScan(t, rs); stats.Blobs != 1 || stats.Deleted != 0 || stats.Repaired != 1 {
+		t.Errorf("scan after the blob was put again got %+v", stats)
+	}
+	for i, b := range backends {
+		if got := readFile(t, b, "shot"); got != "third" {
+			t.Errorf("replica %d holds %q", i, got)
+		}
+		if got := listed(t, b); len(got) != 1 {
+			t.Errorf("replica %d holds %v", i, got)
+		}
+	}
+}
+
+func TestReplicatedStorageNewestCopy(t *testing.T) {
+	rs, backends := newReplicas(t)
+	putRaw(t, rs, "shot", []byte("old"))
+
+	// b alone holds a newer copy, which wins over the majority
+	time.Sleep(time.Millisecond)
+	putRaw(t, backends[1], "shot", []byte("newer"))
+	if stats := mustScan(t, rs); stats.Repaired != 2 {
+		t.Errorf("scan got %+v, want 2 repairs", stats)
+	}
+	for i, b := range backends {
+		if got := readFile(t, b, "shot"); got != "newer" {
+			t.Errorf("replica %d holds %q", i, got)
+		}
+	}
+	if stats := mustScan(t, rs); stats.Repaired != 0 {
+		t.Errorf("second scan got %+v, want nothing to repair", stats)
+	}
+}
@@ -0,0 +1,438 @@
+package spree
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+)
+
+const (
+	// S3 requires every part but the last to be at least 5 MiB
+	s3PartSize = 8 << 20
+	// s3AbortTimeout bounds cleaning up after a failed multipart upload
+	s3AbortTimeout = 10 * time.Second
+)
+
+var (
+	errS3Closed = errors.New("s3 upload is closed")
+)
+
+// S3Config configures an S3-compatible backend (AWS S3, MinIO, Ceph RGW).
+type S3Config struct {
+	// Endpoint is the base URL, e.g. https://s3.us-west-2.amazonaws.com or http://localhost:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses objects as endpoint/bucket/key instead of bucket.endpoint/key.
+	// MinIO and most RGW deployments need this.
+	PathStyle bool
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// S3Storage stores files as objects in an S3 bucket. Large files are sent
+// with multipart uploads as they are read.
+type S3Storage struct {
+	ll     *zap.Logger
+	conf   S3Config
+	base   *url.URL
+	signer *sigV4Signer
+	client *http.Client
+}
+
+var _ Storage = &S3Storage{}
+var _ Presigner = &S3Storage{}
+var _ Locator = &S3Storage{}
+
+// S3Error is an error response from the object store.
+type S3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func NewS3Storage(conf S3Config, ll *zap.Logger) (*S3Storage, error) {
+	if conf.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+	if conf.Region == "" {
+		conf.Region = "us-east-1"
+	}
+
+	base, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("s3 endpoint must be an absolute URL: %s", conf.Endpoint)
+	}
+
+	client := conf.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &S3Storage{
+		ll:   ll,
+		conf: conf,
+		base: base,
+		signer: &sigV4Signer{
+			accessKey: conf.AccessKey,
+			secretKey: conf.SecretKey,
+			region:    conf.Region,
+		},
+		client: client,
+	}, nil
+}
+
+// Put streams r to the bucket, switching to a multipart upload once more than
+// one part has been read. The object doesn't exist until Put succeeds.
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	u := &s3Upload{
+		s:   s,
+		ctx: ctx,
+		key: key,
+	}
+	n, err := io.Copy(u, r)
+	if err != nil {
+		u.abort()
+		return n, err
+	}
+	return n, u.Close()
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	h := http.Header{}
+	switch {
+	case length == 0:
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	case length > 0:
+		h.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		h.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := s.do(ctx, "GET", key, nil, h, nil)
+	if s3Err, ok := err.(*S3Error); ok && s3Err.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// the range starts at or past the end, which the other backends
+		// read as nothing
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	resp, err := s.do(ctx, "HEAD", key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	info := &ObjectInfo{
+		Key:  key,
+		Size: resp.ContentLength,
+	}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		info.ModTime, _ = http.ParseTime(lm)
+	}
+	return info, nil
+}
+
+// List pages through ListObjectsV2. S3 returns keys in order already.
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	var infos []*ObjectInfo
+	var token string
+	for {
+		q := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, "GET", "", q, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		result := struct {
+			Contents []struct {
+				Key          string `xml:"Key"`
+				Size         int64  `xml:"Size"`
+				LastModified string `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			modTime, _ := time.Parse(time.RFC3339, c.LastModified)
+			infos = append(infos, &ObjectInfo{
+				Key:     c.Key,
+				Size:    c.Size,
+				ModTime: modTime,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return infos, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Delete removes key. S3 reports success for keys that don't exist, so it
+// checks first to return os.ErrNotExist like the other backends.
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	if _, err := s.Stat(ctx, key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, "DELETE", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Locate(ctx context.Context, key string) (string, error) {
+	return "s3", nil
+}
+
+// PresignGet returns a URL that can fetch filename directly from the object
+// store until it expires.
+func (s *S3Storage) PresignGet(filename string, expires time.Duration) (string, error) {
+	return s.signer.presign("GET", s.objectURL(filename, nil), expires, time.Now()), nil
+}
+
+func (s *S3Storage) objectURL(key string, q url.Values) *url.URL {
+	u := *s.base
+	prefix := strings.TrimRight(u.Path, "/")
+	rawPrefix := strings.TrimRight(u.EscapedPath(), "/")
+	if s.conf.PathStyle {
+		prefix += "/" + s.conf.Bucket
+		rawPrefix += "/" + uriEscape(s.conf.Bucket, true)
+	} else {
+		u.Host = s.conf.Bucket + "." + u.Host
+	}
+	u.Path = prefix + "/" + key
+	u.RawPath = rawPrefix + "/" + uriEscape(key, false)
+	if q != nil {
+		u.RawQuery = canonicalQuery(q)
+	}
+	return &u
+}
+
+// do sends a signed request and turns non-2xx responses into an *S3Error.
+// A 404 is also reported as os.ErrNotExist.
+func (s *S3Storage) do(ctx context.Context, method, key string, q url.Values, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key, q).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = int64(len(body))
+	if body == nil {
+		req.Body = nil
+	}
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	s.signer.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	s3Err := &S3Error{StatusCode: resp.StatusCode}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := xml.Unmarshal(data, s3Err); err != nil {
+		s3Err.Message = http.StatusText(resp.StatusCode)
+	}
+	return nil, s3Err
+}
+
+// s3Upload buffers writes into parts. Small files are sent with a single
+// PUT on Close, anything larger starts a multipart upload once the first
+// part is full.
+type s3Upload struct {
+	s        *S3Storage
+	ctx      context.Context
+	key      string
+	buf      bytes.Buffer
+	written  int64
+	uploadID string
+	parts    []s3CompletedPart
+	err      error
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	if u.s == nil {
+		return 0, errS3Closed
+	}
+	if u.err != nil {
+		return 0, u.err
+	}
+
+	n, _ := u.buf.Write(p)
+	u.written += int64(n)
+	for u.buf.Len() >= s3PartSize {
+		if err := u.uploadPart(u.buf.Next(s3PartSize)); err != nil {
+			u.err = err
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close finishes the upload. The object doesn't exist until Close succeeds.
+func (u *s3Upload) Close() error {
+	if u.s == nil {
+		return errS3Closed
+	}
+	defer func() { u.s = nil }()
+
+	if u.err != nil {
+		u.abort()
+		return u.err
+	}
+
+	if u.uploadID == "" {
+		resp, err := u.s.do(u.ctx, "PUT", u.key, nil, nil, u.buf.Bytes())
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if u.buf.Len() > 0 {
+		if err := u.uploadPart(u.buf.Bytes()); err != nil {
+			u.abort()
+			return err
+		}
+	}
+
+	complete := struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: u.parts}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		u.abort()
+		return err
+	}
+
+	resp, err := u.s.do(u.ctx, "POST", u.key, url.Values{"uploadId": {u.uploadID}}, nil, body)
+	if err != nil {
+		u.abort()
+		return err
+	}
+	defer resp.Body.Close()
+
+	// a 200 can still carry an error if the completion failed part way
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		s3Err := &S3Error{StatusCode: resp.StatusCode}
+		xml.Unmarshal(data, s3Err)
+		return s3Err
+	}
+	return nil
+}
+
+func (u *s3Upload) uploadPart(data []byte) error {
+	if u.uploadID == "" {
+		resp, err := u.s.do(u.ctx, "POST", u.key, url.Values{"uploads": {""}}, nil, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		initiated := struct {
+			UploadID string `xml:"UploadId"`
+		}{}
+		if err := xml.NewDecoder(resp.Body).Decode(&initiated); err != nil {
+			return err
+		}
+		u.uploadID = initiated.UploadID
+		u.s.ll.Debug("started multipart upload", zap.String("key", u.key), zap.String("upload.id", u.uploadID))
+	}
+
+	partNumber := len(u.parts) + 1
+	q := url.Values{
+		"partNumber": {strconv.Itoa(partNumber)},
+		"uploadId":   {u.uploadID},
+	}
+	resp, err := u.s.do(u.ctx, "PUT", u.key, q, nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	u.parts = append(u.parts, s3CompletedPart{
+		PartNumber: partNumber,
+		ETag:       resp.Header.Get("ETag"),
+	})
+	return nil
+}
+
+func (u *s3Upload) abort() {
+	if u.uploadID == "" {
+		return
+	}
+	// the upload's context may be why it failed, and the parts are stored
+	// until the upload is aborted
+	ctx, cancel := context.WithTimeout(context.Background(), s3AbortTimeout)
+	defer cancel()
+	resp, err := u.s.do(ctx, "DELETE", u.key, url.Values{"uploadId": {u.uploadID}}, nil, nil)
+	if err != nil {
+		u.s.ll.Error("unable to abort multipart upload", zap.String("key", u.key), zap.Error(err))
+		return
+	}
+	resp.Body.Close()
+}
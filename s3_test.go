@@ -0,0 +1,491 @@
+package spree_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+	"github.com/ralfonso/spree/spreetest"
+	"golang.org/x/net/context"
+)
+
+const (
+	fakeS3Bucket    = "shots"
+	fakeS3Region    = "eu-west-1"
+	fakeS3AccessKey = "AKIDEXAMPLE"
+	fakeS3SecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+type fakeS3Object struct {
+	data    []byte
+	modTime time.Time
+}
+
+// fakeS3 is an S3 endpoint for one bucket, addressed path style. It checks
+// every SigV4 signature, pages List results and supports the multipart
+// calls S3Storage makes.
+type fakeS3 struct {
+	*httptest.Server
+	pageSize int
+
+	mu        sync.Mutex
+	objects   map[string]*fakeS3Object
+	uploads   map[string]map[int][]byte
+	nextID    int
+	parts     int
+	listPages int
+	aborts    int
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	s := &fakeS3{
+		pageSize: 2,
+		objects:  make(map[string]*fakeS3Object),
+		uploads:  make(map[string]map[int][]byte),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *fakeS3) storage(t *testing.T, secretKey string) *spree.S3Storage {
+	storage, err := spree.NewS3Storage(spree.S3Config{
+		Endpoint:  s.URL,
+		Region:    fakeS3Region,
+		Bucket:    fakeS3Bucket,
+		AccessKey: fakeS3AccessKey,
+		SecretKey: secretKey,
+		PathStyle: true,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+func (s *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	if code := verifySigV4(r, body); code != "" {
+		s.error(w, http.StatusForbidden, code)
+		return
+	}
+
+	prefix := "/" + fakeS3Bucket
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		s.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == "GET" && key == "" && q.Get("list-type") == "2":
+		s.list(w, q)
+	case r.Method == "GET" || r.Method == "HEAD":
+		s.get(w, r, key)
+	case r.Method == "POST" && q["uploads"] != nil:
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == "PUT" && q.Get("uploadId") != "":
+		parts, ok := s.uploads[q.Get("uploadId")]
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		if !ok || n < 1 {
+			s.error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		parts[n] = body
+		s.parts++
+		w.Header().Set("ETag", etag(body))
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		s.complete(w, key, q.Get("uploadId"), body)
+	case r.Method == "DELETE" && q.Get("uploadId") != "":
+		delete(s.uploads, q.Get("uploadId"))
+		s.aborts++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT":
+		s.objects[key] = &fakeS3Object{data: body, modTime: time.Now()}
+		w.Header().Set("ETag", etag(body))
+	case r.Method == "DELETE":
+		// like S3, deleting a missing key succeeds
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *fakeS3) get(w http.ResponseWriter, r *http.Request, key string) {
+	obj, ok := s.objects[key]
+	if !ok {
+		s.error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	w.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+
+	data := obj.data
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		var start, end int64
+		end = int64(len(data)) - 1
+		spec := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+		start, _ = strconv.ParseInt(spec[0], 10, 64)
+		if spec[1] != "" {
+			end, _ = strconv.ParseInt(spec[1], 10, 64)
+		}
+		if start >= int64(len(data)) {
+			s.error(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		if end >= int64(len(data)) {
+			end = int64(len(data)) - 1
+		}
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == "GET" {
+		w.Write(data)
+	}
+}
+
+func (s *fakeS3) list(w http.ResponseWriter, q url.Values) {
+	s.listPages++
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, q.Get("prefix")) && key > q.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	truncated := len(keys) > s.pageSize
+	if truncated {
+		keys = keys[:s.pageSize]
+	}
+	fmt.Fprint(w, "<ListBucketResult>")
+	for _, key := range keys {
+		obj := s.objects[key]
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>",
+			key, len(obj.data), obj.modTime.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "<IsTruncated>%t</IsTruncated>", truncated)
+	if truncated {
+		fmt.Fprintf(w, "<NextContinuationToken>%s</NextContinuationToken>", keys[len(keys)-1])
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func (s *fakeS3) complete(w http.ResponseWriter, key, id string, body []byte) {
+	parts, ok := s.uploads[id]
+	if !ok {
+		s.error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	req := struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}{}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		s.error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	var data []byte
+	for i, part := range req.Parts {
+		b, ok := parts[part.PartNumber]
+		if part.PartNumber != i+1 || !ok || etag(b) != part.ETag {
+			// S3 reports this in a 200
+			fmt.Fprint(w, "<Error><Code>InvalidPart</Code></Error>")
+			return
+		}
+		if i < len(req.Parts)-1 && len(b) < 5<<20 {
+			fmt.Fprint(w, "<Error><Code>EntityTooSmall</Code></Error>")
+			return
+		}
+		data = append(data, b...)
+	}
+	delete(s.uploads, id)
+	s.objects[key] = &fakeS3Object{data: data, modTime: time.Now()}
+	fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+}
+
+func etag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// verifySigV4 checks the header or query string signature of r and returns
+// the S3 error code for a bad one.
+func verifySigV4(r *http.Request, body []byte) string {
+	q := r.URL.Query()
+	presigned := q.Get("X-Amz-Signature") != ""
+
+	var credential, signedHeaders, signature, date, payloadHash string
+	if presigned {
+		credential, signedHeaders, signature = q.Get("X-Amz-Credential"), q.Get("X-Amz-SignedHeaders"), q.Get("X-Amz-Signature")
+		date, payloadHash = q.Get("X-Amz-Date"), "UNSIGNED-PAYLOAD"
+		q.Del("X-Amz-Signature")
+	} else {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+			return "AccessDenied"
+		}
+		for _, field := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return "AuthorizationHeaderMalformed"
+			}
+			switch kv[0] {
+			case "Credential":
+				credential = kv[1]
+			case "SignedHeaders":
+				signedHeaders = kv[1]
+			case "Signature":
+				signature = kv[1]
+			}
+		}
+		date, payloadHash = r.Header.Get("X-Amz-Date"), r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash != hashHex(body) {
+			return "XAmzContentSHA256Mismatch"
+		}
+	}
+
+	at, err := time.Parse("20060102T150405Z", date)
+	if err != nil || time.Since(at) > 15*time.Minute || at.Sub(time.Now()) > 15*time.Minute {
+		return "RequestTimeTooSkewed"
+	}
+	if presigned {
+		expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || time.Since(at) > time.Duration(expires)*time.Second {
+			return "AccessDenied"
+		}
+	}
+	scope := at.Format("20060102") + "/" + fakeS3Region + "/s3/aws4_request"
+	if credential != fakeS3AccessKey+"/"+scope {
+		return "InvalidAccessKeyId"
+	}
+
+	var headers []string
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers = append(headers, name+":"+strings.TrimSpace(value)+"\n")
+	}
+	var params []string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	sort.Strings(params)
+
+	creq := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(params, "&"),
+		strings.Join(headers, ""),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", date, scope, hashHex([]byte(creq))}, "\n")
+
+	key := hmacSum([]byte("AWS4"+fakeS3SecretKey), at.Format("20060102"))
+	for _, part := range []string{fakeS3Region, "s3", "aws4_request"} {
+		key = hmacSum(key, part)
+	}
+	if !hmac.Equal([]byte(hex.EncodeToString(hmacSum(key, toSign))), []byte(signature)) {
+		return "SignatureDoesNotMatch"
+	}
+	return ""
+}
+
+// awsEscape escapes everything but the unreserved characters.
+func awsEscape(s string) string {
+	return strings.Replace(strings.Replace(url.QueryEscape(s), "+", "%20", -1), "%7E", "~", -1)
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSum(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func TestS3Storage(t *testing.T) {
+	spreetest.TestStorage(t, func(t *testing.T) (spree.Storage, func()) {
+		s := newFakeS3(t)
+		return s.storage(t, fakeS3SecretKey), s.Close
+	})
+}
+
+func TestS3StorageList(t *testing.T) {
+	s := newFakeS3(t)
+	defer s.Close()
+	storage := s.storage(t, fakeS3SecretKey)
+
+	for i := 0; i < 5; i++ {
+		if _, err := storage.Put(context.Background(), fmt.Sprintf("shot %d.png", i), bytes.NewReader([]byte("png"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	infos, err := storage.List(context.Background(), "shot ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 5 || infos[0].Key != "shot 0.png" || infos[4].Key != "shot 4.png" {
+		t.Errorf("List returned %d objects: %v", len(infos), infos)
+	}
+	if s.listPages != 3 {
+		t.Errorf("List fetched %d pages, want 3", s.listPages)
+	}
+}
+
+func TestS3StorageMultipart(t *testing.T) {
+	s := newFakeS3(t)
+	defer s.Close()
+	storage := s.storage(t, fakeS3SecretKey)
+	ctx := context.Background()
+
+	data := make([]byte, 17<<20+5)
+	for i := range data {
+		data[i] = byte(i * 31)
+	}
+	if n, err := storage.Put(ctx, "big.mov", bytes.NewReader(data)); err != nil || n != int64(len(data)) {
+		t.Fatalf("Put = %d, %v", n, err)
+	}
+	if s.parts != 3 {
+		t.Errorf("uploaded %d parts, want 3", s.parts)
+	}
+
+	// a range across the first part boundary
+	rc, err := storage.Get(ctx, "big.mov", 8<<20-10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, data[8<<20-10:8<<20+10]) {
+		t.Errorf("ranged Get returned %d bytes that don't match, %v", len(got), err)
+	}
+	if info, err := storage.Stat(ctx, "big.mov"); err != nil || info.Size != int64(len(data)) {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+}
+
+// cancelingReader cancels its context once it has been read past n bytes.
+type cancelingReader struct {
+	r      io.Reader
+	n      int
+	cancel func()
+}
+
+func (c *cancelingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n -= n
+	if c.n < 0 {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestS3StorageMultipartAbort(t *testing.T) {
+	s := newFakeS3(t)
+	defer s.Close()
+	storage := s.storage(t, fakeS3SecretKey)
+
+	// the upload fails once its context is canceled, and is aborted anyway
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &cancelingReader{r: bytes.NewReader(make([]byte, 20<<20)), n: 9 << 20, cancel: cancel}
+	if _, err := storage.Put(ctx, "big.mov", r); err == nil {
+		t.Fatal("Put succeeded after its context was canceled")
+	}
+	if s.aborts != 1 || len(s.uploads) != 0 {
+		t.Errorf("%d aborts left %d uploads, want the one upload aborted", s.aborts, len(s.uploads))
+	}
+	if _, err := storage.Stat(context.Background(), "big.mov"); !os.IsNotExist(err) {
+		t.Errorf("Stat after a failed upload returned %v", err)
+	}
+}
+
+func TestS3StorageSignature(t *testing.T) {
+	s := newFakeS3(t)
+	defer s.Close()
+
+	storage := s.storage(t, "not the secret")
+	_, err := storage.Put(context.Background(), "shot.png", bytes.NewReader([]byte("png")))
+	if s3Err, ok := err.(*spree.S3Error); !ok || s3Err.StatusCode != http.StatusForbidden || s3Err.Code != "SignatureDoesNotMatch" {
+		t.Errorf("Put with the wrong secret returned %v", err)
+	}
+}
+
+func TestS3StoragePresign(t *testing.T) {
+	s := newFakeS3(t)
+	defer s.Close()
+	storage := s.storage(t, fakeS3SecretKey)
+	if _, err := storage.Put(context.Background(), "a shot+1.png", bytes.NewReader([]byte("png"))); err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := storage.PresignGet("a shot+1.png", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(got) != "png" {
+		t.Errorf("presigned GET returned %d %q", resp.StatusCode, got)
+	}
+
+	// the signature covers the key
+	resp, err = http.Get(strings.Replace(link, "a%20shot%2B1.png", "other.png", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("presigned GET of another key returned %d, want 403", resp.StatusCode)
+	}
+}
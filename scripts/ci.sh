@@ -1,4 +1,4 @@
 #!/bin/bash
 set -e
 
-go test ./cmd/... ./auth/...
+go test . ./cmd/... ./auth/... ./spreetest/...
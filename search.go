@@ -0,0 +1,171 @@
+package spree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxQueryLen   = 500
+	maxQueryTerms = 20
+)
+
+// tokenize splits text into lowercase runs of letters and digits, so
+// "2017-03-01_shot.png" is indexed as 2017, 03, 01, shot and png.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// shotTerms returns the distinct terms shot is indexed under, in order.
+// Tags are indexed whole as well as by their words.
+func shotTerms(shot *Shot) []string {
+	seen := make(map[string]bool)
+	for _, text := range []string{shot.Filename, shot.Title, shot.Description} {
+		for _, term := range tokenize(text) {
+			seen[term] = true
+		}
+	}
+	for _, tag := range shot.Tags {
+		seen[tag] = true
+		for _, term := range tokenize(tag) {
+			seen[term] = true
+		}
+	}
+
+	terms := make([]string, 0, len(seen))
+	for term := range seen {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+// Query is a parsed search. Words must all match unless they're separated
+// by OR, which binds loosest: "bug login OR crash" finds shots with both bug
+// and login, or with crash. A word ending in * matches any term it's a
+// prefix of.
+type Query struct {
+	// clauses are alternatives; every term in a clause must match
+	clauses [][]queryTerm
+	text    string
+}
+
+type queryTerm struct {
+	term   string
+	prefix bool
+}
+
+// ParseQuery parses the search q.
+func ParseQuery(q string) (*Query, error) {
+	if len(q) > maxQueryLen {
+		return nil, fmt.Errorf("query is longer than %d characters", maxQueryLen)
+	}
+
+	query := &Query{text: q}
+	var clause []queryTerm
+	var n int
+	for _, word := range strings.Fields(q) {
+		if word == "OR" {
+			if len(clause) == 0 {
+				return nil, fmt.Errorf("OR needs a term on each side")
+			}
+			query.clauses = append(query.clauses, clause)
+			clause = nil
+			continue
+		}
+
+		prefix := strings.HasSuffix(word, "*")
+		terms := tokenize(word)
+		for i, term := range terms {
+			clause = append(clause, queryTerm{term: term, prefix: prefix && i == len(terms)-1})
+		}
+		n += len(terms)
+	}
+	if len(clause) == 0 {
+		if len(query.clauses) > 0 {
+			return nil, fmt.Errorf("OR needs a term on each side")
+		}
+		return nil, fmt.Errorf("query has no words to search for")
+	}
+	if n > maxQueryTerms {
+		return nil, fmt.Errorf("query has more than %d terms", maxQueryTerms)
+	}
+	query.clauses = append(query.clauses, clause)
+	return query, nil
+}
+
+func (q *Query) String() string {
+	return q.text
+}
+
+// termLookup returns the ids of the shots indexed under term, or under any
+// term starting with it when prefix is set, in order and without repeats.
+type termLookup func(term string, prefix bool) ([]string, error)
+
+// eval returns the ids of the shots matching q, in order.
+func (q *Query) eval(lookup termLookup) ([]string, error) {
+	var out []string
+	for _, clause := range q.clauses {
+		var ids []string
+		for i, t := range clause {
+			found, err := lookup(t.term, t.prefix)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				ids = found
+			} else {
+				ids = intersectIds(ids, found)
+			}
+			if len(ids) == 0 {
+				break
+			}
+		}
+		out = unionIds(out, ids)
+	}
+	return out, nil
+}
+
+// intersectIds returns the ids in both of the ordered lists a and b.
+func intersectIds(a, b []string) []string {
+	var out []string
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// unionIds returns the ids in either of the ordered lists a and b.
+func unionIds(a, b []string) []string {
+	out := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			out = append(out, a[i])
+			i++
+		case a[i] > b[j]:
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	out = append(out, a[i:]...)
+	return append(out, b[j:]...)
+}
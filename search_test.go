@@ -0,0 +1,56 @@
+package spree
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("2017-03-01_Login Page.png, café")
+	want := []string{"2017", "03", "01", "login", "page", "png", "café"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize = %q, want %q", got, want)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]queryTerm
+	}{
+		{"cat", [][]queryTerm{{{"cat", false}}}},
+		{"Cat dog*", [][]queryTerm{{{"cat", false}, {"dog", true}}}},
+		{"a OR b c", [][]queryTerm{{{"a", false}}, {{"b", false}, {"c", false}}}},
+		// or is only an operator in capitals
+		{"a or b", [][]queryTerm{{{"a", false}, {"or", false}, {"b", false}}}},
+		{"bug-report*", [][]queryTerm{{{"bug", false}, {"report", true}}}},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(q.clauses, tt.want) {
+			t.Errorf("ParseQuery(%q) = %v, want %v", tt.query, q.clauses, tt.want)
+		}
+	}
+
+	for _, query := range []string{"", "  ", "*", "OR a", "a OR", "a OR OR b", strings.Repeat("a ", maxQueryTerms+1)} {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("ParseQuery(%q) succeeded", query)
+		}
+	}
+}
+
+func TestIdSets(t *testing.T) {
+	a := []string{"a", "c", "d", "f"}
+	b := []string{"b", "c", "f", "g"}
+	if got, want := intersectIds(a, b), []string{"c", "f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("intersectIds = %v, want %v", got, want)
+	}
+	if got, want := unionIds(a, b), []string{"a", "b", "c", "d", "f", "g"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unionIds = %v, want %v", got, want)
+	}
+}
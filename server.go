@@ -1,55 +1,117 @@
 package spree
 
 import (
+	"bufio"
 	"io"
+	"net/http"
+	"os"
 	"path"
+	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/ralfonso/spree/auth"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 )
 
 var (
-	errInternal    = grpc.Errorf(codes.Internal, "operation failed")
-	errUnknownFile = grpc.Errorf(codes.FailedPrecondition, "no file specified")
-	errInvalidArg  = grpc.Errorf(codes.InvalidArgument, "invalid argument")
+	errInternal         = grpc.Errorf(codes.Internal, "operation failed")
+	errUnauthenticated  = grpc.Errorf(codes.Unauthenticated, "valid token required")
+	errPermissionDenied = grpc.Errorf(codes.PermissionDenied, "admin access required")
+	errUnknownFile      = grpc.Errorf(codes.FailedPrecondition, "no file specified")
+	errInvalidArg       = grpc.Errorf(codes.InvalidArgument, "invalid argument")
+	errNoReplication    = grpc.Errorf(codes.FailedPrecondition, "replication is not configured")
+	errShotNotFound     = grpc.Errorf(codes.NotFound, "shot not found")
+	errNotOwner         = grpc.Errorf(codes.PermissionDenied, "shot belongs to someone else")
+	errAlbumNotFound    = grpc.Errorf(codes.NotFound, "album not found")
+	errNotAlbumOwner    = grpc.Errorf(codes.PermissionDenied, "album belongs to someone else")
+	errE2EText          = grpc.Errorf(codes.InvalidArgument, "encrypted shots can't have a title, description or tags")
 )
 
+// metadataError converts an error from Metadata into the status sent to
+// clients, which shouldn't see the details.
+func metadataError(err error) error {
+	switch err {
+	case ErrNotFound:
+		return errShotNotFound
+	case ErrAlbumNotFound:
+		return errAlbumNotFound
+	}
+	return errInternal
+}
+
 type Server struct {
-	ll      *zap.Logger
-	md      Metadata
-	storage Storage
+	ll       *zap.Logger
+	md       Metadata
+	storage  Storage
+	sessions Sessions
+	audit    *AuditLog
+	replicas *ReplicatedStorage
+
+	// storage stats are totalled over every shot, so they're cached
+	statsMu sync.Mutex
+	stats   *StorageStats
+	statsAt time.Time
 }
 
 var _ SpreeServer = &Server{}
 
-func NewServer(md Metadata, storage Storage, ll *zap.Logger) *Server {
+// NewServer creates a Server. replicas may be nil when storage isn't
+// replicated.
+func NewServer(md Metadata, storage Storage, sessions Sessions, audit *AuditLog, replicas *ReplicatedStorage, ll *zap.Logger) *Server {
 	return &Server{
-		ll:      ll,
-		md:      md,
-		storage: storage,
+		ll:       ll,
+		md:       md,
+		storage:  storage,
+		sessions: sessions,
+		audit:    audit,
+		replicas: replicas,
 	}
 }
 
 func (s *Server) Create(stream Spree_CreateServer) error {
+	shot, err := s.create(stream)
+
+	var shotID string
+	var size uint64
+	if shot != nil {
+		shotID, size = shot.Id, shot.SizeBytes
+	}
+	s.audit.Record(stream.Context(), "Create", shotID, size, err)
+
+	return err
+}
+
+func (s *Server) create(stream Spree_CreateServer) (*Shot, error) {
 	ll := s.ll.With(
 		zap.String("method", "Create"),
 	)
 	ll.Info("starting rpc")
 
+	id, ok := auth.FromContext(stream.Context())
+	if !ok {
+		return nil, errUnauthenticated
+	}
+	ll = ll.With(zap.String("owner", id.Email))
+
 	shot, err := s.handleFileUpload(stream, ll)
 
 	if shot == nil {
-		return errInternal
+		if err == nil {
+			err = errInternal
+		}
+		return nil, err
 	}
+	shot.Owner = strings.ToLower(id.Email)
 
 	err = s.md.PutShot(shot)
 	if err != nil {
 		ll.With(zap.Any("shot", shot)).Error("unable to put shot", zap.Error(err))
-		return err
+		return nil, metadataError(err)
 	}
 	resp := &CreateResponse{
 		Shot: shot,
@@ -57,149 +119,447 @@ func (s *Server) Create(stream Spree_CreateServer) error {
 	err = stream.Send(resp)
 	if err != nil {
 		ll.With(zap.Any("shot", shot)).Error("unable to send shot response", zap.Error(err))
-		return err
+		return shot, err
 	}
-	return nil
+	return shot, nil
 }
 
-func (s *Server) cleanupFile(filename string, success bool, ll *zap.Logger) {
-	if !success {
-		ll.Info("cleaning up unsuccessful upload")
-		err := s.storage.Remove(filename)
-		if err != nil {
+func (s *Server) handleFileUpload(stream Spree_CreateServer, ll *zap.Logger) (*Shot, error) {
+	in, err := stream.Recv()
+	if err == io.EOF {
+		return nil, errUnknownFile
+	}
+	if err != nil {
+		return nil, err
+	}
+	if in.Filename == "" {
+		return nil, errUnknownFile
+	}
+	if err := checkShotText(in.Title, in.Description); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	tags, err := normalizeTags(in.Tags)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	// only the bytes of an e2e shot are encrypted, so text would be in the clear
+	if in.E2E && (in.Title != "" || in.Description != "" || len(tags) > 0) {
+		return nil, errE2EText
+	}
+
+	ctx := stream.Context()
+	filename := path.Base(in.Filename)
+	if in.E2E {
+		// the name would be in the clear like any text. The suffix tells
+		// DirectHandler to serve the bytes as opaque data
+		filename = E2EFilename(strings.TrimSuffix(filename, e2eSuffix)) + e2eSuffix
+	}
+	// the id is part of the storage key, so uploads of the same name never
+	// share a file
+	shot := &Shot{}
+	shot.Id = s.md.GetId(shot)
+	storageKey := shot.Id + "-" + filename
+	ll = ll.With(zap.String("filename", filename), zap.String("id", shot.Id))
+	ll.Info("handling file content")
+
+	r := &uploadReader{
+		stream: stream,
+		next:   in,
+		ll:     ll,
+	}
+
+	// text-like uploads are compressed on the way to storage. e2e uploads
+	// are ciphertext, so there's nothing to sniff.
+	src := bufio.NewReaderSize(r, sniffLen)
+	head, _ := src.Peek(sniffLen)
+	key, encoding := storageKey, ""
+	if !in.E2E && len(head) == sniffLen && compressible(http.DetectContentType(head)) {
+		key, encoding = storageKey+compressedSuffix, gzipEncoding
+	}
+	counter := &countingReader{r: src}
+	var body io.Reader = counter
+	var zr io.ReadCloser
+	if encoding == gzipEncoding {
+		zr = gzipReader(counter)
+		body = zr
+	}
+
+	// Put only creates the file once the whole stream has been stored, so a
+	// failed upload leaves nothing behind
+	stored, err := s.storage.Put(ctx, key, body)
+	if zr != nil {
+		// the compressor reads the stream, so it has to stop before r and
+		// counter are looked at
+		zr.Close()
+	}
+	if r.err != nil {
+		// the stream failed or sent bad chunks, report that rather than the
+		// storage's view of it
+		return nil, r.err
+	}
+	if err != nil {
+		ll.Error("unable to store file", zap.Error(err))
+		return nil, errInternal
+	}
+	n := counter.n
+	ll.Info("completed file read",
+		zap.Int64("bytes", n),
+		zap.Int64("stored.bytes", stored),
+		zap.String("encoding", encoding))
+
+	if n == 0 {
+		ll.Info("cleaning up empty upload")
+		if err := s.storage.Delete(ctx, key); err != nil && !os.IsNotExist(err) {
 			ll.Error("unable to remove file", zap.Error(err))
-			return
+			return nil, errInternal
 		}
+		return nil, errUnknownFile
 	}
+
+	shot.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	shot.Filename = filename
+	shot.StorageKey = storageKey
+	shot.SizeBytes = uint64(n)
+	shot.StoredBytes = uint64(stored)
+	shot.ContentEncoding = encoding
+	shot.E2E = in.E2E
+	shot.Title = in.Title
+	shot.Description = in.Description
+	shot.Tags = tags
+	shot.Backend = &BackendDetails{
+		Type: backendType(ctx, s.storage, key),
+	}
+	return shot, nil
 }
 
-func (s *Server) newFile(filename string, ll *zap.Logger) (File, error) {
-	file, err := s.storage.Create(filename)
+func (s *Server) List(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	ll := s.ll.With(zap.String("method", "List"))
+	ll.Info("starting rpc")
+
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, errUnauthenticated
+	}
+	if req.All {
+		if _, err := requireAdmin(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var shots []*Shot
+	var err error
+	tag := strings.ToLower(strings.TrimSpace(req.Tag))
+	switch {
+	case tag != "":
+		shots, err = s.md.ListShotsByTag(tag)
+		if err == nil && !req.All {
+			shots = ownedBy(shots, id.Email)
+		}
+	case req.All:
+		shots, err = s.md.ListShots()
+	default:
+		shots, err = s.md.ListShotsByOwner(id.Email)
+	}
 	if err != nil {
-		s.ll.Error("unable to open file", zap.Error(err))
-		return nil, err
+		ll.Error("error listing shots", zap.Error(err))
+		return nil, metadataError(err)
+	}
+
+	resp := &ListResponse{
+		Shots: shots,
 	}
-	ll.Info("created new file")
-	return file, nil
+
+	return resp, nil
 }
 
-func (s *Server) handleFileUpload(stream Spree_CreateServer, ll *zap.Logger) (*Shot, error) {
-	var file File
-	var filename string
+func (s *Server) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	ll := s.ll.With(zap.String("method", "Search"))
+	ll.Info("starting rpc")
 
-	in, err := stream.Recv()
-	if err == io.EOF {
-		return nil, errUnknownFile
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, errUnauthenticated
+	}
+	if req.All {
+		if _, err := requireAdmin(ctx); err != nil {
+			return nil, err
+		}
 	}
+	q, err := ParseQuery(req.Query)
 	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	shots, err := s.md.SearchShots(q)
+	if err != nil {
+		ll.Error("error searching shots", zap.Error(err))
+		return nil, metadataError(err)
+	}
+	if !req.All {
+		shots = ownedBy(shots, id.Email)
+	}
+	return &SearchResponse{Shots: shots}, nil
+}
+
+func ownedBy(shots []*Shot, owner string) []*Shot {
+	out := make([]*Shot, 0, len(shots))
+	for _, shot := range shots {
+		if strings.EqualFold(shot.Owner, owner) {
+			out = append(out, shot)
+		}
+	}
+	return out
+}
+
+// requireOwner returns the caller's identity if they own shot or are an
+// admin.
+func requireOwner(ctx context.Context, shot *Shot) (*auth.Identity, error) {
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, errUnauthenticated
+	}
+	if !id.Admin && !strings.EqualFold(id.Email, shot.Owner) {
+		return nil, errNotOwner
+	}
+	return id, nil
+}
+
+func (s *Server) GetStats(ctx context.Context, req *GetStatsRequest) (*GetStatsResponse, error) {
+	ll := s.ll.With(zap.String("method", "GetStats"), zap.String("id", req.Id))
+	ll.Info("starting rpc")
+
+	if req.Id == "" {
+		return nil, errInvalidArg
+	}
+	shot, err := s.md.GetShotById(req.Id)
+	if err != nil {
+		ll.Warn("unable to get shot", zap.Error(err))
+		return nil, metadataError(err)
+	}
+	if _, err := requireOwner(ctx, shot); err != nil {
 		return nil, err
 	}
 
-	var success bool
+	stats, err := s.md.GetViewStats(req.Id)
+	if err != nil {
+		ll.Error("unable to get view stats", zap.Error(err))
+		return nil, metadataError(err)
+	}
+
+	return &GetStatsResponse{
+		Shot:          shot,
+		Daily:         stats.Daily,
+		Referrers:     topCounts(stats.Referrers, -1),
+		Agents:        topCounts(stats.Agents, -1),
+		UniqueViewers: hllCount(stats.Viewers),
+	}, nil
+}
+
+func (s *Server) UpdateShot(ctx context.Context, req *UpdateShotRequest) (*UpdateShotResponse, error) {
+	resp, err := s.updateShot(ctx, req)
+	s.audit.Record(ctx, "UpdateShot", req.Id, 0, err)
+	return resp, err
+}
+
+func (s *Server) updateShot(ctx context.Context, req *UpdateShotRequest) (*UpdateShotResponse, error) {
+	ll := s.ll.With(zap.String("method", "UpdateShot"), zap.String("id", req.Id))
+	ll.Info("starting rpc")
 
-	if in.Filename != "" {
-		filename = path.Base(in.Filename)
-		ll = ll.With(zap.String("filename", filename))
+	if req.Id == "" || len(req.UpdateMask) == 0 {
+		return nil, errInvalidArg
+	}
+	update := req.Shot
+	if update == nil {
+		update = &Shot{}
+	}
+
+	var tags []string
+	var setsText bool
+	for _, field := range req.UpdateMask {
 		var err error
-		file, err = s.newFile(filename, ll)
-		if err != nil {
-			ll.Error("could not create new file", zap.Error(err))
-			return nil, errInternal
+		switch field {
+		case "title":
+			err = checkShotText(update.Title, "")
+			setsText = setsText || update.Title != ""
+		case "description":
+			err = checkShotText("", update.Description)
+			setsText = setsText || update.Description != ""
+		case "tags":
+			tags, err = normalizeTags(update.Tags)
+			setsText = setsText || len(tags) > 0
+		default:
+			return nil, grpc.Errorf(codes.InvalidArgument, "cannot update %q", field)
 		}
-		defer func() {
-			file.Close()
-			s.cleanupFile(filename, success, ll)
-		}()
-
 		if err != nil {
-			return nil, errUnknownFile
+			return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
 		}
-	} else {
-		return nil, errUnknownFile
 	}
 
-	ll.Info("handling file content", zap.String("filename", filename))
-
-	var ptr int64
-	for {
-		if in.Length > 0 {
-			ll.With(
-				zap.Int("in.data.len", len(in.Data)),
-				zap.Int64("in.length", in.Length),
-				zap.Int64("in.offset", in.Offset),
-			).Info("reading file")
-			if int64(len(in.Data)) != in.Length {
-				ll.With(
-					zap.Int("in.data.len", len(in.Data)),
-					zap.Int64("in.length", in.Length),
-				).Error("data/length mismatch")
-				success = false
-				return nil, errInvalidArg
-			}
+	shot, err := s.md.GetShotById(req.Id)
+	if err != nil {
+		ll.Warn("unable to get shot", zap.Error(err))
+		return nil, metadataError(err)
+	}
+	if _, err := requireOwner(ctx, shot); err != nil {
+		return nil, err
+	}
+	if shot.E2E && setsText {
+		return nil, errE2EText
+	}
 
-			if in.Offset != ptr {
-				file.Seek(in.Offset, io.SeekStart)
-				ptr = in.Offset
+	shot, err = s.md.UpdateShot(req.Id, func(shot *Shot) {
+		for _, field := range req.UpdateMask {
+			switch field {
+			case "title":
+				shot.Title = update.Title
+			case "description":
+				shot.Description = update.Description
+			case "tags":
+				shot.Tags = tags
 			}
+		}
+	})
+	if err != nil {
+		ll.Error("unable to update shot", zap.Error(err))
+		return nil, metadataError(err)
+	}
+	return &UpdateShotResponse{Shot: shot}, nil
+}
 
-			n, err := file.Write(in.Data)
-			if err != nil {
-				ll.Error("unable to write to file file", zap.Error(err))
-				success = false
-				return nil, errInternal
-			}
+// DeleteShot removes a shot and its file. Search has to drop deleted shots
+// from its index, and until this RPC nothing deleted shots at all, so there
+// was no delete path to keep the index in step with.
+func (s *Server) DeleteShot(ctx context.Context, req *DeleteShotRequest) (*DeleteShotResponse, error) {
+	resp, err := s.deleteShot(ctx, req)
+	s.audit.Record(ctx, "DeleteShot", req.Id, 0, err)
+	return resp, err
+}
 
-			ptr += in.Length
+func (s *Server) deleteShot(ctx context.Context, req *DeleteShotRequest) (*DeleteShotResponse, error) {
+	ll := s.ll.With(zap.String("method", "DeleteShot"), zap.String("id", req.Id))
+	ll.Info("starting rpc")
 
-			resp := &CreateResponse{
-				Offset:       ptr,
-				BytesWritten: int64(n),
-			}
-			err = stream.Send(resp)
-			if err != nil {
-				ll.Error("unable to send response to client", zap.Error(err))
-				success = false
-				return nil, errInternal
-			}
+	if req.Id == "" {
+		return nil, errInvalidArg
+	}
+	shot, err := s.md.GetShotById(req.Id)
+	if err != nil {
+		ll.Warn("unable to get shot", zap.Error(err))
+		return nil, metadataError(err)
+	}
+	if _, err := requireOwner(ctx, shot); err != nil {
+		return nil, err
+	}
+
+	if err := s.md.DeleteShot(req.Id); err != nil {
+		ll.Error("unable to delete shot", zap.Error(err))
+		return nil, metadataError(err)
+	}
+
+	if !legacyStorageKey(shot) {
+		return &DeleteShotResponse{}, s.deleteFile(ctx, shot, ll)
+	}
+
+	// shots from before storage keys included the id were stored by name,
+	// so another one with the same name may be using the file
+	shots, err := s.md.ListShots()
+	if err != nil {
+		ll.Error("unable to list shots", zap.Error(err))
+		return nil, metadataError(err)
+	}
+	for _, other := range shots {
+		if shotKey(other) == shotKey(shot) {
+			ll.Info("keeping file used by another shot", zap.String("other.id", other.Id))
+			return &DeleteShotResponse{}, nil
+		}
+	}
+	return &DeleteShotResponse{}, s.deleteFile(ctx, shot, ll)
+}
+
+func (s *Server) deleteFile(ctx context.Context, shot *Shot, ll *zap.Logger) error {
+	if err := s.storage.Delete(ctx, shotKey(shot)); err != nil && !os.IsNotExist(err) {
+		ll.Error("unable to delete file", zap.String("key", shotKey(shot)), zap.Error(err))
+		return errInternal
+	}
+	return nil
+}
+
+// uploadReader turns the chunks of a Create stream into an io.Reader for
+// Storage.Put. Chunks must arrive in order; each one is acknowledged once it
+// has been received.
+type uploadReader struct {
+	stream Spree_CreateServer
+	next   *CreateRequest
+	buf    []byte
+	ptr    int64
+	err    error
+	ll     *zap.Logger
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	for len(u.buf) == 0 {
+		if u.err != nil {
+			return 0, u.err
+		}
+		if err := u.recv(); err != nil {
+			return 0, err
 		}
+	}
+
+	n := copy(p, u.buf)
+	u.buf = u.buf[n:]
+	return n, nil
+}
 
-		in, err = stream.Recv()
+// recv reads the next chunk into buf. io.EOF is returned at the end of the
+// stream; any other error is also kept in err.
+func (u *uploadReader) recv() error {
+	in := u.next
+	u.next = nil
+	if in == nil {
+		var err error
+		in, err = u.stream.Recv()
 		if err == io.EOF {
-			ll.Info("completed file read")
-			success = ptr > 0
-			break
+			return io.EOF
 		}
 		if err != nil {
-			ll.Info("error reading from stream, aborting transfer")
-			success = false
-			return nil, err
+			u.ll.Info("error reading from stream, aborting transfer")
+			u.err = err
+			return err
 		}
 	}
-
-	shot := &Shot{
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
-		Filename:  filename,
-		SizeBytes: uint64(ptr),
-		Backend: &BackendDetails{
-			Type: "file",
-		},
+	if in.Length <= 0 {
+		return nil
 	}
-	shot.Id = s.md.GetId(shot)
-	return shot, nil
-}
 
-func (s *Server) List(ctx context.Context, req *ListRequest) (*ListResponse, error) {
-	ll := s.ll.With(zap.String("method", "List"))
-	ll.Info("starting rpc")
-	shots, err := s.md.ListShots()
-	if err != nil {
-		ll.Error("error listing shots", zap.Error(err))
-		return nil, errInternal
+	ll := u.ll.With(
+		zap.Int("in.data.len", len(in.Data)),
+		zap.Int64("in.length", in.Length),
+		zap.Int64("in.offset", in.Offset),
+	)
+	ll.Info("reading file")
+	if int64(len(in.Data)) != in.Length {
+		ll.Error("data/length mismatch")
+		u.err = errInvalidArg
+		return u.err
+	}
+	if in.Offset != u.ptr {
+		ll.Error("out of order chunk", zap.Int64("expected.offset", u.ptr))
+		u.err = errInvalidArg
+		return u.err
 	}
+	u.ptr += in.Length
 
-	resp := &ListResponse{
-		Shots: shots,
+	resp := &CreateResponse{
+		Offset:       u.ptr,
+		BytesWritten: in.Length,
+	}
+	if err := u.stream.Send(resp); err != nil {
+		ll.Error("unable to send response to client", zap.Error(err))
+		u.err = errInternal
+		return u.err
 	}
 
-	return resp, nil
+	u.buf = in.Data
+	return nil
 }
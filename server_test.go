@@ -0,0 +1,267 @@
+package spree_test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+	"github.com/ralfonso/spree/auth"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// createStream plays a client uploading data in one chunk to Create.
+type createStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	reqs []*spree.CreateRequest
+	shot *spree.Shot
+}
+
+func newCreateStream(owner, filename string, data []byte) *createStream {
+	ctx := auth.NewContext(context.Background(), &auth.Identity{Email: owner})
+	reqs := []*spree.CreateRequest{{Filename: filename}}
+	if len(data) > 0 {
+		reqs[0].Length, reqs[0].Data = int64(len(data)), data
+	}
+	return &createStream{ctx: ctx, reqs: reqs}
+}
+
+func (s *createStream) Context() context.Context { return s.ctx }
+
+func (s *createStream) Recv() (*spree.CreateRequest, error) {
+	if len(s.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return req, nil
+}
+
+func (s *createStream) Send(resp *spree.CreateResponse) error {
+	if resp.Shot != nil {
+		s.shot = resp.Shot
+	}
+	return nil
+}
+
+func readFile(t *testing.T, storage spree.Storage, key string) string {
+	rc, err := storage.Get(context.Background(), key, 0, -1)
+	if err != nil {
+		t.Fatalf("reading %s: %v", key, err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCreateSameFilename(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+	storage := spree.NewMemoryStorage()
+	audit, err := spree.NewAuditLog(md, "", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := spree.NewServer(md, storage, md, audit, nil, zap.NewNop())
+
+	alice := newCreateStream("alice@example.com", "shot.png", []byte("alice's shot"))
+	if err := s.Create(alice); err != nil {
+		t.Fatal(err)
+	}
+	bob := newCreateStream("bob@example.com", "/home/bob/shot.png", []byte("bob's shot"))
+	if err := s.Create(bob); err != nil {
+		t.Fatal(err)
+	}
+	if alice.shot.StorageKey == bob.shot.StorageKey {
+		t.Fatalf("both shots are stored at %s", alice.shot.StorageKey)
+	}
+	if alice.shot.Filename != "shot.png" || bob.shot.Filename != "shot.png" {
+		t.Errorf("shots are named %s and %s, want shot.png", alice.shot.Filename, bob.shot.Filename)
+	}
+
+	// an empty upload of the same name fails without touching either file
+	if err := s.Create(newCreateStream("mallory@example.com", "shot.png", nil)); err == nil {
+		t.Error("empty upload succeeded")
+	}
+	if got := readFile(t, storage, alice.shot.StorageKey); got != "alice's shot" {
+		t.Errorf("alice's file holds %q", got)
+	}
+	if got := readFile(t, storage, bob.shot.StorageKey); got != "bob's shot" {
+		t.Errorf("bob's file holds %q", got)
+	}
+
+	// deleting one shot leaves the other's file alone
+	ctx := auth.NewContext(context.Background(), &auth.Identity{Email: "alice@example.com"})
+	if _, err := s.DeleteShot(ctx, &spree.DeleteShotRequest{Id: alice.shot.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Stat(context.Background(), alice.shot.StorageKey); err == nil {
+		t.Error("alice's file is still stored")
+	}
+	if got := readFile(t, storage, bob.shot.StorageKey); got != "bob's shot" {
+		t.Errorf("bob's file holds %q after alice's shot was deleted", got)
+	}
+}
+
+// failingStorage fails every Put after reading part of the upload.
+type failingStorage struct {
+	spree.Storage
+}
+
+func (s failingStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if _, err := io.CopyN(ioutil.Discard, r, 10); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("disk full")
+}
+
+func TestCreateStorageFailure(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+	audit, err := spree.NewAuditLog(md, "", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := spree.NewServer(md, failingStorage{spree.NewMemoryStorage()}, md, audit, nil, zap.NewNop())
+
+	// text is compressed as it's stored, so the compressor is still reading
+	// the stream when Put gives up
+	chunk := make([]byte, 32<<10)
+	rand.Read(chunk[:len(chunk)/2])
+	hex.Encode(chunk, chunk[:len(chunk)/2])
+	stream := newCreateStream("alice@example.com", "notes.txt", chunk)
+	for i := 1; i < 20; i++ {
+		stream.reqs = append(stream.reqs, &spree.CreateRequest{
+			Offset: int64(i * len(chunk)),
+			Length: int64(len(chunk)),
+			Data:   chunk,
+		})
+	}
+	if err := s.Create(stream); grpc.Code(err) != codes.Internal {
+		t.Errorf("failed upload got error %v", err)
+	}
+	if stream.shot != nil {
+		t.Errorf("failed upload created %+v", stream.shot)
+	}
+	// the compressor has stopped reading the stream by the time Create returns
+	if len(stream.reqs) == 0 {
+		t.Error("the whole upload was read after storage failed")
+	}
+}
+
+func TestCreateE2EText(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+	audit, err := spree.NewAuditLog(md, "", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := spree.NewServer(md, spree.NewMemoryStorage(), md, audit, nil, zap.NewNop())
+
+	titled := newCreateStream("alice@example.com", "secret.png", []byte("sealed"))
+	titled.reqs[0].E2E, titled.reqs[0].Title = true, "in the clear"
+	if err := s.Create(titled); grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("titled e2e upload got error %v", err)
+	}
+
+	plain := newCreateStream("alice@example.com", "/home/alice/secret plans.PNG", []byte("sealed"))
+	plain.reqs[0].E2E = true
+	if err := s.Create(plain); err != nil {
+		t.Fatal(err)
+	}
+	// the name is text in the clear too, so only the extension is kept
+	if plain.shot.Filename != "shot.png.e2e" || strings.Contains(plain.shot.StorageKey, "secret") {
+		t.Errorf("e2e shot is named %s and stored at %s", plain.shot.Filename, plain.shot.StorageKey)
+	}
+	ctx := plain.Context()
+	_, err = s.UpdateShot(ctx, &spree.UpdateShotRequest{
+		Id:         plain.shot.Id,
+		Shot:       &spree.Shot{Tags: []string{"holiday"}},
+		UpdateMask: []string{"tags"},
+	})
+	if grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("tagging an e2e shot got error %v", err)
+	}
+	_, err = s.UpdateShot(ctx, &spree.UpdateShotRequest{Id: plain.shot.Id, UpdateMask: []string{"title", "tags"}})
+	if err != nil {
+		t.Errorf("clearing an e2e shot's text got error %v", err)
+	}
+}
+
+func TestE2EFilename(t *testing.T) {
+	for _, tt := range []struct{ name, want string }{
+		{"holiday.png", "shot.png"},
+		{"/home/alice/Secret Plans.JPEG", "shot.jpeg"},
+		{"archive.tar.gz", "shot.gz"},
+		{"no extension", "shot"},
+		{"trailing.", "shot"},
+		{"odd.p n g", "shot"},
+		{"long.extension123", "shot"},
+	} {
+		if got := spree.E2EFilename(tt.name); got != tt.want {
+			t.Errorf("E2EFilename(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetStorageStats(t *testing.T) {
+	md, cleanup := withBoltKV(t)
+	defer cleanup()
+	audit, err := spree.NewAuditLog(md, "", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := spree.NewServer(md, spree.NewMemoryStorage(), md, audit, nil, zap.NewNop())
+
+	text := []byte(strings.Repeat("compressible text ", 100))
+	for _, stream := range []*createStream{
+		newCreateStream("alice@example.com", "notes.txt", text),
+		newCreateStream("bob@example.com", "shot.png", []byte("png")),
+	} {
+		if err := s.Create(stream); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	user := auth.NewContext(context.Background(), &auth.Identity{Email: "alice@example.com"})
+	if _, err := s.GetStorageStats(user, &spree.GetStorageStatsRequest{}); grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("non-admin got error %v", err)
+	}
+
+	admin := auth.NewContext(context.Background(), &auth.Identity{Email: "admin@example.com", Admin: true})
+	resp, err := s.GetStorageStats(admin, &spree.GetStorageStatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := resp.Stats
+	if stats.Shots != 2 || stats.CompressedShots != 1 || stats.SizeBytes != uint64(len(text)+3) {
+		t.Errorf("stats are %+v", stats)
+	}
+	if stats.SavedBytes <= 0 || stats.SavedBytes != int64(stats.SizeBytes)-int64(stats.StoredBytes) {
+		t.Errorf("saved %d of %d bytes, storing %d", stats.SavedBytes, stats.SizeBytes, stats.StoredBytes)
+	}
+
+	// the totals are cached rather than recounted on every call
+	if err := s.Create(newCreateStream("bob@example.com", "more.png", []byte("png"))); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = s.GetStorageStats(admin, &spree.GetStorageStatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Stats.Shots != 2 {
+		t.Errorf("cached stats count %d shots, want 2", resp.Stats.Shots)
+	}
+}
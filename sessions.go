@@ -0,0 +1,10 @@
+package spree
+
+// Sessions manages the deny list consulted by the auth interceptors and
+// reports which clients have been seen.
+type Sessions interface {
+	SetIdentityRevoked(email string, revoked bool) error
+	SetTokenRevoked(tokenID string, revoked bool) error
+	ListClients() ([]*Client, error)
+	ListRevokedTokens() ([]string, error)
+}
@@ -15,6 +15,35 @@ It has these top-level messages:
 	BackendDetails
 	ListRequest
 	ListResponse
+	RevokeIdentityRequest
+	RevokeIdentityResponse
+	Client
+	ListActiveClientsRequest
+	ListActiveClientsResponse
+	AuditRecord
+	QueryAuditRequest
+	QueryAuditResponse
+	ReplicaHealthRequest
+	ReplicaStatus
+	ReplicaHealthResponse
+	GetStorageStatsRequest
+	GetStorageStatsResponse
+	StorageStats
+	ViewStats
+	ViewCount
+	GetStatsRequest
+	GetStatsResponse
+	UpdateShotRequest
+	UpdateShotResponse
+	SearchRequest
+	SearchResponse
+	DeleteShotRequest
+	DeleteShotResponse
+	Album
+	CreateAlbumRequest
+	GetAlbumRequest
+	AlbumShotsRequest
+	AlbumResponse
 */
 package spree
 
@@ -43,6 +72,12 @@ type CreateRequest struct {
 	Offset   int64  `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
 	Length   int64  `protobuf:"varint,3,opt,name=length" json:"length,omitempty"`
 	Data     []byte `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	// data is encrypted by the client and unreadable by the server
+	E2E bool `protobuf:"varint,5,opt,name=e2e" json:"e2e,omitempty"`
+	// only read from the first message
+	Title       string   `protobuf:"bytes,6,opt,name=title" json:"title,omitempty"`
+	Description string   `protobuf:"bytes,7,opt,name=description" json:"description,omitempty"`
+	Tags        []string `protobuf:"bytes,8,rep,name=tags" json:"tags,omitempty"`
 }
 
 func (m *CreateRequest) Reset()                    { *m = CreateRequest{} }
@@ -69,13 +104,35 @@ func (m *CreateResponse) GetShot() *Shot {
 }
 
 type Shot struct {
-	Id        string          `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
-	CreatedAt string          `protobuf:"bytes,2,opt,name=created_at,json=createdAt" json:"created_at,omitempty"`
-	Filename  string          `protobuf:"bytes,3,opt,name=filename" json:"filename,omitempty"`
-	Views     uint64          `protobuf:"varint,4,opt,name=views" json:"views,omitempty"`
-	Path      string          `protobuf:"bytes,5,opt,name=path" json:"path,omitempty"`
-	SizeBytes uint64          `protobuf:"varint,7,opt,name=size_bytes,json=sizeBytes" json:"size_bytes,omitempty"`
-	Backend   *BackendDetails `protobuf:"bytes,6,opt,name=backend" json:"backend,omitempty"`
+	Id        string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	CreatedAt string `protobuf:"bytes,2,opt,name=created_at,json=createdAt" json:"created_at,omitempty"`
+	Filename  string `protobuf:"bytes,3,opt,name=filename" json:"filename,omitempty"`
+	Views     uint64 `protobuf:"varint,4,opt,name=views" json:"views,omitempty"`
+	Path      string `protobuf:"bytes,5,opt,name=path" json:"path,omitempty"`
+	SizeBytes uint64 `protobuf:"varint,7,opt,name=size_bytes,json=sizeBytes" json:"size_bytes,omitempty"`
+	// email of the uploader
+	Owner string `protobuf:"bytes,8,opt,name=owner" json:"owner,omitempty"`
+	// the content is end-to-end encrypted; the key is only in the share link
+	E2E bool `protobuf:"varint,9,opt,name=e2e" json:"e2e,omitempty"`
+	// RFC3339 time of the most recent view
+	LastViewedAt string `protobuf:"bytes,10,opt,name=last_viewed_at,json=lastViewedAt" json:"last_viewed_at,omitempty"`
+	// "gzip" when the file was compressed before it was stored
+	ContentEncoding string `protobuf:"bytes,11,opt,name=content_encoding,json=contentEncoding" json:"content_encoding,omitempty"`
+	// bytes used in storage, after compression
+	StoredBytes uint64 `protobuf:"varint,12,opt,name=stored_bytes,json=storedBytes" json:"stored_bytes,omitempty"`
+	// hits from link preview fetchers and other bots, which aren't in views
+	CrawlerViews uint64 `protobuf:"varint,13,opt,name=crawler_views,json=crawlerViews" json:"crawler_views,omitempty"`
+	Title        string `protobuf:"bytes,14,opt,name=title" json:"title,omitempty"`
+	Description  string `protobuf:"bytes,15,opt,name=description" json:"description,omitempty"`
+	// lowercase and ordered
+	Tags []string `protobuf:"bytes,16,rep,name=tags" json:"tags,omitempty"`
+	// where the file is stored, and served from under /r/. Compressed files
+	// are stored with a suffix added.
+	StorageKey string `protobuf:"bytes,17,opt,name=storage_key,json=storageKey" json:"storage_key,omitempty"`
+	// RFC3339 time the file was last fetched from /r/, which embeds do
+	// without visiting the page
+	LastReadAt string          `protobuf:"bytes,18,opt,name=last_read_at,json=lastReadAt" json:"last_read_at,omitempty"`
+	Backend    *BackendDetails `protobuf:"bytes,6,opt,name=backend" json:"backend,omitempty"`
 }
 
 func (m *Shot) Reset()                    { *m = Shot{} }
@@ -91,6 +148,7 @@ func (m *Shot) GetBackend() *BackendDetails {
 }
 
 type BackendDetails struct {
+	// where the file is stored, e.g. "file", or "hot:file" with tiering
 	Type string `protobuf:"bytes,1,opt,name=type" json:"type,omitempty"`
 }
 
@@ -100,6 +158,10 @@ func (*BackendDetails) ProtoMessage()               {}
 func (*BackendDetails) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
 
 type ListRequest struct {
+	// list every user's shots instead of the caller's (admin only)
+	All bool `protobuf:"varint,1,opt,name=all" json:"all,omitempty"`
+	// only list shots with this tag
+	Tag string `protobuf:"bytes,2,opt,name=tag" json:"tag,omitempty"`
 }
 
 func (m *ListRequest) Reset()                    { *m = ListRequest{} }
@@ -123,6 +185,461 @@ func (m *ListResponse) GetShots() []*Shot {
 	return nil
 }
 
+type RevokeIdentityRequest struct {
+	// revoke every token for this email
+	Email string `protobuf:"bytes,1,opt,name=email" json:"email,omitempty"`
+	// or a single token
+	TokenId string `protobuf:"bytes,2,opt,name=token_id,json=tokenId" json:"token_id,omitempty"`
+	// lift an earlier revocation instead
+	Restore bool `protobuf:"varint,3,opt,name=restore" json:"restore,omitempty"`
+}
+
+func (m *RevokeIdentityRequest) Reset()                    { *m = RevokeIdentityRequest{} }
+func (m *RevokeIdentityRequest) String() string            { return proto.CompactTextString(m) }
+func (*RevokeIdentityRequest) ProtoMessage()               {}
+func (*RevokeIdentityRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+type RevokeIdentityResponse struct {
+}
+
+func (m *RevokeIdentityResponse) Reset()                    { *m = RevokeIdentityResponse{} }
+func (m *RevokeIdentityResponse) String() string            { return proto.CompactTextString(m) }
+func (*RevokeIdentityResponse) ProtoMessage()               {}
+func (*RevokeIdentityResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+type Client struct {
+	Email    string `protobuf:"bytes,1,opt,name=email" json:"email,omitempty"`
+	LastSeen string `protobuf:"bytes,2,opt,name=last_seen,json=lastSeen" json:"last_seen,omitempty"`
+	Addr     string `protobuf:"bytes,3,opt,name=addr" json:"addr,omitempty"`
+	Revoked  bool   `protobuf:"varint,4,opt,name=revoked" json:"revoked,omitempty"`
+	TokenId  string `protobuf:"bytes,5,opt,name=token_id,json=tokenId" json:"token_id,omitempty"`
+}
+
+func (m *Client) Reset()                    { *m = Client{} }
+func (m *Client) String() string            { return proto.CompactTextString(m) }
+func (*Client) ProtoMessage()               {}
+func (*Client) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+type ListActiveClientsRequest struct {
+}
+
+func (m *ListActiveClientsRequest) Reset()                    { *m = ListActiveClientsRequest{} }
+func (m *ListActiveClientsRequest) String() string            { return proto.CompactTextString(m) }
+func (*ListActiveClientsRequest) ProtoMessage()               {}
+func (*ListActiveClientsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+type ListActiveClientsResponse struct {
+	Clients         []*Client `protobuf:"bytes,1,rep,name=clients" json:"clients,omitempty"`
+	RevokedTokenIds []string  `protobuf:"bytes,2,rep,name=revoked_token_ids,json=revokedTokenIds" json:"revoked_token_ids,omitempty"`
+}
+
+func (m *ListActiveClientsResponse) Reset()                    { *m = ListActiveClientsResponse{} }
+func (m *ListActiveClientsResponse) String() string            { return proto.CompactTextString(m) }
+func (*ListActiveClientsResponse) ProtoMessage()               {}
+func (*ListActiveClientsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *ListActiveClientsResponse) GetClients() []*Client {
+	if m != nil {
+		return m.Clients
+	}
+	return nil
+}
+
+type AuditRecord struct {
+	Time      string `protobuf:"bytes,1,opt,name=time" json:"time,omitempty"`
+	Identity  string `protobuf:"bytes,2,opt,name=identity" json:"identity,omitempty"`
+	PeerAddr  string `protobuf:"bytes,3,opt,name=peer_addr,json=peerAddr" json:"peer_addr,omitempty"`
+	Method    string `protobuf:"bytes,4,opt,name=method" json:"method,omitempty"`
+	ShotId    string `protobuf:"bytes,5,opt,name=shot_id,json=shotId" json:"shot_id,omitempty"`
+	SizeBytes uint64 `protobuf:"varint,6,opt,name=size_bytes,json=sizeBytes" json:"size_bytes,omitempty"`
+	// "ok" or the error returned to the caller
+	Result string `protobuf:"bytes,7,opt,name=result" json:"result,omitempty"`
+	// the storage key of a file served over HTTP, where the shot isn't looked up
+	Key string `protobuf:"bytes,8,opt,name=key" json:"key,omitempty"`
+	// what an admin action applied to, such as the identity or token revoked
+	Target string `protobuf:"bytes,9,opt,name=target" json:"target,omitempty"`
+}
+
+func (m *AuditRecord) Reset()                    { *m = AuditRecord{} }
+func (m *AuditRecord) String() string            { return proto.CompactTextString(m) }
+func (*AuditRecord) ProtoMessage()               {}
+func (*AuditRecord) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+type QueryAuditRequest struct {
+	// RFC3339 bounds, both optional
+	Since    string `protobuf:"bytes,1,opt,name=since" json:"since,omitempty"`
+	Until    string `protobuf:"bytes,2,opt,name=until" json:"until,omitempty"`
+	Identity string `protobuf:"bytes,3,opt,name=identity" json:"identity,omitempty"`
+	// the most recent records are returned when there are more than limit
+	Limit int32 `protobuf:"varint,4,opt,name=limit" json:"limit,omitempty"`
+}
+
+func (m *QueryAuditRequest) Reset()                    { *m = QueryAuditRequest{} }
+func (m *QueryAuditRequest) String() string            { return proto.CompactTextString(m) }
+func (*QueryAuditRequest) ProtoMessage()               {}
+func (*QueryAuditRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+type QueryAuditResponse struct {
+	Records []*AuditRecord `protobuf:"bytes,1,rep,name=records" json:"records,omitempty"`
+}
+
+func (m *QueryAuditResponse) Reset()                    { *m = QueryAuditResponse{} }
+func (m *QueryAuditResponse) String() string            { return proto.CompactTextString(m) }
+func (*QueryAuditResponse) ProtoMessage()               {}
+func (*QueryAuditResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *QueryAuditResponse) GetRecords() []*AuditRecord {
+	if m != nil {
+		return m.Records
+	}
+	return nil
+}
+
+type ReplicaHealthRequest struct {
+}
+
+func (m *ReplicaHealthRequest) Reset()                    { *m = ReplicaHealthRequest{} }
+func (m *ReplicaHealthRequest) String() string            { return proto.CompactTextString(m) }
+func (*ReplicaHealthRequest) ProtoMessage()               {}
+func (*ReplicaHealthRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+type ReplicaStatus struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// false from the replica's last error until its next successful operation
+	Healthy     bool   `protobuf:"varint,2,opt,name=healthy" json:"healthy,omitempty"`
+	LastError   string `protobuf:"bytes,3,opt,name=last_error,json=lastError" json:"last_error,omitempty"`
+	LastErrorAt string `protobuf:"bytes,4,opt,name=last_error_at,json=lastErrorAt" json:"last_error_at,omitempty"`
+	// RFC3339 time of the last anti-entropy scan
+	LastScanAt string `protobuf:"bytes,5,opt,name=last_scan_at,json=lastScanAt" json:"last_scan_at,omitempty"`
+	// counts as of the last scan
+	Blobs   int64 `protobuf:"varint,6,opt,name=blobs" json:"blobs,omitempty"`
+	Missing int64 `protobuf:"varint,7,opt,name=missing" json:"missing,omitempty"`
+	// blobs copied to this replica since the server started
+	Repaired int64 `protobuf:"varint,8,opt,name=repaired" json:"repaired,omitempty"`
+}
+
+func (m *ReplicaStatus) Reset()                    { *m = ReplicaStatus{} }
+func (m *ReplicaStatus) String() string            { return proto.CompactTextString(m) }
+func (*ReplicaStatus) ProtoMessage()               {}
+func (*ReplicaStatus) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+type ReplicaHealthResponse struct {
+	Replicas []*ReplicaStatus `protobuf:"bytes,1,rep,name=replicas" json:"replicas,omitempty"`
+}
+
+func (m *ReplicaHealthResponse) Reset()                    { *m = ReplicaHealthResponse{} }
+func (m *ReplicaHealthResponse) String() string            { return proto.CompactTextString(m) }
+func (*ReplicaHealthResponse) ProtoMessage()               {}
+func (*ReplicaHealthResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+func (m *ReplicaHealthResponse) GetReplicas() []*ReplicaStatus {
+	if m != nil {
+		return m.Replicas
+	}
+	return nil
+}
+
+type GetStorageStatsRequest struct {
+}
+
+func (m *GetStorageStatsRequest) Reset()                    { *m = GetStorageStatsRequest{} }
+func (m *GetStorageStatsRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetStorageStatsRequest) ProtoMessage()               {}
+func (*GetStorageStatsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+
+type GetStorageStatsResponse struct {
+	Stats *StorageStats `protobuf:"bytes,1,opt,name=stats" json:"stats,omitempty"`
+}
+
+func (m *GetStorageStatsResponse) Reset()                    { *m = GetStorageStatsResponse{} }
+func (m *GetStorageStatsResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetStorageStatsResponse) ProtoMessage()               {}
+func (*GetStorageStatsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+func (m *GetStorageStatsResponse) GetStats() *StorageStats {
+	if m != nil {
+		return m.Stats
+	}
+	return nil
+}
+
+// StorageStats is how much space compression has saved
+type StorageStats struct {
+	Shots           uint64 `protobuf:"varint,1,opt,name=shots" json:"shots,omitempty"`
+	CompressedShots uint64 `protobuf:"varint,2,opt,name=compressed_shots,json=compressedShots" json:"compressed_shots,omitempty"`
+	// uploaded size of every shot
+	SizeBytes   uint64 `protobuf:"varint,3,opt,name=size_bytes,json=sizeBytes" json:"size_bytes,omitempty"`
+	StoredBytes uint64 `protobuf:"varint,4,opt,name=stored_bytes,json=storedBytes" json:"stored_bytes,omitempty"`
+	// size_bytes - stored_bytes
+	SavedBytes int64 `protobuf:"varint,5,opt,name=saved_bytes,json=savedBytes" json:"saved_bytes,omitempty"`
+}
+
+func (m *StorageStats) Reset()                    { *m = StorageStats{} }
+func (m *StorageStats) String() string            { return proto.CompactTextString(m) }
+func (*StorageStats) ProtoMessage()               {}
+func (*StorageStats) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+// ViewStats is the view history of a shot
+type ViewStats struct {
+	Views uint64 `protobuf:"varint,1,opt,name=views" json:"views,omitempty"`
+	// hits from crawlers, which are only counted here and in agents
+	CrawlerViews uint64 `protobuf:"varint,6,opt,name=crawler_views,json=crawlerViews" json:"crawler_views,omitempty"`
+	// views per UTC day, keyed by YYYY-MM-DD and ordered by day
+	Daily []*ViewCount `protobuf:"bytes,2,rep,name=daily" json:"daily,omitempty"`
+	// views per referring host, keyed by host and ordered by key. Views without
+	// a referrer have an empty key
+	Referrers []*ViewCount `protobuf:"bytes,3,rep,name=referrers" json:"referrers,omitempty"`
+	// views and crawler hits per user agent class, e.g. "desktop" or
+	// "link preview"
+	Agents []*ViewCount `protobuf:"bytes,4,rep,name=agents" json:"agents,omitempty"`
+	// HyperLogLog registers over hashed viewer addresses
+	Viewers []byte `protobuf:"bytes,5,opt,name=viewers,proto3" json:"viewers,omitempty"`
+	// fetches of the file from /r/, by anyone
+	Reads uint64 `protobuf:"varint,7,opt,name=reads" json:"reads,omitempty"`
+}
+
+func (m *ViewStats) Reset()                    { *m = ViewStats{} }
+func (m *ViewStats) String() string            { return proto.CompactTextString(m) }
+func (*ViewStats) ProtoMessage()               {}
+func (*ViewStats) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+func (m *ViewStats) GetDaily() []*ViewCount {
+	if m != nil {
+		return m.Daily
+	}
+	return nil
+}
+
+func (m *ViewStats) GetReferrers() []*ViewCount {
+	if m != nil {
+		return m.Referrers
+	}
+	return nil
+}
+
+func (m *ViewStats) GetAgents() []*ViewCount {
+	if m != nil {
+		return m.Agents
+	}
+	return nil
+}
+
+type ViewCount struct {
+	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Views uint64 `protobuf:"varint,2,opt,name=views" json:"views,omitempty"`
+}
+
+func (m *ViewCount) Reset()                    { *m = ViewCount{} }
+func (m *ViewCount) String() string            { return proto.CompactTextString(m) }
+func (*ViewCount) ProtoMessage()               {}
+func (*ViewCount) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
+
+type GetStatsRequest struct {
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *GetStatsRequest) Reset()                    { *m = GetStatsRequest{} }
+func (m *GetStatsRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetStatsRequest) ProtoMessage()               {}
+func (*GetStatsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
+
+type GetStatsResponse struct {
+	Shot *Shot `protobuf:"bytes,1,opt,name=shot" json:"shot,omitempty"`
+	// ordered by day
+	Daily []*ViewCount `protobuf:"bytes,2,rep,name=daily" json:"daily,omitempty"`
+	// most views first
+	Referrers []*ViewCount `protobuf:"bytes,3,rep,name=referrers" json:"referrers,omitempty"`
+	Agents    []*ViewCount `protobuf:"bytes,4,rep,name=agents" json:"agents,omitempty"`
+	// approximate number of distinct viewer addresses
+	UniqueViewers uint64 `protobuf:"varint,5,opt,name=unique_viewers,json=uniqueViewers" json:"unique_viewers,omitempty"`
+}
+
+func (m *GetStatsResponse) Reset()                    { *m = GetStatsResponse{} }
+func (m *GetStatsResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetStatsResponse) ProtoMessage()               {}
+func (*GetStatsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
+
+func (m *GetStatsResponse) GetShot() *Shot {
+	if m != nil {
+		return m.Shot
+	}
+	return nil
+}
+
+func (m *GetStatsResponse) GetDaily() []*ViewCount {
+	if m != nil {
+		return m.Daily
+	}
+	return nil
+}
+
+func (m *GetStatsResponse) GetReferrers() []*ViewCount {
+	if m != nil {
+		return m.Referrers
+	}
+	return nil
+}
+
+func (m *GetStatsResponse) GetAgents() []*ViewCount {
+	if m != nil {
+		return m.Agents
+	}
+	return nil
+}
+
+type UpdateShotRequest struct {
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	// the new values of the fields named in update_mask
+	Shot *Shot `protobuf:"bytes,2,opt,name=shot" json:"shot,omitempty"`
+	// the fields to update: "title", "description" or "tags"
+	UpdateMask []string `protobuf:"bytes,3,rep,name=update_mask,json=updateMask" json:"update_mask,omitempty"`
+}
+
+func (m *UpdateShotRequest) Reset()                    { *m = UpdateShotRequest{} }
+func (m *UpdateShotRequest) String() string            { return proto.CompactTextString(m) }
+func (*UpdateShotRequest) ProtoMessage()               {}
+func (*UpdateShotRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
+
+func (m *UpdateShotRequest) GetShot() *Shot {
+	if m != nil {
+		return m.Shot
+	}
+	return nil
+}
+
+type UpdateShotResponse struct {
+	Shot *Shot `protobuf:"bytes,1,opt,name=shot" json:"shot,omitempty"`
+}
+
+func (m *UpdateShotResponse) Reset()                    { *m = UpdateShotResponse{} }
+func (m *UpdateShotResponse) String() string            { return proto.CompactTextString(m) }
+func (*UpdateShotResponse) ProtoMessage()               {}
+func (*UpdateShotResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{25} }
+
+func (m *UpdateShotResponse) GetShot() *Shot {
+	if m != nil {
+		return m.Shot
+	}
+	return nil
+}
+
+type SearchRequest struct {
+	// words to match in filenames, titles, descriptions and tags. Every word
+	// must match unless words are separated by OR, and a word ending in *
+	// matches as a prefix
+	Query string `protobuf:"bytes,1,opt,name=query" json:"query,omitempty"`
+	// search every user's shots instead of the caller's (admin only)
+	All bool `protobuf:"varint,2,opt,name=all" json:"all,omitempty"`
+}
+
+func (m *SearchRequest) Reset()                    { *m = SearchRequest{} }
+func (m *SearchRequest) String() string            { return proto.CompactTextString(m) }
+func (*SearchRequest) ProtoMessage()               {}
+func (*SearchRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{26} }
+
+type SearchResponse struct {
+	Shots []*Shot `protobuf:"bytes,1,rep,name=shots" json:"shots,omitempty"`
+}
+
+func (m *SearchResponse) Reset()                    { *m = SearchResponse{} }
+func (m *SearchResponse) String() string            { return proto.CompactTextString(m) }
+func (*SearchResponse) ProtoMessage()               {}
+func (*SearchResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{27} }
+
+func (m *SearchResponse) GetShots() []*Shot {
+	if m != nil {
+		return m.Shots
+	}
+	return nil
+}
+
+type DeleteShotRequest struct {
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *DeleteShotRequest) Reset()                    { *m = DeleteShotRequest{} }
+func (m *DeleteShotRequest) String() string            { return proto.CompactTextString(m) }
+func (*DeleteShotRequest) ProtoMessage()               {}
+func (*DeleteShotRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{28} }
+
+type DeleteShotResponse struct {
+}
+
+func (m *DeleteShotResponse) Reset()                    { *m = DeleteShotResponse{} }
+func (m *DeleteShotResponse) String() string            { return proto.CompactTextString(m) }
+func (*DeleteShotResponse) ProtoMessage()               {}
+func (*DeleteShotResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{29} }
+
+// Album is an ordered collection of shots, shown together at /a/{id}
+type Album struct {
+	Id        string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	CreatedAt string `protobuf:"bytes,2,opt,name=created_at,json=createdAt" json:"created_at,omitempty"`
+	// email of the creator
+	Owner   string   `protobuf:"bytes,3,opt,name=owner" json:"owner,omitempty"`
+	Title   string   `protobuf:"bytes,4,opt,name=title" json:"title,omitempty"`
+	ShotIds []string `protobuf:"bytes,5,rep,name=shot_ids,json=shotIds" json:"shot_ids,omitempty"`
+	Path    string   `protobuf:"bytes,6,opt,name=path" json:"path,omitempty"`
+}
+
+func (m *Album) Reset()                    { *m = Album{} }
+func (m *Album) String() string            { return proto.CompactTextString(m) }
+func (*Album) ProtoMessage()               {}
+func (*Album) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{30} }
+
+type CreateAlbumRequest struct {
+	Title   string   `protobuf:"bytes,1,opt,name=title" json:"title,omitempty"`
+	ShotIds []string `protobuf:"bytes,2,rep,name=shot_ids,json=shotIds" json:"shot_ids,omitempty"`
+}
+
+func (m *CreateAlbumRequest) Reset()                    { *m = CreateAlbumRequest{} }
+func (m *CreateAlbumRequest) String() string            { return proto.CompactTextString(m) }
+func (*CreateAlbumRequest) ProtoMessage()               {}
+func (*CreateAlbumRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{31} }
+
+type GetAlbumRequest struct {
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *GetAlbumRequest) Reset()                    { *m = GetAlbumRequest{} }
+func (m *GetAlbumRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetAlbumRequest) ProtoMessage()               {}
+func (*GetAlbumRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{32} }
+
+type AlbumShotsRequest struct {
+	AlbumId string   `protobuf:"bytes,1,opt,name=album_id,json=albumId" json:"album_id,omitempty"`
+	ShotIds []string `protobuf:"bytes,2,rep,name=shot_ids,json=shotIds" json:"shot_ids,omitempty"`
+}
+
+func (m *AlbumShotsRequest) Reset()                    { *m = AlbumShotsRequest{} }
+func (m *AlbumShotsRequest) String() string            { return proto.CompactTextString(m) }
+func (*AlbumShotsRequest) ProtoMessage()               {}
+func (*AlbumShotsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{33} }
+
+type AlbumResponse struct {
+	Album *Album `protobuf:"bytes,1,opt,name=album" json:"album,omitempty"`
+	// the album's shots, in order. Deleted shots are left out
+	Shots []*Shot `protobuf:"bytes,2,rep,name=shots" json:"shots,omitempty"`
+}
+
+func (m *AlbumResponse) Reset()                    { *m = AlbumResponse{} }
+func (m *AlbumResponse) String() string            { return proto.CompactTextString(m) }
+func (*AlbumResponse) ProtoMessage()               {}
+func (*AlbumResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{34} }
+
+func (m *AlbumResponse) GetAlbum() *Album {
+	if m != nil {
+		return m.Album
+	}
+	return nil
+}
+
+func (m *AlbumResponse) GetShots() []*Shot {
+	if m != nil {
+		return m.Shots
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*CreateRequest)(nil), "CreateRequest")
 	proto.RegisterType((*CreateResponse)(nil), "CreateResponse")
@@ -130,6 +647,35 @@ func init() {
 	proto.RegisterType((*BackendDetails)(nil), "BackendDetails")
 	proto.RegisterType((*ListRequest)(nil), "ListRequest")
 	proto.RegisterType((*ListResponse)(nil), "ListResponse")
+	proto.RegisterType((*RevokeIdentityRequest)(nil), "RevokeIdentityRequest")
+	proto.RegisterType((*RevokeIdentityResponse)(nil), "RevokeIdentityResponse")
+	proto.RegisterType((*Client)(nil), "Client")
+	proto.RegisterType((*ListActiveClientsRequest)(nil), "ListActiveClientsRequest")
+	proto.RegisterType((*ListActiveClientsResponse)(nil), "ListActiveClientsResponse")
+	proto.RegisterType((*AuditRecord)(nil), "AuditRecord")
+	proto.RegisterType((*QueryAuditRequest)(nil), "QueryAuditRequest")
+	proto.RegisterType((*QueryAuditResponse)(nil), "QueryAuditResponse")
+	proto.RegisterType((*ReplicaHealthRequest)(nil), "ReplicaHealthRequest")
+	proto.RegisterType((*ReplicaStatus)(nil), "ReplicaStatus")
+	proto.RegisterType((*ReplicaHealthResponse)(nil), "ReplicaHealthResponse")
+	proto.RegisterType((*GetStorageStatsRequest)(nil), "GetStorageStatsRequest")
+	proto.RegisterType((*GetStorageStatsResponse)(nil), "GetStorageStatsResponse")
+	proto.RegisterType((*StorageStats)(nil), "StorageStats")
+	proto.RegisterType((*ViewStats)(nil), "ViewStats")
+	proto.RegisterType((*ViewCount)(nil), "ViewCount")
+	proto.RegisterType((*GetStatsRequest)(nil), "GetStatsRequest")
+	proto.RegisterType((*GetStatsResponse)(nil), "GetStatsResponse")
+	proto.RegisterType((*UpdateShotRequest)(nil), "UpdateShotRequest")
+	proto.RegisterType((*UpdateShotResponse)(nil), "UpdateShotResponse")
+	proto.RegisterType((*SearchRequest)(nil), "SearchRequest")
+	proto.RegisterType((*SearchResponse)(nil), "SearchResponse")
+	proto.RegisterType((*DeleteShotRequest)(nil), "DeleteShotRequest")
+	proto.RegisterType((*DeleteShotResponse)(nil), "DeleteShotResponse")
+	proto.RegisterType((*Album)(nil), "Album")
+	proto.RegisterType((*CreateAlbumRequest)(nil), "CreateAlbumRequest")
+	proto.RegisterType((*GetAlbumRequest)(nil), "GetAlbumRequest")
+	proto.RegisterType((*AlbumShotsRequest)(nil), "AlbumShotsRequest")
+	proto.RegisterType((*AlbumResponse)(nil), "AlbumResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -145,6 +691,24 @@ const _ = grpc.SupportPackageIsVersion4
 type SpreeClient interface {
 	Create(ctx context.Context, opts ...grpc.CallOption) (Spree_CreateClient, error)
 	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// owner or admin only
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error)
+	UpdateShot(ctx context.Context, in *UpdateShotRequest, opts ...grpc.CallOption) (*UpdateShotResponse, error)
+	DeleteShot(ctx context.Context, in *DeleteShotRequest, opts ...grpc.CallOption) (*DeleteShotResponse, error)
+	// albums may only hold the caller's shots. Changes are owner or admin only
+	CreateAlbum(ctx context.Context, in *CreateAlbumRequest, opts ...grpc.CallOption) (*AlbumResponse, error)
+	GetAlbum(ctx context.Context, in *GetAlbumRequest, opts ...grpc.CallOption) (*AlbumResponse, error)
+	AddAlbumShots(ctx context.Context, in *AlbumShotsRequest, opts ...grpc.CallOption) (*AlbumResponse, error)
+	RemoveAlbumShots(ctx context.Context, in *AlbumShotsRequest, opts ...grpc.CallOption) (*AlbumResponse, error)
+	// shot_ids must list every shot in the album, in the new order
+	ReorderAlbum(ctx context.Context, in *AlbumShotsRequest, opts ...grpc.CallOption) (*AlbumResponse, error)
+	// admin only
+	RevokeIdentity(ctx context.Context, in *RevokeIdentityRequest, opts ...grpc.CallOption) (*RevokeIdentityResponse, error)
+	ListActiveClients(ctx context.Context, in *ListActiveClientsRequest, opts ...grpc.CallOption) (*ListActiveClientsResponse, error)
+	QueryAudit(ctx context.Context, in *QueryAuditRequest, opts ...grpc.CallOption) (*QueryAuditResponse, error)
+	ReplicaHealth(ctx context.Context, in *ReplicaHealthRequest, opts ...grpc.CallOption) (*ReplicaHealthResponse, error)
+	GetStorageStats(ctx context.Context, in *GetStorageStatsRequest, opts ...grpc.CallOption) (*GetStorageStatsResponse, error)
 }
 
 type spreeClient struct {
@@ -195,11 +759,155 @@ func (c *spreeClient) List(ctx context.Context, in *ListRequest, opts ...grpc.Ca
 	return out, nil
 }
 
+func (c *spreeClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := new(SearchResponse)
+	err := grpc.Invoke(ctx, "/Spree/Search", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error) {
+	out := new(GetStatsResponse)
+	err := grpc.Invoke(ctx, "/Spree/GetStats", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) UpdateShot(ctx context.Context, in *UpdateShotRequest, opts ...grpc.CallOption) (*UpdateShotResponse, error) {
+	out := new(UpdateShotResponse)
+	err := grpc.Invoke(ctx, "/Spree/UpdateShot", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) DeleteShot(ctx context.Context, in *DeleteShotRequest, opts ...grpc.CallOption) (*DeleteShotResponse, error) {
+	out := new(DeleteShotResponse)
+	err := grpc.Invoke(ctx, "/Spree/DeleteShot", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) CreateAlbum(ctx context.Context, in *CreateAlbumRequest, opts ...grpc.CallOption) (*AlbumResponse, error) {
+	out := new(AlbumResponse)
+	err := grpc.Invoke(ctx, "/Spree/CreateAlbum", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) GetAlbum(ctx context.Context, in *GetAlbumRequest, opts ...grpc.CallOption) (*AlbumResponse, error) {
+	out := new(AlbumResponse)
+	err := grpc.Invoke(ctx, "/Spree/GetAlbum", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) AddAlbumShots(ctx context.Context, in *AlbumShotsRequest, opts ...grpc.CallOption) (*AlbumResponse, error) {
+	out := new(AlbumResponse)
+	err := grpc.Invoke(ctx, "/Spree/AddAlbumShots", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) RemoveAlbumShots(ctx context.Context, in *AlbumShotsRequest, opts ...grpc.CallOption) (*AlbumResponse, error) {
+	out := new(AlbumResponse)
+	err := grpc.Invoke(ctx, "/Spree/RemoveAlbumShots", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) ReorderAlbum(ctx context.Context, in *AlbumShotsRequest, opts ...grpc.CallOption) (*AlbumResponse, error) {
+	out := new(AlbumResponse)
+	err := grpc.Invoke(ctx, "/Spree/ReorderAlbum", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) RevokeIdentity(ctx context.Context, in *RevokeIdentityRequest, opts ...grpc.CallOption) (*RevokeIdentityResponse, error) {
+	out := new(RevokeIdentityResponse)
+	err := grpc.Invoke(ctx, "/Spree/RevokeIdentity", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) ListActiveClients(ctx context.Context, in *ListActiveClientsRequest, opts ...grpc.CallOption) (*ListActiveClientsResponse, error) {
+	out := new(ListActiveClientsResponse)
+	err := grpc.Invoke(ctx, "/Spree/ListActiveClients", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) QueryAudit(ctx context.Context, in *QueryAuditRequest, opts ...grpc.CallOption) (*QueryAuditResponse, error) {
+	out := new(QueryAuditResponse)
+	err := grpc.Invoke(ctx, "/Spree/QueryAudit", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) ReplicaHealth(ctx context.Context, in *ReplicaHealthRequest, opts ...grpc.CallOption) (*ReplicaHealthResponse, error) {
+	out := new(ReplicaHealthResponse)
+	err := grpc.Invoke(ctx, "/Spree/ReplicaHealth", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *spreeClient) GetStorageStats(ctx context.Context, in *GetStorageStatsRequest, opts ...grpc.CallOption) (*GetStorageStatsResponse, error) {
+	out := new(GetStorageStatsResponse)
+	err := grpc.Invoke(ctx, "/Spree/GetStorageStats", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Spree service
 
 type SpreeServer interface {
 	Create(Spree_CreateServer) error
 	List(context.Context, *ListRequest) (*ListResponse, error)
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// owner or admin only
+	GetStats(context.Context, *GetStatsRequest) (*GetStatsResponse, error)
+	UpdateShot(context.Context, *UpdateShotRequest) (*UpdateShotResponse, error)
+	DeleteShot(context.Context, *DeleteShotRequest) (*DeleteShotResponse, error)
+	// albums may only hold the caller's shots. Changes are owner or admin only
+	CreateAlbum(context.Context, *CreateAlbumRequest) (*AlbumResponse, error)
+	GetAlbum(context.Context, *GetAlbumRequest) (*AlbumResponse, error)
+	AddAlbumShots(context.Context, *AlbumShotsRequest) (*AlbumResponse, error)
+	RemoveAlbumShots(context.Context, *AlbumShotsRequest) (*AlbumResponse, error)
+	// shot_ids must list every shot in the album, in the new order
+	ReorderAlbum(context.Context, *AlbumShotsRequest) (*AlbumResponse, error)
+	// admin only
+	RevokeIdentity(context.Context, *RevokeIdentityRequest) (*RevokeIdentityResponse, error)
+	ListActiveClients(context.Context, *ListActiveClientsRequest) (*ListActiveClientsResponse, error)
+	QueryAudit(context.Context, *QueryAuditRequest) (*QueryAuditResponse, error)
+	ReplicaHealth(context.Context, *ReplicaHealthRequest) (*ReplicaHealthResponse, error)
+	GetStorageStats(context.Context, *GetStorageStatsRequest) (*GetStorageStatsResponse, error)
 }
 
 func RegisterSpreeServer(s *grpc.Server, srv SpreeServer) {
@@ -250,6 +958,258 @@ func _Spree_List_Handler(srv interface{}, ctx context.Context, dec func(interfac
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Spree_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/Search",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/GetStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_UpdateShot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateShotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).UpdateShot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/UpdateShot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).UpdateShot(ctx, req.(*UpdateShotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_DeleteShot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteShotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).DeleteShot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/DeleteShot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).DeleteShot(ctx, req.(*DeleteShotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_CreateAlbum_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAlbumRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).CreateAlbum(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/CreateAlbum",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).CreateAlbum(ctx, req.(*CreateAlbumRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_GetAlbum_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAlbumRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).GetAlbum(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/GetAlbum",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).GetAlbum(ctx, req.(*GetAlbumRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_AddAlbumShots_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AlbumShotsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).AddAlbumShots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/AddAlbumShots",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).AddAlbumShots(ctx, req.(*AlbumShotsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_RemoveAlbumShots_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AlbumShotsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).RemoveAlbumShots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/RemoveAlbumShots",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).RemoveAlbumShots(ctx, req.(*AlbumShotsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_ReorderAlbum_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AlbumShotsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).ReorderAlbum(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/ReorderAlbum",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).ReorderAlbum(ctx, req.(*AlbumShotsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_RevokeIdentity_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeIdentityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).RevokeIdentity(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/RevokeIdentity",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).RevokeIdentity(ctx, req.(*RevokeIdentityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_ListActiveClients_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListActiveClientsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).ListActiveClients(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/ListActiveClients",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).ListActiveClients(ctx, req.(*ListActiveClientsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_QueryAudit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryAuditRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).QueryAudit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/QueryAudit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).QueryAudit(ctx, req.(*QueryAuditRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_ReplicaHealth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplicaHealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).ReplicaHealth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/ReplicaHealth",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).ReplicaHealth(ctx, req.(*ReplicaHealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Spree_GetStorageStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStorageStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SpreeServer).GetStorageStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Spree/GetStorageStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SpreeServer).GetStorageStats(ctx, req.(*GetStorageStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Spree_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "Spree",
 	HandlerType: (*SpreeServer)(nil),
@@ -258,6 +1218,62 @@ var _Spree_serviceDesc = grpc.ServiceDesc{
 			MethodName: "List",
 			Handler:    _Spree_List_Handler,
 		},
+		{
+			MethodName: "Search",
+			Handler:    _Spree_Search_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Spree_GetStats_Handler,
+		},
+		{
+			MethodName: "UpdateShot",
+			Handler:    _Spree_UpdateShot_Handler,
+		},
+		{
+			MethodName: "DeleteShot",
+			Handler:    _Spree_DeleteShot_Handler,
+		},
+		{
+			MethodName: "CreateAlbum",
+			Handler:    _Spree_CreateAlbum_Handler,
+		},
+		{
+			MethodName: "GetAlbum",
+			Handler:    _Spree_GetAlbum_Handler,
+		},
+		{
+			MethodName: "AddAlbumShots",
+			Handler:    _Spree_AddAlbumShots_Handler,
+		},
+		{
+			MethodName: "RemoveAlbumShots",
+			Handler:    _Spree_RemoveAlbumShots_Handler,
+		},
+		{
+			MethodName: "ReorderAlbum",
+			Handler:    _Spree_ReorderAlbum_Handler,
+		},
+		{
+			MethodName: "RevokeIdentity",
+			Handler:    _Spree_RevokeIdentity_Handler,
+		},
+		{
+			MethodName: "ListActiveClients",
+			Handler:    _Spree_ListActiveClients_Handler,
+		},
+		{
+			MethodName: "QueryAudit",
+			Handler:    _Spree_QueryAudit_Handler,
+		},
+		{
+			MethodName: "ReplicaHealth",
+			Handler:    _Spree_ReplicaHealth_Handler,
+		},
+		{
+			MethodName: "GetStorageStats",
+			Handler:    _Spree_GetStorageStats_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -273,29 +1289,113 @@ var _Spree_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("spree.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 371 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x74, 0x92, 0x5f, 0xcb, 0xd3, 0x30,
-	0x14, 0xc6, 0xdf, 0xac, 0x7f, 0x5e, 0x7b, 0xd6, 0x76, 0x10, 0x44, 0xe2, 0x44, 0x28, 0x51, 0xa1,
-	0x22, 0x54, 0x99, 0x9f, 0xc0, 0xe9, 0xa5, 0x57, 0xd9, 0x85, 0x97, 0x25, 0x5b, 0xcf, 0x6c, 0x70,
-	0xb6, 0xb5, 0x89, 0x8e, 0xf9, 0xfd, 0xfc, 0x5e, 0x92, 0xa4, 0xd3, 0x55, 0xf0, 0xee, 0x3c, 0x4f,
-	0x42, 0x7e, 0x39, 0xcf, 0x39, 0xb0, 0xd4, 0xc3, 0x88, 0x58, 0x0d, 0x63, 0x6f, 0x7a, 0xde, 0x43,
-	0xf6, 0x7e, 0x44, 0x69, 0x50, 0xe0, 0xb7, 0xef, 0xa8, 0x0d, 0x5d, 0xc3, 0x83, 0xa3, 0x3a, 0x61,
-	0x27, 0xbf, 0x22, 0x23, 0x05, 0x29, 0x13, 0xf1, 0x47, 0xd3, 0x47, 0x10, 0xf7, 0xc7, 0xa3, 0x46,
-	0xc3, 0x16, 0x05, 0x29, 0x03, 0x31, 0x29, 0xeb, 0x9f, 0xb0, 0xfb, 0x6c, 0x5a, 0x16, 0x78, 0xdf,
-	0x2b, 0x4a, 0x21, 0x6c, 0xa4, 0x91, 0x2c, 0x2c, 0x48, 0x99, 0x0a, 0x57, 0xf3, 0x16, 0xf2, 0x2b,
-	0x50, 0x0f, 0x7d, 0xa7, 0x91, 0x3e, 0x86, 0x50, 0xb7, 0xbd, 0x71, 0xb4, 0xe5, 0x26, 0xaa, 0x76,
-	0x6d, 0x6f, 0x84, 0xb3, 0xfe, 0x0b, 0x7c, 0x06, 0xd9, 0xfe, 0x62, 0x50, 0xd7, 0xe7, 0x51, 0x19,
-	0x83, 0xdd, 0xc4, 0x4d, 0x9d, 0xf9, 0xc9, 0x7b, 0xfc, 0x17, 0x81, 0xd0, 0xbe, 0x45, 0x73, 0x58,
-	0xa8, 0x66, 0x6a, 0x66, 0xa1, 0x1a, 0xfa, 0x14, 0xe0, 0xe0, 0xbe, 0xd0, 0xd4, 0xd2, 0xbf, 0x9c,
-	0x88, 0x64, 0x72, 0xde, 0xcd, 0x13, 0x08, 0xfe, 0x49, 0xe0, 0x21, 0x44, 0x3f, 0x14, 0x9e, 0xb5,
-	0x6b, 0x29, 0x14, 0x5e, 0xd8, 0x3e, 0x07, 0x69, 0x5a, 0x16, 0xb9, 0xdb, 0xae, 0xb6, 0x10, 0xad,
-	0x7e, 0x62, 0xed, 0xbe, 0xc4, 0xee, 0xdd, 0xf5, 0xc4, 0x3a, 0x5b, 0x6b, 0xd0, 0x97, 0x70, 0xbf,
-	0x97, 0x87, 0x2f, 0xd8, 0x35, 0x2c, 0x76, 0x7d, 0xaf, 0xaa, 0xad, 0xd7, 0x1f, 0xd0, 0x48, 0x75,
-	0xd2, 0xe2, 0x7a, 0xce, 0x9f, 0x43, 0x3e, 0x3f, 0xb2, 0x3c, 0x73, 0x19, 0xae, 0xf3, 0x71, 0x35,
-	0xcf, 0x60, 0xf9, 0x51, 0x69, 0x33, 0x8d, 0x91, 0xbf, 0x82, 0xd4, 0xcb, 0x29, 0xe4, 0x27, 0x10,
-	0xd9, 0x44, 0x35, 0x23, 0x45, 0xf0, 0x37, 0x65, 0xef, 0x6d, 0x6a, 0x88, 0x76, 0x76, 0x27, 0xe8,
-	0x6b, 0x88, 0xfd, 0x70, 0x68, 0x5e, 0xcd, 0xd6, 0x62, 0xbd, 0xaa, 0xe6, 0x53, 0xe3, 0x77, 0x25,
-	0x79, 0x43, 0xe8, 0x0b, 0x08, 0x2d, 0x86, 0xa6, 0xd5, 0x0d, 0x7c, 0x9d, 0x55, 0xb7, 0x6c, 0x7e,
-	0xb7, 0x8f, 0xdd, 0xb2, 0xbd, 0xfd, 0x1d, 0x00, 0x00, 0xff, 0xff, 0xfd, 0x04, 0xf5, 0x2e, 0x7b,
-	0x02, 0x00, 0x00,
+	// 1714 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xbc, 0x58, 0xcd, 0x92, 0x23, 0x47,
+	0xf1, 0x57, 0xeb, 0x5b, 0xa9, 0x8f, 0x99, 0xa9, 0x9d, 0x9d, 0xed, 0x91, 0xff, 0xff, 0xb0, 0xb6,
+	0xd6, 0x26, 0x06, 0x3b, 0x28, 0xe3, 0xb1, 0x09, 0x73, 0x20, 0x08, 0xe4, 0xf1, 0x02, 0x03, 0xe6,
+	0x40, 0x0d, 0x2c, 0x47, 0x45, 0x8d, 0x3a, 0x77, 0xd4, 0x4c, 0xab, 0x5b, 0xdb, 0x5d, 0x9a, 0x8d,
+	0xe1, 0xcc, 0x0b, 0x10, 0x01, 0x2f, 0xc2, 0x33, 0x70, 0xe5, 0x35, 0x38, 0x11, 0x3c, 0x03, 0x51,
+	0x59, 0xd5, 0xea, 0x6e, 0x7d, 0xac, 0xed, 0x0b, 0xb7, 0xca, 0x5f, 0x65, 0x75, 0x65, 0xfe, 0xea,
+	0x57, 0x59, 0x29, 0x41, 0x3f, 0x5b, 0xa5, 0x88, 0x62, 0x95, 0x26, 0x3a, 0xe1, 0xff, 0xf4, 0x60,
+	0x78, 0x95, 0xa2, 0xd2, 0x28, 0xf1, 0xcd, 0x1a, 0x33, 0xcd, 0xc6, 0xd0, 0x7d, 0x1d, 0x46, 0x18,
+	0xab, 0x25, 0xfa, 0xde, 0xc4, 0xbb, 0xe8, 0xc9, 0x8d, 0xcd, 0xce, 0xa0, 0x9d, 0xbc, 0x7e, 0x9d,
+	0xa1, 0xf6, 0xeb, 0x13, 0xef, 0xa2, 0x21, 0x9d, 0x65, 0xf0, 0x08, 0xe3, 0x3b, 0xbd, 0xf0, 0x1b,
+	0x16, 0xb7, 0x16, 0x63, 0xd0, 0x0c, 0x94, 0x56, 0x7e, 0x73, 0xe2, 0x5d, 0x0c, 0x24, 0x8d, 0xd9,
+	0x31, 0x34, 0xf0, 0x12, 0xfd, 0xd6, 0xc4, 0xbb, 0xe8, 0x4a, 0x33, 0x64, 0xa7, 0xd0, 0xd2, 0xa1,
+	0x8e, 0xd0, 0x6f, 0xd3, 0x76, 0xd6, 0x60, 0x13, 0xe8, 0x07, 0x98, 0xcd, 0xd3, 0x70, 0xa5, 0xc3,
+	0x24, 0xf6, 0x3b, 0x34, 0x57, 0x86, 0xcc, 0xd7, 0xb5, 0xba, 0xcb, 0xfc, 0xee, 0xa4, 0x71, 0xd1,
+	0x93, 0x34, 0xe6, 0x0b, 0x18, 0xe5, 0xe9, 0x64, 0xab, 0x24, 0xce, 0x90, 0x9d, 0x43, 0x33, 0x5b,
+	0x24, 0x9a, 0x72, 0xe9, 0x5f, 0xb6, 0xc4, 0xcd, 0x22, 0xd1, 0x92, 0xa0, 0x83, 0xe9, 0xbc, 0x80,
+	0xe1, 0xed, 0xa3, 0xc6, 0x6c, 0xf6, 0x36, 0x0d, 0xb5, 0xc6, 0xd8, 0x65, 0x35, 0x20, 0xf0, 0x0f,
+	0x16, 0xe3, 0x7f, 0x6b, 0x42, 0xd3, 0x7c, 0x8b, 0x8d, 0xa0, 0x1e, 0x06, 0x8e, 0xaa, 0x7a, 0x18,
+	0xb0, 0xff, 0x07, 0x98, 0x53, 0x08, 0xc1, 0x4c, 0xd9, 0x2f, 0xf7, 0x64, 0xcf, 0x21, 0xd3, 0x2a,
+	0xbf, 0x8d, 0x2d, 0x7e, 0x4f, 0xa1, 0xf5, 0x10, 0xe2, 0xdb, 0x8c, 0x08, 0x6b, 0x4a, 0x6b, 0x98,
+	0x3c, 0x57, 0x4a, 0x2f, 0x88, 0xb2, 0x9e, 0xa4, 0xb1, 0xd9, 0x24, 0x0b, 0xff, 0x84, 0x33, 0x0a,
+	0x89, 0xc8, 0x69, 0xca, 0x9e, 0x41, 0xbe, 0x34, 0x80, 0xf9, 0x50, 0xf2, 0x36, 0xc6, 0xd4, 0xef,
+	0x5a, 0x4a, 0xc9, 0xc8, 0xa9, 0xef, 0x15, 0xd4, 0x7f, 0x00, 0xa3, 0x48, 0x65, 0x7a, 0x66, 0x36,
+	0xb2, 0xf1, 0x02, 0x2d, 0x18, 0x18, 0xf4, 0x15, 0x81, 0x53, 0xcd, 0xbe, 0x0f, 0xc7, 0xf3, 0x24,
+	0xd6, 0x18, 0xeb, 0x19, 0xc6, 0xf3, 0x24, 0x08, 0xe3, 0x3b, 0xbf, 0x4f, 0x7e, 0x47, 0x0e, 0x7f,
+	0xe9, 0x60, 0xf6, 0x1c, 0x06, 0x99, 0x4e, 0x52, 0x0c, 0x5c, 0x64, 0x03, 0x8a, 0xac, 0x6f, 0x31,
+	0x1b, 0xdb, 0x0b, 0x18, 0xce, 0x53, 0xf5, 0x36, 0xc2, 0x74, 0x66, 0x93, 0x1d, 0x92, 0xcf, 0xc0,
+	0x81, 0xaf, 0x28, 0xe7, 0x8d, 0x26, 0x46, 0xef, 0xd0, 0xc4, 0xd1, 0x61, 0x4d, 0x1c, 0x17, 0x9a,
+	0x60, 0xef, 0x03, 0xed, 0xaf, 0xee, 0x70, 0x76, 0x8f, 0x8f, 0xfe, 0x09, 0xad, 0x02, 0x07, 0xfd,
+	0x1a, 0x1f, 0xd9, 0x04, 0x28, 0xdf, 0x59, 0x8a, 0x8a, 0x38, 0x60, 0xd6, 0xc3, 0x60, 0x12, 0x95,
+	0x65, 0xa0, 0x73, 0xab, 0xe6, 0xf7, 0x18, 0x07, 0x24, 0xd2, 0xfe, 0xe5, 0x91, 0xf8, 0xd2, 0xda,
+	0x5f, 0xa1, 0x56, 0x61, 0x94, 0xc9, 0x7c, 0x9e, 0x7f, 0x00, 0xa3, 0xea, 0x14, 0xc5, 0xf4, 0xb8,
+	0xca, 0x6f, 0x13, 0x8d, 0xf9, 0xa7, 0xd0, 0xff, 0x3a, 0xcc, 0x74, 0x7e, 0xe9, 0x8e, 0xa1, 0xa1,
+	0xa2, 0x88, 0x3c, 0xba, 0xd2, 0x0c, 0x0d, 0xa2, 0xd5, 0x9d, 0x93, 0x8f, 0x19, 0xf2, 0x8f, 0x61,
+	0x60, 0x97, 0x38, 0x61, 0xbf, 0x07, 0x2d, 0xa3, 0xe2, 0xcc, 0xf7, 0x26, 0x8d, 0x42, 0xd9, 0x16,
+	0xe3, 0xb7, 0xf0, 0x54, 0xe2, 0x43, 0x72, 0x8f, 0xd7, 0x01, 0xc6, 0x3a, 0xd4, 0x8f, 0xf9, 0x4e,
+	0xa7, 0xd0, 0xc2, 0xa5, 0x0a, 0x23, 0x17, 0x8d, 0x35, 0xd8, 0x39, 0x74, 0x75, 0x72, 0x8f, 0xf1,
+	0x2c, 0x0c, 0xdc, 0x96, 0x1d, 0xb2, 0xaf, 0x03, 0xe6, 0x43, 0x27, 0x45, 0x3a, 0x3f, 0x92, 0x6b,
+	0x57, 0xe6, 0x26, 0xf7, 0xe1, 0x6c, 0x7b, 0x0f, 0x1b, 0x1a, 0xff, 0xb3, 0x07, 0xed, 0xab, 0x28,
+	0xc4, 0xf8, 0xd0, 0x7e, 0xef, 0x41, 0x8f, 0x18, 0xcf, 0x10, 0x63, 0xb7, 0x61, 0xd7, 0x00, 0x37,
+	0x88, 0x74, 0x86, 0x2a, 0x08, 0x52, 0x77, 0x3b, 0x68, 0x6c, 0xa3, 0x30, 0x7b, 0x05, 0x7e, 0x33,
+	0x8f, 0x82, 0xcc, 0x4a, 0xe8, 0xad, 0x4a, 0xe8, 0x7c, 0x0c, 0xbe, 0x61, 0x6c, 0x3a, 0xd7, 0xe1,
+	0x03, 0xda, 0x78, 0x32, 0xc7, 0x03, 0xff, 0x23, 0x9c, 0xef, 0x99, 0x73, 0xd4, 0x3e, 0x87, 0xce,
+	0xdc, 0x42, 0x8e, 0xdc, 0x8e, 0xb0, 0x2e, 0x32, 0xc7, 0xd9, 0x47, 0x70, 0xe2, 0x22, 0x98, 0xe5,
+	0xdb, 0x67, 0x7e, 0x9d, 0x54, 0x77, 0xe4, 0x26, 0x7e, 0x67, 0xc3, 0xc8, 0xf8, 0xbf, 0x3d, 0xe8,
+	0x4f, 0xd7, 0x41, 0xa8, 0x25, 0xce, 0x93, 0x34, 0x20, 0x41, 0x84, 0xcb, 0x42, 0x10, 0xe1, 0x12,
+	0x4d, 0x59, 0x08, 0x1d, 0x8d, 0x39, 0x21, 0xb9, 0x6d, 0xd8, 0x5a, 0x21, 0xa6, 0xb3, 0x12, 0x2b,
+	0x5d, 0x03, 0x4c, 0x0d, 0x33, 0x67, 0xd0, 0x5e, 0xa2, 0x5e, 0x24, 0x96, 0x98, 0x9e, 0x74, 0x16,
+	0x7b, 0x06, 0x1d, 0x23, 0x85, 0x82, 0x96, 0xb6, 0x31, 0xaf, 0x83, 0xad, 0xd2, 0xd1, 0xde, 0x2e,
+	0x1d, 0x67, 0xd0, 0x4e, 0x31, 0x5b, 0x47, 0xda, 0x95, 0x5c, 0x67, 0x19, 0x41, 0x9a, 0xdb, 0x63,
+	0x0b, 0x8a, 0x19, 0x1a, 0x4f, 0xad, 0xd2, 0x3b, 0xd4, 0x54, 0x51, 0x7a, 0xd2, 0x59, 0xfc, 0x0d,
+	0x9c, 0xfc, 0x76, 0x8d, 0xe9, 0xa3, 0x4b, 0x79, 0xa3, 0xbb, 0x2c, 0x8c, 0xe7, 0x79, 0xd2, 0xd6,
+	0x30, 0xe8, 0x3a, 0xd6, 0x61, 0xe4, 0x52, 0xb6, 0x46, 0x85, 0x8b, 0xc6, 0x16, 0x17, 0xa7, 0xd0,
+	0x8a, 0xc2, 0x65, 0xa8, 0x29, 0xdb, 0x96, 0xb4, 0x06, 0xff, 0x09, 0xb0, 0xf2, 0x96, 0xee, 0x18,
+	0xbf, 0x67, 0x44, 0x63, 0x18, 0xcf, 0x8f, 0x71, 0x20, 0x4a, 0xc7, 0x20, 0xf3, 0x49, 0x7e, 0x06,
+	0xa7, 0x12, 0x57, 0x51, 0x38, 0x57, 0xbf, 0x44, 0x15, 0xe9, 0x45, 0xae, 0x91, 0xff, 0x78, 0x30,
+	0x74, 0x13, 0x37, 0x5a, 0xe9, 0x35, 0x5d, 0xe5, 0xd2, 0xc3, 0x48, 0x63, 0x23, 0xcd, 0x05, 0x2d,
+	0xb3, 0x07, 0xd7, 0x95, 0xb9, 0x69, 0x98, 0x26, 0x95, 0x63, 0x9a, 0x26, 0xf9, 0xc1, 0x91, 0xee,
+	0x5f, 0x1a, 0x80, 0x71, 0x18, 0x16, 0xd3, 0x33, 0x65, 0x53, 0xea, 0xc9, 0xfe, 0xc6, 0x63, 0xaa,
+	0x37, 0xa5, 0x29, 0x9b, 0xab, 0xd8, 0xb8, 0xb4, 0x8a, 0xd2, 0x74, 0x33, 0x57, 0xf1, 0x94, 0x88,
+	0xbd, 0x8d, 0x92, 0x5b, 0x7b, 0x92, 0x0d, 0x69, 0x0d, 0x13, 0xd4, 0x32, 0xcc, 0x32, 0x53, 0xa9,
+	0x3b, 0x84, 0xe7, 0xa6, 0x21, 0x37, 0xc5, 0x95, 0x0a, 0x53, 0x0c, 0xe8, 0x30, 0x1b, 0x72, 0x63,
+	0xf3, 0x2b, 0x78, 0xea, 0xf2, 0xcd, 0x89, 0x70, 0x4c, 0x7e, 0x44, 0x8b, 0xcc, 0x44, 0x4e, 0xe5,
+	0x48, 0x54, 0x98, 0x91, 0x9b, 0x79, 0x53, 0x16, 0x7e, 0x81, 0xfa, 0xc6, 0x96, 0x57, 0x33, 0xbb,
+	0xb9, 0x73, 0x3f, 0x85, 0x67, 0x3b, 0x33, 0x6e, 0x83, 0x17, 0xd0, 0xca, 0x0c, 0xe0, 0x9e, 0xe9,
+	0xa1, 0xa8, 0x78, 0xd9, 0x39, 0xfe, 0x77, 0x0f, 0x06, 0x65, 0x9c, 0x44, 0xe5, 0x4a, 0x20, 0xbd,
+	0x97, 0x64, 0xd8, 0xe7, 0x6a, 0xb9, 0x4a, 0x31, 0xcb, 0x30, 0x98, 0x59, 0x87, 0x3a, 0x39, 0x1c,
+	0x15, 0xf8, 0x0d, 0xb9, 0x56, 0xef, 0x42, 0x63, 0xfb, 0x2e, 0x6c, 0xbf, 0x66, 0xcd, 0xdd, 0xd7,
+	0xcc, 0x3c, 0x2e, 0xea, 0x61, 0xe3, 0xd1, 0x22, 0x46, 0x81, 0x20, 0x72, 0xe0, 0xff, 0xf2, 0xa0,
+	0x67, 0xde, 0xb4, 0x4d, 0xc4, 0xf6, 0xd1, 0xf3, 0xca, 0x2f, 0xfc, 0xce, 0x93, 0xd8, 0xde, 0xf3,
+	0x24, 0x4e, 0xa0, 0x15, 0xa8, 0x30, 0x7a, 0xa4, 0x2a, 0xd3, 0xbf, 0x04, 0x61, 0xe0, 0xab, 0x64,
+	0x1d, 0x6b, 0x69, 0x27, 0xd8, 0x05, 0xf4, 0x52, 0x7c, 0x8d, 0x69, 0x8a, 0xa9, 0x49, 0x66, 0xdb,
+	0xab, 0x98, 0x64, 0x1c, 0xda, 0xea, 0x8e, 0xea, 0x5b, 0x73, 0xc7, 0xcd, 0xcd, 0x18, 0x09, 0x99,
+	0x60, 0x30, 0xb5, 0x59, 0x0d, 0x64, 0x6e, 0x9a, 0x24, 0xcc, 0x53, 0x99, 0xf7, 0x1d, 0xd6, 0xe0,
+	0x9f, 0x41, 0x6f, 0xf3, 0x91, 0xbc, 0x5a, 0x78, 0x45, 0xb5, 0xd8, 0x64, 0x5e, 0x2f, 0x65, 0xce,
+	0x9f, 0xc3, 0x11, 0x49, 0xa2, 0x50, 0xc9, 0x76, 0x3f, 0xc5, 0xff, 0xe1, 0xc1, 0x71, 0xe1, 0xf3,
+	0xcd, 0x5d, 0xdd, 0xff, 0x9a, 0xa7, 0x0f, 0x61, 0xb4, 0x8e, 0xc3, 0x37, 0x6b, 0x9c, 0x95, 0xe9,
+	0x6a, 0xca, 0xa1, 0x45, 0x5f, 0x59, 0x90, 0xcf, 0xe0, 0xe4, 0xf7, 0xab, 0x40, 0x69, 0xa4, 0x50,
+	0xf7, 0xe7, 0xba, 0x49, 0xab, 0xbe, 0x9b, 0xd6, 0xfb, 0xd0, 0x5f, 0xd3, 0xfa, 0xd9, 0x52, 0x65,
+	0xf7, 0x14, 0x76, 0x4f, 0x82, 0x85, 0x7e, 0xa3, 0xb2, 0x7b, 0xfe, 0x09, 0xb0, 0xf2, 0x06, 0xdf,
+	0x48, 0x14, 0xff, 0x02, 0x86, 0x37, 0xa8, 0xd2, 0xf9, 0xa2, 0x54, 0xa3, 0xdf, 0x98, 0x2a, 0x9a,
+	0xd7, 0x68, 0x32, 0xf2, 0xde, 0xa4, 0xbe, 0xe9, 0x4d, 0xf8, 0x0f, 0x60, 0x94, 0x2f, 0xfc, 0x36,
+	0xbd, 0xc8, 0x0b, 0x38, 0xf9, 0x0a, 0x23, 0x7c, 0x67, 0xe6, 0xfc, 0x14, 0x58, 0xd9, 0xc9, 0x35,
+	0x12, 0x7f, 0xf1, 0xa0, 0x35, 0x8d, 0x6e, 0xd7, 0xcb, 0xef, 0xda, 0x65, 0x6f, 0x1a, 0xe0, 0x46,
+	0xb9, 0x01, 0xde, 0x74, 0x95, 0xcd, 0x72, 0x57, 0x79, 0x0e, 0x5d, 0xf7, 0x52, 0x9a, 0xa3, 0x33,
+	0xb4, 0x76, 0xec, 0x53, 0x59, 0xb4, 0xde, 0xed, 0xa2, 0xf5, 0xe6, 0x2f, 0x81, 0xd9, 0x9f, 0x18,
+	0x14, 0x58, 0x89, 0x3b, 0xfb, 0x69, 0xef, 0xd0, 0xa7, 0xeb, 0x95, 0x4f, 0x3b, 0xe5, 0x57, 0xbe,
+	0xb1, 0xcd, 0xc9, 0x35, 0x9c, 0xd0, 0x3c, 0xd5, 0xaa, 0xdc, 0xe9, 0x1c, 0xba, 0xca, 0x80, 0xb3,
+	0x8d, 0x6b, 0x87, 0xec, 0xeb, 0xe0, 0x5d, 0xbb, 0xfd, 0x0a, 0x86, 0x6e, 0x2b, 0x77, 0x62, 0xff,
+	0x07, 0x2d, 0x5a, 0xe6, 0x84, 0xd1, 0x16, 0x76, 0xda, 0x82, 0xc5, 0x79, 0xd6, 0x77, 0xcf, 0xf3,
+	0xf2, 0xaf, 0x1d, 0x68, 0xdd, 0xac, 0x52, 0x44, 0xf6, 0x09, 0xb4, 0x2d, 0x15, 0x6c, 0x24, 0x2a,
+	0xbf, 0x22, 0xc7, 0x47, 0xa2, 0xfa, 0x33, 0x8c, 0xd7, 0x2e, 0xbc, 0x1f, 0x7a, 0xec, 0x43, 0x68,
+	0x9a, 0xae, 0x8b, 0x0d, 0x44, 0xa9, 0xfb, 0x1d, 0x0f, 0x45, 0xb9, 0xb1, 0xe5, 0x35, 0xf6, 0x31,
+	0xb4, 0xad, 0xc0, 0xd8, 0x48, 0x54, 0x24, 0x3a, 0x3e, 0x12, 0x55, 0xe5, 0xf1, 0x1a, 0xfb, 0x14,
+	0xba, 0x79, 0x79, 0x60, 0xc7, 0x62, 0xab, 0x9a, 0x8c, 0x4f, 0xc4, 0x76, 0xed, 0xe0, 0x35, 0xf6,
+	0x05, 0x40, 0x71, 0x55, 0x18, 0x13, 0x3b, 0x17, 0x73, 0xfc, 0x44, 0xec, 0xde, 0x25, 0xbb, 0xb0,
+	0x50, 0x29, 0x63, 0x62, 0x47, 0xd7, 0xe3, 0x27, 0x62, 0x8f, 0x8c, 0x6b, 0xec, 0x73, 0xe8, 0x97,
+	0x44, 0xc3, 0x9e, 0x88, 0x5d, 0x09, 0x8d, 0x47, 0xa2, 0x72, 0x44, 0xbc, 0xc6, 0x04, 0xa5, 0x66,
+	0x97, 0x1c, 0x8b, 0x7c, 0x78, 0xd8, 0xff, 0x47, 0x30, 0x9c, 0x06, 0x41, 0xa1, 0x19, 0xc6, 0xc4,
+	0x8e, 0x80, 0xf6, 0x2c, 0xfb, 0x31, 0x1c, 0x4b, 0x5c, 0x26, 0x0f, 0xf8, 0x9d, 0x57, 0x7e, 0x0e,
+	0x03, 0x89, 0x49, 0x1a, 0x60, 0x6a, 0x83, 0xfc, 0x76, 0xab, 0xae, 0x60, 0x54, 0xfd, 0xe1, 0xc0,
+	0xce, 0xc4, 0xde, 0x5f, 0x2b, 0xe3, 0x67, 0xe2, 0xc0, 0x2f, 0x8c, 0x1a, 0xfb, 0x1a, 0x4e, 0x76,
+	0x1a, 0x78, 0x76, 0x2e, 0x0e, 0x35, 0xfc, 0xe3, 0xb1, 0x38, 0xd8, 0xef, 0xdb, 0x83, 0x2d, 0x1a,
+	0x48, 0xc6, 0xc4, 0x4e, 0x03, 0x3b, 0x7e, 0x22, 0x76, 0x3b, 0x4c, 0x5e, 0x63, 0x3f, 0x83, 0x61,
+	0xa5, 0x65, 0x62, 0x4f, 0xc5, 0xbe, 0x5e, 0x72, 0x7c, 0x26, 0xf6, 0x76, 0x56, 0xbc, 0xc6, 0x7e,
+	0xee, 0x9e, 0xc0, 0x52, 0x5f, 0xf3, 0x4c, 0xec, 0xef, 0xa0, 0xc6, 0xbe, 0x38, 0xd0, 0x40, 0xf1,
+	0xda, 0x6d, 0x9b, 0xfe, 0xd1, 0xf9, 0xec, 0xbf, 0x03, 0x00, 0x1c, 0x7e, 0xc1, 0x91, 0xe0, 0x11,
+	0x00, 0x00,
 }
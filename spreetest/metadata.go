@@ -0,0 +1,713 @@
+// Package spreetest holds conformance suites for implementations of the
+// spree Metadata and Storage interfaces. Call them from a test in the
+// implementation's package.
+package spreetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ralfonso/spree"
+)
+
+// NewMetadata returns an empty Metadata for a single test. The suite closes
+// it; cleanup is called afterwards to remove anything it left behind and
+// may be nil.
+type NewMetadata func(t *testing.T) (md spree.Metadata, cleanup func())
+
+// TestMetadata runs the Metadata conformance suite against the stores
+// returned by newMD.
+func TestMetadata(t *testing.T, newMD NewMetadata) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, spree.Metadata)
+	}{
+		{"GetId", testGetId},
+		{"PutGet", testPutGet},
+		{"PutReplaces", testPutReplaces},
+		{"ReturnsCopies", testReturnsCopies},
+		{"MissingId", testMissingId},
+		{"ListOrder", testListOrder},
+		{"ListByOwner", testListByOwner},
+		{"ListByTag", testListByTag},
+		{"UpdateShot", testUpdateShot},
+		{"Search", testSearch},
+		{"DeleteShot", testDeleteShot},
+		{"ConcurrentAddViews", testConcurrentAddViews},
+		{"AddViews", testAddViews},
+		{"GetViewStats", testGetViewStats},
+		{"SetBackendType", testSetBackendType},
+		{"Albums", testAlbums},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			md, cleanup := newMD(t)
+			if cleanup != nil {
+				defer cleanup()
+			}
+			defer func() {
+				if err := md.Close(); err != nil {
+					t.Errorf("Close: %v", err)
+				}
+			}()
+			tt.fn(t, md)
+		})
+	}
+
+	t.Run("Close", func(t *testing.T) {
+		md, cleanup := newMD(t)
+		if cleanup != nil {
+			defer cleanup()
+		}
+		testClose(t, md)
+	})
+}
+
+func newShot(md spree.Metadata, owner string) *spree.Shot {
+	shot := &spree.Shot{
+		Filename:  "shot.png",
+		Owner:     owner,
+		SizeBytes: 1024,
+		CreatedAt: "2017-03-01T12:00:00Z",
+		Backend: &spree.BackendDetails{
+			Type: "file",
+		},
+	}
+	shot.Id = md.GetId(shot)
+	return shot
+}
+
+func mustPut(t *testing.T, md spree.Metadata, shot *spree.Shot) {
+	if err := md.PutShot(shot); err != nil {
+		t.Fatalf("PutShot(%s): %v", shot.Id, err)
+	}
+}
+
+func addView(t *testing.T, md spree.Metadata, id string) {
+	if err := md.AddViews(map[string]*spree.ViewStats{id: {Views: 1}}, time.Now()); err != nil {
+		t.Fatalf("AddViews(%s): %v", id, err)
+	}
+}
+
+func mustGet(t *testing.T, md spree.Metadata, id string) *spree.Shot {
+	shot, err := md.GetShotById(id)
+	if err != nil {
+		t.Fatalf("GetShotById(%s): %v", id, err)
+	}
+	return shot
+}
+
+func ids(shots []*spree.Shot) []string {
+	out := make([]string, len(shots))
+	for i, shot := range shots {
+		out[i] = shot.Id
+	}
+	return out
+}
+
+func sameIds(got []*spree.Shot, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i].Id != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testGetId(t *testing.T, md spree.Metadata) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := md.GetId(&spree.Shot{})
+		if id == "" {
+			t.Fatal("GetId returned an empty id")
+		}
+		if seen[id] {
+			t.Fatalf("GetId returned %s twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func testPutGet(t *testing.T, md spree.Metadata) {
+	shot := newShot(md, "someone@example.com")
+	mustPut(t, md, shot)
+
+	if want := "/p/" + shot.Id; shot.Path != want {
+		t.Errorf("PutShot set Path to %q, want %q", shot.Path, want)
+	}
+
+	got := mustGet(t, md, shot.Id)
+	if !proto.Equal(got, shot) {
+		t.Errorf("GetShotById = %v, want %v", got, shot)
+	}
+}
+
+func testPutReplaces(t *testing.T, md spree.Metadata) {
+	shot := newShot(md, "old@example.com")
+	mustPut(t, md, shot)
+
+	shot.Owner = "new@example.com"
+	shot.Filename = "renamed.png"
+	mustPut(t, md, shot)
+
+	got := mustGet(t, md, shot.Id)
+	if got.Owner != shot.Owner || got.Filename != shot.Filename {
+		t.Errorf("GetShotById after replace = %v, want %v", got, shot)
+	}
+
+	shots, err := md.ListShots()
+	if err != nil {
+		t.Fatalf("ListShots: %v", err)
+	}
+	if !sameIds(shots, shot.Id) {
+		t.Errorf("ListShots = %v, want [%s]", ids(shots), shot.Id)
+	}
+
+	old, err := md.ListShotsByOwner("old@example.com")
+	if err != nil {
+		t.Fatalf("ListShotsByOwner: %v", err)
+	}
+	if len(old) != 0 {
+		t.Errorf("previous owner still lists %v", ids(old))
+	}
+	owned, err := md.ListShotsByOwner("new@example.com")
+	if err != nil {
+		t.Fatalf("ListShotsByOwner: %v", err)
+	}
+	if !sameIds(owned, shot.Id) {
+		t.Errorf("ListShotsByOwner = %v, want [%s]", ids(owned), shot.Id)
+	}
+}
+
+// testReturnsCopies checks that callers can't change stored shots without
+// calling PutShot.
+func testReturnsCopies(t *testing.T, md spree.Metadata) {
+	shot := newShot(md, "someone@example.com")
+	mustPut(t, md, shot)
+	shot.Filename = "changed.png"
+
+	got := mustGet(t, md, shot.Id)
+	if got.Filename != "shot.png" {
+		t.Errorf("changing the shot passed to PutShot changed the stored shot")
+	}
+
+	got.Filename = "changed.png"
+	shots, err := md.ListShots()
+	if err != nil {
+		t.Fatalf("ListShots: %v", err)
+	}
+	shots[0].Filename = "changed.png"
+
+	if got := mustGet(t, md, shot.Id); got.Filename != "shot.png" {
+		t.Errorf("changing a returned shot changed the stored shot")
+	}
+}
+
+func testMissingId(t *testing.T, md spree.Metadata) {
+	mustPut(t, md, newShot(md, "someone@example.com"))
+
+	if shot, err := md.GetShotById("missing"); err != spree.ErrNotFound {
+		t.Errorf("GetShotById(missing) = %v, %v, want ErrNotFound", shot, err)
+	}
+	if shot, err := md.GetShotById(""); err != spree.ErrNotFound {
+		t.Errorf("GetShotById(\"\") = %v, %v, want ErrNotFound", shot, err)
+	}
+	if err := md.SetBackendType("missing", "file"); err != spree.ErrNotFound {
+		t.Errorf("SetBackendType(missing) returned %v, want ErrNotFound", err)
+	}
+
+	shots, err := md.ListShots()
+	if err != nil {
+		t.Fatalf("ListShots: %v", err)
+	}
+	if len(shots) != 1 {
+		t.Errorf("updating missing shots created records: ListShots = %v", ids(shots))
+	}
+}
+
+func testListOrder(t *testing.T, md spree.Metadata) {
+	shots, err := md.ListShots()
+	if err != nil {
+		t.Fatalf("ListShots on an empty store: %v", err)
+	}
+	if len(shots) != 0 {
+		t.Fatalf("ListShots on an empty store = %v", ids(shots))
+	}
+
+	for _, id := range []string{"m", "c", "x", "a", "k"} {
+		shot := newShot(md, "someone@example.com")
+		shot.Id = id
+		mustPut(t, md, shot)
+	}
+
+	shots, err = md.ListShots()
+	if err != nil {
+		t.Fatalf("ListShots: %v", err)
+	}
+	if !sameIds(shots, "a", "c", "k", "m", "x") {
+		t.Errorf("ListShots = %v, want ordered by id", ids(shots))
+	}
+	for _, shot := range shots {
+		if want := "/p/" + shot.Id; shot.Path != want {
+			t.Errorf("ListShots returned Path %q, want %q", shot.Path, want)
+		}
+	}
+}
+
+func testListByOwner(t *testing.T, md spree.Metadata) {
+	for i, owner := range []string{"a@example.com", "b@example.com", "A@Example.com", "", "a@example.com"} {
+		shot := newShot(md, owner)
+		shot.Id = fmt.Sprintf("shot%d", 4-i)
+		mustPut(t, md, shot)
+	}
+
+	shots, err := md.ListShotsByOwner("a@example.com")
+	if err != nil {
+		t.Fatalf("ListShotsByOwner: %v", err)
+	}
+	if !sameIds(shots, "shot0", "shot2", "shot4") {
+		t.Errorf("ListShotsByOwner(a@example.com) = %v, want [shot0 shot2 shot4]", ids(shots))
+	}
+
+	shots, err = md.ListShotsByOwner("B@EXAMPLE.COM")
+	if err != nil {
+		t.Fatalf("ListShotsByOwner: %v", err)
+	}
+	if !sameIds(shots, "shot3") {
+		t.Errorf("ListShotsByOwner(B@EXAMPLE.COM) = %v, want [shot3]", ids(shots))
+	}
+
+	shots, err = md.ListShotsByOwner("nobody@example.com")
+	if err != nil {
+		t.Fatalf("ListShotsByOwner: %v", err)
+	}
+	if len(shots) != 0 {
+		t.Errorf("ListShotsByOwner(nobody@example.com) = %v, want none", ids(shots))
+	}
+}
+
+func testListByTag(t *testing.T, md spree.Metadata) {
+	tags := [][]string{{"cats"}, {"cats", "dogs"}, nil, {"dogs"}}
+	for i, tt := range tags {
+		shot := newShot(md, "someone@example.com")
+		shot.Id = fmt.Sprintf("shot%d", 3-i)
+		shot.Tags = tt
+		mustPut(t, md, shot)
+	}
+
+	shots, err := md.ListShotsByTag("cats")
+	if err != nil {
+		t.Fatalf("ListShotsByTag: %v", err)
+	}
+	if !sameIds(shots, "shot2", "shot3") {
+		t.Errorf("ListShotsByTag(cats) = %v, want [shot2 shot3]", ids(shots))
+	}
+
+	// retagging moves the shot between tags
+	shot := mustGet(t, md, "shot2")
+	shot.Tags = []string{"dogs"}
+	mustPut(t, md, shot)
+	if shots, err = md.ListShotsByTag("cats"); err != nil || !sameIds(shots, "shot3") {
+		t.Errorf("ListShotsByTag(cats) after retagging = %v, %v, want [shot3]", ids(shots), err)
+	}
+	if shots, err = md.ListShotsByTag("dogs"); err != nil || !sameIds(shots, "shot0", "shot2") {
+		t.Errorf("ListShotsByTag(dogs) after retagging = %v, %v, want [shot0 shot2]", ids(shots), err)
+	}
+
+	shots, err = md.ListShotsByTag("birds")
+	if err != nil {
+		t.Fatalf("ListShotsByTag: %v", err)
+	}
+	if len(shots) != 0 {
+		t.Errorf("ListShotsByTag(birds) = %v, want none", ids(shots))
+	}
+}
+
+func testUpdateShot(t *testing.T, md spree.Metadata) {
+	shot := newShot(md, "someone@example.com")
+	shot.Tags = []string{"old"}
+	mustPut(t, md, shot)
+	addView(t, md, shot.Id)
+
+	got, err := md.UpdateShot(shot.Id, func(s *spree.Shot) {
+		s.Title = "a title"
+		s.Tags = []string{"new"}
+	})
+	if err != nil {
+		t.Fatalf("UpdateShot: %v", err)
+	}
+	if got.Title != "a title" || got.Views != 1 || got.Id != shot.Id {
+		t.Errorf("UpdateShot returned %v", got)
+	}
+	if stored := mustGet(t, md, shot.Id); !proto.Equal(stored, got) {
+		t.Errorf("stored shot = %v, want %v", stored, got)
+	}
+	if shots, err := md.ListShotsByTag("old"); err != nil || len(shots) != 0 {
+		t.Errorf("ListShotsByTag(old) after UpdateShot = %v, %v, want none", ids(shots), err)
+	}
+	if shots, err := md.ListShotsByTag("new"); err != nil || !sameIds(shots, shot.Id) {
+		t.Errorf("ListShotsByTag(new) after UpdateShot = %v, %v, want [%s]", ids(shots), err, shot.Id)
+	}
+
+	if _, err := md.UpdateShot("missing", func(*spree.Shot) {}); err != spree.ErrNotFound {
+		t.Errorf("UpdateShot(missing) returned %v, want ErrNotFound", err)
+	}
+}
+
+func mustSearch(t *testing.T, md spree.Metadata, query string) []*spree.Shot {
+	q, err := spree.ParseQuery(query)
+	if err != nil {
+		t.Fatalf("ParseQuery(%q): %v", query, err)
+	}
+	shots, err := md.SearchShots(q)
+	if err != nil {
+		t.Fatalf("SearchShots(%q): %v", query, err)
+	}
+	return shots
+}
+
+func testSearch(t *testing.T, md spree.Metadata) {
+	shots := []*spree.Shot{
+		{Id: "shot0", Filename: "2017-03-01_123456789.png", Title: "Login page crash"},
+		{Id: "shot1", Filename: "invoice.pdf", Description: "March invoice for the login service"},
+		{Id: "shot2", Filename: "cat.gif", Tags: []string{"cats", "funny"}},
+		{Id: "shot3", Filename: "logo.svg", Title: "New logo"},
+	}
+	for _, shot := range shots {
+		mustPut(t, md, shot)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"login", []string{"shot0", "shot1"}},
+		{"LOGIN crash", []string{"shot0"}},
+		{"crash OR invoice", []string{"shot0", "shot1"}},
+		{"log*", []string{"shot0", "shot1", "shot3"}},
+		{"cats", []string{"shot2"}},
+		{"png", []string{"shot0"}},
+		{"2017-03-01", []string{"shot0"}},
+		{"dogs", nil},
+		{"login dogs OR logo", []string{"shot3"}},
+	}
+	for _, tt := range tests {
+		if got := mustSearch(t, md, tt.query); !sameIds(got, tt.want...) {
+			t.Errorf("SearchShots(%q) = %v, want %v", tt.query, ids(got), tt.want)
+		}
+	}
+
+	// changes to a shot are searchable straight away
+	if _, err := md.UpdateShot("shot2", func(s *spree.Shot) { s.Tags = []string{"dogs"} }); err != nil {
+		t.Fatalf("UpdateShot: %v", err)
+	}
+	if got := mustSearch(t, md, "cats"); len(got) != 0 {
+		t.Errorf("SearchShots(cats) after retagging = %v, want none", ids(got))
+	}
+	if got := mustSearch(t, md, "dogs"); !sameIds(got, "shot2") {
+		t.Errorf("SearchShots(dogs) after retagging = %v, want [shot2]", ids(got))
+	}
+	shot := mustGet(t, md, "shot3")
+	shot.Title = "Old logo"
+	mustPut(t, md, shot)
+	if got := mustSearch(t, md, "new"); len(got) != 0 {
+		t.Errorf("SearchShots(new) after retitling = %v, want none", ids(got))
+	}
+}
+
+func testDeleteShot(t *testing.T, md spree.Metadata) {
+	shot := newShot(md, "someone@example.com")
+	shot.Title = "doomed"
+	shot.Tags = []string{"gone"}
+	mustPut(t, md, shot)
+	err := md.AddViews(map[string]*spree.ViewStats{shot.Id: {Views: 1}}, time.Now())
+	if err != nil {
+		t.Fatalf("AddViews: %v", err)
+	}
+
+	if err := md.DeleteShot(shot.Id); err != nil {
+		t.Fatalf("DeleteShot: %v", err)
+	}
+	if _, err := md.GetShotById(shot.Id); err != spree.ErrNotFound {
+		t.Errorf("GetShotById after DeleteShot returned %v, want ErrNotFound", err)
+	}
+	if _, err := md.GetViewStats(shot.Id); err != spree.ErrNotFound {
+		t.Errorf("GetViewStats after DeleteShot returned %v, want ErrNotFound", err)
+	}
+	if shots, err := md.ListShotsByOwner("someone@example.com"); err != nil || len(shots) != 0 {
+		t.Errorf("ListShotsByOwner after DeleteShot = %v, %v, want none", ids(shots), err)
+	}
+	if shots, err := md.ListShotsByTag("gone"); err != nil || len(shots) != 0 {
+		t.Errorf("ListShotsByTag after DeleteShot = %v, %v, want none", ids(shots), err)
+	}
+	if got := mustSearch(t, md, "doomed"); len(got) != 0 {
+		t.Errorf("SearchShots after DeleteShot = %v, want none", ids(got))
+	}
+
+	if err := md.DeleteShot(shot.Id); err != spree.ErrNotFound {
+		t.Errorf("second DeleteShot returned %v, want ErrNotFound", err)
+	}
+}
+
+func testConcurrentAddViews(t *testing.T, md spree.Metadata) {
+	a := newShot(md, "someone@example.com")
+	a.Id = "a"
+	mustPut(t, md, a)
+	b := newShot(md, "someone@example.com")
+	b.Id = "b"
+	mustPut(t, md, b)
+
+	const workers, views = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*views)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < views; j++ {
+				batch := map[string]*spree.ViewStats{"a": {Views: 1}, "b": {Views: 1}}
+				if err := md.AddViews(batch, time.Now()); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("AddViews: %v", err)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		if got := mustGet(t, md, id); got.Views != workers*views {
+			t.Errorf("shot %s has %d views, want %d", id, got.Views, workers*views)
+		}
+	}
+}
+
+func testAddViews(t *testing.T, md spree.Metadata) {
+	for _, id := range []string{"a", "b", "c", "d"} {
+		shot := newShot(md, "someone@example.com")
+		shot.Id = id
+		mustPut(t, md, shot)
+	}
+	addView(t, md, "a")
+
+	at := time.Date(2017, 3, 2, 8, 30, 0, 0, time.UTC)
+	err := md.AddViews(map[string]*spree.ViewStats{
+		"a":       {Views: 5},
+		"b":       {Views: 2, CrawlerViews: 1},
+		"c":       {CrawlerViews: 4},
+		"d":       {Reads: 2},
+		"missing": {Views: 3},
+	}, at)
+	if err != nil {
+		t.Fatalf("AddViews: %v", err)
+	}
+
+	want := map[string][2]uint64{"a": {6, 0}, "b": {2, 1}, "c": {0, 4}}
+	for id, views := range want {
+		got := mustGet(t, md, id)
+		if got.Views != views[0] || got.CrawlerViews != views[1] {
+			t.Errorf("shot %s has %d views and %d crawler views, want %d and %d",
+				id, got.Views, got.CrawlerViews, views[0], views[1])
+		}
+		// only people viewing a shot count as it being viewed
+		if viewed := got.LastViewedAt != ""; viewed != (id != "c") {
+			t.Errorf("shot %s LastViewedAt = %q", id, got.LastViewedAt)
+		} else if viewed && got.LastViewedAt != at.Format(time.RFC3339) {
+			t.Errorf("shot %s LastViewedAt = %q, want %q", id, got.LastViewedAt, at.Format(time.RFC3339))
+		}
+	}
+	// reads of the file mark it as in use without counting as a view
+	if got := mustGet(t, md, "d"); got.LastReadAt != at.Format(time.RFC3339) || got.LastViewedAt != "" {
+		t.Errorf("read shot has LastReadAt %q and LastViewedAt %q", got.LastReadAt, got.LastViewedAt)
+	}
+	if got := mustGet(t, md, "a"); got.LastReadAt != "" {
+		t.Errorf("viewed shot has LastReadAt %q", got.LastReadAt)
+	}
+	if _, err := md.GetShotById("missing"); err != spree.ErrNotFound {
+		t.Errorf("AddViews created a missing shot: %v", err)
+	}
+
+	if err := md.AddViews(nil, at); err != nil {
+		t.Errorf("AddViews with no views: %v", err)
+	}
+}
+
+func counts(kv ...interface{}) []*spree.ViewCount {
+	var out []*spree.ViewCount
+	for i := 0; i < len(kv); i += 2 {
+		out = append(out, &spree.ViewCount{Key: kv[i].(string), Views: uint64(kv[i+1].(int))})
+	}
+	return out
+}
+
+func sameCounts(got, want []*spree.ViewCount) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !proto.Equal(got[i], want[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func testGetViewStats(t *testing.T, md spree.Metadata) {
+	shot := newShot(md, "someone@example.com")
+	mustPut(t, md, shot)
+
+	if _, err := md.GetViewStats("missing"); err != spree.ErrNotFound {
+		t.Errorf("GetViewStats(missing) returned %v, want ErrNotFound", err)
+	}
+	stats, err := md.GetViewStats(shot.Id)
+	if err != nil {
+		t.Fatalf("GetViewStats: %v", err)
+	}
+	if stats.Views != 0 || len(stats.Daily) != 0 {
+		t.Errorf("GetViewStats of an unviewed shot = %v, want empty stats", stats)
+	}
+
+	at := time.Now()
+	batches := []*spree.ViewStats{
+		{
+			Views:     3,
+			Daily:     counts("2017-03-01", 1, "2017-03-02", 2),
+			Referrers: counts("", 1, "chat.example.com", 2),
+			Agents:    counts("desktop", 2, "link preview", 1),
+		},
+		{
+			Views:     2,
+			Daily:     counts("2017-03-02", 1, "2017-03-03", 1),
+			Referrers: counts("chat.example.com", 1, "mail.example.com", 1),
+			Agents:    counts("mobile", 2),
+		},
+	}
+	for _, batch := range batches {
+		if err := md.AddViews(map[string]*spree.ViewStats{shot.Id: batch}, at); err != nil {
+			t.Fatalf("AddViews: %v", err)
+		}
+	}
+
+	stats, err = md.GetViewStats(shot.Id)
+	if err != nil {
+		t.Fatalf("GetViewStats: %v", err)
+	}
+	if stats.Views != 5 {
+		t.Errorf("stats have %d views, want 5", stats.Views)
+	}
+	if want := counts("2017-03-01", 1, "2017-03-02", 3, "2017-03-03", 1); !sameCounts(stats.Daily, want) {
+		t.Errorf("Daily = %v, want %v", stats.Daily, want)
+	}
+	if want := counts("", 1, "chat.example.com", 3, "mail.example.com", 1); !sameCounts(stats.Referrers, want) {
+		t.Errorf("Referrers = %v, want %v", stats.Referrers, want)
+	}
+	if want := counts("desktop", 2, "link preview", 1, "mobile", 2); !sameCounts(stats.Agents, want) {
+		t.Errorf("Agents = %v, want %v", stats.Agents, want)
+	}
+}
+
+func testSetBackendType(t *testing.T, md spree.Metadata) {
+	shot := newShot(md, "someone@example.com")
+	mustPut(t, md, shot)
+	addView(t, md, shot.Id)
+
+	if err := md.SetBackendType(shot.Id, "cold:file"); err != nil {
+		t.Fatalf("SetBackendType: %v", err)
+	}
+
+	got := mustGet(t, md, shot.Id)
+	if got.Backend == nil || got.Backend.Type != "cold:file" {
+		t.Errorf("Backend = %v, want type cold:file", got.Backend)
+	}
+	if got.Views != 1 {
+		t.Errorf("SetBackendType changed the views to %d", got.Views)
+	}
+}
+
+func testAlbums(t *testing.T, md spree.Metadata) {
+	album := &spree.Album{
+		Id:      "album0",
+		Owner:   "someone@example.com",
+		Title:   "incident",
+		ShotIds: []string{"c", "a", "b"},
+	}
+	if err := md.PutAlbum(album); err != nil {
+		t.Fatalf("PutAlbum: %v", err)
+	}
+	if want := "/a/album0"; album.Path != want {
+		t.Errorf("PutAlbum set Path to %q, want %q", album.Path, want)
+	}
+
+	got, err := md.GetAlbumById("album0")
+	if err != nil {
+		t.Fatalf("GetAlbumById: %v", err)
+	}
+	if !proto.Equal(got, album) {
+		t.Errorf("GetAlbumById = %v, want %v", got, album)
+	}
+
+	got, err = md.UpdateAlbum("album0", func(a *spree.Album) error {
+		a.ShotIds = append(a.ShotIds, "d")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateAlbum: %v", err)
+	}
+	want := []string{"c", "a", "b", "d"}
+	if fmt.Sprint(got.ShotIds) != fmt.Sprint(want) {
+		t.Errorf("UpdateAlbum returned shots %v, want %v", got.ShotIds, want)
+	}
+
+	// a failed update leaves the album as it was
+	errBad := fmt.Errorf("bad update")
+	_, err = md.UpdateAlbum("album0", func(a *spree.Album) error {
+		a.ShotIds = nil
+		return errBad
+	})
+	if err != errBad {
+		t.Errorf("UpdateAlbum returned %v, want the error from fn", err)
+	}
+	if got, err := md.GetAlbumById("album0"); err != nil || fmt.Sprint(got.ShotIds) != fmt.Sprint(want) {
+		t.Errorf("album after failed update = %v, %v, want shots %v", got, err, want)
+	}
+
+	if _, err := md.GetAlbumById("missing"); err != spree.ErrAlbumNotFound {
+		t.Errorf("GetAlbumById(missing) returned %v, want ErrAlbumNotFound", err)
+	}
+	if _, err := md.UpdateAlbum("missing", func(*spree.Album) error { return nil }); err != spree.ErrAlbumNotFound {
+		t.Errorf("UpdateAlbum(missing) returned %v, want ErrAlbumNotFound", err)
+	}
+}
+
+func testClose(t *testing.T, md spree.Metadata) {
+	shot := newShot(md, "someone@example.com")
+	mustPut(t, md, shot)
+
+	if err := md.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if err := md.PutShot(newShot(md, "someone@example.com")); err == nil {
+		t.Error("PutShot succeeded after Close")
+	}
+	if _, err := md.GetShotById(shot.Id); err == nil {
+		t.Error("GetShotById succeeded after Close")
+	}
+	if _, err := md.ListShots(); err == nil {
+		t.Error("ListShots succeeded after Close")
+	}
+	if err := md.AddViews(map[string]*spree.ViewStats{shot.Id: {Views: 1}}, time.Now()); err == nil {
+		t.Error("AddViews succeeded after Close")
+	}
+}
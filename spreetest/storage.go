@@ -0,0 +1,363 @@
+package spreetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/ralfonso/spree"
+	"golang.org/x/net/context"
+)
+
+// NewStorage returns an empty Storage for a single test. cleanup is called
+// once the test is done and may be nil.
+type NewStorage func(t *testing.T) (storage spree.Storage, cleanup func())
+
+// TestStorage runs the Storage conformance suite against the backends
+// returned by newStorage. Keys are plain file names, the most any backend
+// accepts.
+func TestStorage(t *testing.T, newStorage NewStorage) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, spree.Storage)
+	}{
+		{"PutGet", testBlobPutGet},
+		{"PutEmpty", testBlobPutEmpty},
+		{"PutReplaces", testBlobPutReplaces},
+		{"PutReadError", testBlobPutReadError},
+		{"GetRange", testBlobGetRange},
+		{"Stat", testBlobStat},
+		{"Missing", testBlobMissing},
+		{"ListOrder", testBlobListOrder},
+		{"ListPrefix", testBlobListPrefix},
+		{"Delete", testBlobDelete},
+		{"ConcurrentPut", testBlobConcurrentPut},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			storage, cleanup := newStorage(t)
+			if cleanup != nil {
+				defer cleanup()
+			}
+			tt.fn(t, storage)
+		})
+	}
+}
+
+// blob returns n bytes that differ from segment to segment so misplaced
+// ranges are caught.
+func blob(n int, seed byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = seed + byte(i*7) + byte(i>>10)
+	}
+	return b
+}
+
+func put(t *testing.T, storage spree.Storage, key string, data []byte) {
+	n, err := storage.Put(context.Background(), key, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Put(%s): %v", key, err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("Put(%s) stored %d bytes, want %d", key, n, len(data))
+	}
+}
+
+func get(t *testing.T, storage spree.Storage, key string, offset, length int64) []byte {
+	rc, err := storage.Get(context.Background(), key, offset, length)
+	if err != nil {
+		t.Fatalf("Get(%s, %d, %d): %v", key, offset, length, err)
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading Get(%s, %d, %d): %v", key, offset, length, err)
+	}
+	return data
+}
+
+func keys(infos []*spree.ObjectInfo) []string {
+	out := make([]string, len(infos))
+	for i, info := range infos {
+		out[i] = info.Key
+	}
+	return out
+}
+
+func sameKeys(got []*spree.ObjectInfo, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i].Key != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testBlobPutGet(t *testing.T, storage spree.Storage) {
+	data := blob(200*1024+17, 1)
+	put(t, storage, "shot.png", data)
+
+	if got := get(t, storage, "shot.png", 0, -1); !bytes.Equal(got, data) {
+		t.Errorf("Get returned %d bytes that don't match the %d stored", len(got), len(data))
+	}
+}
+
+func testBlobPutEmpty(t *testing.T, storage spree.Storage) {
+	put(t, storage, "empty", nil)
+
+	if got := get(t, storage, "empty", 0, -1); len(got) != 0 {
+		t.Errorf("Get of an empty blob returned %d bytes", len(got))
+	}
+	info, err := storage.Stat(context.Background(), "empty")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size != 0 {
+		t.Errorf("Stat of an empty blob has size %d", info.Size)
+	}
+}
+
+func testBlobPutReplaces(t *testing.T, storage spree.Storage) {
+	put(t, storage, "shot.png", blob(100*1024, 1))
+	data := blob(10, 2)
+	put(t, storage, "shot.png", data)
+
+	if got := get(t, storage, "shot.png", 0, -1); !bytes.Equal(got, data) {
+		t.Errorf("Get after replace = %v, want %v", got, data)
+	}
+	infos, err := storage.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if !sameKeys(infos, "shot.png") {
+		t.Errorf("List after replace = %v, want [shot.png]", keys(infos))
+	}
+}
+
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+// testBlobPutReadError checks that an upload that fails part way doesn't leave
+// a partial blob or replace the existing one.
+func testBlobPutReadError(t *testing.T, storage spree.Storage) {
+	data := blob(1000, 1)
+	put(t, storage, "existing", data)
+
+	errBroken := errors.New("connection reset")
+	for _, key := range []string{"existing", "new"} {
+		r := &failingReader{r: bytes.NewReader(blob(100*1024, 2)), err: errBroken}
+		if _, err := storage.Put(context.Background(), key, r); err == nil {
+			t.Errorf("Put(%s) succeeded although reading failed", key)
+		}
+	}
+
+	if _, err := storage.Stat(context.Background(), "new"); !os.IsNotExist(err) {
+		t.Errorf("failed Put left a blob behind: Stat returned %v", err)
+	}
+	if got := get(t, storage, "existing", 0, -1); !bytes.Equal(got, data) {
+		t.Errorf("failed Put replaced the existing blob")
+	}
+}
+
+func testBlobGetRange(t *testing.T, storage spree.Storage) {
+	data := blob(150*1024+3, 1)
+	put(t, storage, "shot.png", data)
+	size := int64(len(data))
+
+	ranges := []struct {
+		offset, length int64
+	}{
+		{0, 1},
+		{0, 100},
+		{10, 100},
+		{64*1024 - 5, 10},
+		{64 * 1024, 64 * 1024},
+		{100, -1},
+		{size - 1, -1},
+		{size - 10, 100},
+		{size, -1},
+		{0, size},
+	}
+	for _, r := range ranges {
+		end := size
+		if r.length >= 0 && r.offset+r.length < size {
+			end = r.offset + r.length
+		}
+		want := data[r.offset:end]
+		if got := get(t, storage, "shot.png", r.offset, r.length); !bytes.Equal(got, want) {
+			t.Errorf("Get(%d, %d) returned %d bytes that don't match the %d expected",
+				r.offset, r.length, len(got), len(want))
+		}
+	}
+}
+
+func testBlobStat(t *testing.T, storage spree.Storage) {
+	data := blob(12345, 1)
+	put(t, storage, "shot.png", data)
+
+	info, err := storage.Stat(context.Background(), "shot.png")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Key != "shot.png" {
+		t.Errorf("Stat returned key %q, want shot.png", info.Key)
+	}
+	if info.Size != int64(len(data)) {
+		t.Errorf("Stat returned size %d, want %d", info.Size, len(data))
+	}
+	if info.ModTime.IsZero() {
+		t.Error("Stat returned no ModTime")
+	}
+}
+
+func testBlobMissing(t *testing.T, storage spree.Storage) {
+	put(t, storage, "present", blob(10, 1))
+	ctx := context.Background()
+
+	if _, err := storage.Get(ctx, "missing", 0, -1); !os.IsNotExist(err) {
+		t.Errorf("Get(missing) returned %v, want a not exist error", err)
+	}
+	if _, err := storage.Get(ctx, "missing", 5, 10); !os.IsNotExist(err) {
+		t.Errorf("ranged Get(missing) returned %v, want a not exist error", err)
+	}
+	if _, err := storage.Stat(ctx, "missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat(missing) returned %v, want a not exist error", err)
+	}
+	if err := storage.Delete(ctx, "missing"); !os.IsNotExist(err) {
+		t.Errorf("Delete(missing) returned %v, want a not exist error", err)
+	}
+}
+
+func testBlobListOrder(t *testing.T, storage spree.Storage) {
+	infos, err := storage.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("List on empty storage: %v", err)
+	}
+	if len(infos) != 0 {
+		t.Fatalf("List on empty storage = %v", keys(infos))
+	}
+
+	sizes := map[string]int{"m.png": 3, "c.png": 1, "x.png": 4, "a.png": 0, "k.png": 2}
+	for _, key := range []string{"m.png", "c.png", "x.png", "a.png", "k.png"} {
+		put(t, storage, key, blob(sizes[key]*1000, 1))
+	}
+
+	infos, err = storage.List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if !sameKeys(infos, "a.png", "c.png", "k.png", "m.png", "x.png") {
+		t.Fatalf("List = %v, want ordered by key", keys(infos))
+	}
+	for _, info := range infos {
+		if want := int64(sizes[info.Key] * 1000); info.Size != want {
+			t.Errorf("List reported %s with size %d, want %d", info.Key, info.Size, want)
+		}
+	}
+}
+
+func testBlobListPrefix(t *testing.T, storage spree.Storage) {
+	for _, key := range []string{"ab2", "b1", "ab1", "a", "abc", "ba"} {
+		put(t, storage, key, blob(10, 1))
+	}
+
+	cases := []struct {
+		prefix string
+		want   []string
+	}{
+		{"a", []string{"a", "ab1", "ab2", "abc"}},
+		{"ab", []string{"ab1", "ab2", "abc"}},
+		{"b", []string{"b1", "ba"}},
+		{"abc", []string{"abc"}},
+		{"z", nil},
+	}
+	for _, c := range cases {
+		infos, err := storage.List(context.Background(), c.prefix)
+		if err != nil {
+			t.Fatalf("List(%s): %v", c.prefix, err)
+		}
+		if !sameKeys(infos, c.want...) {
+			t.Errorf("List(%s) = %v, want %v", c.prefix, keys(infos), c.want)
+		}
+	}
+}
+
+func testBlobDelete(t *testing.T, storage spree.Storage) {
+	put(t, storage, "keep", blob(10, 1))
+	put(t, storage, "remove", blob(10, 2))
+	ctx := context.Background()
+
+	if err := storage.Delete(ctx, "remove"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := storage.Stat(ctx, "remove"); !os.IsNotExist(err) {
+		t.Errorf("Stat after Delete returned %v, want a not exist error", err)
+	}
+	if _, err := storage.Get(ctx, "remove", 0, -1); !os.IsNotExist(err) {
+		t.Errorf("Get after Delete returned %v, want a not exist error", err)
+	}
+
+	infos, err := storage.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if !sameKeys(infos, "keep") {
+		t.Errorf("List after Delete = %v, want [keep]", keys(infos))
+	}
+
+	// a deleted key can be written again
+	put(t, storage, "remove", blob(5, 3))
+	if got := get(t, storage, "remove", 0, -1); !bytes.Equal(got, blob(5, 3)) {
+		t.Error("Get after re-Put returned the wrong data")
+	}
+}
+
+func testBlobConcurrentPut(t *testing.T, storage spree.Storage) {
+	const n = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("shot%02d", i)
+			if _, err := storage.Put(context.Background(), key, bytes.NewReader(blob(70*1024, byte(i)))); err != nil {
+				errs <- fmt.Errorf("Put(%s): %v", key, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("shot%02d", i)
+		if got := get(t, storage, key, 0, -1); !bytes.Equal(got, blob(70*1024, byte(i))) {
+			t.Errorf("%s doesn't hold the data written to it", key)
+		}
+	}
+}
@@ -1,16 +1,125 @@
 package spree
 
-import "io"
+import (
+	"io"
+	"time"
 
-type File interface {
-	io.Reader
-	io.Writer
-	io.Seeker
-	io.Closer
+	"golang.org/x/net/context"
+)
+
+// ObjectInfo describes a stored blob.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
 }
 
+// Storage stores blobs by key. Missing keys are reported with an error for
+// which os.IsNotExist is true.
 type Storage interface {
-	Open(filename string) (File, error)
-	Create(filename string) (File, error)
-	Remove(filename string) error
+	// Put stores everything read from r under key, replacing any existing
+	// blob, and returns the number of bytes stored.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Get returns length bytes of the blob starting at offset. A negative
+	// length reads to the end.
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// List returns the blobs whose keys start with prefix, ordered by key.
+	List(ctx context.Context, prefix string) ([]*ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Presigner is implemented by storage that can hand out temporary URLs for
+// reading a file straight from the backend.
+type Presigner interface {
+	PresignGet(key string, expires time.Duration) (string, error)
+}
+
+// Locator is implemented by storage that can name the backend holding a
+// blob. The name is recorded in BackendDetails.
+type Locator interface {
+	Locate(ctx context.Context, key string) (string, error)
+}
+
+// backendType names where key is stored, or "unknown" if the storage can't
+// say.
+func backendType(ctx context.Context, storage Storage, key string) string {
+	l, ok := storage.(Locator)
+	if !ok {
+		return "unknown"
+	}
+	typ, err := l.Locate(ctx, key)
+	if err != nil {
+		return "unknown"
+	}
+	return typ
+}
+
+// blobReader adapts ranged Gets to an io.ReadSeeker, which lets
+// http.ServeContent handle Range and conditional requests for any Storage.
+type blobReader struct {
+	ctx     context.Context
+	storage Storage
+	key     string
+	size    int64
+	off     int64
+	rc      io.ReadCloser
+}
+
+func newBlobReader(ctx context.Context, storage Storage, info *ObjectInfo) *blobReader {
+	return &blobReader{
+		ctx:     ctx,
+		storage: storage,
+		key:     info.Key,
+		size:    info.Size,
+	}
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	if b.off >= b.size {
+		return 0, io.EOF
+	}
+	if b.rc == nil {
+		rc, err := b.storage.Get(b.ctx, b.key, b.off, -1)
+		if err != nil {
+			return 0, err
+		}
+		b.rc = rc
+	}
+
+	n, err := b.rc.Read(p)
+	b.off += int64(n)
+	return n, err
+}
+
+func (b *blobReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = b.off + offset
+	case io.SeekEnd:
+		abs = b.size + offset
+	default:
+		return 0, errInvalidWhence
+	}
+	if abs < 0 {
+		return 0, errNegativeOffset
+	}
+	if abs != b.off && b.rc != nil {
+		b.rc.Close()
+		b.rc = nil
+	}
+	b.off = abs
+	return abs, nil
+}
+
+func (b *blobReader) Close() error {
+	if b.rc == nil {
+		return nil
+	}
+	err := b.rc.Close()
+	b.rc = nil
+	return err
 }
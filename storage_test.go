@@ -0,0 +1,121 @@
+package spree_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+	"github.com/ralfonso/spree/spreetest"
+	"golang.org/x/net/context"
+)
+
+func TestFileStorage(t *testing.T) {
+	spreetest.TestStorage(t, func(t *testing.T) (spree.Storage, func()) {
+		dir, err := ioutil.TempDir("", "spree-file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		storage, err := spree.NewFileStorage(dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+		return storage, func() { os.RemoveAll(dir) }
+	})
+}
+
+func TestFileStorageSweepTempFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spree-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage, err := spree.NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, key := range []string{"abc-new.png", "def-old.png"} {
+		if _, err := storage.Put(context.Background(), key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{".upload-stale", ".upload-fresh"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("partial"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, ".upload-dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"def-old.png", ".upload-stale", ".upload-dir"} {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := storage.SweepTempFiles(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("swept %d files, want 1", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".upload-stale")); !os.IsNotExist(err) {
+		t.Errorf("stale temp file is still there: %v", err)
+	}
+	for _, name := range []string{".upload-fresh", ".upload-dir", "abc-new.png", "def-old.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was swept: %v", name, err)
+		}
+	}
+	for _, key := range []string{"abc-new.png", "def-old.png"} {
+		if got := readFile(t, storage, key); got != key {
+			t.Errorf("%s holds %q after the sweep", key, got)
+		}
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	spreetest.TestStorage(t, func(t *testing.T) (spree.Storage, func()) {
+		return spree.NewMemoryStorage(), nil
+	})
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	master, err := spree.NewMasterKey(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spreetest.TestStorage(t, func(t *testing.T) (spree.Storage, func()) {
+		return spree.NewEncryptedStorage(spree.NewMemoryStorage(), master, nil, zap.NewNop()), nil
+	})
+}
+
+func TestTieredStorage(t *testing.T) {
+	spreetest.TestStorage(t, func(t *testing.T) (spree.Storage, func()) {
+		return spree.NewTieredStorage(spree.NewMemoryStorage(), spree.NewMemoryStorage(), zap.NewNop()), nil
+	})
+}
+
+func TestReplicatedStorage(t *testing.T) {
+	spreetest.TestStorage(t, func(t *testing.T) (spree.Storage, func()) {
+		replicas := []spree.Replica{
+			{Name: "a", Storage: spree.NewMemoryStorage()},
+			{Name: "b", Storage: spree.NewMemoryStorage()},
+			{Name: "c", Storage: spree.NewMemoryStorage()},
+		}
+		storage, err := spree.NewReplicatedStorage(replicas, 2, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return storage, nil
+	})
+}
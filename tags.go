@@ -0,0 +1,67 @@
+package spree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxTitleLen       = 200
+	maxDescriptionLen = 4000
+	maxTags           = 20
+	maxTagLen         = 50
+)
+
+// normalizeTags lowercases and trims tags, drops empty and repeated ones,
+// and sorts the rest. Tags may not contain whitespace, commas or slashes.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLen {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLen)
+		}
+		if i := strings.IndexFunc(tag, func(r rune) bool {
+			return unicode.IsSpace(r) || r == ',' || r == '/'
+		}); i >= 0 {
+			return nil, fmt.Errorf("tag %q may not contain %q", tag, tag[i:i+1])
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("a shot can have at most %d tags", maxTags)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// checkShotText checks the title and description given for a shot.
+func checkShotText(title, description string) error {
+	if utf8.RuneCountInString(title) > maxTitleLen {
+		return fmt.Errorf("title is longer than %d characters", maxTitleLen)
+	}
+	if strings.ContainsAny(title, "\r\n") {
+		return fmt.Errorf("title may not span lines")
+	}
+	if utf8.RuneCountInString(description) > maxDescriptionLen {
+		return fmt.Errorf("description is longer than %d characters", maxDescriptionLen)
+	}
+	return nil
+}
+
+func hasTag(shot *Shot, tag string) bool {
+	for _, t := range shot.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package spree
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want []string
+		ok   bool
+	}{
+		{nil, []string{}, true},
+		{[]string{" Dogs", "cats", "dogs", ""}, []string{"cats", "dogs"}, true},
+		{[]string{"two words"}, nil, false},
+		{[]string{"a,b"}, nil, false},
+		{[]string{"a/b"}, nil, false},
+		{[]string{strings.Repeat("x", maxTagLen+1)}, nil, false},
+	}
+	for _, tt := range tests {
+		got, err := normalizeTags(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("normalizeTags(%q) error = %v", tt.in, err)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeTags(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	many := make([]string, maxTags+1)
+	for i := range many {
+		many[i] = strings.Repeat("t", i+1)
+	}
+	if _, err := normalizeTags(many); err == nil {
+		t.Errorf("normalizeTags accepted %d tags", len(many))
+	}
+}
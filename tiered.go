@@ -0,0 +1,360 @@
+package spree
+
+import (
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+)
+
+const (
+	hotTier  = "hot"
+	coldTier = "cold"
+
+	// promotions run in the background, bounded so a burst of reads from
+	// the cold tier doesn't pin the request contexts
+	promoteTimeout = 10 * time.Minute
+)
+
+// TieredStorage writes new blobs to a fast hot backend and keeps blobs that
+// have gone cold on a cheaper one. Reads check the hot tier first. A blob
+// read from the cold tier is served from there and copied back to the hot
+// tier in the background.
+//
+// Puts, deletes and moves of a key hold its lock, so a move can't copy an
+// old blob over a new one or bring back a deleted one.
+type TieredStorage struct {
+	ll   *zap.Logger
+	hot  Storage
+	cold Storage
+
+	mu        sync.Mutex
+	promoting map[string]bool
+	locks     map[string]*keyLock
+}
+
+// keyLock is held while a key is written. refs counts the holders and
+// waiters so it can be dropped once unused.
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+var _ Storage = &TieredStorage{}
+var _ Locator = &TieredStorage{}
+
+func NewTieredStorage(hot, cold Storage, ll *zap.Logger) *TieredStorage {
+	return &TieredStorage{
+		ll:        ll,
+		hot:       hot,
+		cold:      cold,
+		promoting: make(map[string]bool),
+		locks:     make(map[string]*keyLock),
+	}
+}
+
+// lock locks key and returns the function that unlocks it.
+func (t *TieredStorage) lock(key string) func() {
+	t.mu.Lock()
+	l := t.locks[key]
+	if l == nil {
+		l = &keyLock{}
+		t.locks[key] = l
+	}
+	l.refs++
+	t.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		t.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(t.locks, key)
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *TieredStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	defer t.lock(key)()
+
+	n, err := t.hot.Put(ctx, key, r)
+	if err != nil {
+		return n, err
+	}
+
+	// an older copy in the cold tier would come back if the new one were
+	// demoted and the demotion failed part way
+	if err := t.cold.Delete(ctx, key); err != nil && !os.IsNotExist(err) {
+		t.ll.Warn("unable to remove stale cold copy", zap.String("key", key), zap.Error(err))
+	}
+	return n, nil
+}
+
+func (t *TieredStorage) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := t.hot.Get(ctx, key, offset, length)
+	if err == nil || !os.IsNotExist(err) {
+		return rc, err
+	}
+
+	rc, err = t.cold.Get(ctx, key, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	t.promote(key)
+	return rc, nil
+}
+
+func (t *TieredStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := t.hot.Stat(ctx, key)
+	if err == nil || !os.IsNotExist(err) {
+		return info, err
+	}
+	return t.cold.Stat(ctx, key)
+}
+
+// List merges both tiers. A blob caught mid-move is listed once, from the
+// hot tier.
+func (t *TieredStorage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	hot, err := t.hot.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	cold, err := t.cold.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(hot))
+	infos := make([]*ObjectInfo, 0, len(hot)+len(cold))
+	for _, info := range hot {
+		seen[info.Key] = true
+		infos = append(infos, info)
+	}
+	for _, info := range cold {
+		if !seen[info.Key] {
+			infos = append(infos, info)
+		}
+	}
+	sort.Sort(byKey(infos))
+	return infos, nil
+}
+
+func (t *TieredStorage) Delete(ctx context.Context, key string) error {
+	defer t.lock(key)()
+
+	herr := t.hot.Delete(ctx, key)
+	if herr != nil && !os.IsNotExist(herr) {
+		return herr
+	}
+	cerr := t.cold.Delete(ctx, key)
+	if cerr != nil && !os.IsNotExist(cerr) {
+		return cerr
+	}
+	if herr != nil && cerr != nil {
+		return herr
+	}
+	return nil
+}
+
+// Locate reports the tier and the backend within it, e.g. "hot:file".
+func (t *TieredStorage) Locate(ctx context.Context, key string) (string, error) {
+	if _, err := t.hot.Stat(ctx, key); err == nil {
+		return hotTier + ":" + backendType(ctx, t.hot, key), nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if _, err := t.cold.Stat(ctx, key); err != nil {
+		return "", err
+	}
+	return coldTier + ":" + backendType(ctx, t.cold, key), nil
+}
+
+// Demote moves a blob to the cold tier. The blob is copied before the hot
+// copy is removed, so it stays readable throughout.
+func (t *TieredStorage) Demote(ctx context.Context, key string) error {
+	return t.move(ctx, key, t.hot, t.cold)
+}
+
+// Promote moves a blob back to the hot tier.
+func (t *TieredStorage) Promote(ctx context.Context, key string) error {
+	return t.move(ctx, key, t.cold, t.hot)
+}
+
+func (t *TieredStorage) move(ctx context.Context, key string, from, to Storage) error {
+	defer t.lock(key)()
+
+	rc, err := from.Get(ctx, key, 0, -1)
+	if err != nil {
+		return err
+	}
+	_, err = to.Put(ctx, key, rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	return from.Delete(ctx, key)
+}
+
+// promote starts a background promotion of key unless one is running.
+func (t *TieredStorage) promote(key string) {
+	t.mu.Lock()
+	if t.promoting[key] {
+		t.mu.Unlock()
+		return
+	}
+	t.promoting[key] = true
+	t.mu.Unlock()
+
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.promoting, key)
+			t.mu.Unlock()
+		}()
+
+		ll := t.ll.With(zap.String("key", key))
+		ctx, cancel := context.WithTimeout(context.Background(), promoteTimeout)
+		defer cancel()
+		err := t.Promote(ctx, key)
+		if os.IsNotExist(err) {
+			// replaced or deleted since it was read
+			ll.Debug("blob left the cold tier before it was promoted")
+			return
+		}
+		if err != nil {
+			ll.Error("unable to promote blob to the hot tier", zap.Error(err))
+			return
+		}
+		ll.Info("promoted blob to the hot tier")
+	}()
+}
+
+// TierStats counts what a migration pass did.
+type TierStats struct {
+	Demoted    int
+	Reconciled int
+	Failed     int
+}
+
+// TierMigrator demotes the files of shots that haven't been viewed recently
+// and keeps BackendDetails in line with where each file actually is.
+type TierMigrator struct {
+	ll        *zap.Logger
+	md        Metadata
+	tiers     *TieredStorage
+	coldAfter time.Duration
+}
+
+func NewTierMigrator(md Metadata, tiers *TieredStorage, coldAfter time.Duration, ll *zap.Logger) *TierMigrator {
+	return &TierMigrator{
+		ll:        ll,
+		md:        md,
+		tiers:     tiers,
+		coldAfter: coldAfter,
+	}
+}
+
+// Run makes a single pass over every shot. A shot is cold when its last
+// view or read, or its upload if it has never been viewed, is older than
+// coldAfter.
+// Errors with individual shots are logged and counted; only listing the
+// shots fails the pass.
+func (m *TierMigrator) Run(ctx context.Context) (TierStats, error) {
+	var stats TierStats
+
+	shots, err := m.md.ListShots()
+	if err != nil {
+		return stats, err
+	}
+
+	cutoff := time.Now().Add(-m.coldAfter)
+	for _, shot := range shots {
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+		key := shotKey(shot)
+		ll := m.ll.With(zap.String("id", shot.Id), zap.String("key", key))
+
+		if lastActive(shot).Before(cutoff) {
+			if _, err := m.tiers.hot.Stat(ctx, key); err == nil {
+				if err := m.tiers.Demote(ctx, key); err != nil {
+					ll.Error("unable to demote shot", zap.Error(err))
+					stats.Failed++
+					continue
+				}
+				ll.Info("demoted shot to the cold tier")
+				stats.Demoted++
+			}
+		}
+
+		// files are promoted as they are read, so catch up on those too
+		typ, err := m.tiers.Locate(ctx, key)
+		if err != nil {
+			ll.Warn("unable to locate shot file", zap.Error(err))
+			stats.Failed++
+			continue
+		}
+		if shot.Backend != nil && shot.Backend.Type == typ {
+			continue
+		}
+		if err := m.md.SetBackendType(shot.Id, typ); err != nil {
+			ll.Error("unable to record shot backend", zap.Error(err))
+			stats.Failed++
+			continue
+		}
+		stats.Reconciled++
+	}
+
+	return stats, nil
+}
+
+// Loop runs a pass every interval until ctx is done.
+func (m *TierMigrator) Loop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := m.Run(ctx)
+		if err != nil {
+			m.ll.Error("tier migration failed", zap.Error(err))
+		} else {
+			m.ll.Info("tier migration complete",
+				zap.Int("demoted", stats.Demoted),
+				zap.Int("reconciled", stats.Reconciled),
+				zap.Int("failed", stats.Failed))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lastActive is when a shot was last viewed or its file was read, falling
+// back to its upload for shots that have never been viewed or were viewed
+// before view times were recorded.
+func lastActive(shot *Shot) time.Time {
+	var last time.Time
+	for _, at := range []string{shot.LastViewedAt, shot.LastReadAt} {
+		if t, err := time.Parse(time.RFC3339, at); err == nil && t.After(last) {
+			last = t
+		}
+	}
+	if !last.IsZero() {
+		return last
+	}
+	t, err := time.Parse(time.RFC3339, shot.CreatedAt)
+	if err != nil {
+		// keep shots with unreadable times where they are
+		return time.Now()
+	}
+	return t
+}
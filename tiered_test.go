@@ -0,0 +1,157 @@
+package spree_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+	"golang.org/x/net/context"
+)
+
+func stored(t *testing.T, s spree.Storage, key string) bool {
+	_, err := s.Stat(context.Background(), key)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestTieredStorageMove(t *testing.T) {
+	ctx := context.Background()
+	hot, cold := spree.NewMemoryStorage(), spree.NewMemoryStorage()
+	tiers := spree.NewTieredStorage(hot, cold, zap.NewNop())
+	putRaw(t, tiers, "shot", []byte("pixels"))
+
+	if err := tiers.Demote(ctx, "shot"); err != nil {
+		t.Fatal(err)
+	}
+	if stored(t, hot, "shot") || !stored(t, cold, "shot") {
+		t.Error("demoted blob isn't only in the cold tier")
+	}
+	if err := tiers.Promote(ctx, "shot"); err != nil {
+		t.Fatal(err)
+	}
+	if !stored(t, hot, "shot") || stored(t, cold, "shot") {
+		t.Error("promoted blob isn't only in the hot tier")
+	}
+
+	// reading a cold blob promotes it in the background
+	if err := tiers.Demote(ctx, "shot"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, tiers, "shot"); got != "pixels" {
+		t.Errorf("read %q from the cold tier", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !stored(t, hot, "shot") || stored(t, cold, "shot") {
+		if time.Now().After(deadline) {
+			t.Fatal("blob read from the cold tier wasn't promoted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := readFile(t, tiers, "shot"); got != "pixels" {
+		t.Errorf("read %q after promotion", got)
+	}
+}
+
+// gatedStorage signals started and waits for release before each Put.
+type gatedStorage struct {
+	spree.Storage
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *gatedStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return s.Storage.Put(ctx, key, r)
+}
+
+func TestTieredStorageConcurrentPut(t *testing.T) {
+	ctx := context.Background()
+	hot := spree.NewMemoryStorage()
+	cold := &gatedStorage{
+		Storage: spree.NewMemoryStorage(),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	tiers := spree.NewTieredStorage(hot, cold, zap.NewNop())
+	putRaw(t, tiers, "shot", []byte("old"))
+
+	demoted := make(chan error)
+	go func() { demoted <- tiers.Demote(ctx, "shot") }()
+	<-cold.started
+
+	// a new blob arrives while the old one is being copied to the cold tier
+	put := make(chan error)
+	go func() {
+		_, err := tiers.Put(ctx, "shot", bytes.NewReader([]byte("new")))
+		put <- err
+	}()
+	select {
+	case err := <-put:
+		t.Fatalf("put finished during the demotion: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(cold.release)
+	if err := <-demoted; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-put; err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, tiers, "shot"); got != "new" {
+		t.Errorf("read %q after a put that raced a demotion", got)
+	}
+	if stored(t, cold, "shot") {
+		t.Error("old blob was left in the cold tier")
+	}
+}
+
+func TestTierMigratorReads(t *testing.T) {
+	ctx := context.Background()
+	md := spree.NewMemoryMetadata()
+	hot, cold := spree.NewMemoryStorage(), spree.NewMemoryStorage()
+	tiers := spree.NewTieredStorage(hot, cold, zap.NewNop())
+
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	for _, id := range []string{"idle", "embedded"} {
+		shot := &spree.Shot{Id: id, Filename: "shot.png", StorageKey: id + "-shot.png", CreatedAt: old}
+		if err := md.PutShot(shot); err != nil {
+			t.Fatal(err)
+		}
+		putRaw(t, tiers, shot.StorageKey, []byte(id))
+	}
+
+	// the embedded shot's file is fetched without its page being viewed
+	views := spree.NewViewCounter(md, zap.NewNop())
+	views.Read("embedded")
+	if _, err := views.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	shot, err := md.GetShotById("embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shot.LastReadAt == "" || shot.LastViewedAt != "" || shot.Views != 0 {
+		t.Errorf("read recorded as last read %q, last viewed %q and %d views",
+			shot.LastReadAt, shot.LastViewedAt, shot.Views)
+	}
+
+	stats, err := spree.NewTierMigrator(md, tiers, 24*time.Hour, zap.NewNop()).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Demoted != 1 {
+		t.Errorf("demoted %d shots, want 1", stats.Demoted)
+	}
+	if !stored(t, cold, "idle-shot.png") || !stored(t, hot, "embedded-shot.png") {
+		t.Error("only the idle shot should have been demoted")
+	}
+}
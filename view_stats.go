@@ -0,0 +1,369 @@
+package spree
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// hllPrecision gives 1024 one-byte registers per shot, for a standard
+	// error of about 3% in the unique viewer estimate
+	hllPrecision = 10
+	hllRegisters = 1 << hllPrecision
+
+	// maxReferrers bounds the referrers kept per shot. The least common are
+	// dropped first.
+	maxReferrers = 100
+
+	dayFormat = "2006-01-02"
+)
+
+// View is a single hit on a shot's display page.
+type View struct {
+	At time.Time
+	// Referrer is the host of the referring page, empty if there was none
+	Referrer string
+	// Agent is the class of the user agent, see agentClass
+	Agent string
+	// Viewer is a hash of the viewer's address
+	Viewer uint64
+	// Crawler is set for hits that weren't made by a person, see isCrawler
+	Crawler bool
+}
+
+// newView describes the request r for a display page from the client at
+// addr. Addresses are only kept as a hash, for the unique viewer count.
+func newView(r *http.Request, addr string, at time.Time) View {
+	var referrer string
+	if u, err := url.Parse(r.Referer()); err == nil {
+		referrer = strings.ToLower(u.Host)
+	}
+
+	agent := agentClass(r.UserAgent())
+	return View{
+		At:       at,
+		Referrer: referrer,
+		Agent:    agent,
+		Viewer:   viewerHash(addr),
+		Crawler:  isCrawler(r, agent),
+	}
+}
+
+// clientAddr is the address of the client that sent r. X-Forwarded-For is
+// only honoured for requests from a trusted proxy, and then the last address
+// in it that isn't a trusted proxy is used, since clients can put anything
+// before that.
+func clientAddr(r *http.Request, trusted []*net.IPNet) string {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if !isTrustedProxy(addr, trusted) {
+		return addr
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		addr = hop
+		if !isTrustedProxy(hop, trusted) {
+			break
+		}
+	}
+	return addr
+}
+
+func isTrustedProxy(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses addresses and CIDR ranges of proxies whose
+// X-Forwarded-For headers are believed.
+func ParseTrustedProxies(addrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", addr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy range %q: %v", addr, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// prefetchHeaders are set by browsers and proxies fetching a page that
+// nobody has asked to see yet.
+var prefetchHeaders = []string{"Purpose", "Sec-Purpose", "X-Purpose", "X-Moz"}
+
+// isCrawler reports whether r, whose user agent is of class agent, looks
+// like a link preview fetcher, bot or prefetch rather than a person. Some
+// unfurlers send a browser user agent, so besides the agent class this
+// counts HEAD requests and prefetches, and agents that aren't a known
+// browser and don't send Accept-Language, which every browser does.
+func isCrawler(r *http.Request, agent string) bool {
+	switch agent {
+	case "link preview", "bot":
+		return true
+	}
+	if r.Method == http.MethodHead {
+		return true
+	}
+	for _, h := range prefetchHeaders {
+		v := strings.ToLower(r.Header.Get(h))
+		if strings.Contains(v, "prefetch") || strings.Contains(v, "preview") {
+			return true
+		}
+	}
+	switch agent {
+	case "unknown", "other":
+		return r.Header.Get("Accept-Language") == ""
+	}
+	return false
+}
+
+func viewerHash(addr string) uint64 {
+	sum := sha256.Sum256([]byte(addr))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+var (
+	// link preview fetchers are checked before generic bots so a shot pasted
+	// into chat shows up as such
+	linkPreviewAgents = []string{"slackbot", "discordbot", "telegrambot", "twitterbot",
+		"facebookexternalhit", "whatsapp", "skypeuripreview", "mattermost", "linkedinbot"}
+	botAgents    = []string{"bot", "crawler", "spider", "curl", "wget", "python", "go-http-client", "java/", "okhttp"}
+	mobileAgents = []string{"mobile", "android", "iphone", "ipad"}
+)
+
+// agentClass sorts user agents into a handful of classes.
+func agentClass(ua string) string {
+	ua = strings.ToLower(ua)
+	switch {
+	case ua == "":
+		return "unknown"
+	case containsAny(ua, linkPreviewAgents):
+		return "link preview"
+	case containsAny(ua, botAgents):
+		return "bot"
+	case containsAny(ua, mobileAgents):
+		return "mobile"
+	case strings.HasPrefix(ua, "mozilla/"):
+		return "desktop"
+	}
+	return "other"
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// hllAdd records hash in a HyperLogLog sketch. The top bits pick the
+// register, which keeps the longest run of leading zeros seen in the rest.
+func hllAdd(regs []byte, hash uint64) {
+	idx := hash >> (64 - hllPrecision)
+	rest := hash<<hllPrecision | 1<<(hllPrecision-1)
+	rank := byte(1)
+	for rest&(1<<63) == 0 {
+		rank++
+		rest <<= 1
+	}
+	if rank > regs[idx] {
+		regs[idx] = rank
+	}
+}
+
+// hllMerge folds the sketch src into dst.
+func hllMerge(dst, src []byte) {
+	for i := range src {
+		if i < len(dst) && src[i] > dst[i] {
+			dst[i] = src[i]
+		}
+	}
+}
+
+// hllCount estimates the number of distinct hashes added to regs, using
+// linear counting while most registers are still empty.
+func hllCount(regs []byte) uint64 {
+	if len(regs) != hllRegisters {
+		return 0
+	}
+
+	m := float64(hllRegisters)
+	var sum float64
+	var zeros int
+	for _, r := range regs {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	est := alpha * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
+
+// viewTally accumulates views of one shot between flushes. Crawler hits
+// are only counted in crawlerViews and agents, so they don't show up as
+// views, days, referrers or viewers.
+type viewTally struct {
+	views        uint64
+	crawlerViews uint64
+	reads        uint64
+	daily        map[string]uint64
+	referrers    map[string]uint64
+	agents       map[string]uint64
+	viewers      []byte
+}
+
+func newViewTally() *viewTally {
+	return &viewTally{
+		daily:     make(map[string]uint64),
+		referrers: make(map[string]uint64),
+		agents:    make(map[string]uint64),
+		viewers:   make([]byte, hllRegisters),
+	}
+}
+
+func (t *viewTally) add(v View) {
+	t.agents[v.Agent]++
+	if v.Crawler {
+		t.crawlerViews++
+		return
+	}
+	t.views++
+	t.daily[v.At.UTC().Format(dayFormat)]++
+	t.referrers[v.Referrer]++
+	hllAdd(t.viewers, v.Viewer)
+}
+
+func (t *viewTally) merge(stats *ViewStats) {
+	t.views += stats.Views
+	t.crawlerViews += stats.CrawlerViews
+	t.reads += stats.Reads
+	addCounts(t.daily, stats.Daily)
+	addCounts(t.referrers, stats.Referrers)
+	addCounts(t.agents, stats.Agents)
+	hllMerge(t.viewers, stats.Viewers)
+}
+
+func (t *viewTally) stats() *ViewStats {
+	return &ViewStats{
+		Views:        t.views,
+		CrawlerViews: t.crawlerViews,
+		Reads:        t.reads,
+		Daily:        sortedCounts(t.daily),
+		Referrers:    sortedCounts(trimCounts(t.referrers, maxReferrers)),
+		Agents:       sortedCounts(t.agents),
+		Viewers:      t.viewers,
+	}
+}
+
+// mergeViewStats adds the views in delta to stored, which may be nil.
+func mergeViewStats(stored, delta *ViewStats) *ViewStats {
+	t := newViewTally()
+	if stored != nil {
+		t.merge(stored)
+	}
+	t.merge(delta)
+	return t.stats()
+}
+
+func addCounts(dst map[string]uint64, counts []*ViewCount) {
+	for _, c := range counts {
+		dst[c.Key] += c.Views
+	}
+}
+
+// sortedCounts returns counts ordered by key.
+func sortedCounts(counts map[string]uint64) []*ViewCount {
+	out := make([]*ViewCount, 0, len(counts))
+	for k, n := range counts {
+		out = append(out, &ViewCount{Key: k, Views: n})
+	}
+	sort.Sort(byCountKey(out))
+	return out
+}
+
+// trimCounts keeps the n keys with the most views.
+func trimCounts(counts map[string]uint64, n int) map[string]uint64 {
+	if len(counts) <= n {
+		return counts
+	}
+	top := topCounts(sortedCounts(counts), n)
+	out := make(map[string]uint64, len(top))
+	for _, c := range top {
+		out[c.Key] = c.Views
+	}
+	return out
+}
+
+// topCounts returns up to n counts with the most views first. Ties are
+// broken by key so the order is stable.
+func topCounts(counts []*ViewCount, n int) []*ViewCount {
+	out := make([]*ViewCount, len(counts))
+	copy(out, counts)
+	sort.Sort(byCountViews(out))
+	if n >= 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+type byCountKey []*ViewCount
+
+func (b byCountKey) Len() int           { return len(b) }
+func (b byCountKey) Less(i, j int) bool { return b[i].Key < b[j].Key }
+func (b byCountKey) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+type byCountViews []*ViewCount
+
+func (b byCountViews) Len() int { return len(b) }
+func (b byCountViews) Less(i, j int) bool {
+	if b[i].Views != b[j].Views {
+		return b[i].Views > b[j].Views
+	}
+	return b[i].Key < b[j].Key
+}
+func (b byCountViews) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,171 @@
+package spree
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100, 1000, 10000, 100000} {
+		regs := make([]byte, hllRegisters)
+		for i := 0; i < n; i++ {
+			// each viewer is seen several times
+			for j := 0; j < 3; j++ {
+				hllAdd(regs, viewerHash(fmt.Sprintf("10.%d.%d.%d", i>>16, (i>>8)&0xff, i&0xff)))
+			}
+		}
+
+		got := float64(hllCount(regs))
+		if diff := got - float64(n); diff > 0.1*float64(n)+1 || diff < -0.1*float64(n)-1 {
+			t.Errorf("%d distinct viewers estimated as %.0f", n, got)
+		}
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	a := make([]byte, hllRegisters)
+	b := make([]byte, hllRegisters)
+	for i := 0; i < 2000; i++ {
+		h := viewerHash(fmt.Sprint(i))
+		if i < 1500 {
+			hllAdd(a, h)
+		}
+		if i >= 500 {
+			hllAdd(b, h)
+		}
+	}
+	hllMerge(a, b)
+
+	if got := float64(hllCount(a)); got < 1800 || got > 2200 {
+		t.Errorf("merged sketches estimate %.0f viewers, want about 2000", got)
+	}
+}
+
+func TestAgentClass(t *testing.T) {
+	tests := []struct {
+		ua, class string
+	}{
+		{"", "unknown"},
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", "link preview"},
+		{"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", "link preview"},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "bot"},
+		{"curl/7.52.1", "bot"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 10_2 like Mac OS X) AppleWebKit/602.3.12", "mobile"},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 Chrome/56.0.2924.87 Safari/537.36", "desktop"},
+		{"spreectl", "other"},
+	}
+	for _, tt := range tests {
+		if got := agentClass(tt.ua); got != tt.class {
+			t.Errorf("agentClass(%q) = %q, want %q", tt.ua, got, tt.class)
+		}
+	}
+}
+
+func TestIsCrawler(t *testing.T) {
+	const chrome = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 Chrome/56.0.2924.87 Safari/537.36"
+	tests := []struct {
+		method  string
+		headers map[string]string
+		crawler bool
+	}{
+		{"GET", map[string]string{"User-Agent": chrome, "Accept-Language": "en-US"}, false},
+		// browsers that don't send Accept-Language are still people
+		{"GET", map[string]string{"User-Agent": chrome}, false},
+		{"GET", map[string]string{"User-Agent": "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"}, true},
+		{"GET", map[string]string{"User-Agent": "curl/7.52.1", "Accept-Language": "en-US"}, true},
+		{"HEAD", map[string]string{"User-Agent": chrome, "Accept-Language": "en-US"}, true},
+		{"GET", map[string]string{"User-Agent": chrome, "Accept-Language": "en-US", "Sec-Purpose": "prefetch"}, true},
+		{"GET", map[string]string{"User-Agent": chrome, "Accept-Language": "en-US", "X-Purpose": "preview"}, true},
+		{"GET", map[string]string{}, true},
+		{"GET", map[string]string{"User-Agent": "SomeUnfurler/1.0"}, true},
+		{"GET", map[string]string{"User-Agent": "SomeReader/1.0", "Accept-Language": "de"}, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/p/abc", nil)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := newView(r, "192.0.2.1", time.Now()).Crawler; got != tt.crawler {
+			t.Errorf("%s with %v: crawler = %v, want %v", tt.method, tt.headers, got, tt.crawler)
+		}
+	}
+}
+
+func TestViewTallyCrawlers(t *testing.T) {
+	at := time.Date(2017, 3, 2, 8, 30, 0, 0, time.UTC)
+	tally := newViewTally()
+	tally.add(View{At: at, Referrer: "example.com", Agent: "desktop", Viewer: 1})
+	tally.add(View{At: at, Referrer: "slack.com", Agent: "link preview", Viewer: 2, Crawler: true})
+	tally.add(View{At: at, Agent: "link preview", Viewer: 3, Crawler: true})
+
+	stats := tally.stats()
+	if stats.Views != 1 || stats.CrawlerViews != 2 {
+		t.Errorf("tallied %d views and %d crawler views, want 1 and 2", stats.Views, stats.CrawlerViews)
+	}
+	if len(stats.Daily) != 1 || stats.Daily[0].Views != 1 {
+		t.Errorf("daily counts include crawlers: %v", stats.Daily)
+	}
+	if len(stats.Referrers) != 1 || stats.Referrers[0].Key != "example.com" {
+		t.Errorf("referrers include crawlers: %v", stats.Referrers)
+	}
+	if got := hllCount(stats.Viewers); got != 1 {
+		t.Errorf("%d unique viewers, want 1", got)
+	}
+	if len(stats.Agents) != 2 {
+		t.Errorf("agents = %v, want desktop and link preview", stats.Agents)
+	}
+}
+
+func TestMergeViewStatsTrimsReferrers(t *testing.T) {
+	delta := &ViewStats{}
+	for i := 0; i < maxReferrers+20; i++ {
+		delta.Referrers = append(delta.Referrers, &ViewCount{Key: fmt.Sprintf("host%03d", i), Views: uint64(i + 1)})
+	}
+
+	stats := mergeViewStats(nil, delta)
+	if len(stats.Referrers) != maxReferrers {
+		t.Fatalf("kept %d referrers, want %d", len(stats.Referrers), maxReferrers)
+	}
+	for _, c := range stats.Referrers {
+		if c.Views <= 20 {
+			t.Errorf("kept %s with %d views over busier referrers", c.Key, c.Views)
+		}
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", " 192.0.2.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote string
+		fwd    string
+		want   string
+	}{
+		{"203.0.113.5:4000", "", "203.0.113.5"},
+		// only trusted proxies may forward
+		{"203.0.113.5:4000", "198.51.100.1", "203.0.113.5"},
+		{"192.0.2.7:4000", "198.51.100.1", "198.51.100.1"},
+		{"192.0.2.7:4000", "", "192.0.2.7"},
+		// a client can prepend anything, so the last untrusted hop wins
+		{"10.1.1.1:4000", "6.6.6.6, 198.51.100.1, 10.2.2.2", "198.51.100.1"},
+		{"10.1.1.1:4000", "10.3.3.3, 10.2.2.2", "10.3.3.3"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/p/abc", nil)
+		r.RemoteAddr = tt.remote
+		if tt.fwd != "" {
+			r.Header.Set("X-Forwarded-For", tt.fwd)
+		}
+		if got := clientAddr(r, trusted); got != tt.want {
+			t.Errorf("%s forwarding %q: got %s, want %s", tt.remote, tt.fwd, got, tt.want)
+		}
+	}
+
+	if _, err := ParseTrustedProxies([]string{"proxy.example.com"}); err == nil {
+		t.Error("parsed a hostname as a proxy")
+	}
+}
@@ -0,0 +1,134 @@
+package spree
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+)
+
+const (
+	// viewShards spreads counters over several locks so concurrent hits on
+	// different shots don't contend
+	viewShards = 32
+)
+
+// ViewCounter counts views in memory and writes them to Metadata in
+// batches, so a popular shot costs one write per flush instead of one per
+// hit. Along with the count it tallies the ViewStats of each shot. Views
+// that haven't been flushed yet aren't visible in Metadata.
+type ViewCounter struct {
+	ll     *zap.Logger
+	md     Metadata
+	shards [viewShards]viewShard
+
+	// flushMu keeps flushes, and the views they put back on failure, in order
+	flushMu sync.Mutex
+}
+
+type viewShard struct {
+	mu      sync.Mutex
+	tallies map[string]*viewTally
+}
+
+func NewViewCounter(md Metadata, ll *zap.Logger) *ViewCounter {
+	v := &ViewCounter{
+		ll: ll,
+		md: md,
+	}
+	for i := range v.shards {
+		v.shards[i].tallies = make(map[string]*viewTally)
+	}
+	return v
+}
+
+func (v *ViewCounter) shard(id string) *viewShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &v.shards[h.Sum32()%viewShards]
+}
+
+// Add counts a view of the shot with id.
+func (v *ViewCounter) Add(id string, view View) {
+	v.update(id, func(t *viewTally) { t.add(view) })
+}
+
+// Read counts a fetch of the file of the shot with id.
+func (v *ViewCounter) Read(id string) {
+	v.update(id, func(t *viewTally) { t.reads++ })
+}
+
+func (v *ViewCounter) update(id string, fn func(*viewTally)) {
+	s := v.shard(id)
+	s.mu.Lock()
+	t, ok := s.tallies[id]
+	if !ok {
+		t = newViewTally()
+		s.tallies[id] = t
+	}
+	fn(t)
+	s.mu.Unlock()
+}
+
+// Flush writes the views counted since the last flush in a single
+// Metadata.AddViews call and returns how many shots were updated. If the
+// write fails the views are kept for the next flush.
+func (v *ViewCounter) Flush() (int, error) {
+	v.flushMu.Lock()
+	defer v.flushMu.Unlock()
+
+	batch := make(map[string]*ViewStats)
+	for i := range v.shards {
+		s := &v.shards[i]
+		s.mu.Lock()
+		if len(s.tallies) > 0 {
+			for id, t := range s.tallies {
+				batch[id] = t.stats()
+			}
+			s.tallies = make(map[string]*viewTally)
+		}
+		s.mu.Unlock()
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	if err := v.md.AddViews(batch, time.Now()); err != nil {
+		for id, stats := range batch {
+			stats := stats
+			v.update(id, func(t *viewTally) { t.merge(stats) })
+		}
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+// Loop flushes every interval until ctx is done, then flushes once more so
+// no views are lost at shutdown.
+func (v *ViewCounter) Loop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			v.flush()
+		case <-ctx.Done():
+			v.flush()
+			return
+		}
+	}
+}
+
+func (v *ViewCounter) flush() {
+	n, err := v.Flush()
+	if err != nil {
+		v.ll.Error("unable to flush views, retrying next flush", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		v.ll.Debug("flushed views", zap.Int("shots", n))
+	}
+}
@@ -0,0 +1,182 @@
+package spree_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ralfonso/spree"
+	"golang.org/x/net/context"
+)
+
+func TestViewCounter(t *testing.T) {
+	md := spree.NewMemoryMetadata()
+	for _, id := range []string{"a", "b"} {
+		if err := md.PutShot(&spree.Shot{Id: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	views := spree.NewViewCounter(md, zap.NewNop())
+	view := spree.View{At: time.Now(), Agent: "desktop", Viewer: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		views.Loop(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	const workers, hits = 8, 500
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < hits; j++ {
+				views.Add("a", view)
+				views.Add("b", view)
+				views.Add("deleted", view)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// stopping the loop flushes whatever is left
+	cancel()
+	<-done
+
+	for _, id := range []string{"a", "b"} {
+		shot, err := md.GetShotById(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shot.Views != workers*hits {
+			t.Errorf("shot %s has %d views, want %d", id, shot.Views, workers*hits)
+		}
+	}
+}
+
+func TestViewCounterRetries(t *testing.T) {
+	md := &flakyMetadata{Metadata: spree.NewMemoryMetadata()}
+	if err := md.PutShot(&spree.Shot{Id: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	views := spree.NewViewCounter(md, zap.NewNop())
+	views.Add("a", spree.View{At: time.Now(), Viewer: 1})
+	views.Add("a", spree.View{At: time.Now(), Viewer: 2})
+
+	md.fail = true
+	if _, err := views.Flush(); err == nil {
+		t.Fatal("Flush succeeded although AddViews failed")
+	}
+
+	// the views are kept for the next flush
+	md.fail = false
+	if n, err := views.Flush(); err != nil || n != 1 {
+		t.Fatalf("Flush = %d, %v, want 1 shot", n, err)
+	}
+	if n, err := views.Flush(); err != nil || n != 0 {
+		t.Fatalf("second Flush = %d, %v, want nothing to flush", n, err)
+	}
+	shot, err := md.GetShotById("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shot.Views != 2 {
+		t.Errorf("shot has %d views, want 2", shot.Views)
+	}
+}
+
+// flakyMetadata fails AddViews while fail is set.
+type flakyMetadata struct {
+	spree.Metadata
+	fail bool
+}
+
+func (f *flakyMetadata) AddViews(views map[string]*spree.ViewStats, at time.Time) error {
+	if f.fail {
+		return fmt.Errorf("database unavailable")
+	}
+	return f.Metadata.AddViews(views, at)
+}
+
+// BenchmarkPutShotWhileViewed measures uploads while a shot is being viewed
+// from many goroutines. "direct" writes every view in its own transaction,
+// as /p/ used to; "batched" counts them in a ViewCounter.
+func BenchmarkPutShotWhileViewed(b *testing.B) {
+	b.Run("direct", func(b *testing.B) {
+		benchmarkPutShotWhileViewed(b, func(md *spree.BoltKV) (func(string), func()) {
+			return func(id string) { md.IncrementViews(id) }, func() {}
+		})
+	})
+	b.Run("batched", func(b *testing.B) {
+		benchmarkPutShotWhileViewed(b, func(md *spree.BoltKV) (func(string), func()) {
+			views := spree.NewViewCounter(md, zap.NewNop())
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				views.Loop(ctx, 100*time.Millisecond)
+				close(done)
+			}()
+			view := spree.View{At: time.Now(), Agent: "desktop"}
+			return func(id string) { views.Add(id, view) }, func() {
+				cancel()
+				<-done
+			}
+		})
+	})
+}
+
+func benchmarkPutShotWhileViewed(b *testing.B, counter func(*spree.BoltKV) (view func(string), stop func())) {
+	dir, err := ioutil.TempDir("", "spree-views")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	md, err := spree.NewBoltKV(filepath.Join(dir, "spree.boltdb"), "spree", zap.NewNop())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer md.Close()
+	if err := md.PutShot(&spree.Shot{Id: "viral"}); err != nil {
+		b.Fatal(err)
+	}
+
+	view, stopCounter := counter(md)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					view("viral")
+				}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		shot := &spree.Shot{Id: fmt.Sprintf("upload%d", i), Filename: "shot.png"}
+		if err := md.PutShot(shot); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	close(stop)
+	wg.Wait()
+	stopCounter()
+}